@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
 
@@ -18,6 +19,11 @@ func main() {
 	// execute parses CLI args and runs the right subcommand
 	if err := root.Execute(); err != nil {
 		fmt.Fprintln(os.Stderr, err)
+		// errors may pick their own exit code (e.g. 2 when run --auto-restart gives up)
+		var coded interface{ ExitCode() int }
+		if errors.As(err, &coded) {
+			os.Exit(coded.ExitCode())
+		}
 		os.Exit(1)
 	}
 }
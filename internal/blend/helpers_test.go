@@ -2,7 +2,10 @@
 
 import (
 	"os"
+	"runtime"
+	"runtime/debug"
 	"testing"
+	"time"
 )
 
 // shared helper: write a file or fail the test
@@ -35,3 +38,36 @@ func toInt64Slice(v any) ([]int64, bool) {
 		return nil, false
 	}
 }
+
+// peakHeap runs f and returns the largest live-heap growth seen while it ran,
+// sampled every millisecond with the collector kept aggressive so garbage
+// does not count.
+func peakHeap(f func()) uint64 {
+	defer debug.SetGCPercent(debug.SetGCPercent(5))
+	runtime.GC()
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	base := ms.HeapAlloc
+
+	var peak uint64
+	done, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		var m runtime.MemStats
+		for {
+			runtime.ReadMemStats(&m)
+			if m.HeapAlloc > base && m.HeapAlloc-base > peak {
+				peak = m.HeapAlloc - base
+			}
+			select {
+			case <-done:
+				return
+			case <-time.After(time.Millisecond):
+			}
+		}
+	}()
+	f()
+	close(done)
+	<-stopped
+	return peak
+}
@@ -2,8 +2,8 @@
 
 import (
 	"bufio"
+	"bytes"
 	"fmt"
-	"os"
 	"strings"
 
 	"github.com/nekwebdev/confb/internal/config"
@@ -15,26 +15,89 @@
 // - Comments starting with ';' or '#' are ignored.
 // - Blank lines ignored.
 // - Lines outside any section are treated as section "" (global).
+// - Section order: first_seen (default), lex, or source_priority (grouped by the
+//   source file that first defined the section, lexicographic within a file).
+// - default_section (e.g. "DEFAULT"): that section renders first and every other
+//   section inherits its keys unless it sets them itself.
+// - global_section (e.g. "global"): the global section renders under that header,
+//   and a source section with that name is read as the global section.
 func BlendINI(rules *config.MergeRules, files []string) (string, error) {
+	return BlendINIWith(rules, files, Options{})
+}
+
+// BlendINIWith is BlendINI with per-build options (e.g. source encodings).
+func BlendINIWith(rules *config.MergeRules, files []string, opts Options) (string, error) {
 	mode := strings.ToLower(rules.INIRepeatedKeys)
 	if mode == "" { mode = "last_wins" }
 
 	type sec map[string][]string // key -> list of values (for append mode)
 	acc := map[string]sec{}      // section name -> keys map
 	seenSec := []string{}        // to render sections in stable order
+	origin := map[string]int{}   // section name -> index of first defining file
+	fileIdx := 0
 
 	ensure := func(name string) sec {
 		if s, ok := acc[name]; ok { return s }
 		acc[name] = sec{}
 		seenSec = append(seenSec, name)
+		origin[name] = fileIdx
 		return acc[name]
 	}
 
-	for _, path := range files {
-		f, err := os.Open(path)
+	// render (nfiles: how many files have been merged so far)
+	render := func(nfiles int) string {
+		var b strings.Builder
+		order := orderSections(seenSec, origin, nfiles, strings.ToLower(rules.INISectionOrder))
+		def, hasDef := acc[rules.INIDefaultSection]
+		hasDef = hasDef && rules.INIDefaultSection != ""
+		if hasDef {
+			order = defaultFirst(order, rules.INIDefaultSection)
+		}
+		for _, name := range order {
+			sect := acc[name]
+			if hasDef && name != "" && name != rules.INIDefaultSection {
+				sect = inheritDefaults(sect, def)
+			}
+			if name == "" && rules.INIGlobalSection != "" {
+				if len(sect) == 0 {
+					continue
+				}
+				b.WriteString("[" + rules.INIGlobalSection + "]\n")
+			}
+			if name != "" {
+				b.WriteString("[")
+				b.WriteString(name)
+				b.WriteString("]\n")
+			}
+			// deterministic key order: lexicographic
+			keys := make([]string, 0, len(sect))
+			for k := range sect { keys = append(keys, k) }
+			sortStrings(keys)
+			for _, k := range keys {
+				for _, v := range sect[k] {
+					b.WriteString(k)
+					b.WriteString("=")
+					b.WriteString(v)
+					b.WriteString("\n")
+				}
+			}
+			if !strings.HasSuffix(b.String(), "\n") {
+				b.WriteString("\n")
+			}
+		}
+		if !strings.HasSuffix(b.String(), "\n") {
+			b.WriteString("\n")
+		}
+		return b.String()
+	}
+
+	for idx, path := range files {
+		fileIdx = idx
+		b, err := opts.read(path)
 		if err != nil { return "", fmt.Errorf("read %q: %w", path, err) }
-		sc := bufio.NewScanner(f)
+		sc := bufio.NewScanner(bytes.NewReader(b))
 		sect := ensure("") // global by default
+		sectName := ""
 
 		for sc.Scan() {
 			line := strings.TrimSpace(sc.Text())
@@ -45,7 +108,11 @@ func BlendINI(rules *config.MergeRules, files []string) (string, error) {
 			// section header?
 			if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
 				name := strings.TrimSpace(line[1 : len(line)-1])
+				if rules.INIGlobalSection != "" && name == rules.INIGlobalSection {
+					name = "" // the configured global section
+				}
 				sect = ensure(name)
+				sectName = name
 				continue
 			}
 			// key=value (first '=' splits)
@@ -64,39 +131,87 @@ func BlendINI(rules *config.MergeRules, files []string) (string, error) {
 			default: // last_wins
 				sect[key] = []string{val}
 			}
+			if opts.Provenance != nil {
+				opts.Provenance[iniKeyPath(sectName, key)] = path
+			}
+		}
+		if opts.Trace != nil {
+			opts.traceText(idx+1, path, render(idx+1))
 		}
-		_ = f.Close()
 	}
 
-	// render
-	var b strings.Builder
-	for _, name := range seenSec {
-		sect := acc[name]
-		if name != "" {
-			b.WriteString("[")
-			b.WriteString(name)
-			b.WriteString("]\n")
+	return render(len(files)), nil
+}
+
+// iniKeyPath names a key for provenance: "section.key", or just "key" in the
+// global section.
+func iniKeyPath(section, key string) string {
+	if section == "" {
+		return key
+	}
+	return section + "." + key
+}
+
+// orderSections applies the section_order policy. The global section "" (if any)
+// always renders first so its keys stay above the first header.
+func orderSections(seen []string, origin map[string]int, nfiles int, policy string) []string {
+	out := make([]string, 0, len(seen))
+	rest := make([]string, 0, len(seen))
+	for _, name := range seen {
+		if name == "" {
+			out = append(out, name)
+		} else {
+			rest = append(rest, name)
 		}
-		// deterministic key order: lexicographic
-		keys := make([]string, 0, len(sect))
-		for k := range sect { keys = append(keys, k) }
-		sortStrings(keys)
-		for _, k := range keys {
-			for _, v := range sect[k] {
-				b.WriteString(k)
-				b.WriteString("=")
-				b.WriteString(v)
-				b.WriteString("\n")
+	}
+	switch policy {
+	case "lex":
+		sortStrings(rest)
+		out = append(out, rest...)
+	case "source_priority":
+		for i := 0; i < nfiles; i++ {
+			var group []string
+			for _, name := range rest {
+				if origin[name] == i {
+					group = append(group, name)
+				}
 			}
+			sortStrings(group)
+			out = append(out, group...)
 		}
-		if !strings.HasSuffix(b.String(), "\n") {
-			b.WriteString("\n")
+	default: // first_seen
+		out = append(out, rest...)
+	}
+	return out
+}
+
+// defaultFirst moves the default section right after the global section "".
+func defaultFirst(order []string, def string) []string {
+	out := make([]string, 0, len(order))
+	for _, name := range order {
+		if name == "" {
+			out = append(out, name)
+		}
+	}
+	out = append(out, def)
+	for _, name := range order {
+		if name != "" && name != def {
+			out = append(out, name)
 		}
 	}
-	if !strings.HasSuffix(b.String(), "\n") {
-		b.WriteString("\n")
+	return out
+}
+
+// inheritDefaults returns sect with the keys of def it does not set itself.
+func inheritDefaults(sect, def map[string][]string) map[string][]string {
+	out := make(map[string][]string, len(sect)+len(def))
+	for k, v := range def {
+		out[k] = v
+	}
+	for k, v := range sect {
+		out[k] = v
 	}
-	return b.String(), nil
+	return out
 }
 
 // tiny local sorter to avoid importing sort in this file
@@ -2,6 +2,7 @@
 
 import (
 	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 
@@ -73,3 +74,135 @@ func TestINI_Append_RepeatedKeys(t *testing.T) {
 		t.Fatalf("expected name=base to be present, got:\n%s", out)
 	}
 }
+
+func TestINI_SectionOrder_Policies(t *testing.T) {
+	td := t.TempDir()
+	first := filepath.Join(td, "10-first.ini")
+	second := filepath.Join(td, "20-second.ini")
+
+	writeFileT(t, first, `
+[zeta]
+a=1
+[mu]
+b=2
+`)
+	writeFileT(t, second, `
+[beta]
+c=3
+[zeta]
+a=4
+`)
+
+	headers := func(out string) string {
+		var hs []string
+		for _, l := range strings.Split(out, "\n") {
+			if strings.HasPrefix(l, "[") {
+				hs = append(hs, l)
+			}
+		}
+		return strings.Join(hs, "")
+	}
+
+	cases := []struct {
+		policy string
+		want   string
+	}{
+		{"first_seen", "[zeta][mu][beta]"},
+		{"lex", "[beta][mu][zeta]"},
+		{"source_priority", "[mu][zeta][beta]"},
+	}
+	for _, c := range cases {
+		rules := &config.MergeRules{INIRepeatedKeys: "last_wins", INISectionOrder: c.policy}
+		out, err := BlendINI(rules, []string{first, second})
+		if err != nil {
+			t.Fatalf("%s: BlendINI error: %v", c.policy, err)
+		}
+		if got := headers(out); got != c.want {
+			t.Fatalf("%s: section order = %s, want %s\n%s", c.policy, got, c.want, out)
+		}
+		// deterministic across runs
+		again, _ := BlendINI(rules, []string{first, second})
+		if again != out {
+			t.Fatalf("%s: output not deterministic", c.policy)
+		}
+	}
+}
+
+func TestINI_DefaultSection_Inherited(t *testing.T) {
+	td := t.TempDir()
+	base := filepath.Join(td, "base.ini")
+	over := filepath.Join(td, "overlay.ini")
+
+	writeFileT(t, base, `
+[web]
+port=80
+
+[DEFAULT]
+timeout=30
+retries=1
+`)
+	writeFileT(t, over, `
+[db]
+port=5432
+retries=5
+`)
+
+	rules := &config.MergeRules{INIRepeatedKeys: "last_wins", INIDefaultSection: "DEFAULT"}
+	out, err := BlendINI(rules, []string{base, over})
+	if err != nil {
+		t.Fatalf("BlendINI error: %v", err)
+	}
+
+	want := "[DEFAULT]\nretries=1\ntimeout=30\n" +
+		"[web]\nport=80\nretries=1\ntimeout=30\n" +
+		"[db]\nport=5432\nretries=5\ntimeout=30\n"
+	if strings.TrimLeft(out, "\n") != want { // the empty global section renders as a blank line
+		t.Fatalf("unexpected output:\n%s\nwant:\n%s", out, want)
+	}
+}
+
+func TestINI_Provenance(t *testing.T) {
+	td := t.TempDir()
+	a := filepath.Join(td, "a.ini")
+	b := filepath.Join(td, "b.ini")
+
+	writeFileT(t, a, "top=1\n[server]\nhost=a\nport=80\n")
+	writeFileT(t, b, "[server]\nhost=b\n")
+
+	prov := map[string]string{}
+	if _, err := BlendINIWith(&config.MergeRules{}, []string{a, b}, Options{Provenance: prov}); err != nil {
+		t.Fatalf("BlendINIWith: %v", err)
+	}
+	want := map[string]string{"top": a, "server.host": b, "server.port": a}
+	if !reflect.DeepEqual(prov, want) {
+		t.Fatalf("provenance = %v, want %v", prov, want)
+	}
+}
+
+func TestINI_GlobalSection_Named(t *testing.T) {
+	td := t.TempDir()
+	a := filepath.Join(td, "a.ini")
+	b := filepath.Join(td, "b.ini")
+
+	writeFileT(t, a, "top=1\n[server]\nhost=a\n")
+	writeFileT(t, b, "[global]\ntop=2\nmore=x\n")
+
+	out, err := BlendINI(&config.MergeRules{INIGlobalSection: "global"}, []string{a, b})
+	if err != nil {
+		t.Fatalf("BlendINI: %v", err)
+	}
+	want := "[global]\nmore=x\ntop=2\n[server]\nhost=a\n"
+	if out != want {
+		t.Fatalf("output:\n%q\nwant:\n%q", out, want)
+	}
+
+	// no top-level keys: no empty [global] header
+	writeFileT(t, filepath.Join(td, "only.ini"), "[server]\nhost=a\n")
+	out, err = BlendINI(&config.MergeRules{INIGlobalSection: "global"}, []string{filepath.Join(td, "only.ini")})
+	if err != nil {
+		t.Fatalf("BlendINI: %v", err)
+	}
+	if strings.Contains(out, "[global]") {
+		t.Fatalf("empty global section rendered:\n%s", out)
+	}
+}
@@ -0,0 +1,162 @@
+package blend
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// JSON key order preservation (rules.json_preserve_order).
+//
+// Merging works on map[string]any, which forgets key order. We record the key
+// order of every object while decoding the sources (first file first, keys new
+// in later files appended in their order), then render maps in that order.
+// Objects inside arrays share one order per array path ("[]"), since merging
+// may move elements around.
+
+// jsonKeyOrder maps an object path (keys joined with \x00) to its keys in
+// first-seen order.
+type jsonKeyOrder struct {
+	keys map[string][]string
+	seen map[string]struct{}
+}
+
+func newJSONKeyOrder() *jsonKeyOrder {
+	return &jsonKeyOrder{keys: map[string][]string{}, seen: map[string]struct{}{}}
+}
+
+func (o *jsonKeyOrder) add(path, key string) {
+	id := path + "\x01" + key
+	if _, ok := o.seen[id]; ok {
+		return
+	}
+	o.seen[id] = struct{}{}
+	o.keys[path] = append(o.keys[path], key)
+}
+
+// decodeJSONOrdered parses b like json.Unmarshal into any, but keeps numbers
+// as json.Number (so they render exactly as written) and records key order.
+func decodeJSONOrdered(b []byte, order *jsonKeyOrder) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var doc any
+	if err := dec.Decode(&doc); err != nil {
+		return nil, err
+	}
+
+	dec = json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var walk func(path string) error
+	walk = func(path string) error {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		d, ok := tok.(json.Delim)
+		if !ok {
+			return nil
+		}
+		switch d {
+		case '{':
+			for dec.More() {
+				kt, err := dec.Token()
+				if err != nil {
+					return err
+				}
+				k := kt.(string)
+				order.add(path, k)
+				if err := walk(path + "\x00" + k); err != nil {
+					return err
+				}
+			}
+		case '[':
+			for dec.More() {
+				if err := walk(path + "\x00[]"); err != nil {
+					return err
+				}
+			}
+		}
+		_, err = dec.Token() // closing delimiter
+		return err
+	}
+	if err := walk(""); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// marshalJSONOrdered renders v like json.MarshalIndent (json.Marshal when
+// indent is ""), writing object keys in the recorded order; keys without a
+// recorded position follow, sorted.
+func marshalJSONOrdered(v any, order *jsonKeyOrder, indent string) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := writeJSONOrdered(&buf, "", v, order); err != nil {
+		return nil, err
+	}
+	if indent == "" {
+		return buf.Bytes(), nil
+	}
+	var out bytes.Buffer
+	if err := json.Indent(&out, buf.Bytes(), "", indent); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+func writeJSONOrdered(buf *bytes.Buffer, path string, v any, order *jsonKeyOrder) error {
+	switch t := v.(type) {
+	case map[string]any:
+		keys := make([]string, 0, len(t))
+		listed := map[string]struct{}{}
+		for _, k := range order.keys[path] {
+			if _, ok := t[k]; ok {
+				keys = append(keys, k)
+				listed[k] = struct{}{}
+			}
+		}
+		var rest []string
+		for k := range t {
+			if _, ok := listed[k]; !ok {
+				rest = append(rest, k)
+			}
+		}
+		sort.Strings(rest)
+		keys = append(keys, rest...)
+
+		buf.WriteByte('{')
+		for i, k := range keys {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			kb, err := json.Marshal(k)
+			if err != nil {
+				return err
+			}
+			buf.Write(kb)
+			buf.WriteByte(':')
+			if err := writeJSONOrdered(buf, path+"\x00"+k, t[k], order); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	case []any:
+		buf.WriteByte('[')
+		for i, e := range t {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := writeJSONOrdered(buf, path+"\x00[]", e, order); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	default:
+		b, err := json.Marshal(t)
+		if err != nil {
+			return fmt.Errorf("%v: %w", t, err)
+		}
+		buf.Write(b)
+	}
+	return nil
+}
@@ -4,6 +4,7 @@
 	"encoding/json"
 	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/nekwebdev/confb/internal/config"
@@ -127,3 +128,130 @@ func TestJSON_MapsReplace_ArraysReplace(t *testing.T) {
 		t.Fatalf("svc.nest.x = %v (%T), want 42 (float64)", nest["x"], nest["x"])
 	}
 }
+
+func TestJSON_Indent_CompactVsIndented(t *testing.T) {
+	td := t.TempDir()
+	base := filepath.Join(td, "base.json")
+	over := filepath.Join(td, "overlay.json")
+	writeFileT(t, base, `{"a": {"x": 1}, "list": [1, 2]}`)
+	writeFileT(t, over, `{"a": {"y": "two"}}`)
+
+	compact := ""
+	tab := "\t"
+	outputs := map[string]string{}
+	for name, indent := range map[string]*string{"default": nil, "compact": &compact, "tab": &tab} {
+		rules := &config.MergeRules{Maps: "deep", Arrays: "replace", JSONIndent: indent}
+		out, err := BlendStructured("json", rules, []string{base, over})
+		if err != nil {
+			t.Fatalf("%s: BlendStructured(json) error: %v", name, err)
+		}
+		outputs[name] = out
+	}
+
+	if got := outputs["compact"]; got != `{"a":{"x":1,"y":"two"},"list":[1,2]}`+"\n" {
+		t.Fatalf("compact output = %q", got)
+	}
+	if !strings.Contains(outputs["default"], "\n  \"a\": {") {
+		t.Fatalf("default output not two-space indented:\n%s", outputs["default"])
+	}
+	if !strings.Contains(outputs["tab"], "\n\t\"a\": {") {
+		t.Fatalf("tab output not tab indented:\n%s", outputs["tab"])
+	}
+
+	var a, b any
+	if err := json.Unmarshal([]byte(outputs["compact"]), &a); err != nil {
+		t.Fatalf("compact does not parse: %v", err)
+	}
+	if err := json.Unmarshal([]byte(outputs["default"]), &b); err != nil {
+		t.Fatalf("indented does not parse: %v", err)
+	}
+	if !reflect.DeepEqual(a, b) {
+		t.Fatalf("compact and indented differ semantically:\n%v\n%v", a, b)
+	}
+}
+
+func TestJSON_PreserveOrder(t *testing.T) {
+	td := t.TempDir()
+	base := filepath.Join(td, "base.json")
+	over := filepath.Join(td, "overlay.json")
+	writeFileT(t, base, `{"zeta": 1, "alpha": {"y": 1.50, "x": [{"b": 1, "a": 2}]}, "mid": true}`)
+	writeFileT(t, over, `{"new2": "n", "alpha": {"w": 0, "y": 2}, "new1": null}`)
+
+	rules := &config.MergeRules{Maps: "deep", Arrays: "replace", JSONPreserveOrder: true}
+	out, err := BlendStructured("json", rules, []string{base, over})
+	if err != nil {
+		t.Fatalf("BlendStructured(json) error: %v", err)
+	}
+	want := `{
+  "zeta": 1,
+  "alpha": {
+    "y": 2,
+    "x": [
+      {
+        "b": 1,
+        "a": 2
+      }
+    ],
+    "w": 0
+  },
+  "mid": true,
+  "new2": "n",
+  "new1": null
+}
+`
+	if out != want {
+		t.Fatalf("output:\n%s\nwant:\n%s", out, want)
+	}
+
+	again, err := BlendStructured("json", rules, []string{base, over})
+	if err != nil {
+		t.Fatalf("second run: %v", err)
+	}
+	if again != out {
+		t.Fatalf("runs differ:\n%s\n---\n%s", out, again)
+	}
+}
+
+func TestJSON_InvalidUTF8ReportsLine(t *testing.T) {
+	td := t.TempDir()
+	bad := filepath.Join(td, "bad.json")
+	writeFileT(t, bad, "{\n  \"a\": 1,\n  \"b\": \"x\xffy\"\n}\n")
+
+	_, err := BlendStructured("json", &config.MergeRules{Maps: "deep", Arrays: "replace"}, []string{bad})
+	if err == nil || err.Error() != bad+":3: invalid UTF-8 byte 0xFF" {
+		t.Fatalf("want %s:3 invalid byte 0xFF, got %v", bad, err)
+	}
+}
+
+func TestJSON_StreamingMatchesUnmarshal(t *testing.T) {
+	td := t.TempDir()
+	path := filepath.Join(td, "a.json")
+	src := `{"a": [1, 2.5, "x", null, true, {}], "b": {"c": {"d": []}}, "a2": "é", "b": 3}`
+	writeFileT(t, path, src)
+	got, ok, err := decodeStream("json", path, Options{})
+	if err != nil || !ok {
+		t.Fatalf("decodeStream: ok=%v err=%v", ok, err)
+	}
+	var want any
+	if err := json.Unmarshal([]byte(src), &want); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("want %#v, got %#v", want, got)
+	}
+
+	for name, bad := range map[string]string{
+		"trailing":  `{"a": 1} {"b": 2}`,
+		"truncated": `{"a": [1, 2`,
+	} {
+		writeFileT(t, path, bad)
+		if _, _, err := decodeStream("json", path, Options{}); err == nil || !strings.Contains(err.Error(), "parse JSON") {
+			t.Fatalf("%s: want parse JSON error, got %v", name, err)
+		}
+	}
+
+	writeFileT(t, path, " \n")
+	if _, ok, err := decodeStream("json", path, Options{}); ok || err != nil {
+		t.Fatalf("blank file: want ok=false, nil error, got %v, %v", ok, err)
+	}
+}
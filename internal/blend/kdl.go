@@ -3,7 +3,6 @@
 import (
 	"bufio"
 	"fmt"
-	"os"
 	"sort"
 	"strings"
 
@@ -12,8 +11,14 @@
 
 // BlendKDL merges KDL fragments according to rules (keys + optional section_keys)
 // Blocks may have identifier arguments (the "head"), e.g. `output "DP-2" { ... }`.
-// Merge occurs only between blocks with the SAME name and SAME head.
+// Merge occurs only between blocks with the SAME name and SAME head, or, with
+// rules.KDLMatchProperty, the same value of that property in the head.
 func BlendKDL(rules *config.MergeRules, files []string) (string, error) {
+	return BlendKDLWith(rules, files, Options{})
+}
+
+// BlendKDLWith is BlendKDL with per-build options (e.g. source encodings).
+func BlendKDLWith(rules *config.MergeRules, files []string, opts Options) (string, error) {
 	if rules == nil {
 		return "", fmt.Errorf("merge rules required")
 	}
@@ -30,25 +35,40 @@ func BlendKDL(rules *config.MergeRules, files []string) (string, error) {
 
 	// root aggregator
 	root := newNode("__root__", "")
+	ro := kdlRender{
+		encounter:  strings.EqualFold(rules.KDLOutputOrder, "encounter"),
+		sortByHead: rules.KDLSortByHead,
+	}
 
 	// parse + merge each file in order
-	for _, path := range files {
-		b, err := os.ReadFile(path)
+	for i, path := range files {
+		b, err := opts.read(path)
 		if err != nil {
 			return "", fmt.Errorf("read %q: %w", path, err)
 		}
-		top, err := parseKDL(string(b))
+		top, dups, err := parseKDL(string(b))
 		if err != nil {
 			return "", fmt.Errorf("%s: %w", path, err)
 		}
+		// keys policy is for merging files; a repeat within one block is a typo
+		// (or an append the file can't express), so flag it
+		for _, d := range dups {
+			if rules.KDLStrictKeys {
+				return "", fmt.Errorf("%s:%d: duplicate key %q in block %q", path, d.line, d.key, d.block)
+			}
+			opts.warn("%s:%d: duplicate key %q in block %q (both kept until merge)", path, d.line, d.key, d.block)
+		}
 
 		// for each top-level section: merge or append
 		for _, childName := range top.ChildrenOrder {
 			list := top.Children[childName]
 			for _, inst := range list {
+				if opts.Provenance != nil {
+					inst.recordProvenance(opts.Provenance, "", path, strings.ToLower(rules.KDLKeys))
+				}
 				if mergeAll || isEligible(childName, eligible) {
 					// merge into first existing instance with same (name, head), or create one
-					dst := root.ensureSingle(childName, inst.Head)
+					dst := root.ensureSingle(childName, inst.Head, rules.KDLMatchProperty)
 					dst.mergeFrom(inst, rules)
 				} else {
 					// keep separate instance
@@ -56,10 +76,13 @@ func BlendKDL(rules *config.MergeRules, files []string) (string, error) {
 				}
 			}
 		}
+		if opts.Trace != nil {
+			opts.traceText(i+1, path, root.renderKDL(0, ro))
+		}
 	}
 
 	// render deterministically
-	return root.renderKDL(0), nil
+	return root.renderKDL(0, ro), nil
 }
 
 func isEligible(name string, set map[string]struct{}) bool {
@@ -104,9 +127,17 @@ func (n *node) clone() *node {
 }
 
 // ensureSingle: find first child with same (name, head), else create.
-func (n *node) ensureSingle(name, head string) *node {
+// With matchProp set and present in head, children match on that property's value instead.
+func (n *node) ensureSingle(name, head, matchProp string) *node {
+	want, byProp := headProp(head, matchProp)
 	if lst, ok := n.Children[name]; ok && len(lst) > 0 {
 		for _, cand := range lst {
+			if byProp {
+				if v, ok := headProp(cand.Head, matchProp); ok && v == want {
+					return cand
+				}
+				continue
+			}
 			if cand.Head == head {
 				return cand
 			}
@@ -117,6 +148,49 @@ func (n *node) ensureSingle(name, head string) *node {
 	return child
 }
 
+// headProp returns the (unquoted) value of key=value in a raw head such as
+// `match-app-id="firefox" open-floating=true`. ok is false if key is empty or absent.
+func headProp(head, key string) (string, bool) {
+	if key == "" {
+		return "", false
+	}
+	for _, tok := range splitHead(head) {
+		k, v, found := strings.Cut(tok, "=")
+		if found && k == key {
+			return strings.Trim(v, `"`), true
+		}
+	}
+	return "", false
+}
+
+// splitHead tokenises a head on whitespace, keeping double-quoted runs intact.
+func splitHead(head string) []string {
+	var toks []string
+	var cur strings.Builder
+	inQuote, escaped := false, false
+	for _, r := range head {
+		switch {
+		case escaped:
+			escaped = false
+		case r == '\\' && inQuote:
+			escaped = true
+		case r == '"':
+			inQuote = !inQuote
+		case (r == ' ' || r == '\t') && !inQuote:
+			if cur.Len() > 0 {
+				toks = append(toks, cur.String())
+				cur.Reset()
+			}
+			continue
+		}
+		cur.WriteRune(r)
+	}
+	if cur.Len() > 0 {
+		toks = append(toks, cur.String())
+	}
+	return toks
+}
+
 func (n *node) appendChild(name string, c *node) {
 	if _, ok := n.Children[name]; !ok {
 		n.Children[name] = []*node{}
@@ -152,30 +226,75 @@ func (n *node) setProp(key, val string, mode string) {
 func (dst *node) mergeFrom(src *node, rules *config.MergeRules) {
 	// merge props
 	mode := strings.ToLower(rules.KDLKeys)
-	for k, vs := range src.Props {
-		for _, v := range vs {
+	for _, k := range src.PropsOrder {
+		for _, v := range src.Props[k] {
 			dst.setProp(k, v, mode)
 		}
 	}
 	// merge children: always coalesce by (name, head) inside a merged section
-	for name, instances := range src.Children {
-		for _, inst := range instances {
-			child := dst.ensureSingle(name, inst.Head)
+	for _, name := range src.ChildrenOrder {
+		for _, inst := range src.Children[name] {
+			child := dst.ensureSingle(name, inst.Head, rules.KDLMatchProperty)
 			child.mergeFrom(inst, rules)
 		}
 	}
 }
 
+// recordProvenance attributes n's props to file as "section.prop" paths; a
+// block head is kept in brackets (`output["DP-2"].mode`). With first_wins an
+// already attributed prop keeps its file.
+func (n *node) recordProvenance(prov map[string]string, prefix, file, mode string) {
+	p := n.Name
+	if n.Head != "" {
+		p += "[" + n.Head + "]"
+	}
+	if prefix != "" {
+		p = prefix + "." + p
+	}
+	for k := range n.Props {
+		key := p + "." + k
+		if _, seen := prov[key]; seen && mode == "first_wins" {
+			continue
+		}
+		prov[key] = file
+	}
+	for _, list := range n.Children {
+		for _, c := range list {
+			c.recordProvenance(prov, p, file, mode)
+		}
+	}
+}
+
+// kdlRender holds the output ordering rules: encounter keeps first-seen
+// section and key order (else names sort), sortByHead sorts the instances of
+// each block name by head (else encounter order).
+type kdlRender struct {
+	encounter  bool
+	sortByHead bool
+}
+
+// instances returns the children named name in render order.
+func (n *node) instances(name string, ro kdlRender) []*node {
+	list := n.Children[name]
+	if ro.sortByHead && len(list) > 1 {
+		list = append([]*node(nil), list...)
+		sort.SliceStable(list, func(i, j int) bool { return list[i].Head < list[j].Head })
+	}
+	return list
+}
+
 // renderKDL prints children in lexicographic name order; props keys sorted lex.
-// Two-space indentation.
-func (n *node) renderKDL(depth int) string {
+// With encounter, both keep their first-seen order instead. Two-space indentation.
+func (n *node) renderKDL(depth int, ro kdlRender) string {
 	if n.Name == "__root__" {
 		var sections []string
 		names := append([]string(nil), n.ChildrenOrder...)
-		sort.Strings(names)
+		if !ro.encounter {
+			sort.Strings(names)
+		}
 		for _, name := range names {
-			for _, c := range n.Children[name] {
-				sections = append(sections, c.renderKDL(depth))
+			for _, c := range n.instances(name, ro) {
+				sections = append(sections, c.renderKDL(depth, ro))
 			}
 		}
 		out := strings.Join(sections, "")
@@ -197,7 +316,9 @@ func (n *node) renderKDL(depth int) string {
 
 	// props sorted by key for determinism
 	keys := append([]string(nil), n.PropsOrder...)
-	sort.Strings(keys)
+	if !ro.encounter {
+		sort.Strings(keys)
+	}
 	for _, k := range keys {
 		vs := n.Props[k]
 		for _, v := range vs {
@@ -217,10 +338,12 @@ func (n *node) renderKDL(depth int) string {
 
 	// children sorted by name
 	chNames := append([]string(nil), n.ChildrenOrder...)
-	sort.Strings(chNames)
+	if !ro.encounter {
+		sort.Strings(chNames)
+	}
 	for _, name := range chNames {
-		for _, c := range n.Children[name] {
-			b.WriteString(c.renderKDL(depth + 1))
+		for _, c := range n.instances(name, ro) {
+			b.WriteString(c.renderKDL(depth+1, ro))
 		}
 	}
 
@@ -231,18 +354,28 @@ func (n *node) renderKDL(depth int) string {
 
 // --- parser ---
 
+// kdlDup is a property key repeated within one block of a single file.
+type kdlDup struct {
+	line       int // 1-based line of the repeat
+	block, key string
+}
+
 // Very small parser: recognizes blocks "ident [args...] {" and nested scopes.
 // Inside a block, any non-`}` / non-block-start line is a property "key value..." (raw).
 // Comments starting with '//' are stripped. Strings/escaping are not fully parsed; args and values are kept raw.
-func parseKDL(s string) (*node, error) {
+// Keys repeated inside a block (not at top level) are kept and reported as dups.
+func parseKDL(s string) (*node, []kdlDup, error) {
 	s = stripLineComments(s)
 	r := bufio.NewReader(strings.NewReader(s))
 	root := newNode("__root__", "")
 	var stack []*node
 	cur := root
+	var dups []kdlDup
+	lineNo := 0
 
 	for {
 		line, err := readLogicalLine(r)
+		lineNo++
 		if line == "" && err != nil {
 			break
 		}
@@ -257,7 +390,7 @@ func parseKDL(s string) (*node, error) {
 		// Closing brace?
 		if line == "}" {
 			if len(stack) == 0 {
-				return nil, fmt.Errorf("unmatched closing brace")
+				return nil, nil, fmt.Errorf("unmatched closing brace")
 			}
 			cur = stack[len(stack)-1]
 			stack = stack[:len(stack)-1]
@@ -281,6 +414,13 @@ func parseKDL(s string) (*node, error) {
 
 		// Otherwise it's a prop: split first token as key, rest as value (kept raw)
 		key, val := splitFirstToken(line)
+		if cur != root && len(cur.Props[key]) > 0 {
+			block := cur.Name
+			if cur.Head != "" {
+				block += " " + cur.Head
+			}
+			dups = append(dups, kdlDup{line: lineNo, block: block, key: key})
+		}
 		cur.setProp(key, val, "append") // merge policy applied later
 		if err != nil {
 			break
@@ -288,9 +428,9 @@ func parseKDL(s string) (*node, error) {
 	}
 
 	if len(stack) != 0 {
-		return nil, fmt.Errorf("unclosed block(s)")
+		return nil, nil, fmt.Errorf("unclosed block(s)")
 	}
-	return root, nil
+	return root, dups, nil
 }
 
 func stripLineComments(s string) string {
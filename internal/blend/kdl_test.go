@@ -1,6 +1,7 @@
 package blend
 
 import (
+	"bytes"
 	"path/filepath"
 	"strings"
 	"testing"
@@ -223,3 +224,204 @@ func TestKDL_NestedMerge_InMergedSection(t *testing.T) {
 		t.Fatalf("expected gaps to have size 8 and inner 2, got:\n%s", out)
 	}
 }
+
+func TestKDL_MatchProperty_CoalescesBlocks(t *testing.T) {
+	td := t.TempDir()
+	a := filepath.Join(td, "a.kdl")
+	b := filepath.Join(td, "b.kdl")
+
+	writeFileT(t, a, `
+window-rule match-app-id="firefox" {
+  open-maximized true
+}
+window-rule match-app-id="kitty" {
+  opacity 0.9
+}
+`)
+	writeFileT(t, b, `
+window-rule match-app-id="firefox" open-on-output="DP-1" {
+  open-maximized false
+  block-out-from "screencast"
+}
+`)
+
+	rules := &config.MergeRules{KDLKeys: "last_wins", KDLMatchProperty: "match-app-id"}
+	out, err := BlendKDL(rules, []string{a, b})
+	if err != nil {
+		t.Fatalf("BlendKDL error: %v", err)
+	}
+	if n := strings.Count(out, "window-rule "); n != 2 {
+		t.Fatalf("expected 2 window-rule blocks (firefox, kitty), got %d:\n%s", n, out)
+	}
+	if strings.Count(out, `match-app-id="firefox"`) != 1 {
+		t.Fatalf("firefox blocks were not coalesced:\n%s", out)
+	}
+	if !strings.Contains(out, "open-maximized false") || strings.Contains(out, "open-maximized true") {
+		t.Fatalf("expected later open-maximized to win:\n%s", out)
+	}
+	if !strings.Contains(out, `block-out-from "screencast"`) || !strings.Contains(out, "opacity 0.9") {
+		t.Fatalf("missing merged properties:\n%s", out)
+	}
+
+	// without match_property the differing heads stay separate
+	out, err = BlendKDL(&config.MergeRules{KDLKeys: "last_wins"}, []string{a, b})
+	if err != nil {
+		t.Fatalf("BlendKDL error: %v", err)
+	}
+	if n := strings.Count(out, `match-app-id="firefox"`); n != 2 {
+		t.Fatalf("expected separate firefox blocks by raw head, got %d:\n%s", n, out)
+	}
+}
+
+func TestKDL_Provenance(t *testing.T) {
+	td := t.TempDir()
+	a := filepath.Join(td, "a.kdl")
+	b := filepath.Join(td, "b.kdl")
+
+	writeFileT(t, a, `
+theme {
+  color "dark"
+  accent "blue"
+}
+output "DP-2" {
+  mode "1920x1080"
+}
+`)
+	writeFileT(t, b, `
+theme {
+  accent "cyan"
+}
+`)
+
+	for _, c := range []struct {
+		keys   string
+		accent string
+	}{
+		{"last_wins", b},
+		{"first_wins", a},
+	} {
+		prov := map[string]string{}
+		if _, err := BlendKDLWith(&config.MergeRules{KDLKeys: c.keys}, []string{a, b}, Options{Provenance: prov}); err != nil {
+			t.Fatalf("BlendKDLWith: %v", err)
+		}
+		if prov["theme.color"] != a || prov["theme.accent"] != c.accent || prov[`output["DP-2"].mode`] != a {
+			t.Fatalf("%s: provenance = %v", c.keys, prov)
+		}
+	}
+}
+
+func TestKDL_OutputOrder_Encounter(t *testing.T) {
+	td := t.TempDir()
+	a := filepath.Join(td, "a.kdl")
+	b := filepath.Join(td, "b.kdl")
+
+	writeFileT(t, a, `
+workspaces {
+  zeta "1"
+  alpha "2"
+  mid "3"
+}
+binds {
+  Mod+Q "close"
+}
+`)
+	writeFileT(t, b, `
+workspaces {
+  beta "4"
+  alpha "5"
+}
+`)
+
+	out, err := BlendKDL(&config.MergeRules{KDLOutputOrder: "encounter"}, []string{a, b})
+	if err != nil {
+		t.Fatalf("BlendKDL: %v", err)
+	}
+	want := `workspaces {
+  zeta "1"
+  alpha "5"
+  mid "3"
+  beta "4"
+}
+binds {
+  Mod+Q "close"
+}
+`
+	if out != want {
+		t.Fatalf("encounter order output:\n%s\nwant:\n%s", out, want)
+	}
+
+	// default stays lexicographic
+	out, err = BlendKDL(&config.MergeRules{}, []string{a, b})
+	if err != nil {
+		t.Fatalf("BlendKDL: %v", err)
+	}
+	if !strings.HasPrefix(out, "binds {") || !strings.Contains(out, "  alpha \"5\"\n  beta \"4\"\n  mid \"3\"\n  zeta \"1\"\n") {
+		t.Fatalf("lex order output:\n%s", out)
+	}
+}
+
+func TestKDL_SortByHead(t *testing.T) {
+	td := t.TempDir()
+	a := filepath.Join(td, "a.kdl")
+	b := filepath.Join(td, "b.kdl")
+
+	writeFileT(t, a, `
+output "DP-2" {
+  mode "2560x1440@144"
+}
+`)
+	writeFileT(t, b, `
+output "DP-1" {
+  mode "1920x1080@60"
+}
+`)
+
+	out, err := BlendKDL(&config.MergeRules{KDLSortByHead: true}, []string{a, b})
+	if err != nil {
+		t.Fatalf("BlendKDL: %v", err)
+	}
+	want := `output "DP-1" {
+  mode "1920x1080@60"
+}
+output "DP-2" {
+  mode "2560x1440@144"
+}
+`
+	if out != want {
+		t.Fatalf("sort_by_head output:\n%s\nwant:\n%s", out, want)
+	}
+
+	// default keeps encounter order of instances
+	out, err = BlendKDL(&config.MergeRules{}, []string{a, b})
+	if err != nil {
+		t.Fatalf("BlendKDL: %v", err)
+	}
+	if strings.Index(out, `"DP-2"`) > strings.Index(out, `"DP-1"`) {
+		t.Fatalf("default output reordered instances:\n%s", out)
+	}
+}
+
+func TestKDL_DuplicateKeyInBlock(t *testing.T) {
+	td := t.TempDir()
+	a := filepath.Join(td, "a.kdl")
+	writeFileT(t, a, `
+output "DP-1" {
+  mode "a"
+  scale 1
+  mode "b"
+}
+`)
+
+	var warn bytes.Buffer
+	if _, err := BlendKDLWith(&config.MergeRules{}, []string{a}, Options{Warn: &warn}); err != nil {
+		t.Fatalf("BlendKDLWith: %v", err)
+	}
+	if want := a + `:5: duplicate key "mode" in block "output \"DP-1\""`; !strings.Contains(warn.String(), want) {
+		t.Fatalf("warning = %q, want it to contain %q", warn.String(), want)
+	}
+
+	_, err := BlendKDLWith(&config.MergeRules{KDLStrictKeys: true}, []string{a}, Options{Warn: &warn})
+	if err == nil || !strings.Contains(err.Error(), a+`:5: duplicate key "mode"`) {
+		t.Fatalf("strict_keys: err = %v, want duplicate key error", err)
+	}
+}
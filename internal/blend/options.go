@@ -0,0 +1,82 @@
+package blend
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	executor "github.com/nekwebdev/confb/internal/exec"
+)
+
+// Options carries per-build inputs that are not merge rules.
+type Options struct {
+	// Encodings maps source paths to their encoding (see exec.ReadSource);
+	// unlisted files are read as UTF-8.
+	Encodings map[string]string
+
+	// MergePatch marks files applied as RFC 7396 JSON Merge Patches instead of
+	// with the target's map/array rules (structured formats only).
+	MergePatch map[string]bool
+
+	// Retry retries transient source read errors (see exec.ReadWithRetry).
+	Retry executor.ReadRetry
+
+	// Transforms maps source paths to the transformers run on their content
+	// (see exec.TransformSource); Target names the target for their context.
+	Transforms map[string][]string
+	Target     string
+
+	// Trace, when set, receives the intermediate merge state after each file:
+	// "[trace] file N: " followed by JSON (structured) or the rendered text (kdl/ini).
+	Trace io.Writer
+
+	// Warn receives merge warnings (e.g. an arrays: intersect fallback) as
+	// "confb: warning: ..." lines; nil means os.Stderr.
+	Warn io.Writer
+
+	// Provenance, when non-nil, is filled with the source file that supplied the
+	// final value of each key: dotted paths for structured formats (e.g.
+	// "services.web.image"), "section.key" for INI and KDL.
+	Provenance map[string]string
+}
+
+// read returns the UTF-8 content of a source file, transformed.
+func (o Options) read(path string) ([]byte, error) {
+	b, err := executor.ReadSourceRetry(path, o.Encodings[path], o.Retry)
+	if err != nil {
+		return nil, err
+	}
+	return executor.TransformSource(b, o.Target, path, o.Transforms[path])
+}
+
+// streamable reports whether path can be decoded straight from the file: it
+// is UTF-8 and has no transformers, which need the whole content.
+func (o Options) streamable(path string) bool {
+	return executor.NormalizeEncoding(o.Encodings[path]) == "utf8" && len(o.Transforms[path]) == 0
+}
+
+// open opens a source file for streaming, retrying a transient open error.
+func (o Options) open(path string) (*os.File, error) {
+	return executor.OpenWithRetry(path, o.Retry.Attempts, o.Retry.Backoff)
+}
+
+// warn writes a merge warning to o.Warn.
+func (o Options) warn(format string, a ...any) {
+	w := o.Warn
+	if w == nil {
+		w = os.Stderr
+	}
+	fmt.Fprintf(w, "confb: warning: "+format+"\n", a...)
+}
+
+// traceText writes a rendered intermediate state, indented under its trace line.
+func (o Options) traceText(n int, path, rendered string) {
+	if o.Trace == nil {
+		return
+	}
+	fmt.Fprintf(o.Trace, "[trace] file %d: %s\n", n, path)
+	for _, line := range strings.Split(strings.TrimRight(rendered, "\n"), "\n") {
+		fmt.Fprintf(o.Trace, "  %s\n", line)
+	}
+}
@@ -3,54 +3,113 @@
 import (
 	"encoding/json"
 	"fmt"
-	"os"
+	"io"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/pelletier/go-toml/v2"
 	"gopkg.in/yaml.v3"
 
 	"github.com/nekwebdev/confb/internal/config"
+	executor "github.com/nekwebdev/confb/internal/exec"
 )
 
 // BlendStructured reads all files, parses them as YAML/JSON/TOML, merges per rules,
 // then returns the serialized result in the same format.
 func BlendStructured(format string, rules *config.MergeRules, files []string) (string, error) {
+	return BlendStructuredAs(format, format, rules, files, Options{})
+}
+
+// BlendStructuredAs is BlendStructured with distinct input and output formats:
+// sources are parsed as inFormat and the merged result is serialized as outFormat.
+func BlendStructuredAs(inFormat, outFormat string, rules *config.MergeRules, files []string, opts Options) (string, error) {
 	if rules == nil {
 		return "", fmt.Errorf("merge rules required")
 	}
-	f := strings.ToLower(format)
+	f := strings.ToLower(inFormat)
 
 	var acc any = nil
-	for _, path := range files {
-		b, err := os.ReadFile(path)
-		if err != nil {
-			return "", fmt.Errorf("read %q: %w", path, err)
-		}
-		if len(strings.TrimSpace(string(b))) == 0 {
-			continue
-		}
-
+	inlinePaths := map[string]struct{}{} // toml_preserve_inline: keys written as inline tables
+	warn := onceWarn(opts.warn)
+	// json_preserve_order: only for json → json, json.Number would not survive other encoders
+	var keyOrder *jsonKeyOrder
+	if rules.JSONPreserveOrder && f == "json" && strings.EqualFold(outFormat, "json") {
+		keyOrder = newJSONKeyOrder()
+	}
+	for i, path := range files {
 		var doc any
-		switch f {
-		case "yaml":
-			if err := yaml.Unmarshal(b, &doc); err != nil {
-			 return "", fmt.Errorf("parse YAML %q: %w", path, err)
+		// yaml and json decode straight from the file; toml (go-toml has no
+		// streaming decoder), ordered json and transformed or re-encoded
+		// sources need the whole content and stay buffered
+		if (f == "yaml" || f == "json") && keyOrder == nil && opts.streamable(path) {
+			var ok bool
+			var err error
+			if doc, ok, err = decodeStream(f, path, opts); err != nil {
+				return "", err
 			}
-		case "json":
-			if err := json.Unmarshal(b, &doc); err != nil {
-			 return "", fmt.Errorf("parse JSON %q: %w", path, err)
+			if !ok {
+				traceJSON(opts.Trace, i+1, acc)
+				continue
 			}
-		case "toml":
-			if err := toml.Unmarshal(b, &doc); err != nil {
-				return "", fmt.Errorf("parse TOML %q: %w", path, err)
+		} else {
+			b, err := opts.read(path)
+			if err != nil {
+				return "", fmt.Errorf("read %q: %w", path, err)
+			}
+			// the decoders would drop or replace bad bytes without saying where
+			if err := executor.CheckUTF8(path, b); err != nil {
+				return "", err
+			}
+			if len(strings.TrimSpace(string(b))) == 0 {
+				traceJSON(opts.Trace, i+1, acc)
+				continue
+			}
+
+			switch f {
+			case "yaml":
+				if err := yaml.Unmarshal(b, &doc); err != nil {
+				 return "", fmt.Errorf("parse YAML %q: %w", path, err)
+				}
+			case "json":
+				if keyOrder != nil {
+					if doc, err = decodeJSONOrdered(b, keyOrder); err != nil {
+						return "", fmt.Errorf("parse JSON %q: %w", path, err)
+					}
+				} else if err := json.Unmarshal(b, &doc); err != nil {
+				 return "", fmt.Errorf("parse JSON %q: %w", path, err)
+				}
+			case "toml":
+				if err := toml.Unmarshal(b, &doc); err != nil {
+					return "", fmt.Errorf("parse TOML %q: %w", path, err)
+				}
+				// go-toml returns map[string]any / []any compatible with our merger
+				if rules.TOMLPreserveInline {
+					paths, err := scanTOMLInline(path)
+					if err != nil {
+						return "", err
+					}
+					for _, p := range paths {
+						inlinePaths[p] = struct{}{}
+					}
+				}
+			default:
+				return "", fmt.Errorf("unsupported format for BlendStructured: %s", inFormat)
 			}
-			// go-toml returns map[string]any / []any compatible with our merger
-		default:
-			return "", fmt.Errorf("unsupported format for BlendStructured: %s", format)
 		}
 
-		acc = mergeAny(acc, doc, rules)
+		if opts.MergePatch[path] {
+			acc = mergePatch(acc, doc)
+		} else {
+			acc = mergeAny(acc, doc, rules, warn, 1)
+		}
+		if opts.Provenance != nil {
+			recordProvenance(opts.Provenance, "", doc, path)
+		}
+		traceJSON(opts.Trace, i+1, acc)
+	}
+	if opts.Provenance != nil {
+		pruneProvenance(opts.Provenance, acc)
 	}
 
 	// default empty doc
@@ -58,21 +117,39 @@ func BlendStructured(format string, rules *config.MergeRules, files []string) (s
 		acc = map[string]any{}
 	}
 
-	switch f {
+	switch strings.ToLower(outFormat) {
 	case "yaml":
-		out, err := yaml.Marshal(acc)
+		out, err := marshalYAML(acc, strings.EqualFold(rules.YAMLStyle, "flow"), strings.ToLower(rules.YAMLScalarStyle))
 		if err != nil { return "", fmt.Errorf("marshal YAML: %w", err) }
 		s := string(out)
 		if !strings.HasSuffix(s, "\n") { s += "\n" }
 		return s, nil
 	case "json":
-		out, err := json.MarshalIndent(acc, "", "  ")
+		indent := "  "
+		if rules.JSONIndent != nil {
+			indent = *rules.JSONIndent
+		}
+		var out []byte
+		var err error
+		if keyOrder != nil {
+			out, err = marshalJSONOrdered(acc, keyOrder, indent)
+		} else if indent == "" {
+			out, err = json.Marshal(acc)
+		} else {
+			out, err = json.MarshalIndent(acc, "", indent)
+		}
 		if err != nil { return "", fmt.Errorf("marshal JSON: %w", err) }
 		s := string(out)
 		if !strings.HasSuffix(s, "\n") { s += "\n" }
 		return s, nil
 	case "toml":
-		out, err := toml.Marshal(acc)
+		var out []byte
+		var err error
+		if strings.EqualFold(rules.TOMLOutputStyle, "compact") {
+			out, err = marshalTOMLCompact(acc)
+		} else {
+			out, err = marshalTOMLInline(acc, inlinePaths)
+		}
 		if err != nil { return "", fmt.Errorf("marshal TOML: %w", err) }
 		s := string(out)
 		if !strings.HasSuffix(s, "\n") { s += "\n" }
@@ -82,11 +159,254 @@ func BlendStructured(format string, rules *config.MergeRules, files []string) (s
 	}
 }
 
+// decodeStream decodes the first YAML document or the JSON value of path
+// while reading it, without holding the raw file in memory. ok is false for a
+// file with no document (empty, blank or only comments).
+func decodeStream(format, path string, opts Options) (doc any, ok bool, err error) {
+	fh, err := opts.open(path)
+	if err != nil {
+		return nil, false, fmt.Errorf("read %q: %w", path, err)
+	}
+	defer fh.Close()
+	r := executor.NewUTF8Reader(path, fh)
+
+	if format == "yaml" {
+		err = yaml.NewDecoder(r).Decode(&doc)
+	} else {
+		dec := json.NewDecoder(r)
+		if doc, err = decodeJSONTokens(dec); err == nil {
+			// json.Unmarshal semantics: nothing but whitespace may follow
+			if _, terr := dec.Token(); terr != io.EOF {
+				err = fmt.Errorf("invalid data after top-level value")
+			}
+		}
+	}
+	switch {
+	case r.Err() != nil:
+		return nil, false, r.Err()
+	case err == io.EOF:
+		return nil, false, nil
+	case err != nil:
+		return nil, false, fmt.Errorf("parse %s %q: %w", strings.ToUpper(format), path, err)
+	}
+	return doc, true, nil
+}
+
+// decodeJSONTokens builds the value json.Unmarshal would from dec's tokens;
+// unlike dec.Decode it never buffers the whole value. io.EOF means no value.
+func decodeJSONTokens(dec *json.Decoder) (any, error) {
+	var walk func() (any, error)
+	walk = func() (any, error) {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		d, ok := tok.(json.Delim)
+		if !ok {
+			return tok, nil // string, float64, bool or nil
+		}
+		switch d {
+		case '{':
+			m := map[string]any{}
+			for dec.More() {
+				kt, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				v, err := walk()
+				if err != nil {
+					return nil, noEOF(err)
+				}
+				m[kt.(string)] = v
+			}
+			_, err = dec.Token() // closing delimiter
+			return m, noEOF(err)
+		case '[':
+			l := []any{}
+			for dec.More() {
+				v, err := walk()
+				if err != nil {
+					return nil, noEOF(err)
+				}
+				l = append(l, v)
+			}
+			_, err = dec.Token()
+			return l, noEOF(err)
+		}
+		return nil, fmt.Errorf("unexpected %v", d)
+	}
+	return walk()
+}
+
+// noEOF turns an io.EOF inside a value into io.ErrUnexpectedEOF.
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// marshalYAML serializes v as YAML; with flow, every mapping and sequence is
+// rendered in flow style, which puts the whole document on a single line.
+// scalarStyle (double_quoted|single_quoted|literal) forces that style on every
+// string value; "" or "any" leaves the choice to go-yaml.
+func marshalYAML(v any, flow bool, scalarStyle string) ([]byte, error) {
+	style, forceScalars := yamlScalarStyles[scalarStyle]
+	if !flow && !forceScalars {
+		return yaml.Marshal(v)
+	}
+	var n yaml.Node
+	if err := n.Encode(v); err != nil {
+		return nil, err
+	}
+	if flow {
+		setFlowStyle(&n)
+	}
+	if forceScalars {
+		setScalarStyle(&n, style)
+	}
+	return yaml.Marshal(&n)
+}
+
+func setFlowStyle(n *yaml.Node) {
+	if n.Kind == yaml.MappingNode || n.Kind == yaml.SequenceNode {
+		n.Style |= yaml.FlowStyle
+	}
+	for _, c := range n.Content {
+		setFlowStyle(c)
+	}
+}
+
+var yamlScalarStyles = map[string]yaml.Style{
+	"double_quoted": yaml.DoubleQuotedStyle,
+	"single_quoted": yaml.SingleQuotedStyle,
+	"literal":       yaml.LiteralStyle,
+}
+
+// setScalarStyle sets style on every string value below n. Mapping keys keep
+// go-yaml's (deterministic) choice, since a literal block cannot be a key.
+func setScalarStyle(n *yaml.Node, style yaml.Style) {
+	for i, c := range n.Content {
+		if n.Kind == yaml.MappingNode && i%2 == 0 {
+			continue
+		}
+		if c.Kind == yaml.ScalarNode && c.Tag == "!!str" {
+			c.Style = style
+		}
+		setScalarStyle(c, style)
+	}
+}
+
+// mergePatch applies patch to base per RFC 7396 (JSON Merge Patch): a null value
+// deletes the key, an object recurses, anything else replaces the value outright.
+func mergePatch(base, patch any) any {
+	pm, ok := toStringMap(patch)
+	if !ok {
+		return clone(patch)
+	}
+	out := map[string]any{}
+	if bm, ok := toStringMap(base); ok {
+		for k, v := range bm {
+			out[k] = clone(v)
+		}
+	}
+	for k, v := range pm {
+		if v == nil {
+			delete(out, k)
+			continue
+		}
+		out[k] = mergePatch(out[k], v)
+	}
+	return out
+}
+
+// recordProvenance attributes every leaf of doc (scalars, arrays, empty maps)
+// under prefix to file.
+func recordProvenance(prov map[string]string, prefix string, doc any, file string) {
+	m, ok := toStringMap(doc)
+	if !ok || len(m) == 0 {
+		if prefix != "" {
+			prov[prefix] = file
+		}
+		return
+	}
+	for k, v := range m {
+		p := k
+		if prefix != "" {
+			p = prefix + "." + k
+		}
+		recordProvenance(prov, p, v, file)
+	}
+}
+
+// pruneProvenance drops paths that are not leaves of the merged result (keys
+// removed by a later map replace, a merge patch, or a type change).
+func pruneProvenance(prov map[string]string, acc any) {
+	leaves := map[string]struct{}{}
+	var walk func(prefix string, v any)
+	walk = func(prefix string, v any) {
+		m, ok := toStringMap(v)
+		if !ok || len(m) == 0 {
+			leaves[prefix] = struct{}{}
+			return
+		}
+		for k, v2 := range m {
+			p := k
+			if prefix != "" {
+				p = prefix + "." + k
+			}
+			walk(p, v2)
+		}
+	}
+	walk("", acc)
+	for p := range prov {
+		if _, ok := leaves[p]; !ok {
+			delete(prov, p)
+		}
+	}
+}
+
+// traceJSON writes the accumulator after file n as a single JSON line.
+func traceJSON(w io.Writer, n int, acc any) {
+	if w == nil {
+		return
+	}
+	b, err := json.Marshal(acc)
+	if err != nil {
+		fmt.Fprintf(w, "[trace] file %d: <unencodable: %v>\n", n, err)
+		return
+	}
+	fmt.Fprintf(w, "[trace] file %d: %s\n", n, b)
+}
+
+// onceWarn wraps warn so that a message repeated within one blend is written once.
+func onceWarn(warn func(string, ...any)) func(string, ...any) {
+	seen := map[string]bool{}
+	return func(format string, a ...any) {
+		msg := fmt.Sprintf(format, a...)
+		if !seen[msg] {
+			seen[msg] = true
+			warn("%s", msg)
+		}
+	}
+}
+
 // --- merging primitives (unchanged) ---
 
-func mergeAny(base, next any, rules *config.MergeRules) any {
+// mergeAny merges next over base; depth is the nesting level of the pair (1
+// for documents). Below max_depth values are no longer merged but replaced,
+// so a pathologically deep document cannot recurse without bound.
+func mergeAny(base, next any, rules *config.MergeRules, warn func(string, ...any), depth int) any {
 	if base == nil { return clone(next) }
 	if next == nil { return base }
+	limit := rules.MaxDepth
+	if limit <= 0 {
+		limit = config.DefaultMaxMergeDepth
+	}
+	if depth > limit {
+		warn("merge depth exceeds max_depth %d; deeper values replace instead of merging", limit)
+		return clone(next)
+	}
 
 	switch b := base.(type) {
 	case map[string]any:
@@ -98,8 +418,20 @@ func mergeAny(base, next any, rules *config.MergeRules) any {
 		out := make(map[string]any, len(b)+len(nmap))
 		for k, v := range b { out[k] = clone(v) }
 		for k, v2 := range nmap {
+			if v2 == nil {
+				switch strings.ToLower(rules.NullStrategy) {
+				case "replace":
+					out[k] = nil
+					continue
+				case "delete":
+					delete(out, k)
+					continue
+				case "ignore":
+					continue
+				}
+			}
 			if v1, exists := out[k]; exists {
-				out[k] = mergeAny(v1, v2, rules)
+				out[k] = mergeAny(v1, v2, rules, warn, depth+1)
 			} else {
 				out[k] = clone(v2)
 			}
@@ -114,6 +446,12 @@ func mergeAny(base, next any, rules *config.MergeRules) any {
 			return append(cloneSlice(b), cloneSlice(narr)...)
 		case "unique_append":
 			return uniqueAppend(cloneSlice(b), cloneSlice(narr))
+		case "intersect":
+			if out, ok := intersect(b, narr); ok {
+				return out
+			}
+			warn("arrays: intersect needs scalar elements (got a map or list); using replace")
+			return clone(narr)
 		default:
 			return clone(narr) // replace
 		}
@@ -153,7 +491,7 @@ func clone(v any) any {
 		return out
 	case []any:
 		return cloneSlice(t)
-	default:
+	default: // scalars, including TOML datetimes (time.Time is a value)
 		return t
 	}
 }
@@ -168,51 +506,8 @@ func uniqueAppend(a, b []any) []any {
 	out := make([]any, 0, len(a)+len(b))
 	seen := map[string]struct{}{}
 
-	key := func(x any) (string, bool) {
-		switch v := x.(type) {
-		case string:
-			return "s:" + v, true
-		case bool:
-			if v {
-				return "b:1", true
-			}
-			return "b:0", true
-		case nil:
-			return "n:", true
-
-		// numeric (TOML often yields int64; JSON/YAML often yield float64)
-		case int:
-			return fmt.Sprintf("i:%d", v), true
-		case int8:
-			return fmt.Sprintf("i:%d", v), true
-		case int16:
-			return fmt.Sprintf("i:%d", v), true
-		case int32:
-			return fmt.Sprintf("i:%d", v), true
-		case int64:
-			return fmt.Sprintf("i:%d", v), true
-		case uint:
-			return fmt.Sprintf("u:%d", v), true
-		case uint8:
-			return fmt.Sprintf("u:%d", v), true
-		case uint16:
-			return fmt.Sprintf("u:%d", v), true
-		case uint32:
-			return fmt.Sprintf("u:%d", v), true
-		case uint64:
-			return fmt.Sprintf("u:%d", v), true
-		case float32:
-			return fmt.Sprintf("f:%g", float64(v)), true
-		case float64:
-			return fmt.Sprintf("f:%g", v), true
-		default:
-			// composite/unknown → don’t attempt to dedup (preserve order)
-			return "", false
-		}
-	}
-
 	for _, x := range a {
-		if k, ok := key(x); ok {
+		if k, ok := scalarKey(x); ok {
 			if _, exists := seen[k]; exists {
 				continue
 			}
@@ -221,7 +516,7 @@ func uniqueAppend(a, b []any) []any {
 		out = append(out, clone(x))
 	}
 	for _, x := range b {
-		if k, ok := key(x); ok {
+		if k, ok := scalarKey(x); ok {
 			if _, exists := seen[k]; exists {
 				continue
 			}
@@ -232,6 +527,90 @@ func uniqueAppend(a, b []any) []any {
 	return out
 }
 
+// scalarKey identifies a scalar for unique_append and intersect; composites
+// (maps, lists) and unknown types have no key.
+func scalarKey(x any) (string, bool) {
+	switch v := x.(type) {
+	case string:
+		return "s:" + v, true
+	case bool:
+		if v {
+			return "b:1", true
+		}
+		return "b:0", true
+	case nil:
+		return "n:", true
+
+	// numeric (TOML often yields int64; JSON/YAML often yield float64)
+	case int:
+		return fmt.Sprintf("i:%d", v), true
+	case int8:
+		return fmt.Sprintf("i:%d", v), true
+	case int16:
+		return fmt.Sprintf("i:%d", v), true
+	case int32:
+		return fmt.Sprintf("i:%d", v), true
+	case int64:
+		return fmt.Sprintf("i:%d", v), true
+	case uint:
+		return fmt.Sprintf("u:%d", v), true
+	case uint8:
+		return fmt.Sprintf("u:%d", v), true
+	case uint16:
+		return fmt.Sprintf("u:%d", v), true
+	case uint32:
+		return fmt.Sprintf("u:%d", v), true
+	case uint64:
+		return fmt.Sprintf("u:%d", v), true
+	case float32:
+		return fmt.Sprintf("f:%g", float64(v)), true
+	case float64:
+		return fmt.Sprintf("f:%g", v), true
+	case json.Number: // json_preserve_order
+		if f, err := v.Float64(); err == nil {
+			return fmt.Sprintf("f:%g", f), true
+		}
+		return "num:" + v.String(), true
+
+	// TOML datetimes: offset datetimes compare as instants, local ones by text
+	case time.Time:
+		return "t:" + v.UTC().Format(time.RFC3339Nano), true
+	case toml.LocalDateTime:
+		return "ldt:" + v.String(), true
+	case toml.LocalDate:
+		return "ld:" + v.String(), true
+	case toml.LocalTime:
+		return "lt:" + v.String(), true
+	default:
+		// composite/unknown → don’t attempt to dedup (preserve order)
+		return "", false
+	}
+}
+
+// intersect keeps the elements of a (in order) whose scalarKey also occurs in
+// b; ok is false if either side holds an element without a key.
+func intersect(a, b []any) (out []any, ok bool) {
+	inB := make(map[string]struct{}, len(b))
+	for _, x := range b {
+		k, ok := scalarKey(x)
+		if !ok {
+			return nil, false
+		}
+		inB[k] = struct{}{}
+	}
+	out = []any{}
+	for _, x := range a {
+		k, ok := scalarKey(x)
+		if !ok {
+			return nil, false
+		}
+		if _, found := inB[k]; found {
+			out = append(out, clone(x))
+		}
+	}
+	return out, true
+}
+
 func guessFormatByExt(path string) string {
 	ext := strings.ToLower(filepath.Ext(path))
 	switch ext {
@@ -0,0 +1,292 @@
+package blend
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+)
+
+// TOML inline-table preservation (rules.toml_preserve_inline).
+//
+// go-toml renders every map as a [table] block. To keep `key = {a = 1, b = 2}`
+// style, we pre-scan the sources for keys written as inline tables, swap those
+// (flat) maps for placeholder strings before marshalling, then substitute the
+// placeholders with an inline rendering of the map.
+
+// scanTOMLInline returns the dotted paths of keys written as inline tables.
+// The scan is line-based: `[a.b]` headers set the current table, `key = {`
+// lines (key may be dotted) inside it are recorded. Arrays of tables are skipped.
+func scanTOMLInline(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("read %q: %w", path, err)
+	}
+	defer f.Close()
+
+	var out []string
+	var table []string
+	inArray := false
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[[") {
+			inArray = true
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			end := strings.Index(line, "]")
+			if end < 0 {
+				continue
+			}
+			inArray = false
+			table = splitTOMLKey(line[1:end])
+			continue
+		}
+		if inArray {
+			continue
+		}
+		eq := strings.Index(line, "=")
+		if eq <= 0 || !strings.HasPrefix(strings.TrimSpace(line[eq+1:]), "{") {
+			continue
+		}
+		key := append(append([]string(nil), table...), splitTOMLKey(line[:eq])...)
+		out = append(out, strings.Join(key, "\x00"))
+	}
+	return out, sc.Err()
+}
+
+// splitTOMLKey splits a (possibly dotted, possibly quoted) key into its parts.
+func splitTOMLKey(s string) []string {
+	var parts []string
+	var cur strings.Builder
+	var quote rune
+	for _, r := range strings.TrimSpace(s) {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				cur.WriteRune(r)
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '.':
+			parts = append(parts, strings.TrimSpace(cur.String()))
+			cur.Reset()
+		default:
+			cur.WriteRune(r)
+		}
+	}
+	return append(parts, strings.TrimSpace(cur.String()))
+}
+
+// marshalTOMLInline marshals acc, rendering the given paths as inline tables
+// when their merged value is a flat map (no nested tables).
+func marshalTOMLInline(acc any, paths map[string]struct{}) ([]byte, error) {
+	root, ok := acc.(map[string]any)
+	if !ok || len(paths) == 0 {
+		return toml.Marshal(acc)
+	}
+	root = clone(root).(map[string]any)
+
+	keys := make([]string, 0, len(paths))
+	for p := range paths {
+		keys = append(keys, p)
+	}
+	sort.Strings(keys)
+
+	inline := map[string]string{} // quoted placeholder -> inline rendering
+	for i, p := range keys {
+		parts := strings.Split(p, "\x00")
+		parent := root
+		for _, k := range parts[:len(parts)-1] {
+			next, ok := parent[k].(map[string]any)
+			if !ok {
+				parent = nil
+				break
+			}
+			parent = next
+		}
+		if parent == nil {
+			continue
+		}
+		last := parts[len(parts)-1]
+		m, ok := parent[last].(map[string]any)
+		if !ok || !isFlatMap(m) {
+			continue
+		}
+		rendered, err := renderInlineTable(m)
+		if err != nil {
+			return nil, err
+		}
+		ph := fmt.Sprintf("__confb_inline_%d__", i)
+		parent[last] = ph
+		inline["'"+ph+"'"] = rendered
+	}
+
+	out, err := toml.Marshal(root)
+	if err != nil {
+		return nil, err
+	}
+	s := string(out)
+	for ph, rendered := range inline {
+		s = strings.Replace(s, ph, rendered, 1)
+	}
+	return []byte(s), nil
+}
+
+// isFlatMap reports whether m holds no tables (maps or arrays of maps).
+func isFlatMap(m map[string]any) bool {
+	for _, v := range m {
+		switch t := v.(type) {
+		case map[string]any:
+			return false
+		case []any:
+			for _, x := range t {
+				if _, ok := x.(map[string]any); ok {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+// renderInlineTable renders m as `{k = v, ...}` with keys sorted.
+func renderInlineTable(m map[string]any) (string, error) {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		b, err := toml.Marshal(map[string]any{k: m[k]})
+		if err != nil {
+			return "", err
+		}
+		parts = append(parts, strings.TrimSpace(string(b)))
+	}
+	return "{" + strings.Join(parts, ", ") + "}", nil
+}
+
+// marshalTOMLCompact marshals acc with every table (nested maps and arrays of
+// maps) written inline under its top-level key, so no [section] headers remain.
+func marshalTOMLCompact(acc any) ([]byte, error) {
+	root, ok := acc.(map[string]any)
+	if !ok {
+		return toml.Marshal(acc)
+	}
+	root = clone(root).(map[string]any)
+
+	keys := make([]string, 0, len(root))
+	for k := range root {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	inline := map[string]string{} // quoted placeholder -> inline rendering
+	for i, k := range keys {
+		if !hasTable(root[k]) {
+			continue
+		}
+		rendered, err := renderInlineValue(root[k])
+		if err != nil {
+			return nil, err
+		}
+		ph := fmt.Sprintf("__confb_inline_%d__", i)
+		root[k] = ph
+		inline["'"+ph+"'"] = rendered
+	}
+
+	out, err := toml.Marshal(root)
+	if err != nil {
+		return nil, err
+	}
+	s := string(out)
+	for ph, rendered := range inline {
+		s = strings.Replace(s, ph, rendered, 1)
+	}
+	return []byte(s), nil
+}
+
+// hasTable reports whether v is a map or an array holding one.
+func hasTable(v any) bool {
+	switch t := v.(type) {
+	case map[string]any:
+		return true
+	case []any:
+		for _, x := range t {
+			if hasTable(x) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// renderInlineValue renders v as a TOML value, maps as nested inline tables
+// with keys sorted.
+func renderInlineValue(v any) (string, error) {
+	switch t := v.(type) {
+	case map[string]any:
+		keys := make([]string, 0, len(t))
+		for k := range t {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		parts := make([]string, 0, len(keys))
+		for _, k := range keys {
+			if !hasTable(t[k]) {
+				b, err := toml.Marshal(map[string]any{k: t[k]})
+				if err != nil {
+					return "", err
+				}
+				parts = append(parts, strings.TrimSpace(string(b)))
+				continue
+			}
+			val, err := renderInlineValue(t[k])
+			if err != nil {
+				return "", err
+			}
+			key, err := renderTOMLKey(k)
+			if err != nil {
+				return "", err
+			}
+			parts = append(parts, key+" = "+val)
+		}
+		return "{" + strings.Join(parts, ", ") + "}", nil
+	case []any:
+		parts := make([]string, 0, len(t))
+		for _, x := range t {
+			s, err := renderInlineValue(x)
+			if err != nil {
+				return "", err
+			}
+			parts = append(parts, s)
+		}
+		return "[" + strings.Join(parts, ", ") + "]", nil
+	default:
+		b, err := toml.Marshal(map[string]any{"v": t})
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(string(b)), "v =")), nil
+	}
+}
+
+// renderTOMLKey renders k as a bare key when possible, quoted otherwise.
+func renderTOMLKey(k string) (string, error) {
+	b, err := toml.Marshal(map[string]any{k: 0})
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(strings.TrimSpace(string(b)), " = 0"), nil
+}
@@ -3,7 +3,9 @@
 import (
 	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/nekwebdev/confb/internal/config"
 	"github.com/pelletier/go-toml/v2"
@@ -105,3 +107,203 @@ func TestTOML_MapsReplace_ArraysReplace(t *testing.T) {
 		t.Fatalf("svc.nest = %#v, want {k:over x:42}", nest)
 	}
 }
+
+func TestTOML_PreserveInlineTables(t *testing.T) {
+	td := t.TempDir()
+	base := filepath.Join(td, "base.toml")
+	over := filepath.Join(td, "overlay.toml")
+
+	writeFileT(t, base, `
+name = "app"
+config = {host = "localhost", port = 5432}
+
+[server]
+tls = {enabled = false}
+`)
+	// overlay writes server.tls as a block; inline still wins for the flat result
+	writeFileT(t, over, `
+[server.tls]
+enabled = true
+`)
+
+	rules := &config.MergeRules{Maps: "deep", Arrays: "replace", TOMLPreserveInline: true}
+	out, err := BlendStructured("toml", rules, []string{base, over})
+	if err != nil {
+		t.Fatalf("BlendStructured(toml) error: %v", err)
+	}
+	if !strings.Contains(out, "config = {host = 'localhost', port = 5432}") {
+		t.Fatalf("config not kept inline:\n%s", out)
+	}
+	if !strings.Contains(out, "tls = {enabled = true}") {
+		t.Fatalf("server.tls not kept inline:\n%s", out)
+	}
+	if strings.Contains(out, "[config]") || strings.Contains(out, "[server.tls]") {
+		t.Fatalf("inline tables also rendered as blocks:\n%s", out)
+	}
+	var doc map[string]any
+	if err := toml.Unmarshal([]byte(out), &doc); err != nil {
+		t.Fatalf("output is not valid TOML: %v\n%s", err, out)
+	}
+
+	// without the rule, go-toml's block style is kept
+	rules.TOMLPreserveInline = false
+	out, err = BlendStructured("toml", rules, []string{base, over})
+	if err != nil {
+		t.Fatalf("BlendStructured(toml) error: %v", err)
+	}
+	if !strings.Contains(out, "[config]") {
+		t.Fatalf("expected block table without toml_preserve_inline:\n%s", out)
+	}
+}
+
+func TestTOML_Datetimes_UniqueAppendRoundTrip(t *testing.T) {
+	td := t.TempDir()
+	base := filepath.Join(td, "base.toml")
+	over := filepath.Join(td, "overlay.toml")
+
+	writeFileT(t, base, `
+created_at = 2024-01-15T10:00:00Z
+windows = [2024-01-15T10:00:00Z, 2024-02-01T08:30:00.5+02:00]
+days = [2024-01-15]
+`)
+	writeFileT(t, over, `
+windows = [2024-02-01T06:30:00.5Z, 2024-03-01T00:00:00Z]
+days = [2024-01-15, 2024-01-16]
+`)
+
+	rules := &config.MergeRules{Maps: "deep", Arrays: "unique_append"}
+	out, err := BlendStructured("toml", rules, []string{base, over})
+	if err != nil {
+		t.Fatalf("BlendStructured(toml) error: %v", err)
+	}
+
+	var got struct {
+		CreatedAt time.Time        `toml:"created_at"`
+		Windows   []time.Time      `toml:"windows"`
+		Days      []toml.LocalDate `toml:"days"`
+	}
+	if err := toml.Unmarshal([]byte(out), &got); err != nil {
+		t.Fatalf("unmarshal result: %v\nout:\n%s", err, out)
+	}
+	if want := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC); !got.CreatedAt.Equal(want) {
+		t.Fatalf("created_at = %v, want %v", got.CreatedAt, want)
+	}
+	// 2024-02-01T08:30:00.5+02:00 and 2024-02-01T06:30:00.5Z are the same instant
+	want := []time.Time{
+		time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC),
+		time.Date(2024, 2, 1, 6, 30, 0, 5e8, time.UTC),
+		time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+	}
+	if len(got.Windows) != len(want) {
+		t.Fatalf("windows = %v, want %v\nout:\n%s", got.Windows, want, out)
+	}
+	for i := range want {
+		if !got.Windows[i].Equal(want[i]) {
+			t.Fatalf("windows[%d] = %v, want %v", i, got.Windows[i], want[i])
+		}
+	}
+	if _, off := got.Windows[1].Zone(); off != 2*3600 {
+		t.Fatalf("windows[1] lost its offset: %v", got.Windows[1])
+	}
+	if len(got.Days) != 2 || got.Days[0].String() != "2024-01-15" || got.Days[1].String() != "2024-01-16" {
+		t.Fatalf("days = %v, want [2024-01-15 2024-01-16]", got.Days)
+	}
+}
+
+func TestTOML_Datetimes_OutsideUnixNanoRange(t *testing.T) {
+	td := t.TempDir()
+	base := filepath.Join(td, "base.toml")
+	over := filepath.Join(td, "overlay.toml")
+
+	// both ends lie outside the years UnixNano can represent (1678–2262)
+	writeFileT(t, base, `
+[app]
+first = 0001-01-01T00:00:00Z
+last = 9999-12-31T00:00:00Z
+windows = [0001-01-01T00:00:00Z, 9999-12-31T00:00:00Z]
+`)
+	writeFileT(t, over, `
+[app]
+name = "x"
+windows = [9999-12-31T00:00:00Z, 0001-01-01T00:00:00.5Z]
+`)
+
+	rules := &config.MergeRules{Maps: "deep", Arrays: "unique_append"}
+	out, err := BlendStructured("toml", rules, []string{base, over})
+	if err != nil {
+		t.Fatalf("BlendStructured(toml) error: %v", err)
+	}
+
+	var got struct {
+		App struct {
+			First   time.Time   `toml:"first"`
+			Last    time.Time   `toml:"last"`
+			Windows []time.Time `toml:"windows"`
+		} `toml:"app"`
+	}
+	if err := toml.Unmarshal([]byte(out), &got); err != nil {
+		t.Fatalf("unmarshal result: %v\nout:\n%s", err, out)
+	}
+	year1 := time.Date(1, 1, 1, 0, 0, 0, 0, time.UTC)
+	year9999 := time.Date(9999, 12, 31, 0, 0, 0, 0, time.UTC)
+	if !got.App.First.Equal(year1) || !got.App.Last.Equal(year9999) {
+		t.Fatalf("first, last = %v, %v; want %v, %v", got.App.First, got.App.Last, year1, year9999)
+	}
+	want := []time.Time{year1, year9999, time.Date(1, 1, 1, 0, 0, 0, 5e8, time.UTC)}
+	if len(got.App.Windows) != len(want) {
+		t.Fatalf("windows = %v, want %v\nout:\n%s", got.App.Windows, want, out)
+	}
+	for i := range want {
+		if !got.App.Windows[i].Equal(want[i]) {
+			t.Fatalf("windows[%d] = %v, want %v", i, got.App.Windows[i], want[i])
+		}
+	}
+}
+
+func TestTOML_OutputStyleCompact(t *testing.T) {
+	td := t.TempDir()
+	src := filepath.Join(td, "a.toml")
+	writeFileT(t, src, `
+title = "x"
+
+[server]
+host = "localhost"
+port = 8080
+
+[server.tls]
+enabled = true
+
+[[plugins]]
+name = "a"
+
+[[plugins]]
+name = "b"
+`)
+
+	rules := &config.MergeRules{Maps: "deep", Arrays: "replace", TOMLOutputStyle: "compact"}
+	out, err := BlendStructured("toml", rules, []string{src})
+	if err != nil {
+		t.Fatalf("BlendStructured(toml) error: %v", err)
+	}
+	for _, line := range strings.Split(out, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "[") {
+			t.Fatalf("compact output has a table header %q:\n%s", line, out)
+		}
+	}
+	if !strings.Contains(out, `server = {host = 'localhost', port = 8080, tls = {enabled = true}}`) {
+		t.Fatalf("server not rendered inline:\n%s", out)
+	}
+
+	var got, want map[string]any
+	if err := toml.Unmarshal([]byte(out), &got); err != nil {
+		t.Fatalf("compact output does not parse: %v\n%s", err, out)
+	}
+	std, err := BlendStructured("toml", &config.MergeRules{Maps: "deep", Arrays: "replace"}, []string{src})
+	if err != nil {
+		t.Fatalf("standard style: %v", err)
+	}
+	_ = toml.Unmarshal([]byte(std), &want)
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("compact output changed the data:\n got %#v\nwant %#v", got, want)
+	}
+}
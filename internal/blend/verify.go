@@ -0,0 +1,65 @@
+package blend
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// VerifyOutput re-parses b, a written output, as format (verify_output).
+// Formats without a parser (raw, auto) always pass. INI is checked more
+// strictly than BlendINI reads it: every line must be blank, a comment, a
+// [section] header or key=value.
+func VerifyOutput(format string, b []byte) error {
+	var err error
+	switch f := strings.ToLower(format); f {
+	case "yaml", "yml":
+		var v any
+		dec := yaml.NewDecoder(bytes.NewReader(b))
+		for err == nil {
+			err = dec.Decode(&v)
+		}
+		if err == io.EOF {
+			err = nil
+		}
+	case "json":
+		var v any
+		err = json.Unmarshal(b, &v)
+	case "toml":
+		var v any
+		err = toml.Unmarshal(b, &v)
+	case "kdl":
+		_, _, err = parseKDL(string(b))
+	case "ini":
+		err = verifyINI(b)
+	default:
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", strings.ToUpper(format), err)
+	}
+	return nil
+}
+
+// verifyINI reports the first line that is not blank, a comment, a section
+// header or key=value.
+func verifyINI(b []byte) error {
+	sc := bufio.NewScanner(bytes.NewReader(b))
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		switch {
+		case line == "", strings.HasPrefix(line, "#"), strings.HasPrefix(line, ";"):
+		case strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]"):
+		case strings.IndexByte(line, '=') > 0:
+		default:
+			return fmt.Errorf("line %d: expected [section] or key=value, got %q", n, line)
+		}
+	}
+	return sc.Err()
+}
@@ -0,0 +1,28 @@
+package blend
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestVerifyOutput_RejectsCorruptOutput(t *testing.T) {
+	valid := map[string]string{
+		"yaml": "# header\na:\n  b: [1, 2]\n",
+		"json": "{\n  \"a\": {\"b\": [1, 2]}\n}\n",
+		"toml": "# header\n[a]\nb = [1, 2]\n",
+		"kdl":  "// header\na {\n  b 1 2\n}\n",
+		"ini":  "; header\n[a]\nb=1\n",
+	}
+	for format, s := range valid {
+		if err := VerifyOutput(format, []byte(s)); err != nil {
+			t.Fatalf("%s: valid output rejected: %v", format, err)
+		}
+		err := VerifyOutput(format, []byte("{{{"+s))
+		if err == nil || !strings.Contains(err.Error(), strings.ToUpper(format)) {
+			t.Fatalf("%s: want an error naming the format, got %v", format, err)
+		}
+	}
+	if err := VerifyOutput("raw", []byte("{{{")); err != nil {
+		t.Fatalf("raw: want no check, got %v", err)
+	}
+}
@@ -1,8 +1,12 @@
 package blend
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
 	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/nekwebdev/confb/internal/config"
@@ -129,3 +133,328 @@ func TestYAML_MapsReplace_ArraysReplace(t *testing.T) {
 		t.Fatalf("svc.nest.x type = %T (val=%v), want numeric", nest["x"], nest["x"])
 	}
 }
+
+func TestYAML_TraceIntermediateStates(t *testing.T) {
+	td := t.TempDir()
+	base := filepath.Join(td, "base.yaml")
+	over := filepath.Join(td, "overlay.yaml")
+	writeFileT(t, base, "a: 1\nnested:\n  x: base\n")
+	writeFileT(t, over, "b: 2\nnested:\n  x: over\n")
+
+	var trace bytes.Buffer
+	rules := &config.MergeRules{Maps: "deep", Arrays: "replace"}
+	if _, err := BlendStructuredAs("yaml", "yaml", rules, []string{base, over}, Options{Trace: &trace}); err != nil {
+		t.Fatalf("BlendStructuredAs error: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(trace.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 trace lines, got %d:\n%s", len(lines), trace.String())
+	}
+	states := make([]map[string]any, len(lines))
+	for i, l := range lines {
+		prefix := fmt.Sprintf("[trace] file %d: ", i+1)
+		if !strings.HasPrefix(l, prefix) {
+			t.Fatalf("line %d = %q, want prefix %q", i, l, prefix)
+		}
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(l, prefix)), &states[i]); err != nil {
+			t.Fatalf("line %d is not JSON: %v", i, err)
+		}
+	}
+
+	want1 := map[string]any{"a": float64(1), "nested": map[string]any{"x": "base"}}
+	if !reflect.DeepEqual(states[0], want1) {
+		t.Fatalf("state after file 1 = %v, want %v", states[0], want1)
+	}
+	want2 := map[string]any{"a": float64(1), "b": float64(2), "nested": map[string]any{"x": "over"}}
+	if !reflect.DeepEqual(states[1], want2) {
+		t.Fatalf("state after file 2 = %v, want %v", states[1], want2)
+	}
+}
+
+func TestYAML_MergePatchSource(t *testing.T) {
+	td := t.TempDir()
+	base := filepath.Join(td, "base.yaml")
+	patch := filepath.Join(td, "patch.yaml")
+	over := filepath.Join(td, "overlay.yaml")
+	writeFileT(t, base, `
+keep: 1
+drop: 2
+nested:
+  x: 1
+  y: 2
+list: [a, b]
+`)
+	writeFileT(t, patch, `
+drop: null
+nested:
+  y: null
+  z: 3
+list: [c]
+`)
+	writeFileT(t, over, `
+nested:
+  w: 4
+list: [d]
+`)
+
+	rules := &config.MergeRules{Maps: "deep", Arrays: "append"}
+	opts := Options{MergePatch: map[string]bool{patch: true}}
+	out, err := BlendStructuredAs("yaml", "yaml", rules, []string{base, patch, over}, opts)
+	if err != nil {
+		t.Fatalf("BlendStructuredAs error: %v", err)
+	}
+	var got map[string]any
+	if err := yaml.Unmarshal([]byte(out), &got); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	want := map[string]any{
+		"keep":   1,
+		"nested": map[string]any{"x": 1, "z": 3, "w": 4}, // overlay still deep-merges
+		"list":   []any{"c", "d"},                       // patch replaced, overlay appended
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("merged = %#v\nwant %#v", got, want)
+	}
+}
+
+func TestYAML_StyleBlockAndFlow(t *testing.T) {
+	td := t.TempDir()
+	src := filepath.Join(td, "a.yaml")
+	writeFileT(t, src, `
+name: app
+server:
+  ports: [80, 443]
+  tls:
+    cert: "/etc/ssl/a b.pem"
+motd: "line one\nline two"
+`)
+
+	render := func(style string) (string, any) {
+		rules := &config.MergeRules{Maps: "deep", Arrays: "replace", YAMLStyle: style}
+		out, err := BlendStructured("yaml", rules, []string{src})
+		if err != nil {
+			t.Fatalf("%s: BlendStructured error: %v", style, err)
+		}
+		var v any
+		if err := yaml.Unmarshal([]byte(out), &v); err != nil {
+			t.Fatalf("%s: output does not parse: %v\n%s", style, err, out)
+		}
+		return out, v
+	}
+
+	block, blockVal := render("block")
+	flow, flowVal := render("flow")
+
+	if strings.Count(block, "\n") < 3 {
+		t.Fatalf("block output should span lines:\n%s", block)
+	}
+	if strings.Count(flow, "\n") != 1 || !strings.HasPrefix(flow, "{") {
+		t.Fatalf("flow output should be a single line, got %q", flow)
+	}
+	if !reflect.DeepEqual(blockVal, flowVal) {
+		t.Fatalf("styles decode differently:\nblock: %#v\nflow:  %#v", blockVal, flowVal)
+	}
+}
+
+func TestYAML_NullStrategy(t *testing.T) {
+	td := t.TempDir()
+	base := filepath.Join(td, "base.yaml")
+	over := filepath.Join(td, "overlay.yaml")
+	writeFileT(t, base, "app:\n  theme: dark\n  port: 8080\n")
+	writeFileT(t, over, "app:\n  theme: null\n  extra: null\n")
+
+	cases := map[string]map[string]any{
+		"replace": {"theme": nil, "port": 8080, "extra": nil},
+		"delete":  {"port": 8080},
+		"ignore":  {"theme": "dark", "port": 8080},
+	}
+	for strategy, want := range cases {
+		rules := &config.MergeRules{Maps: "deep", Arrays: "replace", NullStrategy: strategy}
+		out, err := BlendStructured("yaml", rules, []string{base, over})
+		if err != nil {
+			t.Fatalf("%s: BlendStructured error: %v", strategy, err)
+		}
+		var got map[string]map[string]any
+		if err := yaml.Unmarshal([]byte(out), &got); err != nil {
+			t.Fatalf("%s: output does not parse: %v\n%s", strategy, err, out)
+		}
+		if !reflect.DeepEqual(got["app"], want) {
+			t.Fatalf("%s: app = %#v, want %#v", strategy, got["app"], want)
+		}
+	}
+}
+
+func TestYAML_ArraysIntersect(t *testing.T) {
+	td := t.TempDir()
+	a := filepath.Join(td, "a.yaml")
+	b := filepath.Join(td, "b.yaml")
+	c := filepath.Join(td, "c.yaml")
+	writeFileT(t, a, "allow: [10.0.0.1, 10.0.0.2, 10.0.0.3, 10.0.0.4]\nports: [22, 80, 443]\n")
+	writeFileT(t, b, "allow: [10.0.0.4, 10.0.0.2, 10.0.0.3]\nports: [443, 80]\n")
+	writeFileT(t, c, "allow: [10.0.0.3, 10.0.0.2, 10.0.0.9]\nports: [80]\n")
+
+	rules := &config.MergeRules{Maps: "deep", Arrays: "intersect"}
+	out, err := BlendStructured("yaml", rules, []string{a, b, c})
+	if err != nil {
+		t.Fatalf("BlendStructured error: %v", err)
+	}
+	var got map[string]any
+	if err := yaml.Unmarshal([]byte(out), &got); err != nil {
+		t.Fatalf("output does not parse: %v\n%s", err, out)
+	}
+	want := map[string]any{
+		"allow": []any{"10.0.0.2", "10.0.0.3"}, // order of the first file
+		"ports": []any{80},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %#v, want %#v", got, want)
+	}
+
+	// arrays of maps cannot be intersected: replace, with a warning
+	writeFileT(t, c, "allow: [{ip: 10.0.0.2}]\n")
+	var warn bytes.Buffer
+	out, err = BlendStructuredAs("yaml", "yaml", rules, []string{a, c}, Options{Warn: &warn})
+	if err != nil {
+		t.Fatalf("BlendStructuredAs error: %v", err)
+	}
+	if !strings.Contains(out, "ip: 10.0.0.2") || !strings.Contains(warn.String(), "intersect") {
+		t.Fatalf("want replace + warning, got:\n%s\nwarnings: %q", out, warn.String())
+	}
+}
+
+func TestYAML_ScalarStyle(t *testing.T) {
+	td := t.TempDir()
+	// the same data written with different scalar styles
+	a := filepath.Join(td, "a.yaml")
+	b := filepath.Join(td, "b.yaml")
+	writeFileT(t, a, "name: app\nflag: 'yes'\nport: 80\nmotd: |\n  line one\n  line two\n")
+	writeFileT(t, b, "name: \"app\"\nflag: \"yes\"\nport: 80\nmotd: \"line one\\nline two\\n\"\n")
+
+	render := func(style, src string) string {
+		rules := &config.MergeRules{Maps: "deep", Arrays: "replace", YAMLScalarStyle: style}
+		out, err := BlendStructured("yaml", rules, []string{src})
+		if err != nil {
+			t.Fatalf("%s: BlendStructured error: %v", style, err)
+		}
+		return out
+	}
+
+	// go-yaml picks a style per value: bare, quoted and literal in one document
+	def := render("any", a)
+	if !strings.Contains(def, "name: app\n") || !strings.Contains(def, `flag: "yes"`) || !strings.Contains(def, "motd: |") {
+		t.Fatalf("default output changed:\n%s", def)
+	}
+
+	dqA, dqB := render("double_quoted", a), render("double_quoted", b)
+	if dqA != dqB {
+		t.Fatalf("double_quoted not byte-identical:\n%s\n---\n%s", dqA, dqB)
+	}
+	for _, want := range []string{`name: "app"`, `flag: "yes"`, `motd: "line one\nline two\n"`, "port: 80\n"} {
+		if !strings.Contains(dqA, want) {
+			t.Fatalf("double_quoted output missing %q:\n%s", want, dqA)
+		}
+	}
+
+	if sq := render("single_quoted", a); !strings.Contains(sq, "name: 'app'") {
+		t.Fatalf("single_quoted output:\n%s", sq)
+	}
+	var v1, v2 any
+	_ = yaml.Unmarshal([]byte(def), &v1)
+	if err := yaml.Unmarshal([]byte(render("literal", a)), &v2); err != nil || !reflect.DeepEqual(v1, v2) {
+		t.Fatalf("literal output decodes differently (err=%v): %#v vs %#v", err, v1, v2)
+	}
+}
+
+func TestYAML_MaxDepthReplacesDeeperLevels(t *testing.T) {
+	td := t.TempDir()
+	const levels = 150
+	// level i holds its own key (b<i> or o<i>) and level i+1 under "n"
+	nested := func(prefix, leaf string) string {
+		var sb strings.Builder
+		for i := 1; i <= levels; i++ {
+			ind := strings.Repeat("  ", i-1)
+			fmt.Fprintf(&sb, "%s%s%d: x\n", ind, prefix, i)
+			if i < levels {
+				fmt.Fprintf(&sb, "%sn:\n", ind)
+			} else {
+				fmt.Fprintf(&sb, "%sleaf: %s\n", ind, leaf)
+			}
+		}
+		return sb.String()
+	}
+	base := filepath.Join(td, "base.yaml")
+	over := filepath.Join(td, "over.yaml")
+	writeFileT(t, base, nested("b", "base"))
+	writeFileT(t, over, nested("o", "over"))
+
+	var warn bytes.Buffer
+	out, err := BlendStructuredAs("yaml", "yaml", &config.MergeRules{MaxDepth: 10}, []string{base, over}, Options{Warn: &warn})
+	if err != nil {
+		t.Fatalf("BlendStructuredAs: %v", err)
+	}
+	if got := strings.Count(warn.String(), "max_depth 10"); got != 1 {
+		t.Fatalf("want one max_depth warning, got %d: %q", got, warn.String())
+	}
+
+	var doc map[string]any
+	if err := yaml.Unmarshal([]byte(out), &doc); err != nil {
+		t.Fatalf("parse output: %v", err)
+	}
+	m := doc
+	for i := 1; i <= levels; i++ {
+		_, hasB := m[fmt.Sprintf("b%d", i)]
+		_, hasO := m[fmt.Sprintf("o%d", i)]
+		// levels 1-10 merge; level 11 and below come from the overlay alone
+		if !hasO || hasB != (i <= 10) {
+			t.Fatalf("level %d: b=%v o=%v", i, hasB, hasO)
+		}
+		if i == levels {
+			if m["leaf"] != "over" {
+				t.Fatalf("leaf = %v, want over", m["leaf"])
+			}
+			break
+		}
+		m = m["n"].(map[string]any)
+	}
+}
+
+func TestYAML_StreamingDecodeMemory(t *testing.T) {
+	if testing.Short() {
+		t.Skip("writes a 10MB source")
+	}
+	td := t.TempDir()
+	path := filepath.Join(td, "big.yaml")
+	var sb strings.Builder
+	val := strings.Repeat("x", 1000)
+	for i := 0; i < 10000; i++ {
+		fmt.Fprintf(&sb, "key%05d: %s\n", i, val)
+	}
+	size := uint64(sb.Len())
+	writeFileT(t, path, sb.String())
+	sb = strings.Builder{}
+
+	// the merged output is a second copy by nature; what streaming saves is
+	// the raw file held next to the decoded document
+	var doc any
+	var ok bool
+	var err error
+	peak := peakHeap(func() { doc, ok, err = decodeStream("yaml", path, Options{}) })
+	if err != nil || !ok {
+		t.Fatalf("decodeStream: ok=%v err=%v", ok, err)
+	}
+	if m, _ := doc.(map[string]any); len(m) != 10000 {
+		t.Fatalf("want 10000 keys, got %d", len(m))
+	}
+	if peak >= 2*size {
+		t.Fatalf("peak heap %d bytes for a %d byte file, want < 2x", peak, size)
+	}
+
+	out, err := BlendStructured("yaml", &config.MergeRules{}, []string{path})
+	if err != nil {
+		t.Fatalf("blend: %v", err)
+	}
+	if !strings.Contains(out, "key09999: "+val+"\n") {
+		t.Fatalf("last key missing from output")
+	}
+}
@@ -4,17 +4,24 @@
 	"bytes"
 	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/spf13/cobra"
 
 	"github.com/nekwebdev/confb/internal/blend"
 	"github.com/nekwebdev/confb/internal/config"
+	"github.com/nekwebdev/confb/internal/daemon"
 	executor "github.com/nekwebdev/confb/internal/exec"
 	"github.com/nekwebdev/confb/internal/plan"
 )
@@ -34,6 +41,25 @@ func commentPrefixFor(format string) (string, bool) {
 	}
 }
 
+// headerTime is the time stamped in the header. It never depends on when the
+// build runs, so unchanged inputs give identical bytes: the output mtime for
+// mtime_source newest_source|zero, else SOURCE_DATE_EPOCH if set, else the
+// newest source's mtime (the Unix epoch when no source file has one).
+func headerTime(t config.Target, rt *plan.ResolvedTarget) time.Time {
+	if m := plan.OutputMtime(t, rt); !m.IsZero() {
+		return m.UTC()
+	}
+	if s := os.Getenv("SOURCE_DATE_EPOCH"); s != "" {
+		if sec, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return time.Unix(sec, 0).UTC()
+		}
+	}
+	if rt != nil && !rt.NewestSourceMtime.IsZero() {
+		return rt.NewestSourceMtime.UTC()
+	}
+	return time.Unix(0, 0).UTC()
+}
+
 // headerForTarget builds the annotation header to prepend to an output file.
 // It enumerates sources and merge rules, and includes version/time.
 // Returns nil if the format doesn't support comments.
@@ -52,7 +78,7 @@ func headerForTarget(cmd *cobra.Command, t config.Target, rt *plan.ResolvedTarge
 		"fmt: "+strings.ToLower(t.Format),
 		"target: "+t.Name,
 		"output: "+rt.Output,
-		"time: "+time.Now().Format(time.RFC3339),
+		"time: "+headerTime(t, rt).Format(time.RFC3339),
 	)
 
 	// merge rule summary (format-aware)
@@ -67,12 +93,37 @@ func headerForTarget(cmd *cobra.Command, t config.Target, rt *plan.ResolvedTarge
 			if len(r.KDLSectionKeys) > 0 {
 				parts = append(parts, "section_keys=["+strings.Join(r.KDLSectionKeys, ",")+"]")
 			}
+			if r.KDLMatchProperty != "" {
+				parts = append(parts, "match_property="+r.KDLMatchProperty)
+			}
+			if r.KDLOutputOrder != "" {
+				parts = append(parts, "output_order="+strings.ToLower(r.KDLOutputOrder))
+			}
+			if r.KDLSortByHead {
+				parts = append(parts, "sort_by_head=true")
+			}
+			if r.KDLStrictKeys {
+				parts = append(parts, "strict_keys=true")
+			}
 			if len(parts) > 0 {
 				lines = append(lines, "merge.rules: "+strings.Join(parts, " "))
 			}
 		case "ini":
+			var parts []string
 			if r.INIRepeatedKeys != "" {
-				lines = append(lines, "merge.rules: repeated_keys="+strings.ToLower(r.INIRepeatedKeys))
+				parts = append(parts, "repeated_keys="+strings.ToLower(r.INIRepeatedKeys))
+			}
+			if r.INISectionOrder != "" {
+				parts = append(parts, "section_order="+strings.ToLower(r.INISectionOrder))
+			}
+			if r.INIDefaultSection != "" {
+				parts = append(parts, "default_section="+r.INIDefaultSection)
+			}
+			if r.INIGlobalSection != "" {
+				parts = append(parts, "global_section="+r.INIGlobalSection)
+			}
+			if len(parts) > 0 {
+				lines = append(lines, "merge.rules: "+strings.Join(parts, " "))
 			}
 		default:
 			var parts []string
@@ -82,6 +133,12 @@ func headerForTarget(cmd *cobra.Command, t config.Target, rt *plan.ResolvedTarge
 			if r.Arrays != "" {
 				parts = append(parts, "arrays="+strings.ToLower(r.Arrays))
 			}
+			if r.NullStrategy != "" {
+				parts = append(parts, "null_strategy="+strings.ToLower(r.NullStrategy))
+			}
+			if r.MaxDepth != 0 {
+				parts = append(parts, fmt.Sprintf("max_depth=%d", r.MaxDepth))
+			}
 			if len(parts) > 0 {
 				lines = append(lines, "merge.rules: "+strings.Join(parts, " "))
 			}
@@ -95,7 +152,11 @@ func headerForTarget(cmd *cobra.Command, t config.Target, rt *plan.ResolvedTarge
 			sum := sha256.Sum256(b)
 			sha = hex.EncodeToString(sum[:])
 		}
-		lines = append(lines, fmt.Sprintf("  %d) %s sha256=%s", i+1, p, sha))
+		name := p
+		if plan.IsInlineFile(p) {
+			name = "(inline)"
+		}
+		lines = append(lines, fmt.Sprintf("  %d) %s sha256=%s", i+1, name, sha))
 	}
 
 	var buf bytes.Buffer
@@ -108,28 +169,214 @@ func headerForTarget(cmd *cobra.Command, t config.Target, rt *plan.ResolvedTarge
 	return buf.Bytes()
 }
 
-// parseOverrides parses --output-override TARGET=PATH flags into a map.
-func parseOverrides(list []string) (map[string]string, error) {
+// printHeaderOnly implements --header-only TARGET=FORMAT: it writes the header
+// headerForTarget would stamp on that target, in FORMAT's comment syntax, to
+// the command's stdout. Nothing is built; when the sources can't be resolved
+// (e.g. they don't exist yet) the header lists them as configured.
+func printHeaderOnly(cmd *cobra.Command, cfg *config.Config, effective []config.Target, spec string) error {
+	o, err := parseOverrides("header-only", "FORMAT", []string{spec})
+	if err != nil {
+		return err
+	}
+	name, _, _ := strings.Cut(spec, "=")
+	name = strings.TrimSpace(name)
+	i := slices.IndexFunc(effective, func(t config.Target) bool { return t.Name == name })
+	if i < 0 {
+		return fmt.Errorf("--header-only: unknown target %q", name)
+	}
+	t := effective[i]
+	t.Format = o[name]
+	if _, ok := commentPrefixFor(t.Format); !ok {
+		return fmt.Errorf("--header-only: format %q has no comment syntax for a header", t.Format)
+	}
+
+	rt, err := plan.PlanTarget(cfg, t, "")
+	if err != nil {
+		rt = &plan.ResolvedTarget{Name: t.Name, Output: t.Output}
+		for _, src := range t.Sources {
+			switch {
+			case src.TargetRef != "":
+				if p, err := plan.RefOutput(cfg, src.TargetRef); err == nil {
+					rt.Files = append(rt.Files, p)
+				}
+			case src.Path != "":
+				p := expandPath(os.ExpandEnv(src.Path))
+				if !filepath.IsAbs(p) {
+					p = filepath.Join(cfg.SourceDir(t), p)
+				}
+				rt.Files = append(rt.Files, p)
+			}
+		}
+	}
+	_, err = cmd.OutOrStdout().Write(headerForTarget(cmd, t, rt))
+	return err
+}
+
+// parseOverrides parses repeatable TARGET=VALUE flags (e.g. --output-override TARGET=PATH)
+// into a map. flag and value name the flag in error messages.
+func parseOverrides(flag, value string, list []string) (map[string]string, error) {
 	out := make(map[string]string, len(list))
 	for _, p := range list {
 		parts := strings.SplitN(p, "=", 2)
 		if len(parts) != 2 {
-			return nil, fmt.Errorf("invalid --output-override %q (expected TARGET=PATH)", p)
+			return nil, fmt.Errorf("invalid --%s %q (expected TARGET=%s)", flag, p, value)
 		}
 		k := strings.TrimSpace(parts[0])
 		v := strings.TrimSpace(parts[1])
 		if k == "" || v == "" {
-			return nil, fmt.Errorf("invalid --output-override %q (empty key or value)", p)
+			return nil, fmt.Errorf("invalid --%s %q (empty key or value)", flag, p)
 		}
 		out[k] = v
 	}
 	return out, nil
 }
 
+// selectTargets returns the target names picked by --targets and --targets-file
+// (one name per line; blank lines and # comments ignored), or nil when neither
+// is set. Every name must exist in cfg.
+func selectTargets(cfg *config.Config, names []string, file string) (map[string]bool, error) {
+	if len(names) == 0 && file == "" {
+		return nil, nil
+	}
+	if file != "" {
+		b, err := os.ReadFile(expandPath(file))
+		if err != nil {
+			return nil, fmt.Errorf("--targets-file: %w", err)
+		}
+		for _, line := range strings.Split(string(b), "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			names = append(names, line)
+		}
+	}
+	selected := make(map[string]bool, len(names))
+	var unknown []string
+	for _, n := range names {
+		n = strings.TrimSpace(n)
+		if n == "" {
+			continue
+		}
+		if _, ok := cfg.TargetByName(n); !ok {
+			unknown = append(unknown, n)
+			continue
+		}
+		selected[n] = true
+	}
+	if len(unknown) > 0 {
+		return nil, fmt.Errorf("unknown target(s): %s", strings.Join(unknown, ", "))
+	}
+	if len(selected) == 0 {
+		return nil, errors.New("--targets/--targets-file selected no targets")
+	}
+	return selected, nil
+}
+
+// isStructured reports whether format is handled by blend.BlendStructured.
+func isStructured(format string) bool {
+	switch strings.ToLower(format) {
+	case "yaml", "yml", "json", "toml":
+		return true
+	}
+	return false
+}
+
+// applyFormatOverride switches t to format for this build only.
+// Structured -> structured keeps merging (sources are reserialised), anything -> raw
+// drops merging (plain concat). Other changes are rejected when the target merges,
+// since its rules belong to the original format's engine.
+func applyFormatOverride(t *config.Target, format string) error {
+	if !config.ValidFormat(format) {
+		return fmt.Errorf("%s: --format-override must be one of auto|yaml|toml|ini|json|raw|kdl (got %q)", t.Name, format)
+	}
+	from := strings.ToLower(t.Format)
+	to := strings.ToLower(format)
+	switch {
+	case from == to:
+	case to == "raw":
+		t.Merge = nil
+	case isStructured(from) && isStructured(to):
+		if t.Merge == nil {
+			// reserialising requires parsing; use the loader's structured defaults
+			t.Merge = &config.MergeSpec{Rules: &config.MergeRules{Maps: "deep", Arrays: "replace"}}
+		}
+	case t.Merge != nil:
+		return fmt.Errorf("%s: --format-override %s is incompatible with the target's %s merge rules", t.Name, to, from)
+	}
+	t.Format = to
+	return nil
+}
+
+// applyJSONCompact handles --json-compact TARGET=BOOL: when true the (json) target
+// is serialised without indentation. A json target without merge rules gets the
+// loader's structured defaults so its content can be reserialised.
+func applyJSONCompact(t *config.Target, v string) error {
+	on, err := strconv.ParseBool(v)
+	if err != nil {
+		return fmt.Errorf("invalid --json-compact %s=%s (expected a boolean)", t.Name, v)
+	}
+	if !on {
+		return nil
+	}
+	if strings.ToLower(t.Format) != "json" {
+		return fmt.Errorf("%s: --json-compact requires format json (got %q)", t.Name, t.Format)
+	}
+	rules := config.MergeRules{Maps: "deep", Arrays: "replace"}
+	spec := config.MergeSpec{}
+	if t.Merge != nil {
+		spec = *t.Merge
+		if t.Merge.Rules != nil {
+			rules = *t.Merge.Rules
+		}
+	}
+	compact := ""
+	rules.JSONIndent = &compact
+	spec.Rules = &rules
+	t.Merge = &spec
+	return nil
+}
+
+// displayOutput names an output path in messages ("-" is stdout).
+func displayOutput(p string) string {
+	if p == executor.StdoutPath {
+		return "<stdout>"
+	}
+	return p
+}
+
 func newBuildCmd() *cobra.Command {
 	var trace bool
 	var dryRun bool
 	var overridesFlag []string
+	var formatOverridesFlag []string
+	var jsonCompactFlag []string
+	var verifyWrite bool
+	var verifyOutput bool
+	var outputMode string
+	var traceMerge string
+	var traceMergeFile string
+	var traceProvenance string
+	var provenanceStdout bool
+	var summary bool
+	var cacheDir string
+	var noCache bool
+	var quiet bool
+	var manifestPath string
+	var stageDir string
+	var stageValidate string
+	var envFiles []string
+	var targetNames []string
+	var targetsFile string
+	var exportEnv string
+	var outputPrefix string
+	var parallel int
+	var failFast bool
+	var headerOnly string
+	var watch bool
+	var strict bool
+	var noExtensionCheck bool
+	var watchIdle time.Duration
 
 	cmd := &cobra.Command{
 		Use:   "build",
@@ -140,35 +387,119 @@ func newBuildCmd() *cobra.Command {
 notes:
   • loads default config from ~/.config/confb/confb.yaml unless -c is used or CONFB_CONFIG is set
 	• use --trace to print resolved baseDir, config path, the target plan and merge rules
+  • use --targets a,b (and/or --targets-file PATH, one name per line) to build only
+    those targets; outputs they read via target_ref are used as they are on disk
   • use --output-override TARGET=PATH to redirect a single target output
+    (output "-" or --output-override TARGET=- writes that target to stdout; at most one)
+  • use --format-override TARGET=FORMAT to change a target's output format for this build
+    (yaml/json/toml are reserialised; any format may be overridden to raw)
+  • use --json-compact TARGET=1 to write a json target without indentation
+  • every target is attempted even if an earlier one fails (targets reading a failed
+    one via target_ref are skipped) and all failures are reported; --fail-fast stops
+    at the first
+  • use --header-only TARGET=FORMAT to print the header TARGET would be stamped with
+    (comment prefix of FORMAT) and exit; sources need not exist
+  • use --manifest PATH to write a JSON record of each target (sources with sha256,
+    output sha256, timing); failed targets are listed with their error
+  • use --output-prefix DIR to write every output below DIR, absolute ones included
+    (/etc/app.conf -> DIR/etc/app.conf), after ~/$VARS and --output-override apply
+  • use --parallel N to build up to N targets at once; targets are batched in target_ref
+    order, so a target starts only after every target it reads has been written
+  • use --stage-dir DIR to write every output into DIR (by file name) and move them
+    into place only after all targets succeed; --stage-validate CMD checks DIR first
+  • use --trace-merge TARGET to print the merge state after each source file
+    (implies --trace; --trace-merge-file PATH writes those lines to a file instead)
+  • use --trace-provenance TARGET to write OUTPUT.provenance.json mapping each merged key
+    to the source file that set it (--provenance-stdout prints it instead)
   • if the target format supports comments (kdl/toml/yaml/ini), the output is annotated
     with a header listing sources and (if present) merge rules. json/raw are never annotated.
-  • no file watching here; see 'confb run' for the daemon (watch & rebuild).`,
+    Its time is the output mtime for mtime_source newest_source|zero, else SOURCE_DATE_EPOCH
+    if set, else the newest source's mtime, so rebuilding unchanged inputs gives identical bytes.
+  • use --cache-dir DIR to skip targets whose definition, sources (mtime+size) and output
+    are unchanged since the last build (DIR/target-NAME.cache); --no-cache rebuilds all
+    but still refreshes the cache
+  • an output whose extension belongs to another format (format yaml, output app.json)
+    is reported as a warning; --strict (or strict_extensions: true) makes it an error
+    and --no-extension-check skips the check. raw/auto targets are never checked.
+  • post_build (top level of the config) runs once after all targets succeed
+  • output "env:NAME" (raw targets) sets NAME for post_build instead of writing a file;
+    --export-env PATH also writes "export NAME='…'" lines there for a shell to source
+  • use --summary to end with one line counting targets built, unchanged and failed
+    (--quiet drops the per-target lines)
+  • use --watch to keep running after the build and rebuild on change, like 'confb run'
+    but honouring --targets, --output-override and --output-prefix;
+    --watch-idle-timeout 10m exits once nothing has changed for 10 minutes.
+    SIGHUP does not reload the config in this mode; see 'confb run' for the daemon.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			// honor --chdir early
-			if chdir, _ := cmd.Root().Flags().GetString("chdir"); chdir != "" {
-				if err := os.Chdir(chdir); err != nil {
-					return fmt.Errorf("chdir %q: %w", chdir, err)
-				}
-			}
-
 			cfgPath, _ := cmd.Root().Flags().GetString("config")
 			if cfgPath == "" {
 				return errors.New("no config path (use -c/--config)")
 			}
+			cfgPath, err := autoDiscover(cmd, cfgPath)
+			if err != nil {
+				return err
+			}
+			if outputMode == "" || !executor.ValidWriteMode(outputMode) {
+				return fmt.Errorf("--output-mode must be atomic, copy or in-place (got %q)", outputMode)
+			}
 
-			cfg, err := config.Load(cfgPath)
+			if err := loadEnvFiles(envFiles); err != nil {
+				return err
+			}
+			cfg, err := loadConfig(cmd, cfgPath)
 			if err != nil {
 				return fmt.Errorf("load config: %w", err)
 			}
+			defer func() { _ = plan.RemoveInlineDir() }()
+
+			overrides, err := parseOverrides("output-override", "PATH", overridesFlag)
+			if err != nil {
+				return err
+			}
+			formatOverrides, err := parseOverrides("format-override", "FORMAT", formatOverridesFlag)
+			if err != nil {
+				return err
+			}
+			jsonCompact, err := parseOverrides("json-compact", "BOOL", jsonCompactFlag)
+			if err != nil {
+				return err
+			}
 
-			overrides, err := parseOverrides(overridesFlag)
+			selected, err := selectTargets(cfg, targetNames, targetsFile)
 			if err != nil {
 				return err
 			}
 
+			if traceProvenance != "" {
+				if _, ok := cfg.TargetByName(traceProvenance); !ok {
+					return fmt.Errorf("--trace-provenance: unknown target %q", traceProvenance)
+				}
+			}
+
+			// --trace-merge: per-file merge states for one target
+			var mergeTrace io.Writer
+			if traceMerge != "" {
+				if _, ok := cfg.TargetByName(traceMerge); !ok {
+					return fmt.Errorf("--trace-merge: unknown target %q", traceMerge)
+				}
+				trace = true
+				mergeTrace = os.Stderr
+				if traceMergeFile != "" {
+					f, err := os.Create(traceMergeFile)
+					if err != nil {
+						return fmt.Errorf("--trace-merge-file: %w", err)
+					}
+					defer f.Close()
+					mergeTrace = f
+				}
+			}
+
+			// one build ID per run, for correlating the manifest with hook logs
+			buildID := daemon.NewBuildID()
+
 			// trace header
 			if trace {
+				fmt.Fprintf(os.Stderr, "confb: build id = %s\n", buildID)
 				base, err := cfg.BaseDir()
 				if err != nil {
 					return err
@@ -182,17 +513,259 @@ func newBuildCmd() *cobra.Command {
 				return errors.New("no targets defined (validation should have caught this)")
 			}
 
-			// per-target planning + write
+			// re-check output conflicts once overrides apply; only one target may use stdout
+			var effective []config.Target
+			var toStdout []string
 			for _, t := range cfg.Targets {
-				override := overrides[t.Name]
-				rt, err := plan.PlanTarget(cfg, t, override)
+				if o, ok := overrides[t.Name]; ok {
+					t.Output = o
+				}
+				if outputPrefix != "" && executor.IsFileOutput(t.Output) {
+					// like make's DESTDIR: absolute outputs land below the prefix too
+					t.Output = filepath.Join(expandPath(outputPrefix), t.Output)
+				}
+				if t.Output == executor.StdoutPath {
+					toStdout = append(toStdout, t.Name)
+				}
+				effective = append(effective, t)
+			}
+			if len(toStdout) > 1 {
+				return fmt.Errorf("only one target may write to stdout (output \"-\"); got %s", strings.Join(toStdout, ", "))
+			}
+			if conflicts := config.OutputConflicts(effective); len(conflicts) > 0 {
+				return fmt.Errorf("output conflict after --output-override: %s", strings.Join(conflicts, "; "))
+			}
+			if headerOnly != "" {
+				return printHeaderOnly(cmd, cfg, effective, headerOnly)
+			}
+			// an output extension of another format (a yaml target writing app.json)
+			if !noExtensionCheck {
+				var mismatches []string
+				for _, t := range effective {
+					if selected != nil && !selected[t.Name] {
+						continue
+					}
+					if f, ok := formatOverrides[t.Name]; ok {
+						t.Format = f
+					}
+					if m := config.ExtensionMismatch(t); m != "" {
+						mismatches = append(mismatches, m)
+					}
+				}
+				if len(mismatches) > 0 && (strict || cfg.StrictExtensions) {
+					return fmt.Errorf("output extension mismatch: %s", strings.Join(mismatches, "; "))
+				}
+				for _, m := range mismatches {
+					fmt.Fprintf(cmd.ErrOrStderr(), "confb: warning: %s\n", m)
+				}
+			}
+			if exportEnv == "" && cfg.PostBuild == "" {
+				for _, t := range effective {
+					if _, ok := executor.EnvOutputName(t.Output); ok {
+						return fmt.Errorf("target %q: output %s only reaches post_build; set post_build or use --export-env PATH", t.Name, t.Output)
+					}
+				}
+			}
+
+			// --watch: build once, then rebuild on change like 'confb run'
+			if watch || watchIdle > 0 {
+				for _, name := range watchUnsupported {
+					if cmd.Flags().Changed(name) {
+						return fmt.Errorf("--watch cannot be combined with --%s", name)
+					}
+				}
+				wcfg, err := watchConfig(cfg, effective, selected, formatOverrides, jsonCompact)
+				if err != nil {
+					return err
+				}
+				level := daemon.LogNormal
+				if quiet {
+					level = daemon.LogQuiet
+				}
+				return runDaemon(wcfg, daemon.Options{
+					LogLevel:     level,
+					VerifyWrite:  verifyWrite,
+					VerifyOutput: verifyOutput,
+					WriteMode:    outputMode,
+					IdleTimeout:  watchIdle,
+					FailFast:     failFast,
+				})
+			}
+
+			// --stage-dir: plan every output into the staging dir, promote once all succeed
+			planCfg := cfg
+			var staged []stagedOutput
+			if outputPrefix != "" && stageDir == "" {
+				// plan with the prefixed outputs, so target_ref reads them too
+				prefixed := *cfg
+				prefixed.Targets = effective
+				planCfg = &prefixed
+				overrides = nil
+			}
+			if stageDir != "" {
+				planCfg, staged, err = stageConfig(cfg, effective, expandPath(stageDir))
 				if err != nil {
 					return err
 				}
+				overrides = nil // already applied to the staged outputs
+				if selected != nil {
+					finals := map[string]bool{}
+					for _, t := range effective {
+						if selected[t.Name] {
+							finals[t.Output] = true
+						}
+					}
+					var keep []stagedOutput
+					for _, s := range staged {
+						if finals[s.Final] {
+							keep = append(keep, s)
+						}
+					}
+					staged = keep
+				}
+			}
+
+			wo := executor.WriteOptions{Verify: verifyWrite, Mode: outputMode}
+
+			// dependencies (target_ref) first
+			ordered, err := planCfg.BuildOrder()
+			if err != nil {
+				return err
+			}
+			// --targets, then enabled_if: a target whose conditions fail is skipped
+			var keep []config.Target
+			for _, t := range ordered {
+				if selected != nil && !selected[t.Name] {
+					continue
+				}
+				if !t.Enabled() {
+					if !quiet {
+						fmt.Fprintf(cmd.ErrOrStderr(), "confb: target %q skipped (enabled_if %s)\n", t.Name, strings.Join(t.EnabledIf, ", "))
+					}
+					continue
+				}
+				keep = append(keep, t)
+			}
+			ordered = keep
+
+			// --manifest: provenance of every target, written even when a target fails
+			var entries []manifestEntry
+			var mu sync.Mutex // --parallel: guards entries, the counters, built and log flushes
+			record := func(t config.Target, rt *plan.ResolvedTarget, sum string, started time.Time, err error) {
+				mu.Lock()
+				defer mu.Unlock()
+				if manifestPath != "" {
+					e := newManifestEntry(t, rt, sum, started, err)
+					e.BuildID = buildID
+					entries = append(entries, e)
+				}
+			}
+			// --summary: unchanged = same output checksum as before (header excluded)
+			var nBuilt, nUnchanged, nErrors int
+			var built []string // targets written, for post_build
+			log := cmd.ErrOrStderr()
+			if quiet {
+				log = io.Discard
+			}
+			finish := func(err error) error {
+				if err != nil {
+					nErrors++
+				}
+				if summary {
+					fmt.Fprintf(cmd.ErrOrStderr(), "confb: %d built, %d unchanged, %d errors\n", nBuilt, nUnchanged, nErrors)
+				}
+				if manifestPath == "" {
+					return err
+				}
+				if werr := writeManifest(manifestPath, entries); werr != nil && err == nil {
+					err = fmt.Errorf("write manifest: %w", werr)
+				}
+				return err
+			}
+			fail := func(t config.Target, started time.Time, err error) error {
+				record(t, nil, "", started, err)
+				return err
+			}
+			// count bumps a --summary counter; name (if any) was written, for post_build
+			count := func(n *int, name string) {
+				mu.Lock()
+				defer mu.Unlock()
+				*n++
+				if name != "" {
+					built = append(built, name)
+				}
+			}
+
+			// per-target planning + write
+			buildOne := func(t config.Target) error {
+				started := time.Now()
+				wo := wo
+				log := log
+				if parallel > 1 && log != io.Discard {
+					// keep each target's lines together
+					var buf bytes.Buffer
+					log = &buf
+					defer func() {
+						mu.Lock()
+						defer mu.Unlock()
+						_, _ = io.Copy(cmd.ErrOrStderr(), &buf)
+					}()
+				}
+				srcFormat := strings.ToLower(t.Format)
+				if f, ok := formatOverrides[t.Name]; ok {
+					if err := applyFormatOverride(&t, f); err != nil {
+						return fail(t, started, err)
+					}
+				}
+				if v, ok := jsonCompact[t.Name]; ok {
+					if err := applyJSONCompact(&t, v); err != nil {
+						return fail(t, started, err)
+					}
+				}
+
+				override := overrides[t.Name]
+				rt, err := plan.PlanTarget(planCfg, t, override)
+				if err != nil {
+					return fail(t, started, err)
+				}
+				wo.Encodings = rt.Encodings
+				wo.Transforms, wo.Target = rt.Transforms, t.Name
+				wo.Mtime = plan.OutputMtime(t, rt)
+				wo.PostProcess = t.PostProcess
+				wo.Parse = nil
+				if verifyOutput || t.VerifyOutput {
+					format := t.Format
+					wo.Parse = func(b []byte) error { return blend.VerifyOutput(format, b) }
+				}
+				bo := blend.Options{Encodings: rt.Encodings, MergePatch: rt.MergePatch, Transforms: rt.Transforms, Target: t.Name}
+				if t.Name == traceMerge {
+					bo.Trace = mergeTrace
+				}
+				if t.Name == traceProvenance {
+					if t.Merge == nil {
+						return fail(t, started, fmt.Errorf("--trace-provenance: target %q has no merge rules", t.Name))
+					}
+					bo.Provenance = map[string]string{}
+				}
 
 				if trace {
 					fmt.Fprintf(os.Stderr, "target: %s (format=%s)\n", t.Name, strings.ToLower(t.Format))
 					fmt.Fprintf(os.Stderr, "  output: %s\n", rt.Output)
+					for i, src := range t.Sources {
+						if src.TargetRef != "" {
+							fmt.Fprintf(os.Stderr, "  sources[%d]: target_ref=%s\n", i, src.TargetRef)
+							continue
+						}
+						if src.Inline != "" {
+							fmt.Fprintf(os.Stderr, "  sources[%d]: inline (%d bytes)\n", i, len(src.Inline))
+							continue
+						}
+						if src.DataURI != "" {
+							fmt.Fprintf(os.Stderr, "  sources[%d]: data_uri (%d chars)\n", i, len(src.DataURI))
+							continue
+						}
+						fmt.Fprintf(os.Stderr, "  sources[%d]: %s (sort=%s)\n", i, src.Path, strings.ToLower(src.Sort))
+					}
 					if len(rt.Files) > 0 {
 						fmt.Fprintln(os.Stderr, "  files:")
 						for _, f := range rt.Files {
@@ -207,7 +780,7 @@ func newBuildCmd() *cobra.Command {
 						case "kdl":
 							fmt.Fprintf(os.Stderr, "keys=%s section_keys=%v\n", strings.ToLower(r.KDLKeys), r.KDLSectionKeys)
 						case "ini":
-							fmt.Fprintf(os.Stderr, "repeated_keys=%s\n", strings.ToLower(r.INIRepeatedKeys))
+							fmt.Fprintf(os.Stderr, "repeated_keys=%s section_order=%s\n", strings.ToLower(r.INIRepeatedKeys), strings.ToLower(r.INISectionOrder))
 						default:
 							fmt.Fprintf(os.Stderr, "maps=%s arrays=%s\n", strings.ToLower(r.Maps), strings.ToLower(r.Arrays))
 						}
@@ -215,85 +788,324 @@ func newBuildCmd() *cobra.Command {
 				}
 
 				if dryRun {
-					fmt.Fprintf(os.Stderr, "confb: %s -> %s (dry-run)\n", t.Name, rt.Output)
-					continue
+					fmt.Fprintf(log, "confb: %s -> %s (dry-run)\n", t.Name, displayOutput(rt.Output))
+					record(t, rt, "", started, nil)
+					return nil
 				}
 
-				// merged vs concat path
-				if t.Merge != nil {
-					format := strings.ToLower(t.Format)
-					var content string
-					switch format {
-					case "yaml", "yml", "json", "toml":
-						content, err = blend.BlendStructured(format, t.Merge.Rules, rt.Files)
-					case "kdl":
-						content, err = blend.BlendKDL(t.Merge.Rules, rt.Files)
-					case "ini":
-						content, err = blend.BlendINI(t.Merge.Rules, rt.Files)
-					case "raw":
-						err = fmt.Errorf("merge not supported for format %q", t.Format)
-					default:
-						err = fmt.Errorf("unknown format %q", t.Format)
-					}
-					if err != nil {
-						return fmt.Errorf("%s: merge: %w", rt.Name, err)
+				if cacheDir != "" && !noCache && bo.Provenance == nil {
+					if sum, ok := cacheHit(expandPath(cacheDir), t, rt); ok {
+						fmt.Fprintf(log, "  action: unchanged (cache) %s\n", displayOutput(rt.Output))
+						count(&nUnchanged, "")
+						record(t, rt, sum, started, nil)
+						return nil
 					}
+				}
 
-					// prepend header if supported
-					header := headerForTarget(cmd, t, rt)
-					if header != nil {
-						var buf bytes.Buffer
-						buf.Write(header)
-						buf.WriteString(content)
-						if err := executor.WriteAtomic(rt.Output, buf.String()); err != nil {
-							return err
-						}
+				var before string
+				if summary {
+					before = outputBodySum(t, rt.Output)
+				}
+				sum, err := writeTarget(cmd, log, t, rt, srcFormat, wo, bo)
+				if err == nil && bo.Provenance != nil {
+					err = writeProvenance(cmd, log, rt.Output, bo.Provenance, provenanceStdout, outputMode)
+				}
+				if err == nil && cacheDir != "" {
+					if cerr := writeCache(expandPath(cacheDir), t, rt); cerr != nil {
+						fmt.Fprintf(os.Stderr, "confb: warning: --cache-dir: %v\n", cerr)
+					}
+				}
+				if err == nil {
+					if before != "" && before == outputBodySum(t, rt.Output) {
+						count(&nUnchanged, t.Name)
 					} else {
-						if err := executor.WriteAtomic(rt.Output, content); err != nil {
-							return err
-						}
+						count(&nBuilt, t.Name)
 					}
-					fmt.Fprintf(os.Stderr, "  action: merged (%s) -> wrote %s\n", format, rt.Output)
-				} else {
-					// concat; if header supported, we need to inject it by doing the concat here
-					header := headerForTarget(cmd, t, rt)
-					if header == nil {
-						if err := executor.BuildAndWrite(rt.Output, rt.Files); err != nil {
-							return err
-						}
-						fmt.Fprintf(os.Stderr, "  action: wrote %s\n", rt.Output)
-						continue
+				}
+				record(t, rt, sum, started, err)
+				return err
+			}
+
+			// without --fail-fast every target is attempted; one that reads a
+			// failed target via target_ref is skipped rather than built from a stale file
+			failed := map[string]bool{}
+			attempt := func(t config.Target) error {
+				for _, src := range t.Sources {
+					mu.Lock()
+					dep := failed[src.TargetRef]
+					mu.Unlock()
+					if src.TargetRef != "" && dep {
+						return fail(t, time.Now(), fmt.Errorf("%s: skipped: target_ref %q failed", t.Name, src.TargetRef))
 					}
-					// concat with normalization: CRLF->LF, ensure LF final newline per file
-					var out bytes.Buffer
-					out.Write(header)
-					for _, f := range rt.Files {
-						b, err := os.ReadFile(f)
-						if err != nil {
-							return err
+				}
+				err := buildOne(t)
+				if err != nil {
+					mu.Lock()
+					failed[t.Name] = true
+					mu.Unlock()
+				}
+				return err
+			}
+			if parallel <= 1 {
+				var failedErrs []error
+				for _, t := range ordered {
+					if err := attempt(t); err != nil {
+						if failFast {
+							return finish(err)
 						}
-						s := string(b)
-						s = strings.ReplaceAll(s, "\r\n", "\n")
-						s = strings.ReplaceAll(s, "\r", "\n")
-						if !strings.HasSuffix(s, "\n") {
-							s += "\n"
+						failedErrs = append(failedErrs, err)
+					}
+				}
+				if len(failedErrs) > 0 {
+					nErrors += len(failedErrs) - 1 // finish counts one
+					return finish(errors.Join(failedErrs...))
+				}
+			} else {
+				// --parallel: a batch runs at once, after the batches holding its target_refs
+				batches, err := plan.Schedule(ordered, parallel)
+				if err != nil {
+					return finish(err)
+				}
+				var failedErrs []error
+				for _, batch := range batches {
+					errs := make([]error, len(batch))
+					var wg sync.WaitGroup
+					for i, t := range batch {
+						wg.Add(1)
+						go func() {
+							defer wg.Done()
+							errs[i] = attempt(t)
+						}()
+					}
+					wg.Wait()
+					for _, e := range errs {
+						if e != nil {
+							failedErrs = append(failedErrs, e)
 						}
-						out.WriteString(s)
 					}
-					if err := executor.WriteAtomic(rt.Output, out.String()); err != nil {
-						return err
+					if len(failedErrs) > 0 && failFast {
+						break
+					}
+				}
+				if len(failedErrs) > 0 {
+					sortByTarget(ordered, entries, func(e manifestEntry) string { return e.Target })
+					nErrors += len(failedErrs) - 1 // finish counts one
+					return finish(errors.Join(failedErrs...))
+				}
+				// report in build order, not completion order
+				sortByTarget(ordered, entries, func(e manifestEntry) string { return e.Target })
+				sortByTarget(ordered, built, func(n string) string { return n })
+			}
+
+			if len(staged) > 0 && !dryRun {
+				if stageValidate != "" {
+					if err := runStageValidate(stageValidate, expandPath(stageDir)); err != nil {
+						return finish(err)
 					}
-					fmt.Fprintf(os.Stderr, "  action: wrote %s\n", rt.Output)
 				}
+				if err := promoteStaged(staged, outputMode); err != nil {
+					return finish(err)
+				}
+			}
+			if exportEnv != "" && !dryRun {
+				if err := writeExportEnv(expandPath(exportEnv), effective, built); err != nil {
+					return finish(err)
+				}
+			}
+			if err := finish(nil); err != nil || dryRun || cfg.PostBuild == "" {
+				return err
 			}
-			return nil
+			fmt.Fprintf(log, "confb: running post_build (%d targets)\n", len(built))
+			return daemon.RunPostBuild(cfg, built, buildID)
 		},
 	}
 
 	// flags for build
 	cmd.Flags().BoolVar(&trace, "trace", false, "print resolved baseDir, config path, and per-target plan")
+	cmd.Flags().StringVar(&cacheDir, "cache-dir", "", "skip targets whose definition, source mtimes/sizes and output are unchanged since the last build (cache files in DIR)")
+	cmd.Flags().BoolVar(&noCache, "no-cache", false, "with --cache-dir, ignore the cache and rebuild everything (the cache is still updated)")
+	cmd.Flags().BoolVar(&summary, "summary", false, "finish with one line: targets built, unchanged (same output checksum) and failed")
+	cmd.Flags().BoolVar(&quiet, "quiet", false, "do not print a line per target")
+	cmd.Flags().StringSliceVar(&targetNames, "targets", nil, "build only these targets (comma-separated or repeated; never read from CONFB_TARGETS, which hooks receive)")
+	cmd.Flags().StringVar(&targetsFile, "targets-file", "", "build only the targets listed in PATH, one per line (# comments; combined with --targets)")
 	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "validate and plan only; do not write outputs")
 	cmd.Flags().StringArrayVar(&overridesFlag, "output-override", nil, "override TARGET=PATH (repeatable)")
+	cmd.Flags().StringArrayVar(&formatOverridesFlag, "format-override", nil, "override TARGET=FORMAT (repeatable)")
+	cmd.Flags().BoolVar(&verifyWrite, "verify-write", false, "read each output back after writing and compare checksums")
+	cmd.Flags().StringVar(&outputMode, "output-mode", executor.WriteModeAtomic, "how outputs are written: atomic (temp + rename), copy (temp in the system temp dir, then copied) or in-place (truncate and rewrite; for outputs in read-only directories)")
+	_ = cmd.RegisterFlagCompletionFunc("output-mode", completeValues(executor.WriteModeAtomic, executor.WriteModeCopy, executor.WriteModeInPlace))
+	cmd.Flags().BoolVar(&verifyOutput, "verify-output", false, "re-parse each output in its format after writing (verify_output for every target)")
+	cmd.Flags().StringVar(&traceMerge, "trace-merge", "", "print the intermediate merge state of TARGET after each source file (implies --trace)")
+	cmd.Flags().StringVar(&traceMergeFile, "trace-merge-file", "", "with --trace-merge, write the merge trace to PATH instead of stderr")
+	cmd.Flags().StringVar(&traceProvenance, "trace-provenance", "", "write OUTPUT.provenance.json mapping each merged key of TARGET to the source file that set it")
+	cmd.Flags().BoolVar(&provenanceStdout, "provenance-stdout", false, "with --trace-provenance, print the provenance JSON to stdout instead")
+	cmd.Flags().StringVar(&manifestPath, "manifest", "", "write a JSON manifest of targets, source checksums and output checksums to PATH")
+	cmd.Flags().StringArrayVar(&envFiles, "env-file", nil, "read KEY=VALUE lines from PATH into the environment before loading the config (repeatable; existing variables win)")
+	cmd.Flags().BoolVar(&strict, "strict", false, "fail instead of warning when an output's extension belongs to another format (also strict_extensions: true)")
+	cmd.Flags().BoolVar(&noExtensionCheck, "no-extension-check", false, "do not compare output extensions with target formats")
+	cmd.Flags().BoolVar(&watch, "watch", false, "after building, keep watching the sources and rebuild on change (like 'confb run', with this command's target flags)")
+	cmd.Flags().DurationVar(&watchIdle, "watch-idle-timeout", 0, "with --watch, exit once no source has changed for DURATION (implies --watch)")
+	cmd.Flags().StringVar(&headerOnly, "header-only", "", "print the header TARGET would get, with FORMAT's comment prefix (TARGET=FORMAT), without building")
+	cmd.Flags().BoolVar(&failFast, "fail-fast", false, "stop at the first target that fails (default: build the rest and report every failure)")
+	cmd.Flags().IntVar(&parallel, "parallel", 1, "build up to N targets at once; a target still waits for the targets it reads via target_ref")
+	cmd.Flags().StringVar(&outputPrefix, "output-prefix", "", "prepend DIR to every output path, absolute ones included (e.g. a chroot or image root)")
+	cmd.Flags().StringVar(&exportEnv, "export-env", "", "write \"export NAME='…'\" lines for every env:NAME output built to PATH")
+	cmd.Flags().StringVar(&stageDir, "stage-dir", "", "write all outputs into DIR first and move them into place only after every target succeeds (never read from CONFB_STAGE_DIR, which hooks receive)")
+	cmd.Flags().StringVar(&stageValidate, "stage-validate", "", "with --stage-dir, run CMD (sh, in the staging dir) before promoting; non-zero exit aborts")
+	cmd.Flags().StringArrayVar(&jsonCompactFlag, "json-compact", nil, "serialise json TARGET=1 without indentation (repeatable)")
+	_ = cmd.RegisterFlagCompletionFunc("targets", completeTargets)
 
 	return cmd
 }
+
+// sortByTarget stably reorders items (by the target name key returns) to
+// follow order.
+func sortByTarget[T any](order []config.Target, items []T, key func(T) string) {
+	pos := make(map[string]int, len(order))
+	for i, t := range order {
+		pos[t.Name] = i
+	}
+	sort.SliceStable(items, func(i, j int) bool { return pos[key(items[i])] < pos[key(items[j])] })
+}
+
+// outputBodySum returns the SHA-256 of output's content without the build header
+// (which carries a timestamp), or "" if it cannot be read.
+func outputBodySum(t config.Target, output string) string {
+	if !executor.IsFileOutput(output) {
+		return ""
+	}
+	b, err := os.ReadFile(output)
+	if err != nil {
+		return ""
+	}
+	s := string(b)
+	if prefix, ok := commentPrefixFor(t.Format); ok && strings.HasPrefix(s, prefix+"confb build\n") {
+		if i := strings.Index(s, "\n\n"); i >= 0 {
+			s = s[i+2:]
+		}
+	}
+	return sha256Hex(s)
+}
+
+// writeExportEnv writes one shell "export NAME='value'" line per env:NAME
+// output among the built targets, in build order.
+func writeExportEnv(path string, targets []config.Target, built []string) error {
+	done := map[string]bool{}
+	for _, n := range built {
+		done[n] = true
+	}
+	var b strings.Builder
+	for _, t := range targets {
+		name, ok := executor.EnvOutputName(t.Output)
+		if !ok || !done[t.Name] {
+			continue
+		}
+		fmt.Fprintf(&b, "export %s='%s'\n", name, strings.ReplaceAll(os.Getenv(name), "'", `'\''`))
+	}
+	if err := executor.WriteAtomic(path, b.String()); err != nil {
+		return fmt.Errorf("--export-env: %w", err)
+	}
+	return nil
+}
+
+// writeProvenance writes prov (key path -> source file) as JSON next to output,
+// using write mode mode (--output-mode), or to stdout.
+func writeProvenance(cmd *cobra.Command, log io.Writer, output string, prov map[string]string, toStdout bool, mode string) error {
+	b, err := json.MarshalIndent(prov, "", "  ")
+	if err != nil {
+		return err
+	}
+	if toStdout {
+		_, err = fmt.Fprintln(cmd.OutOrStdout(), string(b))
+		return err
+	}
+	if output == executor.StdoutPath {
+		return errors.New("--trace-provenance: target writes to stdout; use --provenance-stdout")
+	}
+	if !executor.IsFileOutput(output) {
+		return fmt.Errorf("--trace-provenance: target writes to %s; use --provenance-stdout", output)
+	}
+	p := output + ".provenance.json"
+	if err := executor.WriteWithMode(p, string(b)+"\n", mode); err != nil {
+		return err
+	}
+	fmt.Fprintf(log, "  provenance: %s\n", p)
+	return nil
+}
+
+// writeTarget builds and writes one target, reporting the action on log.
+func writeTarget(cmd *cobra.Command, log io.Writer, t config.Target, rt *plan.ResolvedTarget, srcFormat string, wo executor.WriteOptions, bo blend.Options) (string, error) {
+	// merged vs concat path
+	if t.Merge != nil {
+		format := strings.ToLower(t.Format)
+		var content string
+		var err error
+		switch format {
+		case "yaml", "yml", "json", "toml":
+			content, err = blend.BlendStructuredAs(srcFormat, format, t.Merge.Rules, rt.Files, bo)
+		case "kdl":
+			content, err = blend.BlendKDLWith(t.Merge.Rules, rt.Files, bo)
+		case "ini":
+			content, err = blend.BlendINIWith(t.Merge.Rules, rt.Files, bo)
+		case "raw":
+			err = fmt.Errorf("merge not supported for format %q", t.Format)
+		default:
+			err = fmt.Errorf("unknown format %q", t.Format)
+		}
+		if err != nil {
+			return "", fmt.Errorf("%s: merge: %w", rt.Name, err)
+		}
+
+		// prepend header if supported
+		if header := headerForTarget(cmd, t, rt); header != nil {
+			content = string(header) + content
+		}
+		if err := executor.WriteWith(rt.Output, content, wo); err != nil {
+			return "", err
+		}
+		fmt.Fprintf(log, "  action: merged (%s) -> wrote %s\n", format, displayOutput(rt.Output))
+		return sha256Hex(executor.ApplyPostProcess(content, wo.PostProcess)), nil
+	}
+
+	// concat; if header supported, we need to inject it by doing the concat here
+	header := headerForTarget(cmd, t, rt)
+	if header == nil {
+		if err := executor.BuildAndWriteWith(rt.Output, rt.Files, wo); err != nil {
+			return "", err
+		}
+		fmt.Fprintf(log, "  action: wrote %s\n", displayOutput(rt.Output))
+		return executor.SHA256OfSources(rt.Files, wo)
+	}
+	// concat with normalization: CRLF->LF, ensure LF final newline per file
+	var out bytes.Buffer
+	out.Write(header)
+	for _, f := range rt.Files {
+		b, err := executor.ReadSourceRetry(f, rt.Encodings[f], wo.Retry)
+		if err != nil {
+			return "", err
+		}
+		if b, err = executor.TransformSource(b, t.Name, f, rt.Transforms[f]); err != nil {
+			return "", err
+		}
+		if err := executor.CheckUTF8(f, b); err != nil {
+			return "", err
+		}
+		s := string(b)
+		s = strings.ReplaceAll(s, "\r\n", "\n")
+		s = strings.ReplaceAll(s, "\r", "\n")
+		if !strings.HasSuffix(s, "\n") {
+			s += "\n"
+		}
+		out.WriteString(s)
+	}
+	if err := executor.WriteWith(rt.Output, out.String(), wo); err != nil {
+		return "", err
+	}
+	fmt.Fprintf(log, "  action: wrote %s\n", displayOutput(rt.Output))
+	return sha256Hex(executor.ApplyPostProcess(out.String(), wo.PostProcess)), nil
+}
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,99 @@
+package cli
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/nekwebdev/confb/internal/config"
+	executor "github.com/nekwebdev/confb/internal/exec"
+	"github.com/nekwebdev/confb/internal/plan"
+)
+
+// buildCache is what `confb build --cache-dir` remembers about a target's last
+// successful build: the target definition, the mtime and size of every source,
+// and the checksum of the output it wrote.
+type buildCache struct {
+	Target       string                 `json:"target_sha256"` // SHA-256 of the target definition
+	Sources      map[string]sourceStamp `json:"sources"`
+	Output       string                 `json:"output"`
+	OutputSHA256 string                 `json:"output_sha256"`
+}
+
+type sourceStamp struct {
+	MtimeNS int64 `json:"mtime_ns"`
+	Size    int64 `json:"size"`
+}
+
+// cachePath is DIR/target-NAME.cache.
+func cachePath(dir, target string) string {
+	return filepath.Join(dir, "target-"+strings.ReplaceAll(target, string(filepath.Separator), "_")+".cache")
+}
+
+// newBuildCache describes t as planned in rt; ok is false if a source or the
+// output cannot be stat'ed/read (the target then always rebuilds).
+func newBuildCache(t config.Target, rt *plan.ResolvedTarget) (c buildCache, ok bool) {
+	def, err := json.Marshal(t)
+	if err != nil {
+		return c, false
+	}
+	c = buildCache{Target: sha256Hex(string(def)), Sources: map[string]sourceStamp{}, Output: rt.Output}
+	for _, f := range rt.Files {
+		st, err := os.Stat(f)
+		if err != nil {
+			return c, false
+		}
+		c.Sources[f] = sourceStamp{MtimeNS: st.ModTime().UnixNano(), Size: st.Size()}
+	}
+	if !executor.IsFileOutput(rt.Output) {
+		return c, false
+	}
+	b, err := os.ReadFile(rt.Output)
+	if err != nil {
+		return c, false
+	}
+	c.OutputSHA256 = sha256Hex(string(b))
+	return c, true
+}
+
+// cacheHit reports whether the cache in dir matches t and rt exactly: same
+// definition, same sources with the same mtime and size, and an output that
+// still has the checksum written last time (returned as sum).
+func cacheHit(dir string, t config.Target, rt *plan.ResolvedTarget) (sum string, hit bool) {
+	b, err := os.ReadFile(cachePath(dir, t.Name))
+	if err != nil {
+		return "", false
+	}
+	var old buildCache
+	if json.Unmarshal(b, &old) != nil {
+		return "", false
+	}
+	cur, ok := newBuildCache(t, rt)
+	if !ok || cur.Target != old.Target || cur.Output != old.Output || cur.OutputSHA256 != old.OutputSHA256 || len(cur.Sources) != len(old.Sources) {
+		return "", false
+	}
+	for f, s := range cur.Sources {
+		if old.Sources[f] != s {
+			return "", false
+		}
+	}
+	return cur.OutputSHA256, true
+}
+
+// writeCache records t's just-written state in dir; targets it cannot describe
+// (e.g. stdout outputs) are skipped.
+func writeCache(dir string, t config.Target, rt *plan.ResolvedTarget) error {
+	c, ok := newBuildCache(t, rt)
+	if !ok {
+		return nil
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return executor.WriteAtomic(cachePath(dir, t.Name), string(b)+"\n")
+}
@@ -2,10 +2,50 @@
 
 import (
 	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/spf13/cobra"
+
+	"github.com/nekwebdev/confb/internal/config"
 )
 
+// targetFormats are the formats a target can be created with (init --format).
+var targetFormats = []string{"yaml", "json", "toml", "kdl", "ini", "raw"}
+
+// completeValues offers a fixed list of flag values and no file names.
+func completeValues(values ...string) cobra.CompletionFunc {
+	return cobra.FixedCompletions(values, cobra.ShellCompDirectiveNoFileComp)
+}
+
+// completeTargets offers the target names of the config that --config (or
+// CONFB_CONFIG, or the default path) names. --targets is comma-separated, so
+// the names extend what precedes the last comma. A config that does not load
+// offers nothing.
+func completeTargets(cmd *cobra.Command, _ []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	p, err := resolveConfig(cmd)
+	if err != nil || p == config.StdinPath {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	// completion skips PersistentPreRunE, so --chdir has not been applied
+	if cd, _ := cmd.Flags().GetString("chdir"); cd != "" && !filepath.IsAbs(p) {
+		p = filepath.Join(expandPath(cd), p)
+	}
+	cfg, err := config.Load(p, extraConfigs(cmd)...)
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	prefix := ""
+	if i := strings.LastIndex(toComplete, ","); i >= 0 {
+		prefix = toComplete[:i+1]
+	}
+	names := make([]string, 0, len(cfg.Targets))
+	for _, t := range cfg.Targets {
+		names = append(names, prefix+t.Name)
+	}
+	return names, cobra.ShellCompDirectiveNoFileComp
+}
+
 func newCompletionCmd(root *cobra.Command) *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "completion",
@@ -0,0 +1,54 @@
+package cli
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// loadEnvFiles reads dotenv files (KEY=VALUE lines, '#' comments, optional
+// "export " prefix and surrounding quotes) in order and sets each key that is
+// not already in the environment, so the process environment and earlier
+// files take precedence. Source paths may then use ${KEY}.
+func loadEnvFiles(paths []string) error {
+	for _, p := range paths {
+		f, err := os.Open(expandPath(p))
+		if err != nil {
+			return fmt.Errorf("--env-file: %w", err)
+		}
+		sc := bufio.NewScanner(f)
+		n := 0
+		for sc.Scan() {
+			n++
+			line := strings.TrimSpace(sc.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			line = strings.TrimPrefix(line, "export ")
+			key, val, ok := strings.Cut(line, "=")
+			key = strings.TrimSpace(key)
+			if !ok || key == "" {
+				_ = f.Close()
+				return fmt.Errorf("--env-file %s:%d: expected KEY=VALUE", p, n)
+			}
+			val = strings.TrimSpace(val)
+			if len(val) >= 2 && (val[0] == '"' || val[0] == '\'') && val[len(val)-1] == val[0] {
+				val = val[1 : len(val)-1]
+			}
+			if _, set := os.LookupEnv(key); set {
+				continue
+			}
+			if err := os.Setenv(key, val); err != nil {
+				_ = f.Close()
+				return fmt.Errorf("--env-file %s:%d: %w", p, n, err)
+			}
+		}
+		err = sc.Err()
+		_ = f.Close()
+		if err != nil {
+			return fmt.Errorf("--env-file %s: %w", p, err)
+		}
+	}
+	return nil
+}
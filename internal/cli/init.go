@@ -0,0 +1,145 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/nekwebdev/confb/internal/config"
+)
+
+// starterRules holds the canonical merge block (already indented) per format.
+var starterRules = map[string]string{
+	"kdl": `    merge:
+      rules:
+        # keys: last_wins | first_wins | append (repeated properties inside a merged block)
+        keys: last_wins
+        # merge only these top-level sections; others stay separate blocks (omit to merge all)
+        section_keys: ["layout", "output"]
+`,
+	"yaml": `    merge:
+      rules:
+        maps: deep             # deep | replace
+        arrays: unique_append  # replace | append | unique_append
+`,
+	"json": `    merge:
+      rules:
+        maps: deep             # deep | replace
+        arrays: unique_append  # replace | append | unique_append
+`,
+	"toml": `    merge:
+      rules:
+        maps: deep             # deep | replace
+        arrays: replace        # replace | append | unique_append
+`,
+	"ini": `    merge:
+      rules:
+        repeated_keys: last_wins  # last_wins | append
+`,
+	"raw": `    # raw targets are concatenated (newline-normalized); no merge block
+`,
+}
+
+// starterExt maps a format to the file extension used in example paths.
+var starterExt = map[string]string{
+	"kdl": "kdl", "yaml": "yaml", "json": "json", "toml": "toml", "ini": "ini", "raw": "txt",
+}
+
+// starterTarget renders one example target of the given format.
+func starterTarget(format, sources string) string {
+	ext := starterExt[format]
+	if sources == "" {
+		sources = "./conf.d/*." + ext
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "  - name: app_%s\n", format)
+	fmt.Fprintf(&b, "    format: %s\n", format)
+	fmt.Fprintf(&b, "    output: ~/.config/app/config.%s\n", ext)
+	b.WriteString("    sources:\n")
+	fmt.Fprintf(&b, "      - path: %q\n", sources)
+	b.WriteString("        sort: lex\n")
+	b.WriteString(starterRules[format])
+	b.WriteString("    # on_change: |\n    #   notify-send \"confb\" \"rebuilt {target} → {output}\"\n")
+	return b.String()
+}
+
+// starterConfig renders a confb.yaml. With format empty, one commented example
+// per format is generated.
+func starterConfig(format, sources string) string {
+	var b strings.Builder
+	b.WriteString("# confb configuration (generated by `confb init`)\n")
+	b.WriteString("# See confb.sample.yaml for every option.\n\n")
+	b.WriteString("version: 1\n\ntargets:\n")
+	if format != "" {
+		b.WriteString(starterTarget(format, sources))
+		return b.String()
+	}
+	for i, f := range []string{"kdl", "yaml", "json", "toml", "ini", "raw"} {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		switch f {
+		case "kdl":
+			b.WriteString("  # KDL: blocks with the same name + head are merged; keys decides repeated properties\n")
+		case "yaml", "json", "toml":
+			fmt.Fprintf(&b, "  # %s: maps merge deep or replace; arrays replace, append or unique_append\n", strings.ToUpper(f))
+		case "ini":
+			b.WriteString("  # INI: sections merge by name; repeated_keys keeps the last value or all of them\n")
+		case "raw":
+			b.WriteString("  # RAW: plain concatenation in source order\n")
+		}
+		b.WriteString(starterTarget(f, sources))
+	}
+	return b.String()
+}
+
+func newInitCmd() *cobra.Command {
+	var format string
+	var withSources string
+	var force bool
+
+	cmd := &cobra.Command{
+		Use:   "init",
+		Short: "Write a starter confb.yaml",
+		Long: `Init writes a starter configuration to the config path (-c, CONFB_CONFIG or
+~/.config/confb/confb.yaml).
+
+  • --format=FORMAT generates one example target using that format's canonical merge rules
+  • without --format, a commented multi-format starter is generated
+  • --with-sources=PATTERN sets the example source path/glob
+  • an existing file is never overwritten unless --force is given`,
+		Example: `  confb init --format=kdl
+  confb init -c ./confb.yaml --format=yaml --with-sources='./conf.d/*.yaml'`,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			f := strings.ToLower(strings.TrimSpace(format))
+			if f != "" && (!config.ValidFormat(f) || f == "auto") {
+				return fmt.Errorf("invalid --format %q (expected yaml|toml|ini|json|raw|kdl)", format)
+			}
+
+			cfgPath, err := resolveConfig(cmd)
+			if err != nil {
+				return err
+			}
+			if _, err := os.Stat(cfgPath); err == nil && !force {
+				return fmt.Errorf("%s already exists (use --force to overwrite)", cfgPath)
+			}
+			if err := os.MkdirAll(filepath.Dir(cfgPath), 0o755); err != nil {
+				return fmt.Errorf("mkdir %q: %w", filepath.Dir(cfgPath), err)
+			}
+			if err := os.WriteFile(cfgPath, []byte(starterConfig(f, withSources)), 0o644); err != nil {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "confb: wrote %s\n", cfgPath)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&format, "format", "", "format of the example target (kdl|yaml|json|toml|ini|raw)")
+	cmd.Flags().StringVar(&withSources, "with-sources", "", "source path or glob for the example target")
+	cmd.Flags().BoolVar(&force, "force", false, "overwrite an existing config file")
+	_ = cmd.RegisterFlagCompletionFunc("format", completeValues(targetFormats...))
+	return cmd
+}
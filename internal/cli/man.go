@@ -1,6 +1,8 @@
 package cli
 
 import (
+	"fmt"
+	"io"
 	"log"
 	"os"
 
@@ -14,15 +16,15 @@ func generateManCmd(root *cobra.Command) *cobra.Command {
 		Use:   "man",
 		Short: "Generate man pages for confb",
 		Long: `Generate UNIX manual pages for confb and its subcommands.
-By default, outputs to ./man1. Use --output to specify another directory.`,
+By default, outputs to ./man1. Use --output to specify another directory.
+--format=md or --format=rst writes Markdown or reStructuredText instead (for wikis
+and doc sites); --output - streams every page to stdout, separated by "---".`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			outputDir, _ := cmd.Flags().GetString("output")
+			format, _ := cmd.Flags().GetString("format")
 			if outputDir == "" {
 				outputDir = "./man1"
 			}
-			if err := os.MkdirAll(outputDir, 0o755); err != nil {
-				return err
-			}
 			header := &doc.GenManHeader{
 				Title:   "CONFB",
 				Section: "1",
@@ -30,14 +32,66 @@ func generateManCmd(root *cobra.Command) *cobra.Command {
 				Manual:  "confb manual",
 			}
 			cmd.DisableAutoGenTag = true
-			if err := doc.GenManTree(root, header, outputDir); err != nil {
+
+			var genOne func(*cobra.Command, io.Writer) error
+			var genTree func(*cobra.Command, string) error
+			switch format {
+			case "man":
+				genOne = func(c *cobra.Command, w io.Writer) error { return doc.GenMan(c, header, w) }
+				genTree = func(c *cobra.Command, dir string) error { return doc.GenManTree(c, header, dir) }
+			case "md":
+				genOne, genTree = doc.GenMarkdown, doc.GenMarkdownTree
+			case "rst":
+				genOne, genTree = doc.GenReST, doc.GenReSTTree
+			default:
+				return fmt.Errorf("--format must be man, md or rst (got %q)", format)
+			}
+
+			if outputDir == "-" {
+				return genStream(root, cmd.OutOrStdout(), genOne)
+			}
+			if err := os.MkdirAll(outputDir, 0o755); err != nil {
+				return err
+			}
+			if err := genTree(root, outputDir); err != nil {
 				return err
 			}
-			log.Printf("Man pages written to %s\n", outputDir)
+			log.Printf("%s docs written to %s\n", format, outputDir)
 			return nil
 		},
 	}
 
-	cmd.Flags().StringP("output", "o", "./man1", "output directory for generated man pages")
+	cmd.Flags().StringP("output", "o", "./man1", `output directory for generated docs ("-" for stdout; never read from CONFB_OUTPUT, which hooks receive)`)
+	cmd.Flags().String("format", "man", "doc format: man | md | rst")
+	_ = cmd.RegisterFlagCompletionFunc("format", completeValues("man", "md", "rst"))
 	return cmd
 }
+
+// genStream writes the docs of c and its subcommands to w in tree order,
+// separating pages with "---" lines. It skips the commands the doc.Gen*Tree
+// helpers skip.
+func genStream(c *cobra.Command, w io.Writer, gen func(*cobra.Command, io.Writer) error) error {
+	first := true
+	var walk func(*cobra.Command) error
+	walk = func(c *cobra.Command) error {
+		if !c.IsAvailableCommand() || c.IsAdditionalHelpTopicCommand() {
+			return nil
+		}
+		if !first {
+			if _, err := fmt.Fprintln(w, "---"); err != nil {
+				return err
+			}
+		}
+		first = false
+		if err := gen(c, w); err != nil {
+			return err
+		}
+		for _, sub := range c.Commands() {
+			if err := walk(sub); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return walk(c)
+}
@@ -0,0 +1,77 @@
+package cli
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/nekwebdev/confb/internal/config"
+	executor "github.com/nekwebdev/confb/internal/exec"
+	"github.com/nekwebdev/confb/internal/plan"
+)
+
+// manifestEntry records the provenance of one target for `confb build --manifest`.
+type manifestEntry struct {
+	BuildID      string         `json:"build_id"`
+	Target       string         `json:"target"`
+	Output       string         `json:"output"`
+	Format       string         `json:"format"`
+	Files        []manifestFile `json:"files"`
+	NewestMtime  time.Time      `json:"newest_source_mtime,omitzero"` // zero when planning failed
+	MergedSHA256 string         `json:"merged_sha256,omitempty"`      // empty for --dry-run and failures
+	BuiltAt      time.Time      `json:"built_at"`
+	DurationMS   int64          `json:"duration_ms"`
+	Error        string         `json:"error,omitempty"`
+}
+
+// manifestFile is one resolved source with the SHA-256 of its bytes on disk.
+type manifestFile struct {
+	Path   string `json:"path"`
+	SHA256 string `json:"sha256"`
+}
+
+// newManifestEntry describes t after a build attempt that started at started.
+// rt may be nil when planning failed.
+func newManifestEntry(t config.Target, rt *plan.ResolvedTarget, mergedSHA string, started time.Time, err error) manifestEntry {
+	e := manifestEntry{
+		Target:       t.Name,
+		Output:       t.Output,
+		Format:       strings.ToLower(t.Format),
+		Files:        []manifestFile{},
+		MergedSHA256: mergedSHA,
+		BuiltAt:      started.UTC(),
+		DurationMS:   time.Since(started).Milliseconds(),
+	}
+	if rt != nil {
+		e.Output = rt.Output
+		e.NewestMtime = rt.NewestSourceMtime.UTC()
+		for _, f := range rt.Files {
+			mf := manifestFile{Path: f}
+			if b, err := os.ReadFile(f); err == nil {
+				sum := sha256.Sum256(b)
+				mf.SHA256 = hex.EncodeToString(sum[:])
+			}
+			e.Files = append(e.Files, mf)
+		}
+	}
+	if err != nil {
+		e.Error = err.Error()
+		e.MergedSHA256 = ""
+	}
+	return e
+}
+
+// writeManifest writes entries as an indented JSON array, atomically.
+func writeManifest(path string, entries []manifestEntry) error {
+	if entries == nil {
+		entries = []manifestEntry{}
+	}
+	b, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	return executor.WriteAtomic(path, string(b)+"\n")
+}
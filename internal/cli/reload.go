@@ -127,6 +127,7 @@ func newReloadCmd() *cobra.Command {
 	cmd.Flags().BoolVar(&userUnit, "user", false, "use systemd --user instead of system instance")
 	cmd.Flags().StringVar(&method, "method", "auto", "reload method: auto|pid|systemd")
 	cmd.Flags().BoolVar(&trace, "trace", false, "verbose output")
+	_ = cmd.RegisterFlagCompletionFunc("method", completeValues("auto", "pid", "systemd"))
 	return cmd
 }
 
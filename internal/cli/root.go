@@ -7,17 +7,32 @@
 	"strings"
 
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	"github.com/nekwebdev/confb/internal/config"
 )
 
-const defaultRelConfig = ".config/confb/confb.yaml"
+const defaultRelConfigDir = ".config/confb"
+
+// defaultConfigNames are tried in order in the default config directory.
+var defaultConfigNames = []string{"confb.yaml", "confb.toml", "confb.json"}
 
-// defaultConfigPath returns "$HOME/.config/confb/confb.yaml", or "confb.yaml" if $HOME is unknown.
+// defaultConfigPath returns the first of confb.yaml, confb.toml and confb.json
+// that exists in "$HOME/.config/confb" (confb.yaml if none does), or
+// "confb.yaml" if $HOME is unknown.
 func defaultConfigPath() string {
 	home, err := os.UserHomeDir()
 	if err != nil || home == "" {
 		return "confb.yaml"
 	}
-	return filepath.Join(home, defaultRelConfig)
+	dir := filepath.Join(home, defaultRelConfigDir)
+	for _, name := range defaultConfigNames {
+		p := filepath.Join(dir, name)
+		if fi, err := os.Stat(p); err == nil && !fi.IsDir() {
+			return p
+		}
+	}
+	return filepath.Join(dir, defaultConfigNames[0])
 }
 
 // expandPath expands "~" and environment variables in a path.
@@ -33,16 +48,171 @@ func expandPath(p string) string {
 	return os.ExpandEnv(p)
 }
 
+// hookEnv lists the CONFB_* variables confb exports to the commands it runs
+// (on_change, post_build, --stage-validate). They are never read as flag
+// overrides, so a hook that runs confb is not reconfigured by its parent. Some
+// share a flag's name (--targets, --stage-dir, --output); those flags say so
+// in their help and cannot be set from the environment.
+var hookEnv = map[string]bool{
+	"CONFB_BUILD_ID":    true,
+	"CONFB_BUILT_COUNT": true,
+	"CONFB_OUTPUT":      true,
+	"CONFB_STAGE_DIR":   true,
+	"CONFB_TARGET":      true,
+	"CONFB_TARGETS":     true,
+	"CONFB_TIMESTAMP":   true,
+
+	"CONFB_CHANGED_TARGETS": true,
+}
+
+// flagEnvName is the variable that overrides --name: CONFB_ + NAME with
+// hyphens as underscores (--debounce-ms → CONFB_DEBOUNCE_MS).
+func flagEnvName(name string) string {
+	return "CONFB_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// chdirAndFlagEnv is the root PersistentPreRunE. Every flag not passed on the
+// command line takes its value from its CONFB_* variable (see flagEnvName),
+// if set. --chdir (or CONFB_CHDIR) is applied first, so a relative
+// CONFB_CONFIG resolves against the new working directory.
+func chdirAndFlagEnv(c *cobra.Command, _ []string) error {
+	if err := setFlagFromEnv(c, "chdir"); err != nil {
+		return err
+	}
+	if cd, _ := c.Flags().GetString("chdir"); cd != "" {
+		if err := os.Chdir(cd); err != nil {
+			return fmt.Errorf("unable to chdir: %w", err)
+		}
+	}
+	var err error
+	c.Flags().VisitAll(func(f *pflag.Flag) {
+		if err == nil && f.Name != "chdir" {
+			err = setFlagFromEnv(c, f.Name)
+		}
+	})
+	return err
+}
+
+// setFlagFromEnv sets flag name from its CONFB_* variable unless it was
+// passed explicitly; --config is made absolute (except stdin, "-").
+func setFlagFromEnv(c *cobra.Command, name string) error {
+	f := c.Flags().Lookup(name)
+	env := flagEnvName(name)
+	if f == nil || f.Changed || name == "help" || name == "version" || hookEnv[env] {
+		return nil
+	}
+	v, ok := os.LookupEnv(env)
+	if !ok || v == "" {
+		return nil
+	}
+	if name == "config" {
+		v = expandPath(v)
+		if v != config.StdinPath {
+			if abs, err := filepath.Abs(v); err == nil {
+				v = abs
+			}
+		}
+	}
+	if err := c.Flags().Set(name, v); err != nil {
+		return fmt.Errorf("%s: %w", env, err)
+	}
+	return nil
+}
+
 // resolveConfig applies precedence: flag > CONFB_CONFIG > defaultConfigPath.
+// With --auto-discover, a path that does not exist falls back to the nearest
+// confb.yaml in the working directory or its parents (see discoverConfig).
 func resolveConfig(cmd *cobra.Command) (string, error) {
+	p := defaultConfigPath()
 	if f := cmd.Flags().Lookup("config"); f != nil && f.Changed {
 		cp, _ := cmd.Flags().GetString("config")
-		return expandPath(cp), nil
+		p = expandPath(cp)
+	} else if v := os.Getenv("CONFB_CONFIG"); v != "" {
+		p = expandPath(v)
 	}
-	if v := os.Getenv("CONFB_CONFIG"); v != "" {
-		return expandPath(v), nil
+	return autoDiscover(cmd, p)
+}
+
+// loadConfig is config.Load (with --extra-config), except that path "-" reads
+// the command's stdin; relative paths in such a config resolve against the
+// working directory.
+func loadConfig(cmd *cobra.Command, path string) (*config.Config, error) {
+	extra := extraConfigs(cmd)
+	if path != config.StdinPath {
+		return config.Load(path, extra...)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	return config.LoadReader(cmd.InOrStdin(), wd, extra...)
+}
+
+// extraConfigs returns the --extra-config files, absolute and ~/$VARS expanded.
+func extraConfigs(cmd *cobra.Command) []string {
+	paths, _ := cmd.Flags().GetStringArray("extra-config")
+	out := make([]string, 0, len(paths))
+	for _, p := range paths {
+		p = expandPath(p)
+		if abs, err := filepath.Abs(p); err == nil {
+			p = abs
+		}
+		out = append(out, p)
+	}
+	return out
+}
+
+// autoDiscover returns p, or with --auto-discover and p missing, the nearest
+// confb.yaml found by discoverConfig. Stdin ("-") is never replaced.
+func autoDiscover(cmd *cobra.Command, p string) (string, error) {
+	if auto, _ := cmd.Flags().GetBool("auto-discover"); auto && p != config.StdinPath {
+		if _, err := os.Stat(p); os.IsNotExist(err) {
+			found, err := discoverConfig()
+			if err != nil {
+				return "", err
+			}
+			fmt.Fprintf(os.Stderr, "confb: %s not found; discovered %s\n", p, found)
+			return found, nil
+		}
+	}
+	return p, nil
+}
+
+// discoverName is the file --auto-discover looks for.
+const discoverName = "confb.yaml"
+
+// discoverConfig walks from the working directory up to $HOME (or
+// CONFB_DISCOVER_STOP, or the filesystem root when the working directory is
+// outside it) and returns the first confb.yaml found.
+func discoverConfig() (string, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	stop := expandPath(os.Getenv("CONFB_DISCOVER_STOP"))
+	if stop == "" {
+		stop, _ = os.UserHomeDir()
+	}
+	if stop != "" {
+		if abs, err := filepath.Abs(stop); err == nil {
+			stop = abs
+		}
+		if rel, err := filepath.Rel(stop, dir); err != nil || strings.HasPrefix(rel, "..") {
+			stop = "" // not below the stop dir; walk to the root
+		}
+	}
+	start := dir
+	for {
+		p := filepath.Join(dir, discoverName)
+		if fi, err := os.Stat(p); err == nil && !fi.IsDir() {
+			return p, nil
+		}
+		parent := filepath.Dir(dir)
+		if dir == stop || parent == dir {
+			return "", fmt.Errorf("--auto-discover: no %s in %s or its parents", discoverName, start)
+		}
+		dir = parent
 	}
-	return defaultConfigPath(), nil
 }
 
 // NewRootCmd sets up the base "confb" command tree.
@@ -54,7 +224,7 @@ func NewRootCmd(version string) *cobra.Command {
 
 Supported formats:
   - KDL: merge selected sections, key policy (first_wins|last_wins|append)
-  - YAML/JSON/TOML: maps (deep|replace), arrays (append|unique_append|replace)
+  - YAML/JSON/TOML: maps (deep|replace), arrays (append|unique_append|intersect|replace)
   - INI: repeated_keys (append|last_wins)
   - RAW: newline-normalized concatenation
 
@@ -70,23 +240,12 @@ func NewRootCmd(version string) *cobra.Command {
 
 	cmd.SetVersionTemplate("confb version {{.Version}}\n")
 
-	cmd.PersistentFlags().StringP("config", "c", defaultConfigPath(), "path to confb configuration file (env CONFB_CONFIG)")
+	cmd.PersistentFlags().StringP("config", "c", defaultConfigPath(), "path to confb configuration file (env CONFB_CONFIG; \"-\" reads it from stdin for build and validate)")
+	cmd.PersistentFlags().StringArray("extra-config", nil, "merge another config file over --config (repeatable, in order): targets are appended, later defaults and top-level settings win")
 	cmd.PersistentFlags().StringP("chdir", "C", "", "change working directory before reading config")
+	cmd.PersistentFlags().Bool("auto-discover", false, "if the config file does not exist, use the nearest confb.yaml in the working directory or its parents (up to $HOME)")
 
-	// Honor --chdir early; also fold env into the flag if user didn't pass -c.
-	cmd.PersistentPreRunE = func(c *cobra.Command, _ []string) error {
-		if cd, _ := c.Flags().GetString("chdir"); cd != "" {
-			if err := os.Chdir(cd); err != nil {
-				return fmt.Errorf("unable to chdir: %w", err)
-			}
-		}
-		if f := c.Flags().Lookup("config"); f != nil && !f.Changed {
-			if v := os.Getenv("CONFB_CONFIG"); v != "" {
-				_ = c.Flags().Set("config", expandPath(v))
-			}
-		}
-		return nil
-	}
+	cmd.PersistentPreRunE = chdirAndFlagEnv
 
 	// Optional: "version" alias so both "--version" and "version" work
 	cmd.AddCommand(&cobra.Command{
@@ -105,6 +264,9 @@ func NewRootCmd(version string) *cobra.Command {
 		generateManCmd(cmd),
 		newCompletionCmd(cmd),
 		newReloadCmd(),
+		newInitCmd(),
+		newStatusCmd(),
+		newSchemaCmd(),
 	)
 
 	// default action with no subcommand: show help
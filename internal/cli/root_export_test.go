@@ -11,13 +11,20 @@ func NewRootCmdForTest() *cobra.Command {
 	}
 	// mirror root flags
 	root.PersistentFlags().StringP("config", "c", "confb.yaml", "path to confb.yaml")
+	root.PersistentFlags().StringArray("extra-config", nil, "merge another config file over --config")
 	root.PersistentFlags().String("chdir", "", "chdir before running command")
+	root.PersistentFlags().Bool("auto-discover", false, "walk up to find confb.yaml")
+	root.PersistentPreRunE = chdirAndFlagEnv
 
 	// subcommands
 	root.AddCommand(
 		newBuildCmd(),
 		newRunCmd(),
 		newValidateCmd(),
+		newInitCmd(),
+		newStatusCmd(),
+		newSchemaCmd(),
 	)
+	root.AddCommand(generateManCmd(root))
 	return root
 }
@@ -1,11 +1,24 @@
 package cli
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"strings"
+	"syscall"
 	"testing"
+	"time"
+
+	"github.com/spf13/cobra"
 
 	"github.com/nekwebdev/confb/internal/config"
+	"github.com/nekwebdev/confb/internal/daemon"
 )
 
 // write helper
@@ -45,6 +58,136 @@ func TestValidate_OK(t *testing.T) {
 	}
 }
 
+func TestValidate_WarnsMergeWithSingleSource(t *testing.T) {
+	td := t.TempDir()
+	cfg := filepath.Join(td, "confb.yaml")
+
+	writeFileT(t, filepath.Join(td, "a.yaml"), "a: 1\n")
+	writeFileT(t, cfg, `
+version: 1
+targets:
+  - name: y
+    format: yaml
+    output: ./out.yaml
+    sources:
+      - path: ./a.yaml
+    merge:
+      rules:
+        maps: deep
+`)
+
+	var stderr strings.Builder
+	root := NewRootCmdForTest()
+	root.SetErr(&stderr)
+	root.SetArgs([]string{"validate", "-c", cfg})
+	if err := root.Execute(); err != nil {
+		t.Fatalf("validate failed: %v", err)
+	}
+	if !strings.Contains(stderr.String(), `warning: target "y": merge rules set but only one source file resolves`) {
+		t.Fatalf("expected single-source merge warning, got:\n%s", stderr.String())
+	}
+}
+
+func TestValidate_DryBuildReportsInvalidYAML(t *testing.T) {
+	td := t.TempDir()
+	cfg := filepath.Join(td, "confb.yaml")
+	bad := filepath.Join(td, "src", "bad.yaml")
+	writeFileT(t, filepath.Join(td, "src", "ok.yaml"), "a: 1\n")
+	writeFileT(t, bad, "a: [1, 2\n")
+	writeFileT(t, cfg, `
+version: 1
+targets:
+  - name: broken
+    format: yaml
+    output: ./out/broken.yaml
+    sources:
+      - path: ./src/ok.yaml
+      - path: ./src/bad.yaml
+    merge:
+      rules: {}
+  - name: fine
+    format: yaml
+    output: ./out/fine.yaml
+    sources:
+      - path: ./src/ok.yaml
+`)
+
+	// plain validate does not read sources
+	root := NewRootCmdForTest()
+	root.SetErr(io.Discard)
+	root.SetArgs([]string{"validate", "-c", cfg})
+	if err := root.Execute(); err != nil {
+		t.Fatalf("validate: %v", err)
+	}
+
+	root = NewRootCmdForTest()
+	root.SilenceUsage, root.SilenceErrors = true, true
+	root.SetArgs([]string{"validate", "-c", cfg, "--dry-build"})
+	err := root.Execute()
+	if err == nil || !strings.Contains(err.Error(), "broken: parse YAML") || !strings.Contains(err.Error(), bad) {
+		t.Fatalf("validate --dry-build: err = %v, want a parse error naming %s", err, bad)
+	}
+	if _, err := os.Stat(filepath.Join(td, "out")); !os.IsNotExist(err) {
+		t.Fatalf("--dry-build wrote outputs (stat err = %v)", err)
+	}
+
+	// --targets leaves the broken target out
+	root = NewRootCmdForTest()
+	root.SetErr(io.Discard)
+	root.SetArgs([]string{"validate", "-c", cfg, "--dry-build", "--targets", "fine"})
+	if err := root.Execute(); err != nil {
+		t.Fatalf("validate --dry-build --targets fine: %v", err)
+	}
+}
+
+func TestValidate_WarnsSourceIsOtherTargetsOutput(t *testing.T) {
+	td := t.TempDir()
+	cfg := filepath.Join(td, "confb.yaml")
+	writeFileT(t, cfg, `
+version: 1
+targets:
+  - name: a
+    format: yaml
+    output: ./out/a.yaml
+    sources:
+      - path: ./src/a.yaml
+  - name: b
+    format: yaml
+    output: ./out/b.yaml
+    sources:
+      - path: ./out/a.yaml
+  - name: c
+    format: raw
+    output: ./all.txt
+    sources:
+      - path: ./out/*.yaml
+`)
+
+	var stderr strings.Builder
+	root := NewRootCmdForTest()
+	root.SetErr(&stderr)
+	root.SetArgs([]string{"validate", "-c", cfg})
+	if err := root.Execute(); err != nil {
+		t.Fatalf("validate failed: %v", err)
+	}
+	for _, want := range []string{
+		"warning: source `./out/a.yaml` in target `b` appears to be the output of target `a`; consider using `target_ref: a`",
+		"warning: source `./out/*.yaml` in target `c` appears to be the output of target `a`",
+		"warning: source `./out/*.yaml` in target `c` appears to be the output of target `b`",
+	} {
+		if !strings.Contains(stderr.String(), want) {
+			t.Fatalf("missing %q in:\n%s", want, stderr.String())
+		}
+	}
+
+	root = NewRootCmdForTest()
+	root.SilenceUsage, root.SilenceErrors = true, true
+	root.SetArgs([]string{"validate", "-c", cfg, "--strict"})
+	if err := root.Execute(); err == nil || !strings.Contains(err.Error(), "in target `b` appears to be the output of target `a`") {
+		t.Fatalf("want --strict error, got %v", err)
+	}
+}
+
 func TestBuild_DryRun_OK(t *testing.T) {
 	td := t.TempDir()
 	cfg := filepath.Join(td, "confb.yaml")
@@ -79,3 +222,1970 @@ func TestBuild_DryRun_OK(t *testing.T) {
 		t.Fatalf("build --dry-run failed: %v", err)
 	}
 }
+
+func TestBuild_FormatOverride_YAMLToJSON(t *testing.T) {
+	td := t.TempDir()
+	cfg := filepath.Join(td, "confb.yaml")
+	out := filepath.Join(td, "out.json")
+
+	writeFileT(t, filepath.Join(td, "a.yaml"), "a: 1\nlist: [x]\n")
+	writeFileT(t, filepath.Join(td, "b.yaml"), "b: two\nlist: [y]\n")
+	writeFileT(t, cfg, `
+version: 1
+targets:
+  - name: y
+    format: yaml
+    output: ./out.yaml
+    sources:
+      - path: ./a.yaml
+      - path: ./b.yaml
+    merge:
+      rules:
+        maps: deep
+        arrays: append
+`)
+
+	root := NewRootCmdForTest()
+	root.SetArgs([]string{"build", "-c", cfg,
+		"--format-override", "y=json",
+		"--output-override", "y=" + out,
+	})
+	if err := root.Execute(); err != nil {
+		t.Fatalf("build --format-override failed: %v", err)
+	}
+
+	b, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("read out: %v", err)
+	}
+	var got map[string]any
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatalf("output is not valid JSON: %v\n%s", err, b)
+	}
+	if got["a"] != float64(1) || got["b"] != "two" {
+		t.Fatalf("unexpected merged values: %#v", got)
+	}
+	if l, _ := got["list"].([]any); len(l) != 2 {
+		t.Fatalf("list = %#v, want 2 appended items", got["list"])
+	}
+}
+
+func TestBuild_FormatOverride_IncompatibleWithRules(t *testing.T) {
+	td := t.TempDir()
+	cfg := filepath.Join(td, "confb.yaml")
+
+	writeFileT(t, filepath.Join(td, "a.yaml"), "a: 1\n")
+	writeFileT(t, cfg, `
+version: 1
+targets:
+  - name: y
+    format: yaml
+    output: ./out.yaml
+    sources:
+      - path: ./a.yaml
+    merge:
+      rules:
+        maps: deep
+`)
+
+	for _, f := range []string{"kdl", "ini", "bogus"} {
+		root := NewRootCmdForTest()
+		root.SetArgs([]string{"build", "-c", cfg, "--format-override", "y=" + f})
+		if err := root.Execute(); err == nil {
+			t.Fatalf("--format-override y=%s: expected error, got nil", f)
+		}
+	}
+}
+
+func TestBuild_TargetRef_Pipeline(t *testing.T) {
+	td := t.TempDir()
+	cfg := filepath.Join(td, "confb.yaml")
+	outA := filepath.Join(td, "out", "base.yaml")
+	outB := filepath.Join(td, "out", "final.yaml")
+
+	writeFileT(t, filepath.Join(td, "base", "a.yaml"), "name: app\nport: 80\n")
+	writeFileT(t, filepath.Join(td, "base", "b.yaml"), "port: 8080\n")
+	writeFileT(t, filepath.Join(td, "env", "prod.yaml"), "env: prod\n")
+	// B is listed first: the build must still produce A before reading its output.
+	writeFileT(t, cfg, `
+version: 1
+targets:
+  - name: final
+    format: yaml
+    output: `+outB+`
+    sources:
+      - target_ref: base
+      - path: ./env/prod.yaml
+    merge:
+      rules:
+        maps: deep
+  - name: base
+    format: yaml
+    output: `+outA+`
+    sources:
+      - path: ./base/*.yaml
+    merge:
+      rules:
+        maps: deep
+`)
+
+	root := NewRootCmdForTest()
+	root.SetArgs([]string{"build", "-c", cfg})
+	if err := root.Execute(); err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+
+	b, err := os.ReadFile(outB)
+	if err != nil {
+		t.Fatalf("read final: %v", err)
+	}
+	got := string(b)
+	for _, want := range []string{"name: app", "port: 8080", "env: prod"} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("final output missing %q:\n%s", want, got)
+		}
+	}
+}
+
+func TestBuild_JSONCompact(t *testing.T) {
+	td := t.TempDir()
+	cfg := filepath.Join(td, "confb.yaml")
+	out := filepath.Join(td, "out.json")
+
+	writeFileT(t, filepath.Join(td, "a.json"), "{\n  \"a\": 1\n}\n")
+	writeFileT(t, cfg, `
+version: 1
+targets:
+  - name: j
+    format: json
+    output: `+out+`
+    sources:
+      - path: ./a.json
+`)
+
+	root := NewRootCmdForTest()
+	root.SetArgs([]string{"build", "-c", cfg, "--json-compact", "j=1"})
+	if err := root.Execute(); err != nil {
+		t.Fatalf("build --json-compact failed: %v", err)
+	}
+	b, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("read out: %v", err)
+	}
+	if string(b) != "{\"a\":1}\n" {
+		t.Fatalf("compact output = %q", string(b))
+	}
+}
+
+// captureStdout runs fn with os.Stdout redirected to a pipe and returns what was written.
+func captureStdout(t *testing.T, fn func() error) (string, error) {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("pipe: %v", err)
+	}
+	orig := os.Stdout
+	os.Stdout = w
+	done := make(chan string)
+	go func() {
+		b, _ := io.ReadAll(r)
+		done <- string(b)
+	}()
+	runErr := fn()
+	os.Stdout = orig
+	_ = w.Close()
+	return <-done, runErr
+}
+
+func TestBuild_OutputStdout(t *testing.T) {
+	td := t.TempDir()
+	cfg := filepath.Join(td, "confb.yaml")
+
+	writeFileT(t, filepath.Join(td, "a.yaml"), "a: 1\n")
+	writeFileT(t, filepath.Join(td, "b.yaml"), "b: 2\n")
+	writeFileT(t, cfg, `
+version: 1
+targets:
+  - name: y
+    format: yaml
+    output: "-"
+    sources:
+      - path: ./a.yaml
+      - path: ./b.yaml
+    merge:
+      rules:
+        maps: deep
+`)
+
+	out, err := captureStdout(t, func() error {
+		root := NewRootCmdForTest()
+		root.SetArgs([]string{"build", "-c", cfg})
+		return root.Execute()
+	})
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	if !strings.Contains(out, "a: 1\nb: 2\n") {
+		t.Fatalf("stdout missing merged YAML:\n%s", out)
+	}
+	if _, err := os.Stat(filepath.Join(td, "-")); !os.IsNotExist(err) {
+		t.Fatalf("a file named '-' was written")
+	}
+
+	// a second stdout target via --output-override is rejected
+	writeFileT(t, cfg, `
+version: 1
+targets:
+  - name: y
+    format: yaml
+    output: "-"
+    sources:
+      - path: ./a.yaml
+  - name: z
+    format: yaml
+    output: ./z.yaml
+    sources:
+      - path: ./b.yaml
+`)
+	root := NewRootCmdForTest()
+	root.SetArgs([]string{"build", "-c", cfg, "--output-override", "z=-"})
+	if err := root.Execute(); err == nil || !strings.Contains(err.Error(), "only one target may write to stdout") {
+		t.Fatalf("expected stdout conflict error, got %v", err)
+	}
+}
+
+func TestInit_FormatKDL(t *testing.T) {
+	td := t.TempDir()
+	cfg := filepath.Join(td, "confb.yaml")
+
+	root := NewRootCmdForTest()
+	root.SetArgs([]string{"init", "-c", cfg, "--format=kdl", "--with-sources=./conf.d/*.kdl"})
+	if err := root.Execute(); err != nil {
+		t.Fatalf("init failed: %v", err)
+	}
+
+	c, err := config.Load(cfg)
+	if err != nil {
+		t.Fatalf("generated config does not load: %v", err)
+	}
+	if len(c.Targets) != 1 || c.Targets[0].Format != "kdl" {
+		t.Fatalf("targets = %+v, want one kdl target", c.Targets)
+	}
+	tg := c.Targets[0]
+	if tg.Sources[0].Path != "./conf.d/*.kdl" {
+		t.Fatalf("source path = %q", tg.Sources[0].Path)
+	}
+	if tg.Merge == nil || tg.Merge.Rules.KDLKeys != "last_wins" || len(tg.Merge.Rules.KDLSectionKeys) == 0 {
+		t.Fatalf("kdl starter missing canonical merge rules: %+v", tg.Merge)
+	}
+
+	// refuses to overwrite without --force
+	root = NewRootCmdForTest()
+	root.SetArgs([]string{"init", "-c", cfg})
+	if err := root.Execute(); err == nil {
+		t.Fatalf("expected error when config exists")
+	}
+
+	// generic starter covers every format and loads too
+	root = NewRootCmdForTest()
+	root.SetArgs([]string{"init", "-c", cfg, "--force"})
+	if err := root.Execute(); err != nil {
+		t.Fatalf("init --force failed: %v", err)
+	}
+	c, err = config.Load(cfg)
+	if err != nil {
+		t.Fatalf("generic starter does not load: %v", err)
+	}
+	if len(c.Targets) != 6 {
+		t.Fatalf("generic starter has %d targets, want 6", len(c.Targets))
+	}
+}
+
+func TestBuild_OutputOverride_Conflict(t *testing.T) {
+	td := t.TempDir()
+	cfg := filepath.Join(td, "confb.yaml")
+
+	writeFileT(t, filepath.Join(td, "a.txt"), "a\n")
+	writeFileT(t, cfg, `
+version: 1
+targets:
+  - name: one
+    format: raw
+    output: `+filepath.Join(td, "one.txt")+`
+    sources:
+      - path: ./a.txt
+  - name: two
+    format: raw
+    output: `+filepath.Join(td, "two.txt")+`
+    sources:
+      - path: ./a.txt
+`)
+
+	root := NewRootCmdForTest()
+	root.SetArgs([]string{"build", "-c", cfg, "--output-override", "two=" + filepath.Join(td, "one.txt")})
+	err := root.Execute()
+	if err == nil || !strings.Contains(err.Error(), "one, two") {
+		t.Fatalf("expected output conflict error naming both targets, got %v", err)
+	}
+}
+
+func TestBuild_SourceEncodingLatin1(t *testing.T) {
+	td := t.TempDir()
+	cfg := filepath.Join(td, "confb.yaml")
+	out := filepath.Join(td, "out.txt")
+
+	// "grün" in ISO-8859-1: ü is the single byte 0xFC
+	if err := os.WriteFile(filepath.Join(td, "legacy.txt"), []byte("gr\xfcn\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	writeFileT(t, cfg, `
+version: 1
+targets:
+  - name: legacy
+    format: raw
+    output: `+out+`
+    sources:
+      - path: ./legacy.txt
+        encoding: latin1
+`)
+
+	root := NewRootCmdForTest()
+	root.SetArgs([]string{"build", "-c", cfg})
+	if err := root.Execute(); err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	b, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("read out: %v", err)
+	}
+	if string(b) != "gr\xc3\xbcn\n" {
+		t.Fatalf("output = %q, want UTF-8 \"grün\\n\"", string(b))
+	}
+}
+
+func TestBuild_MtimeNewestSource(t *testing.T) {
+	td := t.TempDir()
+	cfg := filepath.Join(td, "confb.yaml")
+	out := filepath.Join(td, "out.yaml")
+	a := filepath.Join(td, "a.yaml")
+	b := filepath.Join(td, "b.yaml")
+
+	writeFileT(t, a, "a: 1\n")
+	writeFileT(t, b, "b: 2\n")
+	older := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	newest := time.Date(2021, 6, 7, 8, 9, 10, 0, time.UTC)
+	if err := os.Chtimes(a, older, older); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(b, newest, newest); err != nil {
+		t.Fatal(err)
+	}
+	writeFileT(t, cfg, `
+version: 1
+targets:
+  - name: app
+    format: yaml
+    output: `+out+`
+    mtime_source: newest_source
+    sources:
+      - path: ./a.yaml
+      - path: ./b.yaml
+    merge:
+      rules:
+        maps: deep
+`)
+
+	root := NewRootCmdForTest()
+	root.SetArgs([]string{"build", "-c", cfg})
+	if err := root.Execute(); err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	fi, err := os.Stat(out)
+	if err != nil {
+		t.Fatalf("stat out: %v", err)
+	}
+	if !fi.ModTime().Equal(newest) {
+		t.Fatalf("output mtime = %v, want %v", fi.ModTime(), newest)
+	}
+}
+
+func TestBuild_InlineSource(t *testing.T) {
+	td := t.TempDir()
+	cfg := filepath.Join(td, "confb.yaml")
+	out := filepath.Join(td, "out.yaml")
+
+	writeFileT(t, filepath.Join(td, "base.yaml"), "name: app\nport: 80\n")
+	writeFileT(t, cfg, `
+version: 1
+targets:
+  - name: app
+    format: yaml
+    output: `+out+`
+    sources:
+      - path: ./base.yaml
+      - inline: |
+          port: 8080
+          debug: true
+    merge:
+      rules:
+        maps: deep
+        arrays: replace
+`)
+
+	tmp := filepath.Join(td, "tmp")
+	if err := os.Mkdir(tmp, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TMPDIR", tmp)
+
+	build := func() string {
+		t.Helper()
+		root := NewRootCmdForTest()
+		root.SetArgs([]string{"build", "-c", cfg})
+		if err := root.Execute(); err != nil {
+			t.Fatalf("build failed: %v", err)
+		}
+		b, err := os.ReadFile(out)
+		if err != nil {
+			t.Fatalf("read out: %v", err)
+		}
+		return string(b)
+	}
+	s := build()
+	for _, want := range []string{"name: app", "port: 8080", "debug: true", "2) (inline) sha256="} {
+		if !strings.Contains(s, want) {
+			t.Fatalf("output missing %q:\n%s", want, s)
+		}
+	}
+
+	// the inline file's temp directory is gone after the build, and the next
+	// build (in a new one) writes the same bytes
+	if entries, err := os.ReadDir(tmp); err != nil || len(entries) != 0 {
+		t.Fatalf("temp dir after build = %v, %v; want empty", entries, err)
+	}
+	if again := build(); again != s {
+		t.Fatalf("rebuild differs:\n%s\n---\n%s", s, again)
+	}
+}
+
+func TestBuild_DataURISource(t *testing.T) {
+	td := t.TempDir()
+	cfg := filepath.Join(td, "confb.yaml")
+	out := filepath.Join(td, "out.json")
+
+	writeFileT(t, filepath.Join(td, "base.json"), `{"name": "app", "port": 80}`)
+	payload := base64.StdEncoding.EncodeToString([]byte(`{"port": 8080, "tls": {"on": true}}`))
+	writeFileT(t, cfg, `
+version: 1
+targets:
+  - name: app
+    format: json
+    output: `+out+`
+    sources:
+      - path: ./base.json
+      - data_uri: "data:application/json;base64,`+payload+`"
+    merge:
+      rules:
+        maps: deep
+`)
+
+	root := NewRootCmdForTest()
+	root.SetArgs([]string{"build", "-c", cfg})
+	if err := root.Execute(); err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	var got map[string]any
+	if err := json.Unmarshal([]byte(mustRead(t, out)), &got); err != nil {
+		t.Fatalf("output is not json: %v", err)
+	}
+	if got["name"] != "app" || got["port"] != float64(8080) {
+		t.Fatalf("unexpected merge result: %v", got)
+	}
+	if tls, _ := got["tls"].(map[string]any); tls["on"] != true {
+		t.Fatalf("tls.on missing from data_uri source: %v", got)
+	}
+}
+
+func TestBuild_EnvOutput(t *testing.T) {
+	td := t.TempDir()
+	cfg := filepath.Join(td, "confb.yaml")
+	exportPath := filepath.Join(td, "env.sh")
+	t.Setenv("CONFB_TEST_ENV_OUT", "")
+
+	writeFileT(t, filepath.Join(td, "a.txt"), "it's one\n")
+	writeFileT(t, filepath.Join(td, "b.txt"), "two\n")
+	writeFileT(t, cfg, `
+version: 1
+targets:
+  - name: env
+    format: raw
+    output: env:CONFB_TEST_ENV_OUT
+    sources:
+      - path: ./a.txt
+      - path: ./b.txt
+`)
+
+	// without a consumer (post_build or --export-env) the env output is refused
+	root := NewRootCmdForTest()
+	root.SilenceUsage, root.SilenceErrors = true, true
+	root.SetArgs([]string{"build", "-c", cfg})
+	if err := root.Execute(); err == nil || !strings.Contains(err.Error(), "only reaches post_build") {
+		t.Fatalf("want env output error, got %v", err)
+	}
+
+	root = NewRootCmdForTest()
+	root.SetArgs([]string{"build", "-c", cfg, "--export-env", exportPath})
+	if err := root.Execute(); err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	if got := os.Getenv("CONFB_TEST_ENV_OUT"); got != "it's one\ntwo\n" {
+		t.Fatalf("env = %q", got)
+	}
+	if got, want := mustRead(t, exportPath), "export CONFB_TEST_ENV_OUT='it'\\''s one\ntwo\n'\n"; got != want {
+		t.Fatalf("export file = %q, want %q", got, want)
+	}
+}
+
+func TestBuild_PostProcessStripTrailingNewline(t *testing.T) {
+	td := t.TempDir()
+	cfg := filepath.Join(td, "confb.yaml")
+	out := filepath.Join(td, "out.yaml")
+
+	writeFileT(t, filepath.Join(td, "a.yaml"), "a: 1\n")
+	writeFileT(t, filepath.Join(td, "b.yaml"), "b: 2\n")
+	writeFileT(t, cfg, `
+version: 1
+targets:
+  - name: app
+    format: yaml
+    output: `+out+`
+    post_process: [no_header, strip_trailing_newline]
+    sources:
+      - path: ./a.yaml
+      - path: ./b.yaml
+    merge:
+      rules:
+        maps: deep
+`)
+
+	root := NewRootCmdForTest()
+	root.SetArgs([]string{"build", "-c", cfg})
+	if err := root.Execute(); err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	if got := mustRead(t, out); got != "a: 1\nb: 2" {
+		t.Fatalf("output = %q, want no header and no trailing newline", got)
+	}
+}
+
+func TestBuild_Manifest(t *testing.T) {
+	td := t.TempDir()
+	cfg := filepath.Join(td, "confb.yaml")
+	manifest := filepath.Join(td, "manifest.json")
+	outYAML := filepath.Join(td, "out.yaml")
+	outTxt := filepath.Join(td, "out.txt")
+
+	writeFileT(t, filepath.Join(td, "a.yaml"), "a: 1\n")
+	writeFileT(t, filepath.Join(td, "b.yaml"), "b: 2\n")
+	writeFileT(t, filepath.Join(td, "notes.txt"), "hello\n")
+	writeFileT(t, cfg, `
+version: 1
+targets:
+  - name: merged
+    format: yaml
+    output: `+outYAML+`
+    sources:
+      - path: ./a.yaml
+      - path: ./b.yaml
+    merge: {}
+  - name: notes
+    format: raw
+    output: `+outTxt+`
+    sources:
+      - path: ./notes.txt
+`)
+
+	root := NewRootCmdForTest()
+	root.SetArgs([]string{"build", "-c", cfg, "--manifest", manifest})
+	if err := root.Execute(); err != nil {
+		t.Fatalf("build --manifest failed: %v", err)
+	}
+
+	var entries []map[string]any
+	if err := json.Unmarshal([]byte(mustRead(t, manifest)), &entries); err != nil {
+		t.Fatalf("manifest is not JSON: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("manifest has %d entries, want 2", len(entries))
+	}
+	sha := func(p string) string {
+		sum := sha256.Sum256([]byte(mustRead(t, p)))
+		return hex.EncodeToString(sum[:])
+	}
+	wantOut := map[string]string{"merged": outYAML, "notes": outTxt}
+	for _, e := range entries {
+		for _, k := range []string{"target", "output", "format", "files", "merged_sha256", "built_at", "duration_ms"} {
+			if _, ok := e[k]; !ok {
+				t.Fatalf("entry %v missing %q", e["target"], k)
+			}
+		}
+		name := e["target"].(string)
+		out := wantOut[name]
+		if e["output"] != out {
+			t.Fatalf("%s: output = %v, want %s", name, e["output"], out)
+		}
+		if e["merged_sha256"] != sha(out) {
+			t.Fatalf("%s: merged_sha256 does not match the written output", name)
+		}
+		for _, f := range e["files"].([]any) {
+			fm := f.(map[string]any)
+			if fm["sha256"] != sha(fm["path"].(string)) {
+				t.Fatalf("%s: sha256 for %v does not match the file on disk", name, fm["path"])
+			}
+		}
+	}
+	if n := len(entries[0]["files"].([]any)); n != 2 {
+		t.Fatalf("merged: %d files, want 2", n)
+	}
+}
+
+func mustRead(t *testing.T, p string) string {
+	t.Helper()
+	b, err := os.ReadFile(p)
+	if err != nil {
+		t.Fatalf("read %s: %v", p, err)
+	}
+	return string(b)
+}
+
+func TestStatus_HistoryJSON(t *testing.T) {
+	td := t.TempDir()
+	statePath := filepath.Join(td, "state.json")
+	writeFileT(t, statePath, `{
+  "pid": 1,
+  "config": "/tmp/confb.yaml",
+  "updated_at": "2025-01-01T03:00:00Z",
+  "targets": {
+    "app": [
+      {"time": "2025-01-01T02:00:00Z", "duration_ms": 3, "sum_after": "aaa", "files": ["/a"]},
+      {"time": "2025-01-01T03:00:00Z", "duration_ms": 4, "sum_before": "aaa", "sum_after": "bbb", "files": ["/a", "/b"]}
+    ]
+  },
+  "debounce_ms": {"app": 250}
+}`)
+
+	root := NewRootCmdForTest()
+	var out strings.Builder
+	root.SetOut(&out)
+	root.SetArgs([]string{"status", "--state-file", statePath, "--history", "app", "--json"})
+	if err := root.Execute(); err != nil {
+		t.Fatalf("status failed: %v", err)
+	}
+	var recs []map[string]any
+	if err := json.Unmarshal([]byte(out.String()), &recs); err != nil {
+		t.Fatalf("status --json output is not JSON: %v\n%s", err, out.String())
+	}
+	if len(recs) != 2 || recs[1]["sum_after"] != "bbb" {
+		t.Fatalf("unexpected history: %v", recs)
+	}
+
+	// the overview shows each target's effective debounce
+	root = NewRootCmdForTest()
+	out.Reset()
+	root.SetOut(&out)
+	root.SetArgs([]string{"status", "--state-file", statePath})
+	if err := root.Execute(); err != nil {
+		t.Fatalf("status failed: %v", err)
+	}
+	if !strings.Contains(out.String(), "DEBOUNCE") || !strings.Contains(out.String(), "250ms") {
+		t.Fatalf("status does not show the debounce:\n%s", out.String())
+	}
+
+	root = NewRootCmdForTest()
+	root.SetArgs([]string{"status", "--state-file", statePath, "--history", "missing"})
+	root.SilenceUsage, root.SilenceErrors = true, true
+	if err := root.Execute(); err == nil || !strings.Contains(err.Error(), `no history for target "missing"`) {
+		t.Fatalf("expected missing-target error, got %v", err)
+	}
+}
+
+func TestValidate_AutoDiscoverConfig(t *testing.T) {
+	td := t.TempDir()
+	t.Setenv("HOME", td)
+	t.Setenv("CONFB_CONFIG", "")
+	writeFileT(t, filepath.Join(td, "confb.yaml"), `
+version: 1
+targets:
+  - name: notes
+    format: raw
+    output: ./out.txt
+    sources:
+      - path: ./notes.txt
+`)
+	sub := filepath.Join(td, "a", "b")
+	if err := os.MkdirAll(sub, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Chdir(sub)
+
+	// without the flag the missing default config is an error
+	root := NewRootCmdForTest()
+	root.SetArgs([]string{"validate"})
+	if err := root.Execute(); err == nil {
+		t.Fatal("validate without --auto-discover unexpectedly succeeded")
+	}
+
+	root = NewRootCmdForTest()
+	root.SetArgs([]string{"validate", "--auto-discover"})
+	if err := root.Execute(); err != nil {
+		t.Fatalf("validate --auto-discover failed: %v", err)
+	}
+
+	// build reads -c directly; it discovers too
+	writeFileT(t, filepath.Join(td, "notes.txt"), "hi\n")
+	root = NewRootCmdForTest()
+	root.SetArgs([]string{"build", "-c", filepath.Join(sub, "confb.yaml"), "--auto-discover"})
+	if err := root.Execute(); err != nil {
+		t.Fatalf("build --auto-discover failed: %v", err)
+	}
+	// relative outputs resolve against the working directory
+	if b, err := os.ReadFile(filepath.Join(sub, "out.txt")); err != nil || string(b) != "hi\n" {
+		t.Fatalf("out.txt = %q, %v", b, err)
+	}
+}
+
+func TestRoot_ChdirResolvesRelativeCONFBConfig(t *testing.T) {
+	td := t.TempDir()
+	start := filepath.Join(td, "start")
+	proj := filepath.Join(td, "proj")
+	conf := func(out string) string {
+		return `
+version: 1
+targets:
+  - name: notes
+    format: raw
+    output: ` + out + `
+    sources:
+      - path: ./notes.txt
+`
+	}
+	// same relative name in both dirs; only proj's should be used
+	writeFileT(t, filepath.Join(start, "confb.yaml"), conf(filepath.Join(td, "wrong.txt")))
+	writeFileT(t, filepath.Join(start, "notes.txt"), "wrong\n")
+	writeFileT(t, filepath.Join(proj, "confb.yaml"), conf(filepath.Join(td, "right.txt")))
+	writeFileT(t, filepath.Join(proj, "notes.txt"), "right\n")
+
+	t.Chdir(start)
+	t.Setenv("CONFB_CONFIG", "./confb.yaml")
+
+	root := NewRootCmdForTest()
+	root.SetArgs([]string{"build", "--chdir", proj})
+	if err := root.Execute(); err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	if got := mustRead(t, filepath.Join(td, "right.txt")); got != "right\n" {
+		t.Fatalf("right.txt = %q", got)
+	}
+	if _, err := os.Stat(filepath.Join(td, "wrong.txt")); !os.IsNotExist(err) {
+		t.Fatalf("config in the starting directory was used (err=%v)", err)
+	}
+	// the flag holds the absolute path, so later directory changes cannot move it
+	got, _ := root.PersistentFlags().GetString("config")
+	want, _ := filepath.EvalSymlinks(filepath.Join(proj, "confb.yaml"))
+	if gotReal, _ := filepath.EvalSymlinks(got); !filepath.IsAbs(got) || gotReal != want {
+		t.Fatalf("--config = %q, want %q", got, want)
+	}
+}
+
+func TestValidateAndBuild_ConfigFromStdin(t *testing.T) {
+	td := t.TempDir()
+	writeFileT(t, filepath.Join(td, "notes.txt"), "hi\n")
+	t.Chdir(td)
+	conf := `
+version: 1
+targets:
+  - name: notes
+    format: raw
+    output: ./out.txt
+    sources:
+      - path: ./notes.txt
+`
+
+	root := NewRootCmdForTest()
+	root.SetIn(strings.NewReader(conf))
+	root.SetArgs([]string{"validate", "-c", "-", "--check-sources"})
+	if err := root.Execute(); err != nil {
+		t.Fatalf("validate -c - failed: %v", err)
+	}
+
+	// relative sources and outputs resolve against the working directory
+	root = NewRootCmdForTest()
+	root.SetIn(strings.NewReader(conf))
+	root.SetArgs([]string{"build", "-c", "-"})
+	if err := root.Execute(); err != nil {
+		t.Fatalf("build -c - failed: %v", err)
+	}
+	if got := mustRead(t, filepath.Join(td, "out.txt")); got != "hi\n" {
+		t.Fatalf("out.txt = %q", got)
+	}
+
+	root = NewRootCmdForTest()
+	root.SilenceUsage, root.SilenceErrors = true, true
+	root.SetIn(strings.NewReader("version: 1\ntargets: []\n"))
+	root.SetArgs([]string{"validate", "-c", "-"})
+	if err := root.Execute(); err == nil {
+		t.Fatal("validate -c - accepted a config without targets")
+	}
+}
+
+func TestRun_FlagsFromEnv(t *testing.T) {
+	td := t.TempDir()
+	cfg := filepath.Join(td, "confb.yaml")
+	writeFileT(t, filepath.Join(td, "a.txt"), "a\n")
+	writeFileT(t, cfg, `
+version: 1
+targets:
+  - name: a
+    format: raw
+    output: ./out.txt
+    sources:
+      - path: ./a.txt
+`)
+
+	var got daemon.Options
+	runDaemon = func(_ *config.Config, opts daemon.Options) error {
+		got = opts
+		return nil
+	}
+	defer func() { runDaemon = daemon.Run }()
+
+	t.Setenv("CONFB_CONFIG", cfg)
+	t.Setenv("CONFB_DEBOUNCE_MS", "500")
+	t.Setenv("CONFB_VERBOSE", "true")
+	t.Setenv("CONFB_LOCK_TIMEOUT", "3s")
+
+	root := NewRootCmdForTest()
+	root.SetArgs([]string{"run", "--lock-timeout", "2s"})
+	if err := root.Execute(); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if got.Debounce != 500*time.Millisecond {
+		t.Fatalf("debounce = %v, want 500ms from CONFB_DEBOUNCE_MS", got.Debounce)
+	}
+	if got.LogLevel != daemon.LogVerbose {
+		t.Fatalf("log level = %v, want verbose from CONFB_VERBOSE", got.LogLevel)
+	}
+	if got.LockTimeout != 2*time.Second {
+		t.Fatalf("lock timeout = %v, want the explicit 2s over CONFB_LOCK_TIMEOUT", got.LockTimeout)
+	}
+
+	t.Setenv("CONFB_DEBOUNCE_MS", "soon")
+	root = NewRootCmdForTest()
+	root.SilenceUsage, root.SilenceErrors = true, true
+	root.SetArgs([]string{"run"})
+	if err := root.Execute(); err == nil || !strings.Contains(err.Error(), "CONFB_DEBOUNCE_MS") {
+		t.Fatalf("want CONFB_DEBOUNCE_MS parse error, got %v", err)
+	}
+}
+
+func TestBuild_OutputPrefix(t *testing.T) {
+	td := t.TempDir()
+	cfg := filepath.Join(td, "confb.yaml")
+	staging := filepath.Join(td, "staging")
+	writeFileT(t, filepath.Join(td, "app.txt"), "app\n")
+	writeFileT(t, filepath.Join(td, "extra.txt"), "extra\n")
+	writeFileT(t, cfg, `
+version: 1
+targets:
+  - name: app
+    format: raw
+    output: /etc/app.conf
+    sources:
+      - path: ./app.txt
+  - name: all
+    format: raw
+    output: ./all.conf
+    sources:
+      - target_ref: app
+      - path: ./extra.txt
+  - name: literal
+    format: raw
+    output: ./$APPDIR/x.conf
+    sources:
+      - path: ./extra.txt
+`)
+	t.Chdir(td)
+	t.Setenv("APPDIR", "expanded")
+
+	root := NewRootCmdForTest()
+	root.SetArgs([]string{"build", "-c", cfg, "--output-prefix", staging})
+	if err := root.Execute(); err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	if got := mustRead(t, filepath.Join(staging, "etc", "app.conf")); got != "app\n" {
+		t.Fatalf("staging/etc/app.conf = %q", got)
+	}
+	// relative outputs are prefixed too, and target_ref reads the prefixed output
+	if got := mustRead(t, filepath.Join(staging, "all.conf")); got != "app\nextra\n" {
+		t.Fatalf("staging/all.conf = %q", got)
+	}
+	if _, err := os.Stat(filepath.Join(td, "all.conf")); !os.IsNotExist(err) {
+		t.Fatalf("unprefixed output written (err=%v)", err)
+	}
+	// the prefix only re-roots outputs; $VARS stay literal as without it
+	if got := mustRead(t, filepath.Join(staging, "$APPDIR", "x.conf")); got != "extra\n" {
+		t.Fatalf("staging/$APPDIR/x.conf = %q", got)
+	}
+}
+
+func TestBuild_ParallelFollowsTargetRefChain(t *testing.T) {
+	td := t.TempDir()
+	cfg := filepath.Join(td, "confb.yaml")
+	writeFileT(t, filepath.Join(td, "a.txt"), "a\n")
+	writeFileT(t, filepath.Join(td, "b.txt"), "b\n")
+	writeFileT(t, filepath.Join(td, "c.txt"), "c\n")
+	// listed last-first: c reads b, which reads a
+	writeFileT(t, cfg, `
+version: 1
+targets:
+  - name: c
+    format: raw
+    output: ./out/c.conf
+    sources:
+      - target_ref: b
+      - path: ./c.txt
+  - name: b
+    format: raw
+    output: ./out/b.conf
+    sources:
+      - target_ref: a
+      - path: ./b.txt
+  - name: a
+    format: raw
+    output: ./out/a.conf
+    sources:
+      - path: ./a.txt
+`)
+	manifest := filepath.Join(td, "manifest.json")
+	t.Chdir(td)
+
+	root := NewRootCmdForTest()
+	root.SetArgs([]string{"build", "-c", cfg, "--parallel=5", "--manifest", manifest})
+	if err := root.Execute(); err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	if got := mustRead(t, filepath.Join(td, "out", "c.conf")); got != "a\nb\nc\n" {
+		t.Fatalf("c.conf = %q", got)
+	}
+	var entries []manifestEntry
+	if err := json.Unmarshal([]byte(mustRead(t, manifest)), &entries); err != nil {
+		t.Fatalf("manifest: %v", err)
+	}
+	var order []string
+	for _, e := range entries {
+		order = append(order, e.Target)
+	}
+	if got := strings.Join(order, ","); got != "a,b,c" {
+		t.Fatalf("manifest order = %s, want a,b,c", got)
+	}
+}
+
+func TestBuild_WatchRebuildsThenExitsWhenIdle(t *testing.T) {
+	td := t.TempDir()
+	cfg := filepath.Join(td, "confb.yaml")
+	src := filepath.Join(td, "a.txt")
+	out := filepath.Join(td, "override", "a.conf")
+	writeFileT(t, src, "a1\n")
+	writeFileT(t, filepath.Join(td, "base.conf"), "base\n") // b's output, not rebuilt here
+	writeFileT(t, cfg, `
+version: 1
+targets:
+  - name: a
+    format: raw
+    output: ./a.conf
+    sources:
+      - target_ref: b
+      - path: ./a.txt
+  - name: b
+    format: raw
+    output: ./base.conf
+    sources:
+      - path: ./missing.txt
+`)
+	t.Chdir(td)
+
+	root := NewRootCmdForTest()
+	root.SetArgs([]string{"build", "-c", cfg, "--targets", "a", "--output-override", "a=" + out, "--watch-idle-timeout", "1500ms", "--quiet"})
+	done := make(chan error, 1)
+	go func() { done <- root.Execute() }()
+
+	waitFor := func(want string) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			if b, err := os.ReadFile(out); err == nil && string(b) == want {
+				return
+			}
+			select {
+			case err := <-done:
+				t.Fatalf("build --watch returned early: %v", err)
+			default:
+			}
+			if time.Now().After(deadline) {
+				b, _ := os.ReadFile(out)
+				t.Fatalf("output = %q, want %q", b, want)
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+	waitFor("base\na1\n")
+	if err := os.WriteFile(src, []byte("a2\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	waitFor("base\na2\n")
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("build --watch: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("build --watch did not exit after the idle timeout")
+	}
+	if _, err := os.Stat(filepath.Join(td, "a.conf")); !os.IsNotExist(err) {
+		t.Fatalf("--output-override ignored: a.conf exists (err=%v)", err)
+	}
+}
+
+func TestBuild_ExtensionMismatch(t *testing.T) {
+	td := t.TempDir()
+	cfg := filepath.Join(td, "confb.yaml")
+	writeFileT(t, filepath.Join(td, "a.yaml"), "a: 1\n")
+	writeFileT(t, cfg, `
+version: 1
+targets:
+  - name: app
+    format: yaml
+    output: ./out/App.JSON
+    sources:
+      - path: ./a.yaml
+`)
+	t.Chdir(td)
+
+	build := func(args ...string) (string, error) {
+		var stderr bytes.Buffer
+		root := NewRootCmdForTest()
+		root.SilenceUsage, root.SilenceErrors = true, true
+		root.SetErr(&stderr)
+		root.SetArgs(append([]string{"build", "-c", cfg}, args...))
+		err := root.Execute()
+		return stderr.String(), err
+	}
+
+	stderr, err := build()
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	if !strings.Contains(stderr, `confb: warning: target "app": output ./out/App.JSON has a json extension but format is yaml`) {
+		t.Fatalf("missing warning, stderr:\n%s", stderr)
+	}
+
+	_ = os.Remove(filepath.Join(td, "out", "App.JSON"))
+	if _, err := build("--strict"); err == nil || !strings.Contains(err.Error(), "output extension mismatch") {
+		t.Fatalf("want mismatch error with --strict, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(td, "out", "App.JSON")); !os.IsNotExist(err) {
+		t.Fatalf("--strict still wrote the output (err=%v)", err)
+	}
+
+	stderr, err = build("--strict", "--no-extension-check")
+	if err != nil || strings.Contains(stderr, "warning") {
+		t.Fatalf("--no-extension-check: err=%v stderr=%q", err, stderr)
+	}
+	// a format override to raw is not checked either
+	if stderr, err = build("--strict", "--format-override", "app=raw"); err != nil || strings.Contains(stderr, "warning") {
+		t.Fatalf("--format-override app=raw: err=%v stderr=%q", err, stderr)
+	}
+}
+
+func TestBuild_ExtraConfigMergesTargets(t *testing.T) {
+	td := t.TempDir()
+	base := filepath.Join(td, "base.yaml")
+	local := filepath.Join(td, "local", "local.yaml")
+	writeFileT(t, filepath.Join(td, "a.txt"), "a\n")
+	writeFileT(t, filepath.Join(td, "b.txt"), "b\n")
+	writeFileT(t, base, `
+version: 1
+targets:
+  - name: a
+    format: raw
+    output: ./out/a.conf
+    sources:
+      - path: ./a.txt
+`)
+	// relative paths resolve against base.yaml's directory, as with include
+	writeFileT(t, local, `
+version: 1
+targets:
+  - name: b
+    format: raw
+    output: ./out/b.conf
+    sources:
+      - path: ./b.txt
+`)
+	t.Chdir(td)
+
+	root := NewRootCmdForTest()
+	root.SetArgs([]string{"build", "-c", base, "--extra-config", local})
+	if err := root.Execute(); err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	if got := mustRead(t, filepath.Join(td, "out", "a.conf")); got != "a\n" {
+		t.Fatalf("a.conf = %q", got)
+	}
+	if got := mustRead(t, filepath.Join(td, "out", "b.conf")); got != "b\n" {
+		t.Fatalf("b.conf = %q", got)
+	}
+}
+
+func TestBuild_SourceTransformEnvInterpolate(t *testing.T) {
+	td := t.TempDir()
+	cfg := filepath.Join(td, "confb.yaml")
+	out := filepath.Join(td, "out.yaml")
+	writeFileT(t, filepath.Join(td, "base.yaml"), "db:\n  user: app\n")
+	writeFileT(t, filepath.Join(td, "secret.yaml"), "db:\n  pass: \"{{ env:MY_SECRET }}\"\n")
+	writeFileT(t, cfg, `
+version: 1
+targets:
+  - name: app
+    format: yaml
+    output: ./out.yaml
+    sources:
+      - path: ./base.yaml
+      - path: ./secret.yaml
+        transforms: [env_interpolate]
+    merge:
+      rules:
+        maps: deep
+`)
+	t.Setenv("MY_SECRET", "s3cr3t")
+	t.Chdir(td)
+
+	root := NewRootCmdForTest()
+	root.SetArgs([]string{"build", "-c", cfg})
+	if err := root.Execute(); err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	if got := mustRead(t, out); !strings.Contains(got, "pass: s3cr3t") || !strings.Contains(got, "user: app") {
+		t.Fatalf("merged output missing the resolved secret:\n%s", got)
+	}
+}
+
+func TestCompletion_FlagValues(t *testing.T) {
+	td := t.TempDir()
+	cfg := filepath.Join(td, "confb.yaml")
+	writeFileT(t, cfg, `
+version: 1
+targets:
+  - name: alpha
+    format: raw
+    output: ./a.out
+    sources:
+      - path: ./a.txt
+  - name: beta
+    format: raw
+    output: ./b.out
+    sources:
+      - path: ./b.txt
+`)
+
+	var script bytes.Buffer
+	if err := NewRootCmdForTest().GenBashCompletionV2(&script, true); err != nil {
+		t.Fatalf("GenBashCompletionV2: %v", err)
+	}
+	if !strings.Contains(script.String(), "__complete") {
+		t.Fatalf("bash script does not call __complete:\n%s", script.String())
+	}
+
+	complete := func(args ...string) []string {
+		t.Helper()
+		var out bytes.Buffer
+		root := NewRootCmdForTest()
+		root.SetOut(&out)
+		root.SetArgs(append([]string{cobra.ShellCompRequestCmd}, args...))
+		if err := root.Execute(); err != nil {
+			t.Fatalf("__complete %v: %v", args, err)
+		}
+		// one candidate per line, then ":<directive>"
+		var got []string
+		for _, l := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+			if !strings.HasPrefix(l, ":") && !strings.HasPrefix(l, "Completion ended") {
+				got = append(got, l)
+			}
+		}
+		return got
+	}
+
+	if got := strings.Join(complete("init", "--format", ""), ","); got != "yaml,json,toml,kdl,ini,raw" {
+		t.Fatalf("init --format candidates = %s", got)
+	}
+	if got := strings.Join(complete("build", "-c", cfg, "--targets", "alpha,"), " "); got != "alpha,alpha alpha,beta" {
+		t.Fatalf("build --targets candidates = %q", got)
+	}
+	if got := complete("build", "-c", filepath.Join(td, "missing.yaml"), "--targets", ""); len(got) != 0 {
+		t.Fatalf("unloadable config should offer nothing, got %v", got)
+	}
+}
+
+func TestBuild_FailFast(t *testing.T) {
+	td := t.TempDir()
+	cfg := filepath.Join(td, "confb.yaml")
+	writeFileT(t, filepath.Join(td, "one.txt"), "one\n")
+	writeFileT(t, filepath.Join(td, "three.txt"), "three\n")
+	writeFileT(t, cfg, `
+version: 1
+targets:
+  - name: one
+    format: raw
+    output: ./out/one.conf
+    sources:
+      - path: ./one.txt
+  - name: two
+    format: raw
+    output: ./out/two.conf
+    sources:
+      - path: ./missing.txt
+  - name: three
+    format: raw
+    output: ./out/three.conf
+    sources:
+      - path: ./three.txt
+`)
+	t.Chdir(td)
+	third := filepath.Join(td, "out", "three.conf")
+
+	root := NewRootCmdForTest()
+	root.SilenceUsage, root.SilenceErrors = true, true
+	root.SetArgs([]string{"build", "-c", cfg, "--fail-fast"})
+	if err := root.Execute(); err == nil || !strings.Contains(err.Error(), "missing.txt") {
+		t.Fatalf("--fail-fast: err = %v, want missing source error", err)
+	}
+	if _, err := os.Stat(third); !os.IsNotExist(err) {
+		t.Fatalf("--fail-fast built the target after the failure (stat err = %v)", err)
+	}
+
+	// default: the failure is reported, but the remaining targets are built
+	root = NewRootCmdForTest()
+	root.SilenceUsage, root.SilenceErrors = true, true
+	root.SetArgs([]string{"build", "-c", cfg})
+	if err := root.Execute(); err == nil || !strings.Contains(err.Error(), "missing.txt") {
+		t.Fatalf("default: err = %v, want missing source error", err)
+	}
+	if got := mustRead(t, third); got != "three\n" {
+		t.Fatalf("three.conf = %q", got)
+	}
+}
+
+func TestBuild_HeaderOnly(t *testing.T) {
+	td := t.TempDir()
+	cfg := filepath.Join(td, "confb.yaml")
+	// the source does not exist: --header-only must not need it
+	writeFileT(t, cfg, `
+version: 1
+targets:
+  - name: app
+    format: raw
+    output: ./out/app.conf
+    sources:
+      - path: ./not-yet.conf
+`)
+	t.Chdir(td)
+
+	root := NewRootCmdForTest()
+	var out bytes.Buffer
+	root.SetOut(&out)
+	root.SetArgs([]string{"build", "-c", cfg, "--header-only", "app=toml"})
+	if err := root.Execute(); err != nil {
+		t.Fatalf("build --header-only: %v", err)
+	}
+	got := out.String()
+	for _, want := range []string{"# confb build\n", "# target: app\n", "# time: ", "not-yet.conf"} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("header missing %q:\n%s", want, got)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(td, "out", "app.conf")); !os.IsNotExist(err) {
+		t.Fatalf("--header-only wrote the output (stat err = %v)", err)
+	}
+
+	root = NewRootCmdForTest()
+	root.SilenceUsage, root.SilenceErrors = true, true
+	root.SetArgs([]string{"build", "-c", cfg, "--header-only", "app=json"})
+	if err := root.Execute(); err == nil || !strings.Contains(err.Error(), "no comment syntax") {
+		t.Fatalf("json header: err = %v", err)
+	}
+}
+
+func TestBuild_EnabledIfSkipsTarget(t *testing.T) {
+	td := t.TempDir()
+	cfg := filepath.Join(td, "confb.yaml")
+	writeFileT(t, filepath.Join(td, "a.txt"), "a\n")
+	writeFileT(t, cfg, `
+version: 1
+targets:
+  - name: nginx
+    format: raw
+    output: ./out/nginx.conf
+    enabled_if: "CONFB_ENV == production"
+    sources:
+      - path: ./a.txt
+  - name: local
+    format: raw
+    output: ./out/local.conf
+    sources:
+      - path: ./a.txt
+`)
+	t.Chdir(td)
+	t.Setenv("CONFB_ENV", "dev")
+
+	var stderr bytes.Buffer
+	root := NewRootCmdForTest()
+	root.SetErr(&stderr)
+	root.SetArgs([]string{"build", "-c", cfg})
+	if err := root.Execute(); err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(td, "out", "nginx.conf")); !os.IsNotExist(err) {
+		t.Fatalf("disabled target was built (stat err = %v)", err)
+	}
+	if got := mustRead(t, filepath.Join(td, "out", "local.conf")); got != "a\n" {
+		t.Fatalf("local.conf = %q", got)
+	}
+	if !strings.Contains(stderr.String(), `target "nginx" skipped (enabled_if CONFB_ENV == production)`) {
+		t.Fatalf("stderr = %q, want skip notice", stderr.String())
+	}
+}
+
+func TestBuild_VerifyOutput(t *testing.T) {
+	td := t.TempDir()
+	cfg := filepath.Join(td, "confb.yaml")
+	writeFileT(t, filepath.Join(td, "a.yaml"), "a:\n  b: [1]\n")
+	writeFileT(t, filepath.Join(td, "b.yaml"), "a:\n  c: x\n")
+	writeFileT(t, filepath.Join(td, "a.ini"), "[s]\nk=1\n")
+	writeFileT(t, cfg, `
+version: 1
+targets:
+  - name: app
+    format: yaml
+    output: ./out/app.yaml
+    verify_output: true
+    merge: {}
+    sources:
+      - path: ./a.yaml
+      - path: ./b.yaml
+  - name: ini
+    format: ini
+    output: ./out/app.ini
+    merge: {}
+    sources:
+      - path: ./a.ini
+`)
+	t.Chdir(td)
+
+	root := NewRootCmdForTest()
+	root.SetArgs([]string{"build", "-c", cfg, "--verify-output"})
+	if err := root.Execute(); err != nil {
+		t.Fatalf("build --verify-output: %v", err)
+	}
+	if got := mustRead(t, filepath.Join(td, "out", "app.yaml")); !strings.Contains(got, "c: x") {
+		t.Fatalf("app.yaml = %q", got)
+	}
+}
+
+func TestBuild_StageDir_AllOrNothing(t *testing.T) {
+	td := t.TempDir()
+	cfg := filepath.Join(td, "confb.yaml")
+	out1 := filepath.Join(td, "etc", "one.conf")
+	out2 := filepath.Join(td, "etc", "two.conf")
+	stage := filepath.Join(td, "stage")
+
+	writeFileT(t, filepath.Join(td, "one.txt"), "one new\n")
+	writeFileT(t, filepath.Join(td, "two.txt"), "two new\n")
+	writeFileT(t, out1, "one old\n")
+	writeFileT(t, out2, "two old\n")
+	writeFileT(t, cfg, `
+version: 1
+targets:
+  - name: one
+    format: raw
+    output: `+out1+`
+    sources:
+      - path: ./one.txt
+  - name: two
+    format: raw
+    output: `+out2+`
+    sources:
+      - path: ./two.txt
+`)
+
+	// failing validation: neither output changes
+	root := NewRootCmdForTest()
+	root.SetArgs([]string{"build", "-c", cfg, "--stage-dir", stage, "--stage-validate", "grep -q new one.conf && false"})
+	if err := root.Execute(); err == nil || !strings.Contains(err.Error(), "not promoted") {
+		t.Fatalf("expected stage validation failure, got %v", err)
+	}
+	if mustRead(t, out1) != "one old\n" || mustRead(t, out2) != "two old\n" {
+		t.Fatal("outputs changed although stage validation failed")
+	}
+
+	// passing validation: both outputs are promoted
+	root = NewRootCmdForTest()
+	root.SetArgs([]string{"build", "-c", cfg, "--stage-dir", stage, "--stage-validate", "test -f one.conf && test -f two.conf"})
+	if err := root.Execute(); err != nil {
+		t.Fatalf("staged build failed: %v", err)
+	}
+	if mustRead(t, out1) != "one new\n" || mustRead(t, out2) != "two new\n" {
+		t.Fatalf("outputs not promoted: %q %q", mustRead(t, out1), mustRead(t, out2))
+	}
+	if _, err := os.Stat(filepath.Join(stage, "one.conf")); !os.IsNotExist(err) {
+		t.Fatalf("staged file left behind after promotion: %v", err)
+	}
+}
+
+func TestBuild_OutputModeInPlace_StageAndProvenance(t *testing.T) {
+	td := t.TempDir()
+	cfg := filepath.Join(td, "confb.yaml")
+	out := filepath.Join(td, "etc", "app.yaml")
+	prov := out + ".provenance.json"
+	stage := filepath.Join(td, "stage")
+
+	writeFileT(t, filepath.Join(td, "a.yaml"), "a: 1\n")
+	writeFileT(t, out, "old: true\n")
+	writeFileT(t, prov, "{}\n")
+	writeFileT(t, cfg, `
+version: 1
+targets:
+  - name: app
+    format: yaml
+    output: `+out+`
+    merge: {}
+    sources:
+      - path: ./a.yaml
+`)
+	inode := func(p string) uint64 {
+		t.Helper()
+		fi, err := os.Stat(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fi.Sys().(*syscall.Stat_t).Ino
+	}
+	outIno, provIno := inode(out), inode(prov)
+
+	// in-place rewrites the existing files instead of renaming new ones over them
+	root := NewRootCmdForTest()
+	root.SetArgs([]string{"build", "-c", cfg, "--stage-dir", stage, "--output-mode", "in-place"})
+	if err := root.Execute(); err != nil {
+		t.Fatalf("staged build: %v", err)
+	}
+	if got := mustRead(t, out); !strings.Contains(got, "a: 1") {
+		t.Fatalf("output not promoted: %q", got)
+	}
+	if inode(out) != outIno {
+		t.Fatalf("promotion replaced %s instead of rewriting it", out)
+	}
+
+	root = NewRootCmdForTest()
+	root.SetArgs([]string{"build", "-c", cfg, "--trace-provenance", "app", "--output-mode", "in-place"})
+	if err := root.Execute(); err != nil {
+		t.Fatalf("build --trace-provenance: %v", err)
+	}
+	if got := mustRead(t, prov); !strings.Contains(got, `"a"`) || inode(prov) != provIno {
+		t.Fatalf("provenance not rewritten in place: %q", got)
+	}
+}
+
+func TestBuild_EnvFile_ExpandsSourcePaths(t *testing.T) {
+	td := t.TempDir()
+	cfg := filepath.Join(td, "confb.yaml")
+	out := filepath.Join(td, "out.yaml")
+	srcDir := filepath.Join(td, "elsewhere")
+
+	writeFileT(t, filepath.Join(srcDir, "base.yaml"), "name: from-env-dir\n")
+	writeFileT(t, filepath.Join(td, "base.yaml"), "name: wrong\n")
+	writeFileT(t, filepath.Join(td, ".env"), "# CI settings\nSRCDIR="+srcDir+"\n")
+	writeFileT(t, cfg, `
+version: 1
+targets:
+  - name: app
+    format: yaml
+    output: `+out+`
+    sources:
+      - path: ${SRCDIR}/base.yaml
+`)
+	if _, set := os.LookupEnv("SRCDIR"); set {
+		t.Skip("SRCDIR already set in the environment")
+	}
+	t.Cleanup(func() { _ = os.Unsetenv("SRCDIR") })
+
+	root := NewRootCmdForTest()
+	root.SetArgs([]string{"build", "-c", cfg, "--env-file", filepath.Join(td, ".env")})
+	if err := root.Execute(); err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	if got := mustRead(t, out); !strings.HasSuffix(got, "\nname: from-env-dir\n") {
+		t.Fatalf("output = %q, want the source from $SRCDIR", got)
+	}
+}
+
+func TestMan_MarkdownFormat(t *testing.T) {
+	td := t.TempDir()
+	root := NewRootCmdForTest()
+	root.SetArgs([]string{"man", "--format=md", "-o", td})
+	if err := root.Execute(); err != nil {
+		t.Fatalf("man --format=md failed: %v", err)
+	}
+
+	entries, err := os.ReadDir(td)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var all strings.Builder
+	for _, e := range entries {
+		if !strings.HasSuffix(e.Name(), ".md") {
+			t.Fatalf("unexpected file %s", e.Name())
+		}
+		b := mustRead(t, filepath.Join(td, e.Name()))
+		if strings.TrimSpace(b) == "" {
+			t.Fatalf("%s is empty", e.Name())
+		}
+		all.WriteString(b)
+	}
+	for _, name := range []string{"confb.md", "confb_build.md", "confb_run.md", "confb_man.md"} {
+		if _, err := os.Stat(filepath.Join(td, name)); err != nil {
+			t.Fatalf("missing %s", name)
+		}
+	}
+	for _, use := range []string{"confb build", "confb run", "confb validate", "confb schema"} {
+		if !strings.Contains(all.String(), use) {
+			t.Fatalf("generated docs do not mention %q", use)
+		}
+	}
+
+	// "-o -" streams every page to stdout
+	var out bytes.Buffer
+	root = NewRootCmdForTest()
+	root.SetOut(&out)
+	root.SetArgs([]string{"man", "--format=rst", "-o", "-"})
+	if err := root.Execute(); err != nil {
+		t.Fatalf("man --format=rst -o - failed: %v", err)
+	}
+	if n := strings.Count(out.String(), "\n---\n"); n != len(entries)-1 {
+		t.Fatalf("stdout has %d separators, want %d", n, len(entries)-1)
+	}
+}
+
+func TestBuild_TraceProvenance(t *testing.T) {
+	td := t.TempDir()
+	cfg := filepath.Join(td, "confb.yaml")
+	out := filepath.Join(td, "out.yaml")
+	a := filepath.Join(td, "a.yaml")
+	b := filepath.Join(td, "b.yaml")
+	c := filepath.Join(td, "c.yaml")
+
+	writeFileT(t, a, "services:\n  web:\n    image: nginx:1\n    port: 80\nname: a\n")
+	writeFileT(t, b, "services:\n  web:\n    image: nginx:2\n  db:\n    image: pg\n")
+	writeFileT(t, c, "name: c\ntags: [x]\n")
+	writeFileT(t, cfg, `
+version: 1
+targets:
+  - name: app
+    format: yaml
+    output: `+out+`
+    sources:
+      - path: ./a.yaml
+      - path: ./b.yaml
+      - path: ./c.yaml
+    merge:
+      rules:
+        maps: deep
+`)
+
+	root := NewRootCmdForTest()
+	root.SetArgs([]string{"build", "-c", cfg, "--trace-provenance", "app"})
+	if err := root.Execute(); err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	var prov map[string]string
+	if err := json.Unmarshal([]byte(mustRead(t, out+".provenance.json")), &prov); err != nil {
+		t.Fatalf("provenance is not JSON: %v", err)
+	}
+	want := map[string]string{
+		"services.web.image": b,
+		"services.web.port":  a,
+		"services.db.image":  b,
+		"name":               c,
+		"tags":               c,
+	}
+	if len(prov) != len(want) {
+		t.Fatalf("provenance = %v, want %v", prov, want)
+	}
+	for k, v := range want {
+		if prov[k] != v {
+			t.Errorf("provenance[%q] = %q, want %q", k, prov[k], v)
+		}
+	}
+
+	// unknown target is rejected
+	root = NewRootCmdForTest()
+	root.SetArgs([]string{"build", "-c", cfg, "--trace-provenance", "nope"})
+	if err := root.Execute(); err == nil || !strings.Contains(err.Error(), "unknown target") {
+		t.Fatalf("want unknown target error, got %v", err)
+	}
+}
+
+func TestBuild_ReproducibleOutput(t *testing.T) {
+	td := t.TempDir()
+	cfg := filepath.Join(td, "confb.yaml")
+	t.Setenv("SOURCE_DATE_EPOCH", "1700000000")
+
+	var keys strings.Builder
+	for i := 0; i < 40; i++ {
+		fmt.Fprintf(&keys, "k%02d: {x: %d, y: [%d]}\n", i, i, i)
+	}
+	writeFileT(t, filepath.Join(td, "a.yaml"), keys.String())
+	writeFileT(t, filepath.Join(td, "b.yaml"), "k00: {z: 1}\nextra: true\n")
+	writeFileT(t, filepath.Join(td, "a.toml"), "[b]\nx = 1\n[a]\ny = 2\n")
+	writeFileT(t, filepath.Join(td, "b.toml"), "[c]\nz = 3\n[a]\nw = 4\n")
+	writeFileT(t, cfg, `
+version: 1
+targets:
+  - name: y
+    format: yaml
+    output: `+filepath.Join(td, "out", "out.yaml")+`
+    mtime_source: newest_source
+    sources:
+      - path: ./*.yaml
+    merge: {}
+  - name: t
+    format: toml
+    output: `+filepath.Join(td, "out", "out.toml")+`
+    sources:
+      - path: ./*.toml
+    merge: {}
+`)
+
+	build := func() map[string]string {
+		root := NewRootCmdForTest()
+		root.SetArgs([]string{"build", "-c", cfg})
+		if err := root.Execute(); err != nil {
+			t.Fatalf("build failed: %v", err)
+		}
+		return map[string]string{
+			"out.yaml": mustRead(t, filepath.Join(td, "out", "out.yaml")),
+			"out.toml": mustRead(t, filepath.Join(td, "out", "out.toml")),
+		}
+	}
+	first := build()
+	time.Sleep(1100 * time.Millisecond) // cross a second boundary in the header time
+	second := build()
+	for name, want := range first {
+		if second[name] != want {
+			t.Fatalf("%s differs between builds:\n--- first\n%s\n--- second\n%s", name, want, second[name])
+		}
+	}
+	if !strings.Contains(first["out.toml"], "time: 2023-11-14T22:13:20Z") {
+		t.Fatalf("toml header does not use SOURCE_DATE_EPOCH:\n%s", first["out.toml"])
+	}
+}
+
+func TestBuild_ReproducibleOutput_Default(t *testing.T) {
+	td := t.TempDir()
+	cfg := filepath.Join(td, "confb.yaml")
+	t.Setenv("SOURCE_DATE_EPOCH", "") // no env, no mtime_source: the plain default
+
+	writeFileT(t, filepath.Join(td, "src", "a.yaml"), "b: {y: 1}\na: [1, 2]\n")
+	writeFileT(t, filepath.Join(td, "src", "b.yaml"), "c: true\nb: {x: 2}\n")
+	newest := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(filepath.Join(td, "src", "b.yaml"), newest, newest); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(filepath.Join(td, "src", "a.yaml"), newest.Add(-time.Hour), newest.Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(td, "out", "out.yaml")
+	writeFileT(t, cfg, `
+version: 1
+targets:
+  - name: y
+    format: yaml
+    output: `+out+`
+    sources:
+      - path: ./src/*.yaml
+    merge: {}
+`)
+
+	build := func() string {
+		root := NewRootCmdForTest()
+		root.SetArgs([]string{"build", "-c", cfg, "--quiet"})
+		if err := root.Execute(); err != nil {
+			t.Fatalf("build failed: %v", err)
+		}
+		return mustRead(t, out)
+	}
+	first := build()
+	time.Sleep(1100 * time.Millisecond) // cross a second boundary
+	if second := build(); second != first {
+		t.Fatalf("output differs between builds:\n--- first\n%s\n--- second\n%s", first, second)
+	}
+	if !strings.Contains(first, "time: 2024-05-01T12:00:00Z") {
+		t.Fatalf("header does not use the newest source mtime:\n%s", first)
+	}
+}
+
+func TestBuild_Summary(t *testing.T) {
+	td := t.TempDir()
+	cfg := filepath.Join(td, "confb.yaml")
+	writeFileT(t, filepath.Join(td, "src", "a.yaml"), "a: 1\n")
+	writeFileT(t, filepath.Join(td, "src", "b.txt"), "b\n")
+	writeFileT(t, cfg, `
+version: 1
+targets:
+  - name: y
+    format: yaml
+    output: `+filepath.Join(td, "out", "a.yaml")+`
+    sources:
+      - path: ./src/a.yaml
+    merge: {}
+  - name: r
+    format: raw
+    output: `+filepath.Join(td, "out", "b.txt")+`
+    sources:
+      - path: ./src/b.txt
+`)
+
+	build := func(args ...string) string {
+		var errOut bytes.Buffer
+		root := NewRootCmdForTest()
+		root.SetErr(&errOut)
+		root.SetArgs(append([]string{"build", "-c", cfg}, args...))
+		if err := root.Execute(); err != nil {
+			t.Fatalf("build failed: %v", err)
+		}
+		return errOut.String()
+	}
+	if got := build("--summary"); !strings.Contains(got, "confb: 2 built, 0 unchanged, 0 errors") {
+		t.Fatalf("first build summary missing:\n%s", got)
+	}
+
+	// only the yaml source changes; the raw output is rewritten with the same bytes
+	writeFileT(t, filepath.Join(td, "src", "a.yaml"), "a: 2\n")
+	got := build("--summary", "--quiet")
+	if strings.TrimSpace(got) != "confb: 1 built, 1 unchanged, 0 errors" {
+		t.Fatalf("quiet summary = %q, want only the summary line", got)
+	}
+}
+
+func TestBuild_CacheDirSkipsUnchanged(t *testing.T) {
+	td := t.TempDir()
+	cfg := filepath.Join(td, "confb.yaml")
+	cacheDir := filepath.Join(td, "cache")
+	out := filepath.Join(td, "out", "app.yaml")
+	src := filepath.Join(td, "src", "a.yaml")
+	writeFileT(t, src, "a: 1\n")
+	writeFileT(t, cfg, `
+version: 1
+targets:
+  - name: app
+    format: yaml
+    output: `+out+`
+    sources:
+      - path: ./src/a.yaml
+    merge: {}
+`)
+
+	build := func(args ...string) {
+		root := NewRootCmdForTest()
+		root.SetArgs(append([]string{"build", "-c", cfg, "--cache-dir", cacheDir}, args...))
+		if err := root.Execute(); err != nil {
+			t.Fatalf("build failed: %v", err)
+		}
+	}
+	old := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	rewritten := func() bool {
+		fi, err := os.Stat(out)
+		if err != nil {
+			t.Fatalf("stat out: %v", err)
+		}
+		return !fi.ModTime().Equal(old)
+	}
+
+	build()
+	if _, err := os.Stat(filepath.Join(cacheDir, "target-app.cache")); err != nil {
+		t.Fatalf("cache file not written: %v", err)
+	}
+
+	if err := os.Chtimes(out, old, old); err != nil {
+		t.Fatal(err)
+	}
+	build()
+	if rewritten() {
+		t.Fatal("unchanged target was rebuilt despite the cache")
+	}
+
+	build("--no-cache")
+	if !rewritten() {
+		t.Fatal("--no-cache did not rebuild")
+	}
+
+	if err := os.Chtimes(out, old, old); err != nil {
+		t.Fatal(err)
+	}
+	writeFileT(t, src, "a: 2\n")
+	build()
+	if !rewritten() || !strings.Contains(mustRead(t, out), "a: 2") {
+		t.Fatal("changed source did not rebuild")
+	}
+}
+
+func TestBuild_PostBuildRunsOnce(t *testing.T) {
+	td := t.TempDir()
+	cfg := filepath.Join(td, "confb.yaml")
+	hookLog := filepath.Join(td, "hook.log")
+	for _, n := range []string{"a", "b", "c"} {
+		writeFileT(t, filepath.Join(td, "src", n+".txt"), n+"\n")
+	}
+	writeFileT(t, cfg, `
+version: 1
+post_build: echo "{built_count} {targets} $CONFB_BUILT_COUNT" >> `+hookLog+`
+targets:
+  - name: a
+    format: raw
+    output: `+filepath.Join(td, "out", "a.txt")+`
+    sources:
+      - path: ./src/a.txt
+  - name: b
+    format: raw
+    output: `+filepath.Join(td, "out", "b.txt")+`
+    sources:
+      - path: ./src/b.txt
+  - name: c
+    format: raw
+    output: `+filepath.Join(td, "out", "c.txt")+`
+    sources:
+      - path: ./src/c.txt
+`)
+
+	root := NewRootCmdForTest()
+	root.SetErr(io.Discard)
+	root.SetArgs([]string{"build", "-c", cfg})
+	if err := root.Execute(); err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	if got := mustRead(t, hookLog); got != "3 a,b,c 3\n" {
+		t.Fatalf("post_build log = %q, want one line %q", got, "3 a,b,c 3\n")
+	}
+}
+
+func TestBuild_TargetsFile(t *testing.T) {
+	td := t.TempDir()
+	cfg := filepath.Join(td, "confb.yaml")
+	for _, n := range []string{"a", "b", "c"} {
+		writeFileT(t, filepath.Join(td, "src", n+".txt"), n+"\n")
+	}
+	writeFileT(t, cfg, `
+version: 1
+targets:
+  - name: a
+    format: raw
+    output: `+filepath.Join(td, "out", "a.txt")+`
+    sources:
+      - path: ./src/a.txt
+  - name: b
+    format: raw
+    output: `+filepath.Join(td, "out", "b.txt")+`
+    sources:
+      - path: ./src/b.txt
+  - name: c
+    format: raw
+    output: `+filepath.Join(td, "out", "c.txt")+`
+    sources:
+      - path: ./src/c.txt
+`)
+	list := filepath.Join(td, "targets.txt")
+	writeFileT(t, list, "# only these\na\n\nc\n")
+
+	root := NewRootCmdForTest()
+	root.SetErr(io.Discard)
+	root.SetArgs([]string{"build", "-c", cfg, "--targets-file", list})
+	if err := root.Execute(); err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	for n, want := range map[string]bool{"a": true, "b": false, "c": true} {
+		_, err := os.Stat(filepath.Join(td, "out", n+".txt"))
+		if built := err == nil; built != want {
+			t.Fatalf("target %s built = %v, want %v", n, built, want)
+		}
+	}
+
+	writeFileT(t, list, "a\nnope\n")
+	root = NewRootCmdForTest()
+	root.SetErr(io.Discard)
+	root.SetArgs([]string{"build", "-c", cfg, "--targets-file", list, "--targets", "b"})
+	if err := root.Execute(); err == nil || !strings.Contains(err.Error(), "unknown target(s): nope") {
+		t.Fatalf("want unknown target error, got %v", err)
+	}
+}
+
+func TestValidate_FormatJSON(t *testing.T) {
+	td := t.TempDir()
+	cfg := filepath.Join(td, "confb.yaml")
+
+	validate := func() (validateReport, error) {
+		var stdout bytes.Buffer
+		root := NewRootCmdForTest()
+		root.SilenceUsage, root.SilenceErrors = true, true // as in NewRootCmd
+		root.SetOut(&stdout)
+		root.SetArgs([]string{"validate", "-c", cfg, "--format=json"})
+		err := root.Execute()
+		var rep validateReport
+		if jerr := json.Unmarshal(stdout.Bytes(), &rep); jerr != nil {
+			t.Fatalf("stdout is not a JSON report: %v\n%s", jerr, stdout.String())
+		}
+		return rep, err
+	}
+
+	writeFileT(t, cfg, `
+version: 1
+targets:
+  - name: y
+    format: yaml
+    output: ./out.yaml
+    sources:
+      - path: ./a.yaml
+`)
+	rep, err := validate()
+	if err != nil || !rep.Valid || len(rep.Errors) != 0 || rep.Warnings == nil {
+		t.Fatalf("valid config: report %+v, err %v", rep, err)
+	}
+
+	writeFileT(t, cfg, `
+version: 1
+targets:
+  - name: y
+    format: yaml
+    output: ./out.yaml
+    sources:
+      - path: ./a.yaml
+    merge:
+      rules:
+        maps: sideways
+`)
+	rep, err = validate()
+	if err == nil || rep.Valid || len(rep.Errors) != 1 {
+		t.Fatalf("invalid config: report %+v, err %v", rep, err)
+	}
+	if e := rep.Errors[0]; e.Field != "merge.rules.maps (target y)" || !strings.Contains(e.Message, `rules.maps must be deep|replace (got "sideways")`) {
+		t.Fatalf("error = %+v", e)
+	}
+}
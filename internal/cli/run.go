@@ -2,6 +2,7 @@
 
 import (
 	"fmt"
+	"strconv"
 	"time"
 
 	"github.com/spf13/cobra"
@@ -10,11 +11,39 @@
 	"github.com/nekwebdev/confb/internal/daemon"
 )
 
+// runDaemon starts the daemon; tests replace it to inspect the options.
+var runDaemon = daemon.Run
+
 func newRunCmd() *cobra.Command {
 	var quiet bool
 	var verbose bool
 	var debounceMS int
 	var color bool
+	var gracePeriod time.Duration
+	var lock bool
+	var lockTimeout time.Duration
+	var verifyWrite bool
+	var metricsAddr string
+	var targetDebounce []string
+	var stateFile string
+	var historyDepth int
+	var watchdogInterval time.Duration
+	var webhookURL string
+	var gracefulDrain bool
+	var envFiles []string
+	var exitOnEmpty bool
+	var failFast bool
+	var batchOnChange bool
+	var sighupAction string
+	var drainTimeout time.Duration
+	var noResume bool
+	var healthcheckAddr string
+	var maxConcurrentFlushes int
+	var autoRestart bool
+	var statusFIFO string
+	var maxRestarts int
+	var restartBackoffMax time.Duration
+	var pidFile string
 
 	cmd := &cobra.Command{
 		Use:   "run",
@@ -35,11 +64,28 @@ func newRunCmd() *cobra.Command {
 			if err != nil {
 				return err
 			}
-			cfg, err := config.Load(cfgPath)
+			if err := loadEnvFiles(envFiles); err != nil {
+				return err
+			}
+			if cfgPath == config.StdinPath {
+				return fmt.Errorf("run cannot read its config from stdin (-c -): SIGHUP reloads re-read the file")
+			}
+			extra := extraConfigs(cmd)
+			cfg, err := config.Load(cfgPath, extra...)
 			if err != nil {
 				return fmt.Errorf("load config: %w", err)
 			}
 
+			perTarget, err := parseTargetDebounce(targetDebounce)
+			if err != nil {
+				return err
+			}
+			if webhookURL != "" {
+				if err := config.ValidWebhookURL(webhookURL); err != nil {
+					return fmt.Errorf("--webhook-url: %w", err)
+				}
+			}
+
 			level := daemon.LogNormal
 			if quiet {
 				level = daemon.LogQuiet
@@ -48,21 +94,90 @@ func newRunCmd() *cobra.Command {
 				level = daemon.LogVerbose
 			}
 
+			switch sighupAction {
+			case "reload", "rebuild", "both":
+			default:
+				return fmt.Errorf("--sighup-action must be reload, rebuild or both (got %q)", sighupAction)
+			}
+
+			// --pid-file waits 1s for a competing daemon unless --lock-timeout says otherwise
+			pidLockTimeout := time.Second
+			if cmd.Flags().Changed("lock-timeout") {
+				pidLockTimeout = lockTimeout
+			}
+
 			opts := daemon.Options{
 				LogLevel:   level,
 				Debounce:   msToDuration(debounceMS),
 				ConfigPath: cfgPath,
 				Color:      color,
+
+				ExtraConfigs: extra,
+
+				GracePeriod:    gracePeriod,
+				WriteLockFiles: lock,
+				LockTimeout:    lockTimeout,
+				PIDFile:        expandPath(pidFile),
+				PIDLockTimeout: pidLockTimeout,
+				VerifyWrite:    verifyWrite,
+				MetricsAddr:    metricsAddr,
+				TargetDebounce: perTarget,
+				StateFile:      expandPath(stateFile),
+				HistoryDepth:   historyDepth,
+				NoResume:       noResume,
+				FailFast:       failFast,
+				BatchOnChange:  batchOnChange,
+				SIGHUPAction:   sighupAction,
+
+				WatchdogInterval: watchdogInterval,
+				WebhookURL:       webhookURL,
+				StatusFIFO:       expandPath(statusFIFO),
+				HealthcheckAddr:  healthcheckAddr,
+				GracefulDrain:    gracefulDrain,
+
+				ExitOnEmptySourceSet: exitOnEmpty,
+				MaxConcurrentFlushes: maxConcurrentFlushes,
+				DrainTimeout:         drainTimeout,
+
+				AutoRestart:       autoRestart,
+				MaxRestarts:       maxRestarts,
+				RestartBackoffMax: restartBackoffMax,
 			}
 
-			return daemon.Run(cfg, opts)
+			return runDaemon(cfg, opts)
 		},
 	}
 
 	cmd.Flags().BoolVar(&quiet, "quiet", false, "reduce log output")
 	cmd.Flags().BoolVar(&verbose, "verbose", false, "increase log output (debug)")
 	cmd.Flags().IntVar(&debounceMS, "debounce-ms", 200, "debounce interval for rebuilds (milliseconds)")
+	cmd.Flags().StringArrayVar(&targetDebounce, "target-debounce", nil, "per-target debounce override TARGET=MS (repeatable; wins over debounce_ms in the config)")
+	cmd.Flags().IntVar(&maxConcurrentFlushes, "max-concurrent-flushes", 0, "run at most N rebuilds at once; the rest wait their turn (0 = no limit)")
 	cmd.Flags().BoolVar(&color, "color", false, "enable ANSI color for log level tags")
+	cmd.Flags().BoolVar(&lock, "lock", false, "write .confb.lock into watched directories; refuse to start if another daemon holds them")
+	cmd.Flags().DurationVar(&lockTimeout, "lock-timeout", 0, "with --lock or --pid-file, wait this long for another daemon to release its locks (0 = fail immediately; --pid-file alone defaults to 1s)")
+	cmd.Flags().StringVar(&pidFile, "pid-file", "", "write the daemon's PID here and hold an exclusive flock on it while running (e.g. ~/.cache/confb/confb.pid, where 'confb reload' looks)")
+	cmd.Flags().BoolVar(&verifyWrite, "verify-write", false, "read each output back after writing and compare checksums")
+	cmd.Flags().DurationVar(&gracePeriod, "grace-period", 0, "buffer watch events for this long after startup before the first rebuild (e.g. 5s)")
+	cmd.Flags().StringVar(&stateFile, "state-file", daemon.DefaultStatePath(), "write recent builds per target to this JSON file for 'confb status' (\"\" disables)")
+	cmd.Flags().BoolVar(&noResume, "no-resume", false, "rewrite every output at startup even if it matches the checksum in the state file")
+	cmd.Flags().IntVar(&historyDepth, "history-depth", daemon.DefaultHistoryDepth, "build records kept per target in the state file")
+	cmd.Flags().DurationVar(&watchdogInterval, "watchdog-interval", 0, "recheck all sources this often and rebuild targets whose events were missed (e.g. 30s; 0 = off)")
+	cmd.Flags().StringArrayVar(&envFiles, "env-file", nil, "read KEY=VALUE lines from PATH into the environment before loading the config (repeatable; existing variables win)")
+	cmd.Flags().StringVar(&sighupAction, "sighup-action", "reload", "what SIGHUP does: reload (re-read the config and rebuild), rebuild (all targets, same config) or both (reload, else rebuild)")
+	_ = cmd.RegisterFlagCompletionFunc("sighup-action", completeValues("reload", "rebuild", "both"))
+	cmd.Flags().BoolVar(&batchOnChange, "batch-on-change", false, "run the config-wide on_change once per rebuild batch with {changed_targets} (default: once per target)")
+	cmd.Flags().BoolVar(&failFast, "fail-fast", false, "exit if any target fails its initial build (default: keep watching it and build the rest)")
+	cmd.Flags().BoolVar(&exitOnEmpty, "exit-on-empty", false, "exit cleanly after startup if a target's optional sources all match nothing (init containers)")
+	cmd.Flags().BoolVar(&gracefulDrain, "graceful-drain", false, "on SIGINT/SIGTERM, let running rebuilds and their on_change hooks finish before exiting")
+	cmd.Flags().DurationVar(&drainTimeout, "drain-timeout", 30*time.Second, "with --graceful-drain, give up waiting after this long")
+	cmd.Flags().BoolVar(&autoRestart, "auto-restart", false, "restart the daemon after a panic, with exponential backoff (1s doubling to --restart-backoff-max)")
+	cmd.Flags().IntVar(&maxRestarts, "max-restarts", 5, "with --auto-restart, give up (exit code 2) after this many consecutive restarts of runs that lasted under 5 minutes")
+	cmd.Flags().DurationVar(&restartBackoffMax, "restart-backoff-max", 60*time.Second, "with --auto-restart, cap the restart backoff at this duration")
+	cmd.Flags().StringVar(&webhookURL, "webhook-url", "", "notify this URL with a JSON summary after every build cycle (overrides webhook.url in the config)")
+	cmd.Flags().StringVar(&statusFIFO, "status-fifo", "", "after every build cycle write a JSON status line to this named pipe (created if missing; skipped without a reader)")
+	cmd.Flags().StringVar(&metricsAddr, "metrics-addr", "", "serve Prometheus metrics on GET /metrics at this address (e.g. :9095)")
+	cmd.Flags().StringVar(&healthcheckAddr, "healthcheck-addr", "", "serve GET /healthz, /readyz and /targets at this address for liveness/readiness probes (e.g. :8080)")
 
 	return cmd
 }
@@ -73,3 +188,20 @@ func msToDuration(ms int) time.Duration {
 	}
 	return time.Duration(ms) * time.Millisecond
 }
+
+// parseTargetDebounce parses repeated TARGET=MS values.
+func parseTargetDebounce(list []string) (map[string]time.Duration, error) {
+	raw, err := parseOverrides("target-debounce", "MS", list)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]time.Duration, len(raw))
+	for name, v := range raw {
+		ms, err := strconv.Atoi(v)
+		if err != nil || ms <= 0 {
+			return nil, fmt.Errorf("invalid --target-debounce %s=%s (expected a positive number of milliseconds)", name, v)
+		}
+		out[name] = time.Duration(ms) * time.Millisecond
+	}
+	return out, nil
+}
@@ -0,0 +1,144 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/nekwebdev/confb/internal/config"
+	executor "github.com/nekwebdev/confb/internal/exec"
+)
+
+// schemaEnums lists the accepted values of enum fields, keyed "Type.yaml_name",
+// in the spelling the schema requires (validation itself ignores case).
+var schemaEnums = map[string][]string{
+	"Defaults.dedupe":              {"by_path", "none"},
+	"Webhook.method":               {"POST", "PUT", "PATCH"},
+	"Target.format":                {"auto", "yaml", "toml", "ini", "json", "raw", "kdl"},
+	"Target.dedupe":                {"by_path", "none"},
+	"Target.mtime_source":          {"now", "newest_source", "zero"},
+	"Target.post_process":          {"ensure_trailing_newline", "strip_trailing_newline", "no_header", "trim_blank_lines", "unix_eol", "windows_eol"},
+	"Source.sort":                  {"lex", "none", "numeric", "mtime_asc", "mtime_desc"},
+	"Source.encoding":              {"utf8", "latin1"},
+	"Source.transforms":            {"env_interpolate"},
+	"MergeRules.maps":              {"deep", "replace"},
+	"MergeRules.arrays":            {"replace", "append", "unique_append", "intersect"},
+	"MergeRules.yaml_style":        {"block", "flow"},
+	"MergeRules.yaml_scalar_style": {"any", "double_quoted", "single_quoted", "literal"},
+	"MergeRules.null_strategy":     {"replace", "delete", "ignore"},
+	"MergeRules.toml_output_style": {"standard", "compact"},
+	"MergeRules.keys":              {"last_wins", "first_wins", "append"},
+	"MergeRules.output_order":      {"lex", "encounter"},
+	"MergeRules.repeated_keys":     {"last_wins", "append"},
+	"MergeRules.section_order":     {"first_seen", "lex", "source_priority"},
+}
+
+// schemaRequired lists required properties per type (Go name).
+var schemaRequired = map[string][]string{
+	"Config":  {"version", "targets"},
+	"Webhook": {"url"},
+	"Target":  {"name", "output", "sources"},
+}
+
+func newSchemaCmd() *cobra.Command {
+	var output string
+
+	cmd := &cobra.Command{
+		Use:   "schema",
+		Short: "Print a JSON Schema for confb.yaml",
+		Long: `Schema prints a JSON Schema describing confb.yaml, for editor completion and
+validation (e.g. the YAML language server). It is generated from the config
+types; descriptions come from their comments.
+
+Enum values must be spelled exactly as listed (e.g. format: yaml, webhook
+method: POST): confb itself ignores their case, but the schema does not.`,
+		Example: `  confb schema --output ./confb.schema.json
+  # then, at the top of confb.yaml:
+  # yaml-language-server: $schema=./confb.schema.json`,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			b, err := json.MarshalIndent(configSchema(), "", "  ")
+			if err != nil {
+				return err
+			}
+			if output == "" || output == executor.StdoutPath {
+				_, err = fmt.Fprintln(cmd.OutOrStdout(), string(b))
+				return err
+			}
+			return executor.WriteAtomic(expandPath(output), string(b)+"\n")
+		},
+	}
+
+	cmd.Flags().StringVarP(&output, "output", "o", "", "write the schema to PATH instead of stdout (never read from CONFB_OUTPUT, which hooks receive)")
+	return cmd
+}
+
+// configSchema builds the JSON Schema (draft 2020-12) of config.Config.
+func configSchema() map[string]any {
+	s := typeSchema(reflect.TypeOf(config.Config{}), config.Docs())
+	s["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	s["title"] = "confb.yaml"
+	s["$comment"] = "enum values are matched case-sensitively; confb itself ignores their case"
+	return s
+}
+
+// typeSchema maps a Go type to a schema: structs become closed objects whose
+// properties are named by their yaml tags.
+func typeSchema(t reflect.Type, docs map[string]string) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int64:
+		return map[string]any{"type": "integer"}
+	case reflect.Slice:
+		return map[string]any{"type": "array", "items": typeSchema(t.Elem(), docs)}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": typeSchema(t.Elem(), docs)}
+	case reflect.Struct:
+	default:
+		return map[string]any{}
+	}
+
+	props := map[string]any{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		p := typeSchema(f.Type, docs)
+		if d := docs[t.Name()+"."+f.Name]; d != "" {
+			p["description"] = d
+		}
+		if enum, ok := schemaEnums[t.Name()+"."+name]; ok {
+			if items, isArray := p["items"].(map[string]any); isArray {
+				items["enum"] = enum // list of enum values
+			} else {
+				p["enum"] = enum
+			}
+		}
+		props[name] = p
+	}
+	s := map[string]any{
+		"type":                 "object",
+		"properties":           props,
+		"additionalProperties": false,
+	}
+	if d := docs[t.Name()]; d != "" {
+		s["description"] = d
+	}
+	if req, ok := schemaRequired[t.Name()]; ok {
+		s["required"] = req
+	}
+	return s
+}
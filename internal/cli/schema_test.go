@@ -0,0 +1,113 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/santhosh-tekuri/jsonschema/v6"
+	"gopkg.in/yaml.v3"
+)
+
+// schemaErrors validates v against schema (the output of `confb schema`) with
+// a draft 2020-12 validator and returns each failure as "location: message".
+func schemaErrors(t *testing.T, schema []byte, v any) []string {
+	t.Helper()
+	doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(schema))
+	if err != nil {
+		t.Fatalf("schema is not JSON: %v", err)
+	}
+	c := jsonschema.NewCompiler()
+	c.DefaultDraft(jsonschema.Draft2020)
+	if err := c.AddResource("confb.schema.json", doc); err != nil {
+		t.Fatalf("add schema: %v", err)
+	}
+	sch, err := c.Compile("confb.schema.json")
+	if err != nil {
+		t.Fatalf("schema does not compile: %v", err)
+	}
+	err = sch.Validate(v)
+	if err == nil {
+		return nil
+	}
+	var ve *jsonschema.ValidationError
+	if !errors.As(err, &ve) {
+		t.Fatalf("validate: %v", err)
+	}
+	var errs []string
+	for _, u := range ve.BasicOutput().Errors {
+		if u.Error != nil {
+			errs = append(errs, u.InstanceLocation+": "+u.Error.String())
+		}
+	}
+	return errs
+}
+
+// yamlAsJSON decodes a YAML document into the values encoding/json would produce.
+func yamlAsJSON(t *testing.T, src string) any {
+	t.Helper()
+	var v any
+	if err := yaml.Unmarshal([]byte(src), &v); err != nil {
+		t.Fatalf("yaml: %v", err)
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("json: %v", err)
+	}
+	out, err := jsonschema.UnmarshalJSON(bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("json: %v", err)
+	}
+	return out
+}
+
+func TestSchema_AcceptsSampleRejectsBad(t *testing.T) {
+	td := t.TempDir()
+	schemaPath := filepath.Join(td, "confb.schema.json")
+
+	root := NewRootCmdForTest()
+	root.SetArgs([]string{"schema", "--output", schemaPath})
+	if err := root.Execute(); err != nil {
+		t.Fatalf("schema failed: %v", err)
+	}
+	schema := []byte(mustRead(t, schemaPath))
+
+	sample, err := os.ReadFile(filepath.Join("..", "..", "confb.sample.yaml"))
+	if err != nil {
+		t.Fatalf("read sample: %v", err)
+	}
+	if errs := schemaErrors(t, schema, yamlAsJSON(t, string(sample))); len(errs) > 0 {
+		t.Fatalf("sample config rejected:\n%v", errs)
+	}
+
+	bad := yamlAsJSON(t, `
+version: 1
+targets:
+  - name: y
+    format: yml
+    output: ./out.yaml
+    dedupe: NONE
+    sources:
+      - path: ./a.yaml
+        sorting: lex
+    merge:
+      rules:
+        arrays: merge
+`)
+	errs := schemaErrors(t, schema, bad)
+	for _, want := range []string{
+		"/targets/0/format: value must be one of",
+		"/targets/0/dedupe: value must be one of", // the schema is case-sensitive
+		"/targets/0/sources/0: additional properties 'sorting' not allowed",
+		"/targets/0/merge/rules/arrays: value must be one of",
+	} {
+		if !slices.ContainsFunc(errs, func(e string) bool { return strings.HasPrefix(e, want) }) {
+			t.Errorf("missing error %q in %v", want, errs)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/nekwebdev/confb/internal/config"
+	executor "github.com/nekwebdev/confb/internal/exec"
+)
+
+// stagedOutput pairs a file written into --stage-dir with its final path.
+type stagedOutput struct {
+	Staged string
+	Final  string
+}
+
+// stageConfig returns a copy of cfg whose targets write into dir (by output
+// basename), with outputs taken from effective (overrides applied). target_ref
+// sources therefore read the staged outputs. Stdout targets are left alone.
+func stageConfig(cfg *config.Config, effective []config.Target, dir string) (*config.Config, []stagedOutput, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, nil, fmt.Errorf("--stage-dir: %w", err)
+	}
+	out := *cfg
+	out.Targets = make([]config.Target, len(effective))
+	var staged []stagedOutput
+	byBase := map[string]string{}
+	for i, t := range effective {
+		if executor.IsFileOutput(t.Output) {
+			base := filepath.Base(t.Output)
+			if other, dup := byBase[base]; dup {
+				return nil, nil, fmt.Errorf("--stage-dir: targets %q and %q both stage as %s", other, t.Name, base)
+			}
+			byBase[base] = t.Name
+			s := filepath.Join(dir, base)
+			staged = append(staged, stagedOutput{Staged: s, Final: t.Output})
+			t.Output = s
+		}
+		out.Targets[i] = t
+	}
+	return &out, staged, nil
+}
+
+// runStageValidate runs cmdStr with sh in the staging directory (also passed as
+// CONFB_STAGE_DIR); a non-zero exit aborts promotion.
+func runStageValidate(cmdStr, dir string) error {
+	c := exec.Command("/bin/sh", "-c", cmdStr)
+	c.Dir = dir
+	c.Env = append(os.Environ(), "CONFB_STAGE_DIR="+dir)
+	c.Stdout = os.Stderr
+	c.Stderr = os.Stderr
+	if err := c.Run(); err != nil {
+		return fmt.Errorf("--stage-validate: %w (outputs not promoted; staged files are in %s)", err, dir)
+	}
+	return nil
+}
+
+// promoteStaged renames each staged file over its final path, in order. Each
+// rename is atomic; across filesystems the content is rewritten atomically instead.
+// With --output-mode copy|in-place (mode) the content is always rewritten
+// with that strategy, so a final path in a read-only directory can be promoted.
+func promoteStaged(staged []stagedOutput, mode string) error {
+	for _, s := range staged {
+		if err := os.MkdirAll(filepath.Dir(s.Final), 0o755); err != nil {
+			return fmt.Errorf("promote %s: %w", s.Final, err)
+		}
+		if mode == "" || mode == executor.WriteModeAtomic {
+			if err := os.Rename(s.Staged, s.Final); err == nil {
+				fmt.Fprintf(os.Stderr, "confb: promoted %s -> %s\n", s.Staged, s.Final)
+				continue
+			}
+		}
+		b, err := os.ReadFile(s.Staged)
+		if err != nil {
+			return fmt.Errorf("promote %s: %w", s.Final, err)
+		}
+		if err := executor.WriteWithMode(s.Final, string(b), mode); err != nil {
+			return fmt.Errorf("promote %s: %w", s.Final, err)
+		}
+		_ = os.Remove(s.Staged)
+		fmt.Fprintf(os.Stderr, "confb: promoted %s -> %s\n", s.Staged, s.Final)
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/nekwebdev/confb/internal/daemon"
+)
+
+func newStatusCmd() *cobra.Command {
+	var stateFile string
+	var history string
+	var asJSON bool
+
+	cmd := &cobra.Command{
+		Use:   "status",
+		Short: "Show the daemon's recent builds from its state file",
+		Long: `Status reads the state file written by 'confb run' and prints the latest build
+of every target. With --history TARGET it prints that target's recent builds
+(the daemon keeps --history-depth records per target, default 10).`,
+		Example: `  confb status
+  confb status --history niri
+  confb status --history niri --json`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if stateFile == "" {
+				stateFile = daemon.DefaultStatePath()
+			}
+			st, err := daemon.ReadState(expandPath(stateFile))
+			if err != nil {
+				return fmt.Errorf("read state file: %w", err)
+			}
+			out := cmd.OutOrStdout()
+
+			if history != "" {
+				recs, ok := st.Targets[history]
+				if !ok {
+					return fmt.Errorf("no history for target %q in %s", history, stateFile)
+				}
+				if asJSON {
+					enc := json.NewEncoder(out)
+					enc.SetIndent("", "  ")
+					return enc.Encode(recs)
+				}
+				tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+				fmt.Fprintln(tw, "TIME\tBUILD\tDURATION\tBEFORE\tAFTER\tFILES\tERROR")
+				for _, r := range recs {
+					fmt.Fprintf(tw, "%s\t%s\t%dms\t%s\t%s\t%d\t%s\n",
+						r.Time.Local().Format(time.DateTime), shortSum(r.BuildID), r.DurationMS,
+						shortSum(r.SumBefore), shortSum(r.SumAfter), len(r.Files), r.Error)
+				}
+				return tw.Flush()
+			}
+
+			if asJSON {
+				enc := json.NewEncoder(out)
+				enc.SetIndent("", "  ")
+				return enc.Encode(st)
+			}
+			fmt.Fprintf(out, "pid %d, config %s, updated %s\n", st.PID, st.Config, st.UpdatedAt.Local().Format(time.DateTime))
+			names := make([]string, 0, len(st.Targets))
+			for name := range st.Targets {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(tw, "TARGET\tLAST BUILD\tDEBOUNCE\tRESULT\tSHA256")
+			for _, name := range names {
+				recs := st.Targets[name]
+				if len(recs) == 0 {
+					continue
+				}
+				last := recs[len(recs)-1]
+				result := "ok"
+				if last.Error != "" {
+					result = "error: " + last.Error
+				}
+				debounce := "-"
+				if ms, ok := st.DebounceMS[name]; ok {
+					debounce = fmt.Sprintf("%dms", ms)
+				}
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", name, last.Time.Local().Format(time.DateTime), debounce, result, shortSum(last.SumAfter))
+			}
+			return tw.Flush()
+		},
+	}
+
+	cmd.Flags().StringVar(&stateFile, "state-file", "", "state file written by 'confb run' (default ~/.cache/confb/state.json)")
+	cmd.Flags().StringVar(&history, "history", "", "print the recent builds of TARGET")
+	cmd.Flags().BoolVar(&asJSON, "json", false, "print JSON instead of a table")
+
+	return cmd
+}
+
+// shortSum abbreviates a hex checksum for tables ("-" when empty).
+func shortSum(s string) string {
+	if s == "" {
+		return "-"
+	}
+	if len(s) > 12 {
+		return s[:12]
+	}
+	return s
+}
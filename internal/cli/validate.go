@@ -1,18 +1,29 @@
 package cli
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/spf13/cobra"
 
 	"github.com/nekwebdev/confb/internal/config"
+	"github.com/nekwebdev/confb/internal/daemon"
+	executor "github.com/nekwebdev/confb/internal/exec"
+	"github.com/nekwebdev/confb/internal/plan"
 )
 
 func newValidateCmd() *cobra.Command {
 	var trace bool
 	var list bool
+	var checkSources bool
+	var strict bool
+	var dryBuild bool
+	var targetNames []string
+	var format string
 
 	cmd := &cobra.Command{
 		Use:   "validate",
@@ -20,16 +31,29 @@ func newValidateCmd() *cobra.Command {
 		Long:  "Validate parses and checks confb.yaml (globs, rules, and options) and prints any errors.",
 		Example: `  confb validate
   confb validate -c ./confb.yaml
-  CONFB_CONFIG=./alt.yaml confb validate`,
+  CONFB_CONFIG=./alt.yaml confb validate
+  confb validate --format json   # {"valid":…,"errors":[…],"warnings":[…]} on stdout
+  confb validate --dry-build --targets app   # also parse and merge app's sources`,
 		RunE: func(cmd *cobra.Command, _ []string) error {
+			if format != "text" && format != "json" {
+				return fmt.Errorf("--format must be text or json (got %q)", format)
+			}
 			cfgPath, err := resolveConfig(cmd)
 			if err != nil {
 				return err
 			}
-			cfg, err := config.Load(cfgPath)
+			defer func() { _ = plan.RemoveInlineDir() }()
+			if format == "json" {
+				return validateJSON(cmd, cfgPath, checkSources, strict, dryBuild, targetNames)
+			}
+			cfg, err := loadConfig(cmd, cfgPath)
 			if err != nil {
 				return fmt.Errorf("config invalid: %w", err)
 			}
+			selected, err := selectTargets(cfg, targetNames, "")
+			if err != nil {
+				return err
+			}
 
 			if trace {
 				base, err := cfg.BaseDir()
@@ -47,6 +71,25 @@ func newValidateCmd() *cobra.Command {
 				}
 			}
 
+			if checkSources {
+				if err := checkTargetSources(cfg, selected); err != nil {
+					return err
+				}
+			}
+			if dryBuild {
+				if issues := dryBuildIssues(cfg, selected); len(issues) > 0 {
+					return fmt.Errorf("dry build failed:\n  - %s", strings.Join(issues, "\n  - "))
+				}
+			}
+
+			refs := cfg.OutputSources()
+			if strict && len(refs) > 0 {
+				return fmt.Errorf("config invalid (--strict):\n  - %s", strings.Join(refs, "\n  - "))
+			}
+			for _, w := range append(append(cfg.Warnings(), mergeWarnings(cfg)...), refs...) {
+				fmt.Fprintf(cmd.ErrOrStderr(), "confb: warning: %s\n", w)
+			}
+
 			fmt.Fprintln(os.Stderr, "confb: validation OK")
 			return nil
 		},
@@ -54,5 +97,166 @@ func newValidateCmd() *cobra.Command {
 
 	cmd.Flags().BoolVar(&trace, "trace", false, "print resolved baseDir and config path")
 	cmd.Flags().BoolVar(&list, "list", false, "list targets after validation")
+	cmd.Flags().StringVar(&format, "format", "text", "output format: text|json (json: a report on stdout; the exit code still reflects errors)")
+	cmd.Flags().BoolVar(&strict, "strict", false, "fail when a source path is another target's output (instead of warning; use target_ref)")
+	cmd.Flags().BoolVar(&checkSources, "check-sources", false, "also resolve every target's sources (they must exist) and reject targets that read their own output")
+	cmd.Flags().BoolVar(&dryBuild, "dry-build", false, "also read, parse and merge every target's sources as build would, without writing")
+	cmd.Flags().StringSliceVar(&targetNames, "targets", nil, "limit --check-sources and --dry-build to these targets (comma-separated or repeated; never read from CONFB_TARGETS, which hooks receive)")
+	_ = cmd.RegisterFlagCompletionFunc("targets", completeTargets)
+	_ = cmd.RegisterFlagCompletionFunc("format", completeValues("text", "json"))
 	return cmd
 }
+
+// validateIssue is one error or warning in `confb validate --format json`.
+type validateIssue struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+type validateReport struct {
+	Valid    bool            `json:"valid"`
+	Errors   []validateIssue `json:"errors"`
+	Warnings []validateIssue `json:"warnings"`
+}
+
+// newValidateIssue splits a "field: message" line as produced by the config
+// loader (e.g. "merge.rules.maps (target web): rules.maps must be …"); lines
+// without a colon use their first word as the field.
+func newValidateIssue(s string) validateIssue {
+	if field, msg, ok := strings.Cut(s, ": "); ok && !strings.Contains(field, "\n") {
+		return validateIssue{Field: field, Message: msg}
+	}
+	field, _, _ := strings.Cut(s, " ")
+	return validateIssue{Field: field, Message: s}
+}
+
+// validateJSON runs validate and prints the outcome as a validateReport on
+// stdout; it returns an error (non-zero exit) when the report has errors.
+func validateJSON(cmd *cobra.Command, cfgPath string, checkSources, strict, dryBuild bool, targetNames []string) error {
+	report := validateReport{Errors: []validateIssue{}, Warnings: []validateIssue{}}
+	cfg, err := loadConfig(cmd, cfgPath)
+	var selected map[string]bool
+	if err == nil {
+		selected, err = selectTargets(cfg, targetNames, "")
+	}
+	var verr *config.ValidationError
+	switch {
+	case errors.As(err, &verr):
+		for _, iss := range verr.Issues {
+			report.Errors = append(report.Errors, newValidateIssue(iss))
+		}
+	case err != nil:
+		report.Errors = append(report.Errors, validateIssue{Field: "config", Message: err.Error()})
+	default:
+		if checkSources {
+			for _, iss := range sourceIssues(cfg, selected) {
+				report.Errors = append(report.Errors, newValidateIssue(iss))
+			}
+		}
+		if dryBuild {
+			for _, iss := range dryBuildIssues(cfg, selected) {
+				report.Errors = append(report.Errors, newValidateIssue(iss))
+			}
+		}
+		for _, w := range append(cfg.Warnings(), mergeWarnings(cfg)...) {
+			report.Warnings = append(report.Warnings, newValidateIssue(w))
+		}
+		for _, r := range cfg.OutputSources() {
+			// the message itself contains "target_ref: ", so do not split it
+			iss := validateIssue{Field: "sources", Message: r}
+			if strict {
+				report.Errors = append(report.Errors, iss)
+			} else {
+				report.Warnings = append(report.Warnings, iss)
+			}
+		}
+	}
+	report.Valid = len(report.Errors) == 0
+
+	b, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(cmd.OutOrStdout(), string(b))
+	if !report.Valid {
+		return fmt.Errorf("config invalid: %d error(s)", len(report.Errors))
+	}
+	return nil
+}
+
+// mergeWarnings flags targets whose merge rules can have no effect because only
+// one source file resolves (usually a missing glob). Targets that fail to plan
+// are skipped; validate does not require sources to exist.
+func mergeWarnings(cfg *config.Config) []string {
+	var out []string
+	for _, t := range cfg.Targets {
+		if t.Merge == nil {
+			continue
+		}
+		rt, err := plan.PlanTarget(cfg, t, "")
+		if err != nil || len(rt.Files) != 1 {
+			continue
+		}
+		out = append(out, fmt.Sprintf("target %q: merge rules set but only one source file resolves (%s); remove merge: or add more sources", t.Name, rt.Files[0]))
+	}
+	return out
+}
+
+// checkTargetSources plans every target (or the selected ones, if selected is
+// non-nil) and reports planning errors and targets whose resolved sources
+// include their own output.
+func checkTargetSources(cfg *config.Config, selected map[string]bool) error {
+	if issues := sourceIssues(cfg, selected); len(issues) > 0 {
+		return fmt.Errorf("source check failed:\n  - %s", strings.Join(issues, "\n  - "))
+	}
+	return nil
+}
+
+// sourceIssues lists the problems checkTargetSources reports, one per line.
+func sourceIssues(cfg *config.Config, selected map[string]bool) []string {
+	var issues []string
+	for _, t := range cfg.Targets {
+		if selected != nil && !selected[t.Name] {
+			continue
+		}
+		rt, err := plan.PlanTarget(cfg, t, "")
+		if err != nil {
+			issues = append(issues, err.Error())
+			continue
+		}
+		if !executor.IsFileOutput(rt.Output) {
+			continue
+		}
+		out, err := filepath.Abs(rt.Output)
+		if err != nil {
+			continue
+		}
+		for _, f := range rt.Files {
+			if f == out {
+				issues = append(issues, fmt.Sprintf("%s: source %s is the target's own output (self-reference)", t.Name, f))
+			}
+		}
+	}
+	return issues
+}
+
+// dryBuildIssues plans and builds every target (or the selected ones) in
+// memory, as --dry-build, and lists the planning, read, parse and merge
+// errors, one per target.
+func dryBuildIssues(cfg *config.Config, selected map[string]bool) []string {
+	var issues []string
+	for _, t := range cfg.Targets {
+		if selected != nil && !selected[t.Name] {
+			continue
+		}
+		rt, err := plan.PlanTarget(cfg, t, "")
+		if err != nil {
+			issues = append(issues, err.Error())
+			continue
+		}
+		if err := daemon.DryBuild(t, rt); err != nil {
+			issues = append(issues, fmt.Sprintf("%s: %v", t.Name, err))
+		}
+	}
+	return issues
+}
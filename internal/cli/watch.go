@@ -0,0 +1,54 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/nekwebdev/confb/internal/config"
+	"github.com/nekwebdev/confb/internal/plan"
+)
+
+// watchUnsupported lists build flags that only make sense for a single run;
+// build --watch rejects them.
+var watchUnsupported = []string{"dry-run", "stage-dir", "stage-validate", "manifest", "cache-dir", "parallel", "export-env", "trace-provenance", "header-only"}
+
+// watchConfig returns the config build --watch hands to the daemon: a copy of
+// cfg holding only the selected targets (all when selected is nil), with
+// outputs taken from effective (overrides and --output-prefix applied) and
+// the per-target format overrides applied. A target_ref to a target that is
+// not selected becomes a path source reading that target's output as it is
+// on disk, so it is watched like any other file.
+func watchConfig(cfg *config.Config, effective []config.Target, selected map[string]bool, formats, jsonCompact map[string]string) (*config.Config, error) {
+	all := *cfg
+	all.Targets = effective
+	out := *cfg
+	out.Targets = nil
+	for _, t := range effective {
+		if selected != nil && !selected[t.Name] {
+			continue
+		}
+		if f, ok := formats[t.Name]; ok {
+			if err := applyFormatOverride(&t, f); err != nil {
+				return nil, err
+			}
+		}
+		if v, ok := jsonCompact[t.Name]; ok {
+			if err := applyJSONCompact(&t, v); err != nil {
+				return nil, err
+			}
+		}
+		sources := make([]config.Source, len(t.Sources))
+		for i, s := range t.Sources {
+			if s.TargetRef != "" && selected != nil && !selected[s.TargetRef] {
+				p, err := plan.RefOutput(&all, s.TargetRef)
+				if err != nil {
+					return nil, fmt.Errorf("target %q: %w", t.Name, err)
+				}
+				s.Path, s.TargetRef = p, ""
+			}
+			sources[i] = s
+		}
+		t.Sources = sources
+		out.Targets = append(out.Targets, t)
+	}
+	return &out, nil
+}
@@ -0,0 +1,97 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Conditions is a target's enabled_if: one condition or a list of them, all
+// of which must hold. Each is "VAR == value", "VAR != value" or just "VAR"
+// (set and non-empty), checked against the process environment.
+type Conditions []string
+
+// UnmarshalYAML accepts a single string as well as a list.
+func (c *Conditions) UnmarshalYAML(n *yaml.Node) error {
+	if n.Kind == yaml.ScalarNode {
+		*c = Conditions{n.Value}
+		return nil
+	}
+	var list []string
+	if err := n.Decode(&list); err != nil {
+		return err
+	}
+	*c = list
+	return nil
+}
+
+// condition is one parsed enabled_if entry.
+type condition struct {
+	name  string
+	op    string // "==", "!=" or "" (non-empty)
+	value string
+}
+
+// parseCondition parses "VAR == value", "VAR != value" or "VAR". The value is
+// the rest of the entry, trimmed, with one pair of surrounding quotes removed.
+func parseCondition(s string) (condition, error) {
+	s = strings.TrimSpace(s)
+	var c condition
+	name, value := s, ""
+	for _, op := range []string{"==", "!="} {
+		if l, r, ok := strings.Cut(s, op); ok {
+			name, value, c.op = strings.TrimSpace(l), strings.TrimSpace(r), op
+			break
+		}
+	}
+	if name == "" {
+		return condition{}, fmt.Errorf("%q: missing variable name", s)
+	}
+	if f := strings.Fields(name); len(f) > 1 {
+		return condition{}, fmt.Errorf("%q: unknown operator %q (want == or !=)", s, f[1])
+	}
+	if !envNameRe.MatchString(name) {
+		return condition{}, fmt.Errorf("%q: %q is not an environment variable name", s, name)
+	}
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		value = value[1 : len(value)-1]
+	}
+	c.name, c.value = name, value
+	return c, nil
+}
+
+// holds evaluates the condition against the environment.
+func (c condition) holds() bool {
+	v := os.Getenv(c.name)
+	switch c.op {
+	case "==":
+		return v == c.value
+	case "!=":
+		return v != c.value
+	default:
+		return v != ""
+	}
+}
+
+// Enabled reports whether every enabled_if condition of t holds (true without
+// any). A condition that does not parse counts as false; validate reports it.
+func (t Target) Enabled() bool {
+	conds := t.enabledIf
+	if conds == nil && len(t.EnabledIf) > 0 {
+		for _, s := range t.EnabledIf {
+			c, err := parseCondition(s)
+			if err != nil {
+				return false
+			}
+			conds = append(conds, c)
+		}
+	}
+	for _, c := range conds {
+		if !c.holds() {
+			return false
+		}
+	}
+	return true
+}
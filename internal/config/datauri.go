@@ -0,0 +1,56 @@
+package config
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// DecodeDataURI parses an RFC 2397 data URI ("data:[<mediatype>][;base64],<data>")
+// and returns its media type (lower-cased, without parameters; "" if omitted)
+// and decoded payload. Payloads without ;base64 are percent-decoded.
+func DecodeDataURI(s string) (mediaType string, data []byte, err error) {
+	rest, ok := strings.CutPrefix(s, "data:")
+	if !ok {
+		return "", nil, fmt.Errorf("data_uri must use the data: scheme")
+	}
+	meta, payload, ok := strings.Cut(rest, ",")
+	if !ok {
+		return "", nil, fmt.Errorf("data_uri is missing the ',' before its payload")
+	}
+	params := strings.Split(meta, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(params[0]))
+	isBase64 := false
+	for _, p := range params[1:] {
+		if strings.EqualFold(strings.TrimSpace(p), "base64") {
+			isBase64 = true
+		}
+	}
+	if isBase64 {
+		data, err = base64.StdEncoding.DecodeString(strings.Join(strings.Fields(payload), ""))
+		if err != nil {
+			return "", nil, fmt.Errorf("data_uri base64 payload: %w", err)
+		}
+		return mediaType, data, nil
+	}
+	dec, err := url.PathUnescape(payload)
+	if err != nil {
+		return "", nil, fmt.Errorf("data_uri payload: %w", err)
+	}
+	return mediaType, []byte(dec), nil
+}
+
+// dataURIFormat maps a data URI media type to the format it implies, or "" if
+// the type says nothing about the format (e.g. text/plain).
+func dataURIFormat(mediaType string) string {
+	switch mediaType {
+	case "application/json", "text/json":
+		return "json"
+	case "application/yaml", "application/x-yaml", "text/yaml", "text/x-yaml":
+		return "yaml"
+	case "application/toml", "text/toml":
+		return "toml"
+	}
+	return ""
+}
@@ -0,0 +1,66 @@
+package config
+
+import (
+	_ "embed"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"strings"
+	"sync"
+)
+
+//go:embed types.go
+var typesSource string
+
+var (
+	docsOnce sync.Once
+	docs     map[string]string
+)
+
+// Docs returns the comments of the config types and their fields, keyed by Go
+// name ("Target", "Target.Output"). A field's doc comment wins over its line
+// comment. Used to describe the schema printed by `confb schema`.
+func Docs() map[string]string {
+	docsOnce.Do(func() {
+		docs = map[string]string{}
+		f, err := parser.ParseFile(token.NewFileSet(), "types.go", typesSource, parser.ParseComments)
+		if err != nil {
+			return
+		}
+		for _, decl := range f.Decls {
+			gd, ok := decl.(*ast.GenDecl)
+			if !ok || gd.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range gd.Specs {
+				ts := spec.(*ast.TypeSpec)
+				if text := commentText(gd.Doc, ts.Doc); text != "" {
+					docs[ts.Name.Name] = text
+				}
+				st, ok := ts.Type.(*ast.StructType)
+				if !ok {
+					continue
+				}
+				for _, field := range st.Fields.List {
+					text := commentText(field.Doc, field.Comment)
+					for _, name := range field.Names {
+						if text != "" {
+							docs[ts.Name.Name+"."+name.Name] = text
+						}
+					}
+				}
+			}
+		}
+	})
+	return docs
+}
+
+// commentText returns the first non-empty comment group, joined into one line.
+func commentText(groups ...*ast.CommentGroup) string {
+	for _, g := range groups {
+		if text := strings.Join(strings.Fields(g.Text()), " "); text != "" {
+			return text
+		}
+	}
+	return ""
+}
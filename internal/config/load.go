@@ -1,17 +1,49 @@
 package config
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"io/fs"
+	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
+	"slices"
 	"strings"
 
+	"github.com/pelletier/go-toml/v2"
 	"gopkg.in/yaml.v3"
+
+	"github.com/nekwebdev/confb/internal/source"
+)
+
+// MaxOnChangeTimeoutS caps on_change_timeout_s (one hour).
+const MaxOnChangeTimeoutS = 3600
+
+// DefaultMaxMergeDepth is merge.rules.max_depth when unset; MaxMergeDepth caps it.
+const (
+	DefaultMaxMergeDepth = 100
+	MaxMergeDepth        = 10000
 )
 
+// StdinPath is the config path meaning "read confb.yaml from standard input".
+const StdinPath = "-"
+
 // Load reads confb.yaml from disk, sets baseDir, normalizes, validates.
-func Load(path string) (*Config, error) {
+// A .toml or .json path (including includes and extra files) is decoded as
+// that format, with the same field names (see unmarshal).
+// Path "-" reads it from stdin instead (see LoadReader). Each extra file is
+// merged on top of it in order (see mergeExtra).
+func Load(path string, extra ...string) (*Config, error) {
+	if path == StdinPath {
+		wd, err := os.Getwd()
+		if err != nil {
+			return nil, err
+		}
+		return LoadReader(os.Stdin, wd, extra...)
+	}
 	abs, err := filepath.Abs(path)
 	if err != nil {
 		return nil, err
@@ -21,14 +53,42 @@ func Load(path string) (*Config, error) {
 	if err != nil {
 		return nil, err
 	}
+	return parse(data, abs, extra)
+}
+
+// LoadReader is Load for a config that is not a file (e.g. stdin): relative
+// paths in it (sources, includes) resolve against baseDir.
+func LoadReader(r io.Reader, baseDir string, extra ...string) (*Config, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("read config: %w", err)
+	}
+	abs, err := filepath.Abs(baseDir)
+	if err != nil {
+		return nil, err
+	}
+	return parse(data, filepath.Join(abs, StdinPath), extra)
+}
 
+// parse decodes, normalizes and validates the config read from abs, with the
+// extra config files merged on top.
+func parse(data []byte, abs string, extra []string) (*Config, error) {
 	var cfg Config
-	if err := yaml.Unmarshal(data, &cfg); err != nil {
+	if err := unmarshal(data, abs, &cfg); err != nil {
 		return nil, err
 	}
 
 	cfg.baseDir = filepath.Dir(abs)
 
+	if err := loadIncludes(&cfg, abs, cfg.Include, map[string]bool{abs: true}); err != nil {
+		return nil, err
+	}
+	for _, p := range extra {
+		if err := mergeExtra(&cfg, abs, p); err != nil {
+			return nil, err
+		}
+	}
+
 	normalize(&cfg)
 
 	if verr := validate(&cfg); !verr.ok() {
@@ -37,9 +97,152 @@ func Load(path string) (*Config, error) {
 	return &cfg, nil
 }
 
+// unmarshal decodes a config file by its extension: .toml and .json are read
+// into plain values and re-encoded as YAML, so the yaml field names (and the
+// YAML decoding rules) apply to every format. Anything else is YAML.
+func unmarshal(data []byte, path string, cfg *Config) error {
+	var v any
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".toml":
+		if err := toml.Unmarshal(data, &v); err != nil {
+			return err
+		}
+	case ".json":
+		if err := json.Unmarshal(data, &v); err != nil {
+			return err
+		}
+	default:
+		return yaml.Unmarshal(data, cfg)
+	}
+	b, err := yaml.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return yaml.Unmarshal(b, cfg)
+}
+
+// loadIncludes appends the targets of every file matched by includes (paths and
+// globs relative to the including file from) to cfg, recursing into their own
+// include lists. stack holds the files currently being loaded, so an include
+// that leads back to one of them is reported as a cycle.
+func loadIncludes(cfg *Config, from string, includes []string, stack map[string]bool) error {
+	dir := filepath.Dir(from)
+	for _, inc := range includes {
+		p := expandTilde(os.ExpandEnv(inc))
+		if !filepath.IsAbs(p) {
+			p = filepath.Join(dir, p)
+		}
+		matches, err := filepath.Glob(p)
+		if err != nil {
+			return fmt.Errorf("%s: include %q: %w", from, inc, err)
+		}
+		if len(matches) == 0 && !strings.ContainsAny(p, "*?[") {
+			return fmt.Errorf("%s: include %q: %w", from, inc, fs.ErrNotExist)
+		}
+		for _, m := range matches {
+			if stack[m] {
+				return fmt.Errorf("%s: include cycle: %q includes %q again", from, inc, m)
+			}
+			data, err := os.ReadFile(m)
+			if err != nil {
+				return fmt.Errorf("%s: include %q: %w", from, inc, err)
+			}
+			var part Config
+			if err := unmarshal(data, m, &part); err != nil {
+				return fmt.Errorf("%s: %w", m, err)
+			}
+			if part.Defaults != nil || part.Webhook != nil || part.PostBuild != "" || part.OnChange != "" {
+				return fmt.Errorf("%s: included files may only declare targets and include", m)
+			}
+			cfg.Targets = append(cfg.Targets, part.Targets...)
+			stack[m] = true
+			err = loadIncludes(cfg, m, part.Include, stack)
+			delete(stack, m)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// mergeExtra merges the config file path (and its includes) into cfg, loaded
+// from first: targets are appended (a name already in use is reported by
+// validate), defaults fields, webhook and the other top-level settings it
+// sets replace cfg's, and its version must match. As with includes, relative
+// source paths still resolve against the first config's directory.
+func mergeExtra(cfg *Config, first, path string) error {
+	abs, err := filepath.Abs(expandTilde(path))
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(abs)
+	if err != nil {
+		return err
+	}
+	var part Config
+	if err := unmarshal(data, abs, &part); err != nil {
+		return fmt.Errorf("%s: %w", abs, err)
+	}
+	if part.Version != cfg.Version {
+		return fmt.Errorf("%s: version %d does not match %s (version %d)", abs, part.Version, first, cfg.Version)
+	}
+	if err := loadIncludes(&part, abs, part.Include, map[string]bool{abs: true}); err != nil {
+		return err
+	}
+	cfg.Targets = append(cfg.Targets, part.Targets...)
+
+	if d := part.Defaults; d != nil {
+		if cfg.Defaults == nil {
+			cfg.Defaults = &Defaults{}
+		}
+		if d.Dedupe != "" {
+			cfg.Defaults.Dedupe = d.Dedupe
+		}
+		if d.Merge != nil {
+			cfg.Defaults.Merge = d.Merge
+		}
+	}
+	if part.Webhook != nil {
+		cfg.Webhook = part.Webhook
+	}
+	if part.PostBuild != "" {
+		cfg.PostBuild = part.PostBuild
+	}
+	if part.PostBuildTimeoutS != 0 {
+		cfg.PostBuildTimeoutS = part.PostBuildTimeoutS
+	}
+	if part.OnChange != "" {
+		cfg.OnChange = part.OnChange
+	}
+	if part.DefaultOnChangeTimeoutS != 0 {
+		cfg.DefaultOnChangeTimeoutS = part.DefaultOnChangeTimeoutS
+	}
+	if part.StrictExtensions {
+		cfg.StrictExtensions = true
+	}
+	return nil
+}
+
 // normalize applies simple defaults and expands ~ in output paths.
 // Keep it minimal; format-aware behavior happens later.
 func normalize(cfg *Config) {
+	var defRules *MergeRules
+	defDedupe := ""
+	if d := cfg.Defaults; d != nil {
+		defDedupe = d.Dedupe
+		if d.Merge != nil {
+			defRules = d.Merge.Rules
+		}
+	}
+
+	if w := cfg.Webhook; w != nil {
+		w.Method = strings.ToUpper(strings.TrimSpace(w.Method))
+		if w.Method == "" {
+			w.Method = "POST"
+		}
+	}
+
 	for i := range cfg.Targets {
 		t := &cfg.Targets[i]
 
@@ -47,6 +250,9 @@ func normalize(cfg *Config) {
 		if t.Format == "" {
 			t.Format = "auto"
 		}
+		if t.Dedupe == "" {
+			t.Dedupe = defDedupe
+		}
 		if t.Dedupe == "" {
 			t.Dedupe = "by_path"
 		}
@@ -56,14 +262,40 @@ func normalize(cfg *Config) {
 		if t.Encoding == "" {
 			t.Encoding = "utf8"
 		}
+		if t.OnChangeTimeoutS == 0 {
+			t.OnChangeTimeoutS = cfg.DefaultOnChangeTimeoutS
+		}
+		if t.MtimeSource == "" {
+			t.MtimeSource = "now"
+		}
+		if len(t.PostProcess) == 0 {
+			t.PostProcess = []string{"ensure_trailing_newline"}
+		}
+		// parse enabled_if once; validate reports the entries that don't parse
+		t.enabledIf = nil
+		for _, s := range t.EnabledIf {
+			c, err := parseCondition(s)
+			if err != nil {
+				t.enabledIf = nil
+				break
+			}
+			t.enabledIf = append(t.enabledIf, c)
+		}
 		// expand ~ in output
 		t.Output = expandTilde(t.Output)
+		t.WorkDir = expandTilde(os.ExpandEnv(t.WorkDir))
 
 		// default sort per source
 		for j := range t.Sources {
 			if t.Sources[j].Sort == "" {
 				t.Sources[j].Sort = "lex"
 			}
+			if t.Sources[j].Encoding == "" {
+				t.Sources[j].Encoding = "utf8"
+			}
+			if t.Sources[j].DirGlob == "" {
+				t.Sources[j].DirGlob = "*"
+			}
 		}
 
 		// Merge: only apply format defaults if user provided a merge block.
@@ -71,6 +303,9 @@ func normalize(cfg *Config) {
 			if t.Merge.Rules == nil {
 				t.Merge.Rules = &MergeRules{}
 			}
+			if defRules != nil {
+				inheritRules(t.Merge.Rules, defRules, strings.ToLower(t.Format))
+			}
 			switch strings.ToLower(t.Format) {
 			case "yaml", "toml", "json":
 				if t.Merge.Rules.Maps == "" {
@@ -91,6 +326,9 @@ func normalize(cfg *Config) {
 				if t.Merge.Rules.INIRepeatedKeys == "" {
 					t.Merge.Rules.INIRepeatedKeys = "last_wins"
 				}
+				if t.Merge.Rules.INISectionOrder == "" {
+					t.Merge.Rules.INISectionOrder = "first_seen"
+				}
 			case "raw", "auto":
 				// no defaults; validation will reject merge under raw/auto
 			}
@@ -98,6 +336,122 @@ func normalize(cfg *Config) {
 	}
 }
 
+// inheritRules fills unset fields of r from the defaults d, copying only the
+// fields that apply to format (so defaults can mix yaml and kdl settings).
+func inheritRules(r, d *MergeRules, format string) {
+	switch format {
+	case "yaml", "toml", "json":
+		if r.Maps == "" {
+			r.Maps = d.Maps
+		}
+		if r.Arrays == "" {
+			r.Arrays = d.Arrays
+		}
+		if format == "json" && r.JSONIndent == nil && d.JSONIndent != nil {
+			v := *d.JSONIndent
+			r.JSONIndent = &v
+		}
+		if format == "json" && !r.JSONPreserveOrder {
+			r.JSONPreserveOrder = d.JSONPreserveOrder
+		}
+		if format == "toml" && !r.TOMLPreserveInline {
+			r.TOMLPreserveInline = d.TOMLPreserveInline
+		}
+		if format == "toml" && r.TOMLOutputStyle == "" {
+			r.TOMLOutputStyle = d.TOMLOutputStyle
+		}
+		if format == "yaml" && r.YAMLStyle == "" {
+			r.YAMLStyle = d.YAMLStyle
+		}
+		if format == "yaml" && r.YAMLScalarStyle == "" {
+			r.YAMLScalarStyle = d.YAMLScalarStyle
+		}
+		if r.NullStrategy == "" {
+			r.NullStrategy = d.NullStrategy
+		}
+		if r.MaxDepth == 0 {
+			r.MaxDepth = d.MaxDepth
+		}
+	case "kdl":
+		if r.KDLKeys == "" {
+			r.KDLKeys = d.KDLKeys
+		}
+		if len(r.KDLSectionKeys) == 0 {
+			r.KDLSectionKeys = append([]string(nil), d.KDLSectionKeys...)
+		}
+		if r.KDLMatchProperty == "" {
+			r.KDLMatchProperty = d.KDLMatchProperty
+		}
+		if r.KDLOutputOrder == "" {
+			r.KDLOutputOrder = d.KDLOutputOrder
+		}
+		if !r.KDLSortByHead {
+			r.KDLSortByHead = d.KDLSortByHead
+		}
+		if !r.KDLStrictKeys {
+			r.KDLStrictKeys = d.KDLStrictKeys
+		}
+	case "ini":
+		if r.INIRepeatedKeys == "" {
+			r.INIRepeatedKeys = d.INIRepeatedKeys
+		}
+		if r.INISectionOrder == "" {
+			r.INISectionOrder = d.INISectionOrder
+		}
+		if r.INIDefaultSection == "" {
+			r.INIDefaultSection = d.INIDefaultSection
+		}
+		if r.INIGlobalSection == "" {
+			r.INIGlobalSection = d.INIGlobalSection
+		}
+	}
+}
+
+// validateDefaults checks the defaults block with the same enums as targets;
+// fields may come from several formats since each target picks its own.
+func validateDefaults(d *Defaults, verr *ValidationError) {
+	if d.Dedupe != "" && !inSet(strings.ToLower(d.Dedupe), "by_path", "none") {
+		verr.add("defaults.dedupe must be by_path|none (got %q)", d.Dedupe)
+	}
+	if d.Merge == nil || d.Merge.Rules == nil {
+		return
+	}
+	r := d.Merge.Rules
+	check := func(field, v string, options ...string) {
+		if v != "" && !inSet(strings.ToLower(v), options...) {
+			verr.add("defaults.merge.rules.%s must be %s (got %q)", field, strings.Join(options, "|"), v)
+		}
+	}
+	check("maps", r.Maps, "deep", "replace")
+	check("arrays", r.Arrays, "replace", "append", "unique_append", "intersect")
+	check("yaml_style", r.YAMLStyle, "block", "flow")
+	check("yaml_scalar_style", r.YAMLScalarStyle, "any", "double_quoted", "single_quoted", "literal")
+	check("toml_output_style", r.TOMLOutputStyle, "standard", "compact")
+	check("null_strategy", r.NullStrategy, "replace", "delete", "ignore")
+	check("keys", r.KDLKeys, "last_wins", "first_wins", "append")
+	check("output_order", r.KDLOutputOrder, "lex", "encounter")
+	check("repeated_keys", r.INIRepeatedKeys, "last_wins", "append")
+	check("section_order", r.INISectionOrder, "first_seen", "lex", "source_priority")
+	for _, sk := range r.KDLSectionKeys {
+		if strings.TrimSpace(sk) == "" {
+			verr.add("defaults.merge.rules.section_keys must not contain empty strings")
+			break
+		}
+	}
+}
+
+// ValidWebhookURL reports whether u is an absolute http:// or https:// URL.
+func ValidWebhookURL(u string) error {
+	p, err := url.Parse(u)
+	if err != nil {
+		return err
+	}
+	if (p.Scheme != "http" && p.Scheme != "https") || p.Host == "" {
+		return fmt.Errorf("must be an http:// or https:// URL (got %q)", u)
+	}
+	return nil
+}
+
 // validate checks semantic rules and accumulates all issues before failing.
 func validate(cfg *Config) *ValidationError {
 	verr := &ValidationError{}
@@ -108,8 +462,26 @@ func validate(cfg *Config) *ValidationError {
 	if len(cfg.Targets) == 0 {
 		verr.add("targets must not be empty")
 	}
+	if cfg.Defaults != nil {
+		validateDefaults(cfg.Defaults, verr)
+	}
+	if w := cfg.Webhook; w != nil {
+		if err := ValidWebhookURL(w.URL); err != nil {
+			verr.add("webhook.url: %v", err)
+		}
+		if !inSet(w.Method, "POST", "PUT", "PATCH") {
+			verr.add("webhook.method must be POST|PUT|PATCH (got %q)", w.Method)
+		}
+	}
+	if cfg.PostBuildTimeoutS < 0 {
+		verr.add("post_build_timeout_s must be >= 0 (got %d)", cfg.PostBuildTimeoutS)
+	}
+	if n := cfg.DefaultOnChangeTimeoutS; n < 0 || n > MaxOnChangeTimeoutS {
+		verr.add("default_on_change_timeout_s must be 0..%d (got %d)", MaxOnChangeTimeoutS, n)
+	}
 
 	seenNames := map[string]struct{}{}
+	var stdoutTargets []string
 	for idx, t := range cfg.Targets {
 		loc := func(field string) string { return field + " (target " + t.Name + ")" }
 
@@ -124,7 +496,7 @@ func validate(cfg *Config) *ValidationError {
 		}
 
 		// format enum
-		if !inSet(strings.ToLower(t.Format), "auto", "yaml", "toml", "ini", "json", "raw", "kdl") {
+		if !ValidFormat(t.Format) {
 			verr.add("%s: format must be one of auto|yaml|toml|ini|json|raw|kdl (got %q)", loc("format"), t.Format)
 		}
 
@@ -132,6 +504,23 @@ func validate(cfg *Config) *ValidationError {
 		if strings.TrimSpace(t.Output) == "" {
 			verr.add("%s: output is required", loc("output"))
 		}
+		if t.Output == "-" {
+			stdoutTargets = append(stdoutTargets, t.Name)
+		}
+		for _, p := range t.PostProcess {
+			if !inSet(p, "ensure_trailing_newline", "strip_trailing_newline", "no_header", "trim_blank_lines", "unix_eol", "windows_eol") {
+				verr.add("%s: post_process %q must be one of ensure_trailing_newline|strip_trailing_newline|no_header|trim_blank_lines|unix_eol|windows_eol", loc("post_process"), p)
+			}
+		}
+		// output env:NAME sets a variable for hooks instead of writing a file
+		if name, ok := strings.CutPrefix(t.Output, "env:"); ok {
+			if !envNameRe.MatchString(name) {
+				verr.add("%s: output env:%s is not a valid environment variable name", loc("output"), name)
+			}
+			if !strings.EqualFold(t.Format, "raw") {
+				verr.add("%s: output env:%s requires format raw (got %q)", loc("output"), name, t.Format)
+			}
+		}
 
 		// dedupe enum
 		if !inSet(strings.ToLower(t.Dedupe), "by_path", "none") {
@@ -147,16 +536,85 @@ func validate(cfg *Config) *ValidationError {
 			verr.add("%s: encoding must be utf8 in MVP (got %q)", loc("encoding"), t.Encoding)
 		}
 
+		if t.OnChangeAsyncTimeoutS < 0 {
+			verr.add("%s: on_change_async_timeout_s must be >= 0 (got %d)", loc("on_change_async_timeout_s"), t.OnChangeAsyncTimeoutS)
+		}
+		if n := t.OnChangeTimeoutS; n < 0 || n > MaxOnChangeTimeoutS {
+			verr.add("%s: on_change_timeout_s must be 0..%d (0 = default; got %d)", loc("on_change_timeout_s"), MaxOnChangeTimeoutS, n)
+		}
+		if t.DebounceMS < 0 {
+			verr.add("%s: debounce_ms must be >= 0 (got %d)", loc("debounce_ms"), t.DebounceMS)
+		}
+		if !inSet(strings.ToLower(t.MtimeSource), "now", "newest_source", "zero") {
+			verr.add("%s: mtime_source must be now|newest_source|zero (got %q)", loc("mtime_source"), t.MtimeSource)
+		}
+		for j, s := range t.EnabledIf {
+			if _, err := parseCondition(s); err != nil {
+				verr.add("%s: %v", loc(fmt.Sprintf("enabled_if[%d]", j)), err)
+			}
+		}
+
 		// sources
 		if len(t.Sources) == 0 {
 			verr.add("%s: sources must not be empty", loc("sources"))
 		}
 		for j, s := range t.Sources {
-			if strings.TrimSpace(s.Path) == "" {
-				verr.add("%s: sources[%d].path is required", loc("sources"), j)
+			if s.TargetRef != "" {
+				if strings.TrimSpace(s.Path) != "" {
+					verr.add("%s: sources[%d] path and target_ref are mutually exclusive", loc("sources"), j)
+				}
+				if s.TargetRef == t.Name {
+					verr.add("%s: sources[%d].target_ref must not reference its own target", loc("sources"), j)
+				} else if ref, ok := cfg.TargetByName(s.TargetRef); !ok {
+					verr.add("%s: sources[%d].target_ref %q does not name a target", loc("sources"), j, s.TargetRef)
+				} else if ref.Output == "-" {
+					verr.add("%s: sources[%d].target_ref %q writes to stdout and cannot be used as a source", loc("sources"), j, s.TargetRef)
+				} else if strings.HasPrefix(ref.Output, "env:") {
+					verr.add("%s: sources[%d].target_ref %q writes to %s and cannot be used as a source", loc("sources"), j, s.TargetRef, ref.Output)
+				}
+				if s.Inline != "" {
+					verr.add("%s: sources[%d] inline and target_ref are mutually exclusive", loc("sources"), j)
+				}
+			} else if s.Inline != "" {
+				if strings.TrimSpace(s.Path) != "" {
+					verr.add("%s: sources[%d] path and inline are mutually exclusive", loc("sources"), j)
+				}
+			} else if s.DataURI != "" {
+				if strings.TrimSpace(s.Path) != "" {
+					verr.add("%s: sources[%d] path and data_uri are mutually exclusive", loc("sources"), j)
+				}
+			} else if strings.TrimSpace(s.Path) == "" {
+				verr.add("%s: sources[%d] requires path, inline or data_uri", loc("sources"), j)
 			}
-			if !inSet(strings.ToLower(s.Sort), "lex", "none") {
-				verr.add("%s: sources[%d].sort must be lex|none (got %q)", loc("sources"), j, s.Sort)
+			if s.DataURI != "" {
+				if s.Inline != "" || s.TargetRef != "" {
+					verr.add("%s: sources[%d] data_uri is mutually exclusive with inline and target_ref", loc("sources"), j)
+				}
+				if mt, _, err := DecodeDataURI(s.DataURI); err != nil {
+					verr.add("%s: sources[%d] %v", loc("sources"), j, err)
+				} else if f := dataURIFormat(mt); f != "" && !strings.EqualFold(f, t.Format) {
+					verr.add("%s: sources[%d].data_uri media type %q implies %s, but the target format is %s", loc("sources"), j, mt, f, t.Format)
+				}
+			}
+			if !inSet(strings.ToLower(s.Sort), "lex", "none", "numeric", "mtime_asc", "mtime_desc") {
+				verr.add("%s: sources[%d].sort must be lex|none|numeric|mtime_asc|mtime_desc (got %q)", loc("sources"), j, s.Sort)
+			}
+			if !inSet(strings.ToLower(s.Encoding), "utf8", "utf-8", "latin1", "iso-8859-1", "iso8859-1") {
+				verr.add("%s: sources[%d].encoding must be utf8|latin1 (got %q)", loc("sources"), j, s.Encoding)
+			}
+			for _, tr := range s.Transforms {
+				if !source.Default.Has(tr) {
+					verr.add("%s: sources[%d].transforms: unknown transform %q (known: %s)", loc("sources"), j, tr, strings.Join(source.Default.Names(), ", "))
+				}
+			}
+			if _, err := filepath.Match(s.DirGlob, ""); err != nil {
+				verr.add("%s: sources[%d].dir_glob %q is not a valid pattern", loc("sources"), j, s.DirGlob)
+			}
+			if s.TargetRef == "" && s.Inline == "" && s.DataURI == "" && mayMatchOutput(cfg.baseDir, cfg.SourceDir(t), s, t.Output) {
+				verr.add("%s: sources[%d] %q may match the target's own output %q (potential self-reference)", loc("sources"), j, s.Path, t.Output)
+			}
+			if s.MergePatch && (t.Merge == nil || !inSet(strings.ToLower(t.Format), "yaml", "json", "toml")) {
+				verr.add("%s: sources[%d].merge_patch requires a merged yaml|json|toml target", loc("sources"), j)
 			}
 		}
 
@@ -183,11 +641,47 @@ func validate(cfg *Config) *ValidationError {
 				if !inSet(strings.ToLower(r.Maps), "deep", "replace") {
 					verr.add("%s: rules.maps must be deep|replace (got %q)", loc("merge.rules.maps"), r.Maps)
 				}
-				if !inSet(strings.ToLower(r.Arrays), "replace", "append", "unique_append") {
-					verr.add("%s: rules.arrays must be replace|append|unique_append (got %q)", loc("merge.rules.arrays"), r.Arrays)
+				if !inSet(strings.ToLower(r.Arrays), "replace", "append", "unique_append", "intersect") {
+					verr.add("%s: rules.arrays must be replace|append|unique_append|intersect (got %q)", loc("merge.rules.arrays"), r.Arrays)
+				}
+				if r.YAMLStyle != "" {
+					if f != "yaml" {
+						verr.add("%s: rules.yaml_style only applies to yaml (got format %q)", loc("merge.rules.yaml_style"), f)
+					} else if !inSet(strings.ToLower(r.YAMLStyle), "block", "flow") {
+						verr.add("%s: rules.yaml_style must be block|flow (got %q)", loc("merge.rules.yaml_style"), r.YAMLStyle)
+					}
+				}
+				if r.YAMLScalarStyle != "" {
+					if f != "yaml" {
+						verr.add("%s: rules.yaml_scalar_style only applies to yaml (got format %q)", loc("merge.rules.yaml_scalar_style"), f)
+					} else if !inSet(strings.ToLower(r.YAMLScalarStyle), "any", "double_quoted", "single_quoted", "literal") {
+						verr.add("%s: rules.yaml_scalar_style must be any|double_quoted|single_quoted|literal (got %q)", loc("merge.rules.yaml_scalar_style"), r.YAMLScalarStyle)
+					}
+				}
+				if r.TOMLOutputStyle != "" {
+					if f != "toml" {
+						verr.add("%s: rules.toml_output_style only applies to toml (got format %q)", loc("merge.rules.toml_output_style"), f)
+					} else if !inSet(strings.ToLower(r.TOMLOutputStyle), "standard", "compact") {
+						verr.add("%s: rules.toml_output_style must be standard|compact (got %q)", loc("merge.rules.toml_output_style"), r.TOMLOutputStyle)
+					}
+				}
+				if r.TOMLPreserveInline && f != "toml" {
+					verr.add("%s: rules.toml_preserve_inline only applies to toml (got format %q)", loc("merge.rules.toml_preserve_inline"), f)
+				}
+				if r.JSONPreserveOrder && f != "json" {
+					verr.add("%s: rules.json_preserve_order only applies to json (got format %q)", loc("merge.rules.json_preserve_order"), f)
+				}
+				if r.JSONIndent != nil && f != "json" {
+					verr.add("%s: rules.json_indent only applies to json (got format %q)", loc("merge.rules.json_indent"), f)
+				}
+				if r.NullStrategy != "" && !inSet(strings.ToLower(r.NullStrategy), "replace", "delete", "ignore") {
+					verr.add("%s: rules.null_strategy must be replace|delete|ignore (got %q)", loc("merge.rules.null_strategy"), r.NullStrategy)
+				}
+				if r.MaxDepth != 0 && (r.MaxDepth < 1 || r.MaxDepth > MaxMergeDepth) {
+					verr.add("%s: rules.max_depth must be 1..%d (got %d)", loc("merge.rules.max_depth"), MaxMergeDepth, r.MaxDepth)
 				}
 				// forbid foreign fields
-				if r.KDLKeys != "" || len(r.KDLSectionKeys) > 0 || r.INIRepeatedKeys != "" {
+				if r.KDLKeys != "" || len(r.KDLSectionKeys) > 0 || r.KDLMatchProperty != "" || r.KDLOutputOrder != "" || r.KDLSortByHead || r.KDLStrictKeys || r.INIRepeatedKeys != "" || r.INISectionOrder != "" || r.INIDefaultSection != "" || r.INIGlobalSection != "" {
 					verr.add("%s: rules contains fields not applicable to %s (kdl/ini fields must be omitted)", loc("merge.rules"), f)
 				}
 
@@ -198,6 +692,9 @@ func validate(cfg *Config) *ValidationError {
 				if !inSet(strings.ToLower(r.KDLKeys), "last_wins", "first_wins", "append") {
 					verr.add("%s: rules.keys must be last_wins|first_wins|append (got %q)", loc("merge.rules.keys"), r.KDLKeys)
 				}
+				if r.KDLOutputOrder != "" && !inSet(strings.ToLower(r.KDLOutputOrder), "lex", "encounter") {
+					verr.add("%s: rules.output_order must be lex|encounter (got %q)", loc("merge.rules.output_order"), r.KDLOutputOrder)
+				}
 				// validate section_keys content (no empty/whitespace entries)
 				for _, sk := range r.KDLSectionKeys {
 					if strings.TrimSpace(sk) == "" {
@@ -206,7 +703,7 @@ func validate(cfg *Config) *ValidationError {
 					}
 				}
 				// forbid foreign fields
-				if r.Maps != "" || r.Arrays != "" || r.INIRepeatedKeys != "" {
+				if r.Maps != "" || r.Arrays != "" || r.JSONIndent != nil || r.TOMLPreserveInline || r.TOMLOutputStyle != "" || r.JSONPreserveOrder || r.YAMLStyle != "" || r.YAMLScalarStyle != "" || r.NullStrategy != "" || r.MaxDepth != 0 || r.INIRepeatedKeys != "" || r.INISectionOrder != "" || r.INIDefaultSection != "" || r.INIGlobalSection != "" {
 					verr.add("%s: rules contains fields not applicable to kdl (maps/arrays/ini fields must be omitted)", loc("merge.rules"))
 				}
 
@@ -217,14 +714,49 @@ func validate(cfg *Config) *ValidationError {
 				if !inSet(strings.ToLower(r.INIRepeatedKeys), "last_wins", "append") {
 					verr.add("%s: rules.repeated_keys must be last_wins|append (got %q)", loc("merge.rules.repeated_keys"), r.INIRepeatedKeys)
 				}
+				if r.INISectionOrder == "" {
+					r.INISectionOrder = "first_seen"
+				}
+				if !inSet(strings.ToLower(r.INISectionOrder), "first_seen", "lex", "source_priority") {
+					verr.add("%s: rules.section_order must be first_seen|lex|source_priority (got %q)", loc("merge.rules.section_order"), r.INISectionOrder)
+				}
+				if r.INIDefaultSection != "" && strings.TrimSpace(r.INIDefaultSection) != r.INIDefaultSection {
+					verr.add("%s: rules.default_section must not have surrounding whitespace (got %q)", loc("merge.rules.default_section"), r.INIDefaultSection)
+				}
+				if r.INIGlobalSection != "" && strings.TrimSpace(r.INIGlobalSection) != r.INIGlobalSection {
+					verr.add("%s: rules.global_section must not have surrounding whitespace (got %q)", loc("merge.rules.global_section"), r.INIGlobalSection)
+				}
+				if r.INIGlobalSection != "" && r.INIGlobalSection == r.INIDefaultSection {
+					verr.add("%s: rules.global_section and rules.default_section must differ (both %q)", loc("merge.rules.global_section"), r.INIGlobalSection)
+				}
 				// forbid foreign fields
-				if r.Maps != "" || r.Arrays != "" || r.KDLKeys != "" || len(r.KDLSectionKeys) > 0 {
+				if r.Maps != "" || r.Arrays != "" || r.JSONIndent != nil || r.TOMLPreserveInline || r.TOMLOutputStyle != "" || r.JSONPreserveOrder || r.YAMLStyle != "" || r.YAMLScalarStyle != "" || r.NullStrategy != "" || r.MaxDepth != 0 || r.KDLKeys != "" || len(r.KDLSectionKeys) > 0 || r.KDLMatchProperty != "" || r.KDLOutputOrder != "" || r.KDLSortByHead || r.KDLStrictKeys {
 					verr.add("%s: rules contains fields not applicable to ini (yaml/toml/kdl fields must be omitted)", loc("merge.rules"))
 				}
 			}
 		}
 	}
 
+	// two targets must not write the same file
+	for _, c := range OutputConflicts(cfg.Targets) {
+		verr.add("%s", c)
+	}
+
+	// output "-" (stdout) can only be used once
+	if len(stdoutTargets) > 1 {
+		verr.add("only one target may write to stdout (output \"-\"); got %s", strings.Join(stdoutTargets, ", "))
+	}
+
+	// target_ref graph must be acyclic (self references are reported above)
+	if cycle := cfg.walkRefs(func(Target) {}); cycle != nil {
+		if cycle[0] != cycle[1] {
+			verr.add("target_ref cycle: %s", strings.Join(cycle, " -> "))
+		}
+	} else if cycle := cfg.triggerCycle(); cycle != nil {
+		// on_change hooks that rebuild targets feeding back into themselves
+		verr.add("on_change cycle: target %s", strings.Join(cycle, " -> target "))
+	}
+
 	return verr
 }
 
@@ -243,6 +775,76 @@ func expandTilde(p string) string {
 	return p
 }
 
+// OutputConflicts reports output paths shared by more than one target, one
+// message per path. Paths are compared as they are written: ~ expanded and
+// cleaned ($VARS are not expanded in outputs); stdout ("-") is checked separately.
+func OutputConflicts(targets []Target) []string {
+	byPath := map[string][]string{}
+	var order []string
+	for _, t := range targets {
+		if strings.TrimSpace(t.Output) == "" || t.Output == "-" {
+			continue
+		}
+		p := filepath.Clean(expandTilde(t.Output))
+		if _, ok := byPath[p]; !ok {
+			order = append(order, p)
+		}
+		byPath[p] = append(byPath[p], t.Name)
+	}
+	var out []string
+	for _, p := range order {
+		if names := byPath[p]; len(names) > 1 {
+			out = append(out, fmt.Sprintf("output %q is written by multiple targets: %s", p, strings.Join(names, ", ")))
+		}
+	}
+	return out
+}
+
+// mayMatchOutput approximates whether source s can resolve to output, without
+// touching the filesystem: the source pattern matches the output path, or the
+// source names the output's directory and its dir_glob matches the file name.
+// Relative sources are taken from srcDir (see SourceDir); a relative output is
+// tried against both the config directory and the working directory.
+// PlanTarget gives the definitive answer.
+func mayMatchOutput(baseDir, srcDir string, s Source, output string) bool {
+	if output == "" || output == "-" {
+		return false
+	}
+	src := expandTilde(os.ExpandEnv(s.Path))
+	if !filepath.IsAbs(src) {
+		src = filepath.Join(srcDir, src)
+	}
+	src = filepath.Clean(src)
+
+	out := expandTilde(os.ExpandEnv(output))
+	candidates := []string{out}
+	if !filepath.IsAbs(out) {
+		candidates = []string{filepath.Join(baseDir, out)}
+		if abs, err := filepath.Abs(out); err == nil {
+			candidates = append(candidates, abs)
+		}
+	}
+	for _, o := range candidates {
+		o = filepath.Clean(o)
+		if ok, _ := filepath.Match(src, o); ok {
+			return true
+		}
+		if src == filepath.Dir(o) {
+			if ok, _ := filepath.Match(s.DirGlob, filepath.Base(o)); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+var envNameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// ValidFormat reports whether f is one of the target formats accepted by the loader.
+func ValidFormat(f string) bool {
+	return inSet(strings.ToLower(f), "auto", "yaml", "toml", "ini", "json", "raw", "kdl")
+}
+
 // helper: simple membership test
 func inSet(v string, options ...string) bool {
 	for _, o := range options {
@@ -282,3 +884,95 @@ func (c *Config) BaseDir() (string, error) {
 	}
 	return c.baseDir, nil
 }
+
+// SourceDir is the directory t's relative source paths resolve against: its
+// work_dir (relative to the config directory unless absolute) or, when unset,
+// the config directory itself.
+func (c *Config) SourceDir(t Target) string {
+	if t.WorkDir == "" {
+		return c.baseDir
+	}
+	if filepath.IsAbs(t.WorkDir) {
+		return filepath.Clean(t.WorkDir)
+	}
+	return filepath.Join(c.baseDir, t.WorkDir)
+}
+
+// Warnings lists questionable but valid settings (Load does not reject them):
+// currently absolute work_dirs outside both the home and config directories.
+func (c *Config) Warnings() []string {
+	var out []string
+	home, _ := os.UserHomeDir()
+	for _, t := range c.Targets {
+		if !filepath.IsAbs(t.WorkDir) {
+			continue
+		}
+		dir := filepath.Clean(t.WorkDir)
+		if within(dir, c.baseDir) || (home != "" && within(dir, home)) {
+			continue
+		}
+		out = append(out, fmt.Sprintf("target %q: work_dir %s is outside the home and config directories", t.Name, dir))
+	}
+	return out
+}
+
+// formatExtensions are the output extensions of each structured format.
+var formatExtensions = map[string][]string{
+	"yaml": {".yaml", ".yml"},
+	"json": {".json"},
+	"toml": {".toml"},
+	"kdl":  {".kdl"},
+	"ini":  {".ini"},
+}
+
+// ExtensionMismatch describes a target whose output extension (compared
+// case-insensitively) belongs to a different format than t.Format, e.g. a
+// yaml target writing config.json; otherwise it returns "". Raw and auto
+// targets, stdout and env: outputs and extensions no format claims (.conf,
+// none) are never reported.
+func ExtensionMismatch(t Target) string {
+	format := strings.ToLower(t.Format)
+	want, ok := formatExtensions[format]
+	if !ok || t.Output == "-" || strings.HasPrefix(t.Output, "env:") {
+		return ""
+	}
+	ext := strings.ToLower(filepath.Ext(t.Output))
+	if slices.Contains(want, ext) {
+		return ""
+	}
+	for f, exts := range formatExtensions {
+		if f != format && slices.Contains(exts, ext) {
+			return fmt.Sprintf("target %q: output %s has a %s extension but format is %s (want %s)", t.Name, t.Output, f, format, strings.Join(want, " or "))
+		}
+	}
+	return ""
+}
+
+// OutputSources reports path sources that (may) resolve to another target's
+// output, a dependency target_ref would declare; see mayMatchOutput. A glob
+// source counts when the output path matches the pattern.
+func (c *Config) OutputSources() []string {
+	var out []string
+	for _, t := range c.Targets {
+		for _, s := range t.Sources {
+			if s.Path == "" || s.TargetRef != "" || s.Inline != "" || s.DataURI != "" {
+				continue
+			}
+			for _, o := range c.Targets {
+				if o.Name == t.Name || strings.HasPrefix(o.Output, "env:") {
+					continue
+				}
+				if mayMatchOutput(c.baseDir, c.SourceDir(t), s, o.Output) {
+					out = append(out, fmt.Sprintf("source `%s` in target `%s` appears to be the output of target `%s`; consider using `target_ref: %s`", s.Path, t.Name, o.Name, o.Name))
+				}
+			}
+		}
+	}
+	return out
+}
+
+// within reports whether p is dir or below it.
+func within(p, dir string) bool {
+	rel, err := filepath.Rel(dir, p)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
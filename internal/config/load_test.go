@@ -181,3 +181,801 @@ func TestLoad_Errors_ForeignFieldsRejected(t *testing.T) {
 		t.Fatalf("unexpected error: %v", err)
 	}
 }
+
+func TestLoad_INI_SectionOrder(t *testing.T) {
+	td := t.TempDir()
+	cfgPath := filepath.Join(td, "confb.yaml")
+
+	writeFileT(t, cfgPath, `
+version: 1
+targets:
+  - name: sys
+    format: ini
+    output: ./sys.ini
+    sources:
+      - path: ./base.ini
+    merge: {}
+`)
+	cfg, err := Load(cfgPath)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if got := cfg.Targets[0].Merge.Rules.INISectionOrder; got != "first_seen" {
+		t.Fatalf("section_order default = %q, want first_seen", got)
+	}
+
+	writeFileT(t, cfgPath, `
+version: 1
+targets:
+  - name: sys
+    format: ini
+    output: ./sys.ini
+    sources:
+      - path: ./base.ini
+    merge:
+      rules:
+        section_order: random
+`)
+	if _, err := Load(cfgPath); err == nil || !strings.Contains(err.Error(), "section_order must be") {
+		t.Fatalf("expected section_order validation error, got %v", err)
+	}
+}
+
+func TestLoad_TargetRef_CycleAndOrder(t *testing.T) {
+	td := t.TempDir()
+	cfgPath := filepath.Join(td, "confb.yaml")
+
+	writeFileT(t, cfgPath, `
+version: 1
+targets:
+  - name: b
+    format: yaml
+    output: ./b.yaml
+    sources:
+      - target_ref: a
+  - name: a
+    format: yaml
+    output: ./a.yaml
+    sources:
+      - path: ./a.yaml.d/*.yaml
+`)
+	cfg, err := Load(cfgPath)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	order, err := cfg.BuildOrder()
+	if err != nil {
+		t.Fatalf("BuildOrder: %v", err)
+	}
+	if len(order) != 2 || order[0].Name != "a" || order[1].Name != "b" {
+		t.Fatalf("build order = %v, want [a b]", []string{order[0].Name, order[1].Name})
+	}
+
+	writeFileT(t, cfgPath, `
+version: 1
+targets:
+  - name: a
+    format: yaml
+    output: ./a.yaml
+    sources:
+      - target_ref: b
+  - name: b
+    format: yaml
+    output: ./b.yaml
+    sources:
+      - target_ref: a
+  - name: c
+    format: yaml
+    output: ./c.yaml
+    sources:
+      - target_ref: missing
+`)
+	_, err = Load(cfgPath)
+	if err == nil {
+		t.Fatalf("expected cycle error, got nil")
+	}
+	if !strings.Contains(err.Error(), "target_ref cycle: a -> b -> a") {
+		t.Fatalf("missing cycle issue: %v", err)
+	}
+	if !strings.Contains(err.Error(), `target_ref "missing" does not name a target`) {
+		t.Fatalf("missing unknown-ref issue: %v", err)
+	}
+}
+
+func TestLoad_Errors_ConflictingOutputs(t *testing.T) {
+	td := t.TempDir()
+	cfgPath := filepath.Join(td, "confb.yaml")
+
+	writeFileT(t, cfgPath, `
+version: 1
+targets:
+  - name: first
+    format: yaml
+    output: ./out/foo.yaml
+    sources:
+      - path: ./a.yaml
+  - name: second
+    format: yaml
+    output: out/foo.yaml
+    sources:
+      - path: ./b.yaml
+  - name: third
+    format: yaml
+    output: ./out/bar.yaml
+    sources:
+      - path: ./c.yaml
+`)
+
+	_, err := Load(cfgPath)
+	if err == nil {
+		t.Fatalf("expected output conflict error, got nil")
+	}
+	msg := err.Error()
+	if !strings.Contains(msg, "first, second") || strings.Contains(msg, "third") {
+		t.Fatalf("unexpected conflict message: %v", err)
+	}
+}
+
+func TestOutputConflicts_ComparesWrittenPaths(t *testing.T) {
+	t.Setenv("CONFB_TEST_DIR", "/srv")
+	targets := []Target{
+		{Name: "env", Output: "$CONFB_TEST_DIR/a.yaml"},
+		{Name: "abs", Output: "/srv/a.yaml"},
+		{Name: "dup", Output: "/srv/./a.yaml"},
+	}
+	got := OutputConflicts(targets)
+	if len(got) != 1 || !strings.Contains(got[0], "abs, dup") || strings.Contains(got[0], "env") {
+		t.Fatalf("want only abs and dup to conflict, got %v", got)
+	}
+}
+
+func TestLoad_YAMLStyle_OnlyForYAML(t *testing.T) {
+	td := t.TempDir()
+	cfgPath := filepath.Join(td, "confb.yaml")
+
+	writeFileT(t, cfgPath, `
+version: 1
+targets:
+  - name: j
+    format: json
+    output: ./out.json
+    sources:
+      - path: ./a.json
+    merge:
+      rules:
+        yaml_style: flow
+`)
+	if _, err := Load(cfgPath); err == nil || !strings.Contains(err.Error(), "yaml_style only applies to yaml") {
+		t.Fatalf("expected yaml_style format error, got %v", err)
+	}
+
+	writeFileT(t, cfgPath, `
+version: 1
+targets:
+  - name: y
+    format: yaml
+    output: ./out.yaml
+    sources:
+      - path: ./a.yaml
+    merge:
+      rules:
+        yaml_style: flow
+`)
+	if _, err := Load(cfgPath); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+}
+
+func TestLoad_JSONIndent_OnlyForJSON(t *testing.T) {
+	td := t.TempDir()
+	cfgPath := filepath.Join(td, "confb.yaml")
+
+	writeFileT(t, cfgPath, `
+version: 1
+targets:
+  - name: y
+    format: yaml
+    output: ./out.yaml
+    sources:
+      - path: ./a.yaml
+    merge:
+      rules:
+        json_indent: "    "
+`)
+	if _, err := Load(cfgPath); err == nil || !strings.Contains(err.Error(), "json_indent only applies to json") {
+		t.Fatalf("expected json_indent format error, got %v", err)
+	}
+}
+
+func TestLoad_TOMLPreserveInline_OnlyForTOML(t *testing.T) {
+	td := t.TempDir()
+	cfgPath := filepath.Join(td, "confb.yaml")
+
+	writeFileT(t, cfgPath, `
+version: 1
+targets:
+  - name: j
+    format: json
+    output: ./out.json
+    sources:
+      - path: ./a.json
+    merge:
+      rules:
+        toml_preserve_inline: true
+`)
+	if _, err := Load(cfgPath); err == nil || !strings.Contains(err.Error(), "toml_preserve_inline only applies to toml") {
+		t.Fatalf("expected toml_preserve_inline format error, got %v", err)
+	}
+}
+
+func TestLoad_MaxDepthRange(t *testing.T) {
+	td := t.TempDir()
+	cfgPath := filepath.Join(td, "confb.yaml")
+	conf := func(depth string) string {
+		return `
+version: 1
+targets:
+  - name: y
+    format: yaml
+    output: ./out.yaml
+    sources:
+      - path: ./a.yaml
+    merge:
+      rules:
+        max_depth: ` + depth + "\n"
+	}
+	for _, bad := range []string{"-1", "10001"} {
+		writeFileT(t, cfgPath, conf(bad))
+		if _, err := Load(cfgPath); err == nil || !strings.Contains(err.Error(), "max_depth must be 1..10000 (got "+bad+")") {
+			t.Fatalf("max_depth %s: got %v", bad, err)
+		}
+	}
+	writeFileT(t, cfgPath, conf("10000"))
+	if _, err := Load(cfgPath); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+}
+
+func TestLoad_Defaults_MergeRulesInherited(t *testing.T) {
+	td := t.TempDir()
+	cfgPath := filepath.Join(td, "confb.yaml")
+
+	writeFileT(t, cfgPath, `
+version: 1
+defaults:
+  dedupe: none
+  merge:
+    rules:
+      maps: replace
+      arrays: unique_append
+      keys: append
+targets:
+  - name: one
+    format: yaml
+    output: ./one.yaml
+    sources:
+      - path: ./a.yaml
+    merge: {}
+  - name: two
+    format: json
+    output: ./two.json
+    sources:
+      - path: ./a.json
+    merge: {}
+  - name: three
+    format: yaml
+    output: ./three.yaml
+    dedupe: by_path
+    sources:
+      - path: ./a.yaml
+    merge:
+      rules:
+        arrays: append
+  - name: plain
+    format: yaml
+    output: ./plain.yaml
+    sources:
+      - path: ./a.yaml
+`)
+	cfg, err := Load(cfgPath)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	for _, name := range []string{"one", "two"} {
+		tg, _ := cfg.TargetByName(name)
+		r := tg.Merge.Rules
+		if r.Maps != "replace" || r.Arrays != "unique_append" {
+			t.Fatalf("%s: rules = maps=%s arrays=%s, want defaults", name, r.Maps, r.Arrays)
+		}
+		if r.KDLKeys != "" {
+			t.Fatalf("%s: inherited kdl-only field keys=%q", name, r.KDLKeys)
+		}
+		if tg.Dedupe != "none" {
+			t.Fatalf("%s: dedupe = %s, want none from defaults", name, tg.Dedupe)
+		}
+	}
+	three, _ := cfg.TargetByName("three")
+	if r := three.Merge.Rules; r.Maps != "replace" || r.Arrays != "append" || three.Dedupe != "by_path" {
+		t.Fatalf("three: maps=%s arrays=%s dedupe=%s, want replace/append/by_path", r.Maps, r.Arrays, three.Dedupe)
+	}
+	if plain, _ := cfg.TargetByName("plain"); plain.Merge != nil {
+		t.Fatalf("plain: target without merge block must not inherit merge")
+	}
+
+	writeFileT(t, cfgPath, `
+version: 1
+defaults:
+  merge:
+    rules:
+      arrays: sometimes
+targets:
+  - name: one
+    format: yaml
+    output: ./one.yaml
+    sources:
+      - path: ./a.yaml
+`)
+	if _, err := Load(cfgPath); err == nil || !strings.Contains(err.Error(), "defaults.merge.rules.arrays must be") {
+		t.Fatalf("expected defaults validation error, got %v", err)
+	}
+}
+
+func TestLoad_Webhook(t *testing.T) {
+	td := t.TempDir()
+	cfgPath := filepath.Join(td, "confb.yaml")
+
+	writeFileT(t, cfgPath, `
+version: 1
+webhook:
+  url: ftp://example.com/hook
+targets:
+  - name: r
+    format: raw
+    output: ./out.txt
+    sources:
+      - path: ./a.txt
+`)
+	if _, err := Load(cfgPath); err == nil || !strings.Contains(err.Error(), "webhook.url") {
+		t.Fatalf("expected webhook.url error, got %v", err)
+	}
+
+	writeFileT(t, cfgPath, `
+version: 1
+webhook:
+  url: https://example.com/hook
+targets:
+  - name: r
+    format: raw
+    output: ./out.txt
+    sources:
+      - path: ./a.txt
+`)
+	cfg, err := Load(cfgPath)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.Webhook.Method != "POST" {
+		t.Fatalf("webhook.method = %q, want POST", cfg.Webhook.Method)
+	}
+}
+
+func TestLoad_SourceMayMatchOwnOutput(t *testing.T) {
+	td := t.TempDir()
+	cfgPath := filepath.Join(td, "confb.yaml")
+
+	writeFileT(t, cfgPath, `
+version: 1
+targets:
+  - name: merged
+    format: yaml
+    output: `+filepath.Join(td, "out", "merged.yaml")+`
+    sources:
+      - path: ./base.yaml
+      - path: ./out/*.yaml
+`)
+	_, err := Load(cfgPath)
+	if err == nil || !strings.Contains(err.Error(), `sources[1] "./out/*.yaml" may match the target's own output`) ||
+		!strings.Contains(err.Error(), "potential self-reference") {
+		t.Fatalf("expected self-reference error, got %v", err)
+	}
+
+	// a directory source whose dir_glob excludes the output is fine
+	writeFileT(t, cfgPath, `
+version: 1
+targets:
+  - name: merged
+    format: yaml
+    output: `+filepath.Join(td, "out", "merged.yaml")+`
+    sources:
+      - path: ./out
+        dir_glob: "*.yml"
+`)
+	if _, err := Load(cfgPath); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+}
+
+func TestLoad_DataURIValidation(t *testing.T) {
+	td := t.TempDir()
+	cfgPath := filepath.Join(td, "confb.yaml")
+
+	for _, tc := range []struct{ uri, want string }{
+		{"https://example.com/a.json", "data_uri must use the data: scheme"},
+		{"data:;base64,!!!", "data_uri base64 payload"},
+		{"data:application/yaml;base64,YTogMQo=", `media type "application/yaml" implies yaml, but the target format is json`},
+	} {
+		writeFileT(t, cfgPath, `
+version: 1
+targets:
+  - name: app
+    format: json
+    output: `+filepath.Join(td, "out.json")+`
+    sources:
+      - data_uri: "`+tc.uri+`"
+`)
+		if _, err := Load(cfgPath); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Fatalf("%s: expected %q, got %v", tc.uri, tc.want, err)
+		}
+	}
+}
+
+func TestLoad_EnvOutputRequiresRaw(t *testing.T) {
+	td := t.TempDir()
+	cfgPath := filepath.Join(td, "confb.yaml")
+	writeFileT(t, cfgPath, `
+version: 1
+targets:
+  - name: app
+    format: yaml
+    output: env:APP-CONFIG
+    sources:
+      - path: ./a.yaml
+`)
+	_, err := Load(cfgPath)
+	if err == nil || !strings.Contains(err.Error(), "output env:APP-CONFIG requires format raw") ||
+		!strings.Contains(err.Error(), "not a valid environment variable name") {
+		t.Fatalf("expected env output errors, got %v", err)
+	}
+}
+
+func TestLoad_OnChangeTimeout(t *testing.T) {
+	td := t.TempDir()
+	cfgPath := filepath.Join(td, "confb.yaml")
+	writeFileT(t, filepath.Join(td, "a.txt"), "a\n")
+	writeFileT(t, cfgPath, `
+version: 1
+default_on_change_timeout_s: 90
+targets:
+  - name: a
+    format: raw
+    output: ./a.out
+    sources:
+      - path: ./a.txt
+  - name: b
+    format: raw
+    output: ./b.out
+    on_change_timeout_s: 600
+    sources:
+      - path: ./a.txt
+`)
+	cfg, err := Load(cfgPath)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if a, b := cfg.Targets[0].OnChangeTimeoutS, cfg.Targets[1].OnChangeTimeoutS; a != 90 || b != 600 {
+		t.Fatalf("on_change_timeout_s = %d, %d; want 90 (default), 600", a, b)
+	}
+
+	writeFileT(t, cfgPath, `
+version: 1
+default_on_change_timeout_s: -1
+targets:
+  - name: a
+    format: raw
+    output: ./a.out
+    on_change_timeout_s: 3601
+    sources:
+      - path: ./a.txt
+`)
+	_, err = Load(cfgPath)
+	if err == nil || !strings.Contains(err.Error(), "default_on_change_timeout_s must be 0..3600 (got -1)") ||
+		!strings.Contains(err.Error(), "on_change_timeout_s must be 0..3600 (0 = default; got 3601)") {
+		t.Fatalf("expected timeout range errors, got %v", err)
+	}
+}
+
+func TestLoad_ExtraConfigs(t *testing.T) {
+	td := t.TempDir()
+	base := filepath.Join(td, "base.yaml")
+	local := filepath.Join(td, "local.yaml")
+	writeFileT(t, base, `
+version: 1
+defaults:
+  dedupe: none
+post_build: echo base
+targets:
+  - name: a
+    format: raw
+    output: ./a.out
+    sources:
+      - path: ./a.txt
+`)
+	writeFileT(t, local, `
+version: 1
+post_build: echo local
+targets:
+  - name: b
+    format: raw
+    output: ./b.out
+    sources:
+      - path: ./b.txt
+`)
+	cfg, err := Load(base, local)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if len(cfg.Targets) != 2 || cfg.Targets[1].Name != "b" {
+		t.Fatalf("targets = %+v, want a then b", cfg.Targets)
+	}
+	// later settings win; unset ones keep the earlier value
+	if cfg.PostBuild != "echo local" || cfg.Targets[1].Dedupe != "none" {
+		t.Fatalf("post_build = %q, b dedupe = %q", cfg.PostBuild, cfg.Targets[1].Dedupe)
+	}
+
+	writeFileT(t, local, `
+version: 2
+targets: []
+`)
+	if _, err := Load(base, local); err == nil || !strings.Contains(err.Error(), "version 2 does not match") {
+		t.Fatalf("want version mismatch, got %v", err)
+	}
+
+	writeFileT(t, local, `
+version: 1
+targets:
+  - name: a
+    format: raw
+    output: ./other.out
+    sources:
+      - path: ./a.txt
+`)
+	if _, err := Load(base, local); err == nil || !strings.Contains(err.Error(), `duplicate target name "a"`) {
+		t.Fatalf("want duplicate target error, got %v", err)
+	}
+}
+
+func TestLoad_OnChangeCycle(t *testing.T) {
+	td := t.TempDir()
+	cfgPath := filepath.Join(td, "confb.yaml")
+
+	for _, c := range []struct {
+		name, conf, want string
+	}{
+		{"hooks", `
+version: 1
+targets:
+  - name: a
+    format: raw
+    output: ./a.txt
+    sources:
+      - path: ./src/a.txt
+    on_change: confb build --targets=b
+  - name: b
+    format: raw
+    output: ./b.txt
+    sources:
+      - path: ./src/b.txt
+    on_change: 'confb -c ./confb.yaml build --targets a,c'
+  - name: c
+    format: raw
+    output: ./c.txt
+    sources:
+      - path: ./src/c.txt
+`, "on_change cycle: target a -> target b -> target a"},
+		{"hook and target_ref", `
+version: 1
+targets:
+  - name: a
+    format: raw
+    output: ./a.txt
+    sources:
+      - path: ./src/a.txt
+  - name: b
+    format: raw
+    output: ./b.txt
+    sources:
+      - target_ref: a
+    on_change: notify-send x && confb build --targets=a
+`, "on_change cycle: target a -> target b -> target a"},
+	} {
+		writeFileT(t, cfgPath, c.conf)
+		_, err := Load(cfgPath)
+		if err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Fatalf("%s: Load error = %v, want %q", c.name, err, c.want)
+		}
+	}
+
+	// a chain without a way back is fine
+	writeFileT(t, cfgPath, `
+version: 1
+targets:
+  - name: a
+    format: raw
+    output: ./a.txt
+    sources:
+      - path: ./src/a.txt
+    on_change: confb build --targets=b
+  - name: b
+    format: raw
+    output: ./b.txt
+    sources:
+      - path: ./src/b.txt
+    on_change: echo confb build --targets=nope
+`)
+	if _, err := Load(cfgPath); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+}
+
+func TestLoad_IncludeMergesTargets(t *testing.T) {
+	td := t.TempDir()
+	cfgPath := filepath.Join(td, "confb.yaml")
+	if err := os.Mkdir(filepath.Join(td, "targets"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	writeFileT(t, cfgPath, `
+version: 1
+include: ["./targets/*.yaml"]
+targets:
+  - name: a
+    format: raw
+    output: ./out/a.txt
+    sources:
+      - path: ./a.txt
+`)
+	writeFileT(t, filepath.Join(td, "targets", "b.yaml"), `
+targets:
+  - name: b
+    format: raw
+    output: ./out/b.txt
+    sources:
+      - path: ./b.txt
+`)
+
+	cfg, err := Load(cfgPath)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if len(cfg.Targets) != 2 || cfg.Targets[0].Name != "a" || cfg.Targets[1].Name != "b" {
+		t.Fatalf("targets = %+v, want a and b", cfg.Targets)
+	}
+
+	// an included file that includes the root again is a cycle
+	writeFileT(t, filepath.Join(td, "targets", "b.yaml"), `
+include: ["../confb.yaml"]
+targets: []
+`)
+	if _, err := Load(cfgPath); err == nil || !strings.Contains(err.Error(), "include cycle") {
+		t.Fatalf("expected include cycle error, got %v", err)
+	}
+}
+
+func TestLoad_TOMLAndJSON(t *testing.T) {
+	td := t.TempDir()
+	tomlPath := filepath.Join(td, "confb.toml")
+	writeFileT(t, tomlPath, `
+version = 1
+post_build = "echo done"
+
+[[targets]]
+name = "app"
+format = "yaml"
+output = "./app.yaml"
+on_change_timeout_s = 5
+
+  [targets.merge.rules]
+  maps = "deep"
+  arrays = "append"
+
+  [[targets.sources]]
+  path = "./base.yaml"
+
+  [[targets.sources]]
+  path = "./local.yaml"
+  optional = true
+
+[[targets]]
+name = "notes"
+format = "raw"
+output = "./notes.txt"
+
+  [[targets.sources]]
+  path = "./notes.d/*.txt"
+`)
+	cfg, err := Load(tomlPath)
+	if err != nil {
+		t.Fatalf("load toml: %v", err)
+	}
+	if cfg.PostBuild != "echo done" || len(cfg.Targets) != 2 {
+		t.Fatalf("cfg = %+v", cfg)
+	}
+	app := cfg.Targets[0]
+	if app.Name != "app" || app.Format != "yaml" || app.OnChangeTimeoutS != 5 {
+		t.Fatalf("targets[0] = %+v", app)
+	}
+	if len(app.Sources) != 2 || app.Sources[0].Path != "./base.yaml" || !app.Sources[1].Optional {
+		t.Fatalf("targets[0].sources = %+v", app.Sources)
+	}
+	if app.Merge == nil || app.Merge.Rules == nil || app.Merge.Rules.Arrays != "append" {
+		t.Fatalf("targets[0].merge = %+v", app.Merge)
+	}
+	if n := cfg.Targets[1]; n.Name != "notes" || len(n.Sources) != 1 || n.Sources[0].Path != "./notes.d/*.txt" {
+		t.Fatalf("targets[1] = %+v", n)
+	}
+
+	jsonPath := filepath.Join(td, "confb.json")
+	writeFileT(t, jsonPath, `{"version": 1, "targets": [{"name": "j", "format": "raw", "output": "./j.txt", "sources": [{"path": "./j.in"}]}]}`)
+	cfg, err = Load(jsonPath)
+	if err != nil {
+		t.Fatalf("load json: %v", err)
+	}
+	if len(cfg.Targets) != 1 || cfg.Targets[0].Name != "j" || cfg.Targets[0].Sources[0].Path != "./j.in" {
+		t.Fatalf("json targets = %+v", cfg.Targets)
+	}
+}
+
+func TestLoad_EnabledIf(t *testing.T) {
+	td := t.TempDir()
+	cfgPath := filepath.Join(td, "confb.yaml")
+	writeFileT(t, cfgPath, `
+version: 1
+targets:
+  - name: prod
+    format: raw
+    output: ./prod.out
+    enabled_if: CONFB_ENV == production
+    sources:
+      - path: ./a.txt
+  - name: dev
+    format: raw
+    output: ./dev.out
+    enabled_if:
+      - CONFB_ENV != "production"
+      - CONFB_USER
+    sources:
+      - path: ./a.txt
+`)
+	t.Setenv("CONFB_ENV", "dev")
+	t.Setenv("CONFB_USER", "")
+	cfg, err := Load(cfgPath)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	prod, dev := cfg.Targets[0], cfg.Targets[1]
+	if prod.Enabled() || dev.Enabled() {
+		t.Fatalf("CONFB_USER unset: prod=%v dev=%v, want both disabled", prod.Enabled(), dev.Enabled())
+	}
+	t.Setenv("CONFB_USER", "me")
+	if prod.Enabled() || !dev.Enabled() {
+		t.Fatalf("prod=%v dev=%v, want only dev enabled", prod.Enabled(), dev.Enabled())
+	}
+	t.Setenv("CONFB_ENV", "production")
+	if !prod.Enabled() || dev.Enabled() {
+		t.Fatalf("production: prod=%v dev=%v, want only prod enabled", prod.Enabled(), dev.Enabled())
+	}
+
+	writeFileT(t, cfgPath, `
+version: 1
+targets:
+  - name: x
+    format: raw
+    output: ./x.out
+    enabled_if: CONFB_ENV > 1
+    sources:
+      - path: ./a.txt
+`)
+	if _, err := Load(cfgPath); err == nil || !strings.Contains(err.Error(), `unknown operator ">"`) {
+		t.Fatalf("want unknown operator error, got %v", err)
+	}
+}
@@ -0,0 +1,155 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// TargetByName returns the target called name.
+func (c *Config) TargetByName(name string) (Target, bool) {
+	for _, t := range c.Targets {
+		if t.Name == name {
+			return t, true
+		}
+	}
+	return Target{}, false
+}
+
+// BuildOrder returns the targets ordered so that every target referenced via a
+// source's target_ref is built before the targets that consume it. Otherwise the
+// config order is kept. Cycles are an error (Load already rejects them).
+func (c *Config) BuildOrder() ([]Target, error) {
+	order := make([]Target, 0, len(c.Targets))
+	if cycle := c.walkRefs(func(t Target) { order = append(order, t) }); cycle != nil {
+		return nil, fmt.Errorf("target_ref cycle: %s", strings.Join(cycle, " -> "))
+	}
+	return order, nil
+}
+
+// walkRefs runs a DFS over target_ref edges, calling emit for each target in
+// dependency order. Unknown refs are skipped (validate reports them). It stops at
+// the first cycle and returns it as a list of names (first == last).
+func (c *Config) walkRefs(emit func(Target)) []string {
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	state := make(map[string]int, len(c.Targets))
+	var stack []string
+
+	var visit func(t Target) []string
+	visit = func(t Target) []string {
+		switch state[t.Name] {
+		case done:
+			return nil
+		case visiting:
+			for i, n := range stack {
+				if n == t.Name {
+					return append(append([]string(nil), stack[i:]...), t.Name)
+				}
+			}
+			return []string{t.Name, t.Name}
+		}
+		state[t.Name] = visiting
+		stack = append(stack, t.Name)
+		for _, s := range t.Sources {
+			if s.TargetRef == "" {
+				continue
+			}
+			dep, ok := c.TargetByName(s.TargetRef)
+			if !ok {
+				continue
+			}
+			if cycle := visit(dep); cycle != nil {
+				return cycle
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[t.Name] = done
+		emit(t)
+		return nil
+	}
+
+	for _, t := range c.Targets {
+		if cycle := visit(t); cycle != nil {
+			return cycle
+		}
+	}
+	return nil
+}
+
+// hookBuildRe matches a `confb [flags] build ... --targets=A,B` (or `--targets A,B`)
+// invocation inside an on_change command.
+var hookBuildRe = regexp.MustCompile(`\bconfb\s[^;&|\n]*?\bbuild\b[^;&|\n]*?--targets(?:=|\s+)([^\s;&|]+)`)
+
+// hookTargets returns the targets an on_change command rebuilds with confb build.
+func hookTargets(cmd string) []string {
+	var out []string
+	for _, m := range hookBuildRe.FindAllStringSubmatch(cmd, -1) {
+		for _, n := range strings.Split(strings.Trim(m[1], `"'`), ",") {
+			if n = strings.TrimSpace(n); n != "" {
+				out = append(out, n)
+			}
+		}
+	}
+	return out
+}
+
+// triggerCycle looks for a loop in which rebuilding a target eventually
+// rebuilds itself: a target_ref producer triggers its consumers, and a target
+// whose on_change runs `confb build --targets=X` triggers X. Only explicit
+// references count. It returns the first cycle found (first == last), or nil.
+func (c *Config) triggerCycle() []string {
+	next := map[string][]string{}
+	for _, t := range c.Targets {
+		for _, s := range t.Sources {
+			if _, ok := c.TargetByName(s.TargetRef); ok {
+				next[s.TargetRef] = append(next[s.TargetRef], t.Name)
+			}
+		}
+		for _, n := range hookTargets(t.OnChange) {
+			if _, ok := c.TargetByName(n); ok {
+				next[t.Name] = append(next[t.Name], n)
+			}
+		}
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	state := make(map[string]int, len(c.Targets))
+	var stack []string
+	var visit func(name string) []string
+	visit = func(name string) []string {
+		switch state[name] {
+		case done:
+			return nil
+		case visiting:
+			for i, n := range stack {
+				if n == name {
+					return append(append([]string(nil), stack[i:]...), name)
+				}
+			}
+		}
+		state[name] = visiting
+		stack = append(stack, name)
+		for _, n := range next[name] {
+			if cycle := visit(n); cycle != nil {
+				return cycle
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[name] = done
+		return nil
+	}
+	for _, t := range c.Targets {
+		if cycle := visit(t.Name); cycle != nil {
+			return cycle
+		}
+	}
+	return nil
+}
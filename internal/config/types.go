@@ -4,30 +4,94 @@
 
 // Versioned config file. We currently only accept version: 1
 type Config struct {
-	Version int      `yaml:"version"`
-	Targets []Target `yaml:"targets"`
+	Version  int       `yaml:"version"`
+	Defaults *Defaults `yaml:"defaults,omitempty"`
+	Webhook  *Webhook  `yaml:"webhook,omitempty"`
+	Include  []string  `yaml:"include,omitempty"` // more config files (globs) whose targets are appended
+	Targets  []Target  `yaml:"targets"`
+
+	// PostBuild runs once after every `confb build` and every daemon rebuild batch.
+	PostBuild         string `yaml:"post_build,omitempty"`
+	PostBuildTimeoutS int    `yaml:"post_build_timeout_s,omitempty"` // default 30
+
+	// OnChange is the daemon's config-wide on_change: it runs after targets are
+	// rebuilt, once per target or (with `confb run --batch-on-change`) once per batch.
+	OnChange string `yaml:"on_change,omitempty"`
+
+	// DefaultOnChangeTimeoutS is on_change_timeout_s for targets that leave it unset.
+	DefaultOnChangeTimeoutS int `yaml:"default_on_change_timeout_s,omitempty"`
+
+	// StrictExtensions makes `confb build` fail (instead of warn) when an
+	// output's extension belongs to another format (see ExtensionMismatch).
+	StrictExtensions bool `yaml:"strict_extensions,omitempty"`
+
 	// baseDir is set by the loader (directory of the confb.yaml)
 	baseDir string `yaml:"-"`
 }
 
+// Defaults are the base layer under every target's own settings.
+// Merge rules are inherited only by targets that declare a merge block, and only
+// the fields that apply to the target's format are copied.
+type Defaults struct {
+	Dedupe string     `yaml:"dedupe,omitempty"` // by_path|none
+	Merge  *MergeSpec `yaml:"merge,omitempty"`  // only rules are used
+}
+
+// Webhook is notified by the daemon after every build cycle.
+type Webhook struct {
+	URL    string `yaml:"url"`              // http:// or https://
+	Method string `yaml:"method,omitempty"` // POST (default) | PUT | PATCH
+}
+
 // A single build target (one output file)
 type Target struct {
 	Name     string     `yaml:"name"`
-	Format   string     `yaml:"format"`   // auto|yaml|toml|ini|json|raw|kdl
-	Output   string     `yaml:"output"`   // path (may include ~)
-	Sources  []Source   `yaml:"sources"`  // ordered
-	Dedupe   string     `yaml:"dedupe"`   // by_path|none (default by_path)
-	Newline  string     `yaml:"newline"`  // "\n" only in MVP
-	Encoding string     `yaml:"encoding"` // utf8 only in MVP
-	Merge    *MergeSpec `yaml:"merge,omitempty"` // optional; enables format-aware merging later
+	Format   string     `yaml:"format"`              // auto|yaml|toml|ini|json|raw|kdl
+	Output   string     `yaml:"output"`              // path (may include ~); "-" = stdout
+	Sources  []Source   `yaml:"sources"`             // ordered
+	Dedupe   string     `yaml:"dedupe"`              // by_path|none (default by_path)
+	Newline  string     `yaml:"newline"`             // "\n" only in MVP
+	Encoding string     `yaml:"encoding"`            // utf8 only in MVP
+	Merge    *MergeSpec `yaml:"merge,omitempty"`     // optional; enables format-aware merging later
 	OnChange string     `yaml:"on_change,omitempty"` // optional; shell command to run after successful write
+
+	OnChangePipeOutput    bool `yaml:"on_change_pipe_output,omitempty"`     // pipe the written output to on_change's stdin
+	OnChangeAsync         bool `yaml:"on_change_async,omitempty"`           // run on_change in the background (daemon)
+	OnChangeAsyncTimeoutS int  `yaml:"on_change_async_timeout_s,omitempty"` // async hook timeout in seconds (default 60)
+	OnChangeTimeoutS      int  `yaml:"on_change_timeout_s,omitempty"`       // sync hook timeout in seconds (default 20, max 3600)
+
+	DebounceMS int `yaml:"debounce_ms,omitempty"` // daemon debounce for this target (0 = global --debounce-ms)
+
+	MtimeSource string `yaml:"mtime_source,omitempty"` // output mtime: now|newest_source|zero (default now)
+
+	PostProcess []string `yaml:"post_process,omitempty"` // output post-processors, in order (default [ensure_trailing_newline])
+
+	VerifyOutput bool `yaml:"verify_output,omitempty"` // re-parse the written output in its format; a failure is a confb bug, not a source error
+
+	WorkDir string `yaml:"work_dir,omitempty"` // base for relative source paths, itself relative to confb.yaml's dir (default: that dir)
+
+	EnabledIf Conditions  `yaml:"enabled_if,omitempty"` // env conditions ("VAR == value", "VAR != value", "VAR"), all must hold; else the target is skipped
+	enabledIf []condition `yaml:"-"`                    // EnabledIf parsed by normalize
 }
 
 // A source entry (file path or glob), with options
 type Source struct {
-	Path     string `yaml:"path"`               // required; can be a glob
+	Path     string `yaml:"path"`               // required unless target_ref/inline/data_uri; can be a glob or a directory
 	Optional bool   `yaml:"optional,omitempty"` // if true, missing glob is not fatal
-	Sort     string `yaml:"sort,omitempty"`     // lex|none (default lex)
+	Sort     string `yaml:"sort,omitempty"`     // lex|none|numeric|mtime_asc|mtime_desc (default lex)
+	Encoding string `yaml:"encoding,omitempty"` // utf8|latin1 (default utf8); decoded to UTF-8 before merging
+	DirGlob  string `yaml:"dir_glob,omitempty"` // when path is a directory: filter for its direct children (default "*")
+	Priority int    `yaml:"priority,omitempty"` // when sources match the same file, the highest priority keeps it (ties: first source)
+
+	FollowSymlinks bool `yaml:"follow_symlinks,omitempty"` // glob: walk the base directory, following symlinked files and directories
+
+	MergePatch bool `yaml:"merge_patch,omitempty"` // yaml/json/toml: apply this source as an RFC 7396 JSON Merge Patch
+
+	TargetRef string `yaml:"target_ref,omitempty"` // use another target's output as this source (instead of path)
+	Inline    string `yaml:"inline,omitempty"`     // literal fragment used as this source's content (instead of path)
+	DataURI   string `yaml:"data_uri,omitempty"`   // data: URI (optionally ;base64) decoded as this source's content (instead of path)
+
+	Transforms []string `yaml:"transforms,omitempty"` // content transformers applied in order before parsing (e.g. env_interpolate)
 }
 
 // MergeSpec declares how to merge fragments for this target.
@@ -44,25 +108,56 @@ type MergeSpec struct {
 //
 // For yaml/toml/json:
 //   - Maps:   "deep" (default) | "replace"
-//   - Arrays: "replace" (default) | "append" | "unique_append"
+//   - Arrays: "replace" (default) | "append" | "unique_append" | "intersect"
+//   - JSONIndent: indent used for json output; nil → two spaces, "" → compact
+//   - JSONPreserveOrder: keep the sources' key order in json output (default: sorted keys)
+//   - TOMLPreserveInline: keep keys written as inline tables inline in toml output
+//   - TOMLOutputStyle: "standard" (default, [section] tables) | "compact" (all tables inline)
+//   - YAMLStyle: block (default) or flow collections in yaml output
+//   - YAMLScalarStyle: any (default) or one style forced on every string value in yaml output
+//   - NullStrategy: a null in a later file "replace"s the value, "delete"s the key or is "ignore"d
+//   - MaxDepth: nesting level below which values are replaced instead of merged (default 100)
 //
 // For kdl:
 //   - KDLKeys:        "last_wins" (default) | "first_wins" | "append"
 //   - KDLSectionKeys: optional list of identifiers to merge; if empty → merge all matching identifiers.
+//   - KDLMatchProperty: blocks with the same name and the same value of this head property merge.
+//   - KDLOutputOrder: "lex" (default) | "encounter" (sections and keys in first-seen order)
+//   - KDLSortByHead: sort instances of the same block name by head (e.g. output "DP-1" before "DP-2")
+//   - KDLStrictKeys: fail (instead of warn) when a key repeats within one block of a single file
 //
 // For ini:
 //   - INIRepeatedKeys: "last_wins" (default) | "append"
+//   - INISectionOrder: "first_seen" (default) | "lex" | "source_priority"
+//   - INIDefaultSection: name of a section (e.g. "DEFAULT") whose keys every other section inherits.
+//   - INIGlobalSection: header for the keys above the first section (default none).
 type MergeRules struct {
 	// Structured formats
 	Maps   string `yaml:"maps,omitempty"`   // deep|replace
-	Arrays string `yaml:"arrays,omitempty"` // replace|append|unique_append
+	Arrays string `yaml:"arrays,omitempty"` // replace|append|unique_append|intersect
+
+	JSONIndent         *string `yaml:"json_indent,omitempty"`          // json output only; "" = compact
+	TOMLPreserveInline bool    `yaml:"toml_preserve_inline,omitempty"` // toml output only
+	TOMLOutputStyle    string  `yaml:"toml_output_style,omitempty"`    // toml output only; standard|compact (default standard)
+	YAMLStyle          string  `yaml:"yaml_style,omitempty"`           // yaml output only; block|flow (default block)
+	YAMLScalarStyle    string  `yaml:"yaml_scalar_style,omitempty"`    // yaml output only; any|double_quoted|single_quoted|literal (default any)
+	NullStrategy       string  `yaml:"null_strategy,omitempty"`        // replace|delete|ignore: what a null in a later file does
+	JSONPreserveOrder  bool    `yaml:"json_preserve_order,omitempty"`  // json output only; keep source key order
+	MaxDepth           int     `yaml:"max_depth,omitempty"`            // deepest level merged (1..10000, default 100); deeper values are replaced
 
 	// KDL
-	KDLKeys        string   `yaml:"keys,omitempty"`          // last_wins|first_wins|append
-	KDLSectionKeys []string `yaml:"section_keys,omitempty"`  // optional list; if empty -> merge all identifiers
+	KDLKeys          string   `yaml:"keys,omitempty"`           // last_wins|first_wins|append
+	KDLSectionKeys   []string `yaml:"section_keys,omitempty"`   // optional list; if empty -> merge all identifiers
+	KDLMatchProperty string   `yaml:"match_property,omitempty"` // match blocks by this head property (e.g. match-app-id) instead of the raw head
+	KDLOutputOrder   string   `yaml:"output_order,omitempty"`   // lex|encounter (default lex)
+	KDLSortByHead    bool     `yaml:"sort_by_head,omitempty"`   // render instances of a block name sorted by head
+	KDLStrictKeys    bool     `yaml:"strict_keys,omitempty"`    // a key repeated within one block of a file is an error (default: warning)
 
 	// INI
-	INIRepeatedKeys string `yaml:"repeated_keys,omitempty"` // last_wins|append
+	INIRepeatedKeys   string `yaml:"repeated_keys,omitempty"`   // last_wins|append
+	INISectionOrder   string `yaml:"section_order,omitempty"`   // first_seen|lex|source_priority
+	INIDefaultSection string `yaml:"default_section,omitempty"` // e.g. DEFAULT; its keys fill in missing keys of other sections
+	INIGlobalSection  string `yaml:"global_section,omitempty"`  // e.g. global; header for keys above the first section
 }
 
 // ValidationError aggregates multiple field issues into one error.
@@ -1,10 +1,23 @@
 package daemon
 
 import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"regexp"
 	"runtime"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"testing"
 	"time"
@@ -147,3 +160,1876 @@ func waitUntil(t *testing.T, d time.Duration, cond func() bool, msg func() strin
 func quoteYAML(s string) string {
 	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
 }
+
+func TestRunOnChange_PipeOutputToStdin(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires /bin/sh")
+	}
+
+	td := t.TempDir()
+	out := filepath.Join(td, "out.yaml")
+	side := filepath.Join(td, "stdin.txt")
+	writeFileT(t, out, "a: 1\nb: two\n")
+
+	tg := config.Target{
+		Name:               "y",
+		OnChange:           "cat > " + quoteYAML(side),
+		OnChangePipeOutput: true,
+	}
+	runOnChange(tg, out, "", func(LogLevel, string) {}, LogQuiet)
+
+	b, err := os.ReadFile(side)
+	if err != nil {
+		t.Fatalf("read side-effect file: %v", err)
+	}
+	if string(b) != "a: 1\nb: two\n" {
+		t.Fatalf("hook stdin = %q, want merged output", string(b))
+	}
+
+	// unreadable output: hook still runs, with empty stdin
+	var logged []string
+	runOnChange(tg, filepath.Join(td, "missing.yaml"), "", func(_ LogLevel, msg string) {
+		logged = append(logged, msg)
+	}, LogQuiet)
+	b, err = os.ReadFile(side)
+	if err != nil || len(b) != 0 {
+		t.Fatalf("expected empty stdin for missing output, got %q (err=%v)", string(b), err)
+	}
+	if !strings.Contains(strings.Join(logged, "\n"), "cannot read") {
+		t.Fatalf("expected a warning about the unreadable output, got %v", logged)
+	}
+}
+
+func TestRun_OnChangeAsync_DoesNotBlockRebuild(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("signals differ on Windows; skip daemon E2E")
+	}
+
+	td := t.TempDir()
+	src := filepath.Join(td, "src", "a.txt")
+	out := filepath.Join(td, "out.txt")
+	writeFileT(t, src, "one\n")
+
+	cfgPath := filepath.Join(td, "confb.yaml")
+	writeFileT(t, cfgPath, `
+version: 1
+targets:
+  - name: raw
+    format: raw
+    output: `+quoteYAML(out)+`
+    sources:
+      - path: `+quoteYAML(src)+`
+    on_change: sleep 3
+    on_change_async: true
+    on_change_async_timeout_s: 5
+`)
+
+	cfg, err := config.Load(cfgPath)
+	if err != nil {
+		t.Fatalf("config.Load: %v", err)
+	}
+
+	start := time.Now()
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- Run(cfg, Options{
+			LogLevel:   LogQuiet,
+			Debounce:   50 * time.Millisecond,
+			ConfigPath: cfgPath,
+		})
+	}()
+
+	// the initial build fires the slow hook; a rebuild must still land well before it finishes
+	waitUntil(t, 2500*time.Millisecond, func() bool {
+		writeFileT(t, src, "two\n")
+		time.Sleep(100 * time.Millisecond)
+		b, err := os.ReadFile(out)
+		return err == nil && string(b) == "two\n"
+	}, func() string {
+		b, _ := os.ReadFile(out)
+		return "rebuild blocked by async hook; out=" + string(b)
+	})
+	if time.Since(start) >= 3*time.Second {
+		t.Fatalf("rebuild took %v; async hook appears to block", time.Since(start))
+	}
+
+	_ = syscall.Kill(os.Getpid(), syscall.SIGINT)
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Fatalf("daemon returned error on shutdown: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("daemon did not exit after SIGINT")
+	}
+}
+
+func TestRun_GracePeriod_DefersFirstRebuild(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("signals differ on Windows; skip daemon E2E")
+	}
+
+	td := t.TempDir()
+	src := filepath.Join(td, "src", "a.txt")
+	out := filepath.Join(td, "out.txt")
+	writeFileT(t, src, "one\n")
+
+	cfgPath := filepath.Join(td, "confb.yaml")
+	writeFileT(t, cfgPath, `
+version: 1
+targets:
+  - name: raw
+    format: raw
+    output: `+quoteYAML(out)+`
+    sources:
+      - path: `+quoteYAML(src)+`
+`)
+
+	cfg, err := config.Load(cfgPath)
+	if err != nil {
+		t.Fatalf("config.Load: %v", err)
+	}
+
+	grace := 1500 * time.Millisecond
+	start := time.Now()
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- Run(cfg, Options{
+			LogLevel:    LogQuiet,
+			Debounce:    50 * time.Millisecond,
+			ConfigPath:  cfgPath,
+			GracePeriod: grace,
+		})
+	}()
+
+	// wait for the initial build, give the watcher a moment, then edit during the grace period
+	waitUntil(t, 5*time.Second, func() bool {
+		b, err := os.ReadFile(out)
+		return err == nil && string(b) == "one\n"
+	}, func() string { return "initial build did not happen" })
+	time.Sleep(150 * time.Millisecond)
+	writeFileT(t, src, "two\n")
+
+	time.Sleep(500 * time.Millisecond)
+	if time.Since(start) < grace {
+		if b, _ := os.ReadFile(out); string(b) != "one\n" {
+			t.Fatalf("rebuilt during grace period: %q", string(b))
+		}
+	}
+
+	waitUntil(t, 5*time.Second, func() bool {
+		b, err := os.ReadFile(out)
+		return err == nil && string(b) == "two\n"
+	}, func() string { return "buffered event was not replayed after the grace period" })
+	if time.Since(start) < grace {
+		t.Fatalf("rebuild happened after %v, before the %v grace period ended", time.Since(start), grace)
+	}
+
+	_ = syscall.Kill(os.Getpid(), syscall.SIGINT)
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Fatalf("daemon returned error on shutdown: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("daemon did not exit after SIGINT")
+	}
+}
+
+func TestRun_LockFiles_SecondDaemonRefuses(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("signals differ on Windows; skip daemon E2E")
+	}
+
+	td := t.TempDir()
+	src := filepath.Join(td, "src", "a.txt")
+	out := filepath.Join(td, "out.txt")
+	lockPath := filepath.Join(td, "src", ".confb.lock")
+	writeFileT(t, src, "one\n")
+
+	cfgPath := filepath.Join(td, "confb.yaml")
+	writeFileT(t, cfgPath, `
+version: 1
+targets:
+  - name: raw
+    format: raw
+    output: `+quoteYAML(out)+`
+    sources:
+      - path: `+quoteYAML(filepath.Join(td, "src", "*"))+`
+`)
+
+	cfg, err := config.Load(cfgPath)
+	if err != nil {
+		t.Fatalf("config.Load: %v", err)
+	}
+	opts := Options{LogLevel: LogQuiet, ConfigPath: cfgPath, WriteLockFiles: true}
+
+	errCh := make(chan error, 1)
+	go func() { errCh <- Run(cfg, opts) }()
+
+	waitUntil(t, 5*time.Second, func() bool {
+		b, err := os.ReadFile(out)
+		return err == nil && string(b) == "one\n"
+	}, func() string { return "first daemon did not build" })
+	if b, err := os.ReadFile(lockPath); err != nil || !strings.Contains(string(b), `"pid"`) {
+		t.Fatalf("lock file missing or malformed: %q (err=%v)", string(b), err)
+	}
+
+	// second daemon on the same dirs must fail fast
+	second := make(chan error, 1)
+	go func() { second <- Run(cfg, opts) }()
+	select {
+	case err := <-second:
+		if err == nil || !strings.Contains(err.Error(), "held by running confb") {
+			t.Fatalf("second daemon: want lock error, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("second daemon did not refuse to start")
+	}
+
+	_ = syscall.Kill(os.Getpid(), syscall.SIGINT)
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Fatalf("daemon returned error on shutdown: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("daemon did not exit after SIGINT")
+	}
+	if _, err := os.Stat(lockPath); !os.IsNotExist(err) {
+		t.Fatalf("lock file not removed on shutdown (err=%v)", err)
+	}
+}
+
+func TestRun_MetricsEndpoint(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("signals differ on Windows; skip daemon E2E")
+	}
+
+	// reserve a free port
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	addr := ln.Addr().String()
+	_ = ln.Close()
+
+	td := t.TempDir()
+	srcDir := filepath.Join(td, "src")
+	out := filepath.Join(td, "out.txt")
+	writeFileT(t, filepath.Join(srcDir, "a.txt"), "a\n")
+	writeFileT(t, filepath.Join(srcDir, "b.txt"), "b\n")
+
+	cfgPath := filepath.Join(td, "confb.yaml")
+	writeFileT(t, cfgPath, `
+version: 1
+targets:
+  - name: raw
+    format: raw
+    output: `+quoteYAML(out)+`
+    sources:
+      - path: `+quoteYAML(filepath.Join(srcDir, "*.txt"))+`
+    on_change: "true"
+`)
+	cfg, err := config.Load(cfgPath)
+	if err != nil {
+		t.Fatalf("config.Load: %v", err)
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- Run(cfg, Options{
+			LogLevel:    LogQuiet,
+			Debounce:    50 * time.Millisecond,
+			ConfigPath:  cfgPath,
+			MetricsAddr: addr,
+		})
+	}()
+
+	// scrape parses "name{labels} value" lines into a map, failing on malformed lines
+	scrape := func() (map[string]float64, error) {
+		resp, err := http.Get("http://" + addr + "/metrics")
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+			t.Fatalf("content type = %q", ct)
+		}
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		series := map[string]float64{}
+		for _, line := range strings.Split(strings.TrimSpace(string(body)), "\n") {
+			if strings.HasPrefix(line, "# HELP ") || strings.HasPrefix(line, "# TYPE ") {
+				continue
+			}
+			i := strings.LastIndexByte(line, ' ')
+			if i < 0 {
+				t.Fatalf("malformed metrics line %q", line)
+			}
+			v, err := strconv.ParseFloat(line[i+1:], 64)
+			if err != nil {
+				t.Fatalf("bad value in %q: %v", line, err)
+			}
+			series[line[:i]] = v
+		}
+		return series, nil
+	}
+
+	var m map[string]float64
+	waitUntil(t, 5*time.Second, func() bool {
+		m, err = scrape()
+		return err == nil && m[`confb_rebuilds_total{target="raw"}`] == 1
+	}, func() string { return fmt.Sprintf("initial build not reported: %v %v", m, err) })
+
+	if got := m[`confb_source_files_count{target="raw"}`]; got != 2 {
+		t.Fatalf("source_files_count = %v, want 2", got)
+	}
+	if got := m[`confb_on_change_runs_total{target="raw"}`]; got != 1 {
+		t.Fatalf("on_change_runs_total = %v, want 1", got)
+	}
+	if ts := m[`confb_last_rebuild_timestamp_seconds{target="raw"}`]; ts < float64(time.Now().Add(-time.Minute).Unix()) {
+		t.Fatalf("last_rebuild_timestamp_seconds = %v", ts)
+	}
+
+	time.Sleep(150 * time.Millisecond)
+	writeFileT(t, filepath.Join(srcDir, "c.txt"), "c\n")
+	waitUntil(t, 5*time.Second, func() bool {
+		m, err = scrape()
+		return err == nil && m[`confb_rebuilds_total{target="raw"}`] == 2 && m[`confb_source_files_count{target="raw"}`] == 3
+	}, func() string { return fmt.Sprintf("rebuild not reported: %v %v", m, err) })
+
+	_ = syscall.Kill(os.Getpid(), syscall.SIGINT)
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Fatalf("daemon returned error on shutdown: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("daemon did not exit after SIGINT")
+	}
+	if _, err := http.Get("http://" + addr + "/metrics"); err == nil {
+		t.Fatal("metrics server still serving after Run returned")
+	}
+}
+
+func TestRun_PerTargetDebounce(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("signals differ on Windows; skip daemon E2E")
+	}
+
+	td := t.TempDir()
+	fastSrc := filepath.Join(td, "fast", "a.txt")
+	slowSrc := filepath.Join(td, "slow", "a.txt")
+	fastOut := filepath.Join(td, "fast.txt")
+	slowOut := filepath.Join(td, "slow.txt")
+	writeFileT(t, fastSrc, "one\n")
+	writeFileT(t, slowSrc, "one\n")
+
+	cfgPath := filepath.Join(td, "confb.yaml")
+	writeFileT(t, cfgPath, `
+version: 1
+targets:
+  - name: fast
+    format: raw
+    output: `+quoteYAML(fastOut)+`
+    debounce_ms: 5000 # overridden from the CLI
+    sources:
+      - path: `+quoteYAML(fastSrc)+`
+  - name: slow
+    format: raw
+    output: `+quoteYAML(slowOut)+`
+    debounce_ms: 1500
+    sources:
+      - path: `+quoteYAML(slowSrc)+`
+`)
+	cfg, err := config.Load(cfgPath)
+	if err != nil {
+		t.Fatalf("config.Load: %v", err)
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- Run(cfg, Options{
+			LogLevel:       LogQuiet,
+			Debounce:       200 * time.Millisecond,
+			ConfigPath:     cfgPath,
+			TargetDebounce: map[string]time.Duration{"fast": 50 * time.Millisecond},
+		})
+	}()
+
+	waitUntil(t, 5*time.Second, func() bool {
+		a, errA := os.ReadFile(fastOut)
+		b, errB := os.ReadFile(slowOut)
+		return errA == nil && errB == nil && string(a) == "one\n" && string(b) == "one\n"
+	}, func() string { return "initial build did not happen" })
+	time.Sleep(150 * time.Millisecond)
+
+	edited := time.Now()
+	writeFileT(t, fastSrc, "two\n")
+	writeFileT(t, slowSrc, "two\n")
+
+	waitUntil(t, 1*time.Second, func() bool {
+		b, err := os.ReadFile(fastOut)
+		return err == nil && string(b) == "two\n"
+	}, func() string { return "fast target did not rebuild within its CLI debounce" })
+	if b, _ := os.ReadFile(slowOut); string(b) != "one\n" {
+		t.Fatalf("slow target rebuilt after %v, before its 1500ms debounce", time.Since(edited))
+	}
+
+	waitUntil(t, 5*time.Second, func() bool {
+		b, err := os.ReadFile(slowOut)
+		return err == nil && string(b) == "two\n"
+	}, func() string { return "slow target did not rebuild" })
+	if el := time.Since(edited); el < 1500*time.Millisecond {
+		t.Fatalf("slow target rebuilt after %v, want >= 1500ms", el)
+	}
+
+	_ = syscall.Kill(os.Getpid(), syscall.SIGINT)
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Fatalf("daemon returned error on shutdown: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("daemon did not exit after SIGINT")
+	}
+}
+
+func TestRun_StateFileHistory(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("signals differ on Windows; skip daemon E2E")
+	}
+
+	td := t.TempDir()
+	src := filepath.Join(td, "src", "a.txt")
+	out := filepath.Join(td, "out.txt")
+	statePath := filepath.Join(td, "state", "state.json")
+	writeFileT(t, src, "v0\n")
+
+	cfgPath := filepath.Join(td, "confb.yaml")
+	writeFileT(t, cfgPath, `
+version: 1
+targets:
+  - name: raw
+    format: raw
+    output: `+quoteYAML(out)+`
+    sources:
+      - path: `+quoteYAML(src)+`
+`)
+	cfg, err := config.Load(cfgPath)
+	if err != nil {
+		t.Fatalf("config.Load: %v", err)
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- Run(cfg, Options{
+			LogLevel:   LogQuiet,
+			Debounce:   20 * time.Millisecond,
+			ConfigPath: cfgPath,
+			StateFile:  statePath,
+
+			TargetDebounce: map[string]time.Duration{"raw": 30 * time.Millisecond},
+		})
+	}()
+
+	waitUntil(t, 5*time.Second, func() bool {
+		b, err := os.ReadFile(out)
+		return err == nil && string(b) == "v0\n"
+	}, func() string { return "initial build did not happen" })
+	time.Sleep(150 * time.Millisecond)
+
+	// 15 rebuilds, each waited for so they are not coalesced by the debounce
+	for i := 1; i <= 15; i++ {
+		want := fmt.Sprintf("v%d\n", i)
+		writeFileT(t, src, want)
+		waitUntil(t, 5*time.Second, func() bool {
+			b, err := os.ReadFile(out)
+			return err == nil && string(b) == want
+		}, func() string { return "rebuild " + strings.TrimSpace(want) + " did not happen" })
+	}
+
+	// the record lands just after the output is written; wait for the 15th
+	lastSum := sha256Hex("v15\n")
+	var st *State
+	waitUntil(t, 5*time.Second, func() bool {
+		st, err = ReadState(statePath)
+		if err != nil || len(st.Targets["raw"]) == 0 {
+			return false
+		}
+		recs := st.Targets["raw"]
+		return recs[len(recs)-1].SumAfter == lastSum
+	}, func() string { return fmt.Sprintf("15th rebuild not recorded: %v", err) })
+
+	if d := st.DebounceMS["raw"]; d != 30 {
+		t.Fatalf("debounce_ms[raw] = %d, want the --target-debounce 30", d)
+	}
+	if n := len(st.Targets["raw"]); n != DefaultHistoryDepth {
+		t.Fatalf("history has %d records, want %d", n, DefaultHistoryDepth)
+	}
+
+	recs := st.Targets["raw"]
+	for i := 1; i < len(recs); i++ {
+		if !recs[i].Time.After(recs[i-1].Time) {
+			t.Fatalf("timestamps not increasing at %d: %v then %v", i, recs[i-1].Time, recs[i].Time)
+		}
+		if recs[i].SumBefore != recs[i-1].SumAfter {
+			t.Fatalf("record %d: sum_before does not chain from the previous build", i)
+		}
+	}
+	if last := recs[len(recs)-1]; last.Error != "" || len(last.Files) != 1 {
+		t.Fatalf("last record = %+v", last)
+	}
+
+	_ = syscall.Kill(os.Getpid(), syscall.SIGINT)
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Fatalf("daemon returned error on shutdown: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("daemon did not exit after SIGINT")
+	}
+}
+
+func TestRun_OnChangeBuildID(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("signals differ on Windows; skip daemon E2E")
+	}
+
+	td := t.TempDir()
+	src := filepath.Join(td, "src", "a.txt")
+	out := filepath.Join(td, "out.txt")
+	idFile := filepath.Join(td, "build_id")
+	statePath := filepath.Join(td, "state.json")
+	writeFileT(t, src, "v0\n")
+
+	cfgPath := filepath.Join(td, "confb.yaml")
+	writeFileT(t, cfgPath, `
+version: 1
+targets:
+  - name: raw
+    format: raw
+    output: `+quoteYAML(out)+`
+    sources:
+      - path: `+quoteYAML(src)+`
+    on_change: 'printf %s "$CONFB_BUILD_ID" > `+idFile+`'
+`)
+	cfg, err := config.Load(cfgPath)
+	if err != nil {
+		t.Fatalf("config.Load: %v", err)
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- Run(cfg, Options{
+			LogLevel:   LogQuiet,
+			Debounce:   20 * time.Millisecond,
+			ConfigPath: cfgPath,
+			StateFile:  statePath,
+		})
+	}()
+
+	var id string
+	waitUntil(t, 5*time.Second, func() bool {
+		b, err := os.ReadFile(idFile)
+		id = string(b)
+		return err == nil && id != ""
+	}, func() string { return "on_change did not write CONFB_BUILD_ID" })
+
+	if !regexp.MustCompile(`^[0-9a-f]{8}$`).MatchString(id) {
+		t.Fatalf("CONFB_BUILD_ID = %q, want 8 lowercase hex chars", id)
+	}
+	st, err := ReadState(statePath)
+	if err != nil {
+		t.Fatalf("ReadState: %v", err)
+	}
+	if recs := st.Targets["raw"]; len(recs) != 1 || recs[0].BuildID != id {
+		t.Fatalf("state records = %+v, want one with build_id %q", recs, id)
+	}
+
+	_ = syscall.Kill(os.Getpid(), syscall.SIGINT)
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Fatalf("daemon returned error on shutdown: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("daemon did not exit after SIGINT")
+	}
+}
+
+func TestRun_WatchdogCatchesMissedEvents(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("signals differ on Windows; skip daemon E2E")
+	}
+
+	td := t.TempDir()
+	src := filepath.Join(td, "src", "a.txt")
+	out := filepath.Join(td, "out.txt")
+	writeFileT(t, src, "v0\n")
+
+	cfgPath := filepath.Join(td, "confb.yaml")
+	writeFileT(t, cfgPath, `
+version: 1
+targets:
+  - name: raw
+    format: raw
+    output: `+quoteYAML(out)+`
+    sources:
+      - path: `+quoteYAML(src)+`
+`)
+	cfg, err := config.Load(cfgPath)
+	if err != nil {
+		t.Fatalf("config.Load: %v", err)
+	}
+
+	// behave as if every fsnotify event were lost
+	dropFSEvents = true
+	defer func() { dropFSEvents = false }()
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- Run(cfg, Options{
+			LogLevel:         LogQuiet,
+			Debounce:         20 * time.Millisecond,
+			ConfigPath:       cfgPath,
+			WatchdogInterval: 100 * time.Millisecond,
+		})
+	}()
+
+	waitUntil(t, 5*time.Second, func() bool {
+		b, err := os.ReadFile(out)
+		return err == nil && string(b) == "v0\n"
+	}, func() string { return "initial build did not happen" })
+
+	writeFileT(t, src, "v1\n")
+	waitUntil(t, 5*time.Second, func() bool {
+		b, err := os.ReadFile(out)
+		return err == nil && string(b) == "v1\n"
+	}, func() string { return "watchdog did not trigger a rebuild" })
+
+	_ = syscall.Kill(os.Getpid(), syscall.SIGINT)
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Fatalf("daemon returned error on shutdown: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("daemon did not exit after SIGINT")
+	}
+}
+
+func TestRun_WatchdogIgnoresLastingErrors(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("signals differ on Windows; skip daemon E2E")
+	}
+
+	td := t.TempDir()
+	good := filepath.Join(td, "src", "good.txt")
+	bad := filepath.Join(td, "src", "bad.json")
+	writeFileT(t, good, "ok\n")
+	writeFileT(t, bad, "{broken\n")
+
+	cfgPath := filepath.Join(td, "confb.yaml")
+	writeFileT(t, cfgPath, `
+version: 1
+targets:
+  - name: good
+    format: raw
+    output: `+quoteYAML(filepath.Join(td, "good.out"))+`
+    sources:
+      - path: `+quoteYAML(good)+`
+  - name: bad
+    format: json
+    output: `+quoteYAML(filepath.Join(td, "bad.out"))+`
+    sources:
+      - path: `+quoteYAML(bad)+`
+    merge: {}
+`)
+	cfg, err := config.Load(cfgPath)
+	if err != nil {
+		t.Fatalf("config.Load: %v", err)
+	}
+
+	var builds atomic.Int32
+	beforeBuild = func(tg config.Target) {
+		if tg.Name == "bad" {
+			builds.Add(1)
+		}
+	}
+	defer func() { beforeBuild = nil }()
+	dropFSEvents = true
+	defer func() { dropFSEvents = false }()
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- Run(cfg, Options{
+			LogLevel:         LogQuiet,
+			Debounce:         10 * time.Millisecond,
+			ConfigPath:       cfgPath,
+			WatchdogInterval: 20 * time.Millisecond,
+		})
+	}()
+
+	waitUntil(t, 5*time.Second, func() bool { return builds.Load() == 1 },
+		func() string { return "initial build did not happen" })
+
+	// the source stays broken: no rebuild however many ticks pass
+	time.Sleep(300 * time.Millisecond)
+	if n := builds.Load(); n != 1 {
+		t.Fatalf("lasting error rebuilt %d times, want 1 build", n)
+	}
+
+	// a change to the broken source is still picked up
+	writeFileT(t, bad, "{still broken\n")
+	waitUntil(t, 5*time.Second, func() bool { return builds.Load() == 2 },
+		func() string { return fmt.Sprintf("%d builds after the change, want 2", builds.Load()) })
+
+	_ = syscall.Kill(os.Getpid(), syscall.SIGINT)
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Fatalf("daemon returned error on shutdown: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("daemon did not exit after SIGINT")
+	}
+}
+
+func TestRun_WebhookOnRebuild(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("signals differ on Windows; skip daemon E2E")
+	}
+
+	bodies := make(chan []byte, 4)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("webhook request: %s %s", r.Method, r.Header.Get("Content-Type"))
+		}
+		b, _ := io.ReadAll(r.Body)
+		bodies <- b
+	}))
+	defer srv.Close()
+
+	td := t.TempDir()
+	src := filepath.Join(td, "src", "a.txt")
+	out := filepath.Join(td, "out.txt")
+	writeFileT(t, src, "v0\n")
+
+	cfgPath := filepath.Join(td, "confb.yaml")
+	writeFileT(t, cfgPath, `
+version: 1
+webhook:
+  url: `+srv.URL+`
+targets:
+  - name: raw
+    format: raw
+    output: `+quoteYAML(out)+`
+    sources:
+      - path: `+quoteYAML(src)+`
+`)
+	cfg, err := config.Load(cfgPath)
+	if err != nil {
+		t.Fatalf("config.Load: %v", err)
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- Run(cfg, Options{
+			LogLevel:   LogQuiet,
+			Debounce:   20 * time.Millisecond,
+			ConfigPath: cfgPath,
+		})
+	}()
+
+	next := func(what string) buildCycle {
+		t.Helper()
+		select {
+		case b := <-bodies:
+			var c buildCycle
+			if err := json.Unmarshal(b, &c); err != nil {
+				t.Fatalf("%s: webhook body is not JSON: %v\n%s", what, err, b)
+			}
+			return c
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: no webhook received", what)
+		}
+		return buildCycle{}
+	}
+
+	initial := next("initial build")
+	writeFileT(t, src, "v1\n")
+	rebuild := next("rebuild")
+
+	for _, c := range []buildCycle{initial, rebuild} {
+		if len(c.BuildID) != 8 || c.Timestamp.IsZero() || len(c.Errors) != 0 || len(c.Targets) != 1 {
+			t.Fatalf("unexpected webhook body: %+v", c)
+		}
+		if tg := c.Targets[0]; tg.Name != "raw" || tg.Output != out || len(tg.Checksum) != 64 {
+			t.Fatalf("unexpected webhook target: %+v", tg)
+		}
+	}
+	if rebuild.Targets[0].Checksum != sha256Hex("v1\n") || rebuild.BuildID == initial.BuildID {
+		t.Fatalf("rebuild webhook = %+v (initial %+v)", rebuild, initial)
+	}
+
+	_ = syscall.Kill(os.Getpid(), syscall.SIGINT)
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Fatalf("daemon returned error on shutdown: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("daemon did not exit after SIGINT")
+	}
+}
+
+func TestRun_GracefulDrain_WaitsForHook(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("signals differ on Windows; skip daemon E2E")
+	}
+
+	td := t.TempDir()
+	src := filepath.Join(td, "src", "a.txt")
+	out := filepath.Join(td, "out.txt")
+	arm := filepath.Join(td, "arm")
+	started := filepath.Join(td, "started")
+	done := filepath.Join(td, "done")
+	writeFileT(t, src, "v0\n")
+
+	cfgPath := filepath.Join(td, "confb.yaml")
+	writeFileT(t, cfgPath, `
+version: 1
+targets:
+  - name: raw
+    format: raw
+    output: `+quoteYAML(out)+`
+    sources:
+      - path: `+quoteYAML(src)+`
+    on_change: 'if [ -f `+arm+` ]; then touch `+started+`; sleep 2; touch `+done+`; fi'
+`)
+	cfg, err := config.Load(cfgPath)
+	if err != nil {
+		t.Fatalf("config.Load: %v", err)
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- Run(cfg, Options{
+			LogLevel:      LogQuiet,
+			Debounce:      20 * time.Millisecond,
+			ConfigPath:    cfgPath,
+			GracefulDrain: true,
+			DrainTimeout:  10 * time.Second,
+		})
+	}()
+
+	waitUntil(t, 5*time.Second, func() bool {
+		b, err := os.ReadFile(out)
+		return err == nil && string(b) == "v0\n"
+	}, func() string { return "initial build did not happen" })
+
+	// only the rebuild's hook is slow
+	writeFileT(t, arm, "")
+	writeFileT(t, src, "v1\n")
+	waitUntil(t, 5*time.Second, func() bool {
+		_, err := os.Stat(started)
+		return err == nil
+	}, func() string { return "rebuild hook did not start" })
+
+	_ = syscall.Kill(os.Getpid(), syscall.SIGTERM)
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Fatalf("daemon returned error on shutdown: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("daemon did not exit after SIGTERM")
+	}
+	if _, err := os.Stat(done); err != nil {
+		t.Fatalf("daemon exited before the on_change hook finished: %v", err)
+	}
+}
+
+func TestRun_ExitOnEmptySourceSet(t *testing.T) {
+	td := t.TempDir()
+	cfgPath := filepath.Join(td, "confb.yaml")
+	writeFileT(t, cfgPath, `
+version: 1
+targets:
+  - name: conf_d
+    format: raw
+    output: `+quoteYAML(filepath.Join(td, "out.conf"))+`
+    sources:
+      - path: `+quoteYAML(filepath.Join(td, "conf.d", "*.conf"))+`
+        optional: true
+`)
+	cfg, err := config.Load(cfgPath)
+	if err != nil {
+		t.Fatalf("config.Load: %v", err)
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- Run(cfg, Options{LogLevel: LogQuiet, ConfigPath: cfgPath, ExitOnEmptySourceSet: true})
+	}()
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Fatalf("Run returned %v, want nil", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("daemon kept running with an empty source set")
+	}
+
+	// without the option an empty source set is still an error
+	if err := Run(cfg, Options{LogLevel: LogQuiet, ConfigPath: cfgPath}); err == nil || !strings.Contains(err.Error(), "resolved file list is empty") {
+		t.Fatalf("Run without ExitOnEmptySourceSet = %v, want empty file list error", err)
+	}
+}
+
+func TestRun_ResumeSkipsUnchangedOutput(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("signals differ on Windows; skip daemon E2E")
+	}
+
+	td := t.TempDir()
+	src := filepath.Join(td, "src", "a.txt")
+	out := filepath.Join(td, "out.txt")
+	hooks := filepath.Join(td, "hooks")
+	statePath := filepath.Join(td, "state.json")
+	writeFileT(t, src, "v0\n")
+
+	cfgPath := filepath.Join(td, "confb.yaml")
+	writeFileT(t, cfgPath, `
+version: 1
+targets:
+  - name: raw
+    format: raw
+    output: `+quoteYAML(out)+`
+    sources:
+      - path: `+quoteYAML(src)+`
+    on_change: 'echo x >> `+hooks+`'
+`)
+	cfg, err := config.Load(cfgPath)
+	if err != nil {
+		t.Fatalf("config.Load: %v", err)
+	}
+
+	hookRuns := func() int {
+		b, _ := os.ReadFile(hooks)
+		return strings.Count(string(b), "x\n")
+	}
+	// run starts the daemon, waits for ready, then stops it with SIGINT
+	run := func(noResume bool, ready func() bool) {
+		t.Helper()
+		errCh := make(chan error, 1)
+		go func() {
+			errCh <- Run(cfg, Options{LogLevel: LogQuiet, ConfigPath: cfgPath, StateFile: statePath, NoResume: noResume})
+		}()
+		waitUntil(t, 5*time.Second, ready, func() string { return fmt.Sprintf("daemon not ready (hook runs: %d)", hookRuns()) })
+		_ = syscall.Kill(os.Getpid(), syscall.SIGINT)
+		select {
+		case err := <-errCh:
+			if err != nil {
+				t.Fatalf("daemon returned error on shutdown: %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("daemon did not exit after SIGINT")
+		}
+	}
+
+	run(false, func() bool { return hookRuns() == 1 })
+
+	old := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(out, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	// resumed start: nothing to signal readiness, so give the initial build a moment
+	started := time.Now()
+	run(false, func() bool { return time.Since(started) > 500*time.Millisecond })
+	fi, err := os.Stat(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fi.ModTime().Equal(old) {
+		t.Fatalf("output mtime = %v, want unchanged %v", fi.ModTime(), old)
+	}
+	if n := hookRuns(); n != 1 {
+		t.Fatalf("on_change ran %d times, want 1 (resumed start must not run it)", n)
+	}
+
+	run(true, func() bool { return hookRuns() == 2 })
+	if fi, _ := os.Stat(out); fi.ModTime().Equal(old) {
+		t.Fatal("--no-resume did not rewrite the output")
+	}
+}
+
+func TestRun_GlobPicksUpFilesInNewDirectories(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("signals differ on Windows; skip daemon E2E")
+	}
+
+	td := t.TempDir()
+	base := filepath.Join(td, "base.txt")
+	out := filepath.Join(td, "out.txt")
+	writeFileT(t, base, "base\n")
+
+	cfgPath := filepath.Join(td, "confb.yaml")
+	writeFileT(t, cfgPath, `
+version: 1
+targets:
+  - name: raw
+    format: raw
+    output: `+quoteYAML(out)+`
+    sources:
+      - path: `+quoteYAML(base)+`
+      - path: `+quoteYAML(filepath.Join(td, "drop", "*", "*.txt"))+`
+        optional: true
+`)
+	cfg, err := config.Load(cfgPath)
+	if err != nil {
+		t.Fatalf("config.Load: %v", err)
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- Run(cfg, Options{LogLevel: LogQuiet, Debounce: 20 * time.Millisecond, ConfigPath: cfgPath})
+	}()
+	waitUntil(t, 5*time.Second, func() bool {
+		b, err := os.ReadFile(out)
+		return err == nil && string(b) == "base\n"
+	}, func() string { return "initial build did not happen" })
+
+	if _, err := os.Stat(filepath.Join(td, "drop", "*")); err == nil {
+		t.Fatal("daemon created a literal glob directory")
+	}
+
+	// the directory did not exist at startup; a file created in it must still rebuild
+	writeFileT(t, filepath.Join(td, "drop", "a", "x.txt"), "x\n")
+	waitUntil(t, 5*time.Second, func() bool {
+		b, _ := os.ReadFile(out)
+		return string(b) == "base\nx\n"
+	}, func() string { b, _ := os.ReadFile(out); return "first file not picked up; out=" + string(b) })
+
+	// later writes inside the new directory arrive through its own watch
+	writeFileT(t, filepath.Join(td, "drop", "a", "y.txt"), "y\n")
+	waitUntil(t, 5*time.Second, func() bool {
+		b, _ := os.ReadFile(out)
+		return string(b) == "base\nx\ny\n"
+	}, func() string { b, _ := os.ReadFile(out); return "second file not picked up; out=" + string(b) })
+
+	_ = syscall.Kill(os.Getpid(), syscall.SIGINT)
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Fatalf("daemon returned error on shutdown: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("daemon did not exit after SIGINT")
+	}
+}
+
+func TestRun_HealthcheckEndpoints(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("signals differ on Windows; skip daemon E2E")
+	}
+
+	// reserve a free port
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	addr := ln.Addr().String()
+	_ = ln.Close()
+
+	td := t.TempDir()
+	src := filepath.Join(td, "src", "a.json")
+	out := filepath.Join(td, "out.json")
+	writeFileT(t, src, `{"a": 1}`)
+
+	cfgPath := filepath.Join(td, "confb.yaml")
+	writeFileT(t, cfgPath, `
+version: 1
+targets:
+  - name: app
+    format: json
+    output: `+quoteYAML(out)+`
+    sources:
+      - path: `+quoteYAML(src)+`
+    merge: {}
+`)
+	cfg, err := config.Load(cfgPath)
+	if err != nil {
+		t.Fatalf("config.Load: %v", err)
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- Run(cfg, Options{
+			LogLevel:        LogQuiet,
+			Debounce:        20 * time.Millisecond,
+			ConfigPath:      cfgPath,
+			HealthcheckAddr: addr,
+		})
+	}()
+
+	status := func(path string) int {
+		resp, err := http.Get("http://" + addr + path)
+		if err != nil {
+			return 0
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	waitUntil(t, 5*time.Second, func() bool { return status("/readyz") == http.StatusOK },
+		func() string { return "readyz never returned 200" })
+	if code := status("/healthz"); code != http.StatusOK {
+		t.Fatalf("healthz = %d, want 200", code)
+	}
+
+	// a failing rebuild turns healthz unhealthy and shows up in /targets
+	writeFileT(t, src, `{"a": `)
+	waitUntil(t, 5*time.Second, func() bool { return status("/healthz") == http.StatusServiceUnavailable },
+		func() string { return "healthz did not report the failed rebuild" })
+
+	resp, err := http.Get("http://" + addr + "/targets")
+	if err != nil {
+		t.Fatalf("GET /targets: %v", err)
+	}
+	var targets []struct {
+		Name   string `json:"name"`
+		Status string `json:"status"`
+		Error  string `json:"error"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&targets)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("decode /targets: %v", err)
+	}
+	if len(targets) != 1 || targets[0].Name != "app" || targets[0].Status != "error" || targets[0].Error == "" {
+		t.Fatalf("/targets = %+v, want app with an error", targets)
+	}
+
+	writeFileT(t, src, `{"a": 2}`)
+	waitUntil(t, 5*time.Second, func() bool { return status("/healthz") == http.StatusOK },
+		func() string { return "healthz did not recover after a good rebuild" })
+
+	_ = syscall.Kill(os.Getpid(), syscall.SIGINT)
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Fatalf("daemon returned error on shutdown: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("daemon did not exit after SIGINT")
+	}
+	if code := status("/healthz"); code != 0 {
+		t.Fatalf("healthcheck server still answering after exit (status %d)", code)
+	}
+}
+
+func TestRun_MaxConcurrentFlushes(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("signals differ on Windows; skip daemon E2E")
+	}
+
+	td := t.TempDir()
+	srcDir := filepath.Join(td, "src")
+	hookLog := filepath.Join(td, "hooks.log")
+	writeFileT(t, filepath.Join(srcDir, "a.txt"), "a\n")
+
+	// each (synchronous) hook marks its start and end, so overlapping flushes show up
+	var b strings.Builder
+	b.WriteString("version: 1\ntargets:\n")
+	for i := 0; i < 5; i++ {
+		fmt.Fprintf(&b, `  - name: t%d
+    format: raw
+    output: %s
+    sources:
+      - path: %s
+    on_change: 'echo + >> %s; sleep 0.3; echo - >> %s'
+`, i, quoteYAML(filepath.Join(td, fmt.Sprintf("out%d.txt", i))), quoteYAML(filepath.Join(srcDir, "*.txt")), hookLog, hookLog)
+	}
+	cfgPath := filepath.Join(td, "confb.yaml")
+	writeFileT(t, cfgPath, b.String())
+	cfg, err := config.Load(cfgPath)
+	if err != nil {
+		t.Fatalf("config.Load: %v", err)
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- Run(cfg, Options{
+			LogLevel:             LogQuiet,
+			Debounce:             20 * time.Millisecond,
+			ConfigPath:           cfgPath,
+			MaxConcurrentFlushes: 2,
+		})
+	}()
+
+	ends := func() int {
+		data, _ := os.ReadFile(hookLog)
+		return strings.Count(string(data), "-")
+	}
+	waitUntil(t, 10*time.Second, func() bool { return ends() == 5 },
+		func() string { return "initial build hooks did not finish" })
+
+	// one new file rebuilds all five targets at once
+	writeFileT(t, filepath.Join(srcDir, "b.txt"), "b\n")
+	waitUntil(t, 10*time.Second, func() bool { return ends() == 10 },
+		func() string { return fmt.Sprintf("rebuild hooks did not finish (%d/10)", ends()) })
+
+	data, _ := os.ReadFile(hookLog)
+	running, peak := 0, 0
+	for _, line := range strings.Fields(string(data)) {
+		if line == "+" {
+			running++
+		} else {
+			running--
+		}
+		peak = max(peak, running)
+	}
+	if peak != 2 {
+		t.Fatalf("peak concurrent flushes = %d, want 2\n%s", peak, data)
+	}
+
+	_ = syscall.Kill(os.Getpid(), syscall.SIGINT)
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Fatalf("daemon returned error on shutdown: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("daemon did not exit after SIGINT")
+	}
+}
+
+func TestRun_AutoRestartAfterPanic(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("signals differ on Windows; skip daemon E2E")
+	}
+
+	td := t.TempDir()
+	src := filepath.Join(td, "src", "a.txt")
+	out := filepath.Join(td, "out.txt")
+	writeFileT(t, src, "v0\n")
+
+	cfgPath := filepath.Join(td, "confb.yaml")
+	writeFileT(t, cfgPath, `
+version: 1
+targets:
+  - name: raw
+    format: raw
+    output: `+quoteYAML(out)+`
+    sources:
+      - path: `+quoteYAML(src)+`
+`)
+	cfg, err := config.Load(cfgPath)
+	if err != nil {
+		t.Fatalf("config.Load: %v", err)
+	}
+
+	// builds panic while the source says "boom"
+	var panics atomic.Int32
+	beforeBuild = func(config.Target) {
+		if b, _ := os.ReadFile(src); strings.Contains(string(b), "boom") {
+			panics.Add(1)
+			var m map[string]int
+			m["nil"]++
+		}
+	}
+	defer func() { beforeBuild = nil }()
+	oldBackoff := restartBackoff
+	restartBackoff = 10 * time.Millisecond
+	defer func() { restartBackoff = oldBackoff }()
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- Run(cfg, Options{
+			LogLevel:    LogQuiet,
+			Debounce:    20 * time.Millisecond,
+			ConfigPath:  cfgPath,
+			AutoRestart: true,
+			MaxRestarts: 100,
+		})
+	}()
+
+	waitUntil(t, 5*time.Second, func() bool {
+		b, err := os.ReadFile(out)
+		return err == nil && string(b) == "v0\n"
+	}, func() string { return "initial build did not happen" })
+
+	// a rebuild panics, then every restart's initial build does
+	writeFileT(t, src, "boom\n")
+	waitUntil(t, 5*time.Second, func() bool { return panics.Load() >= 3 },
+		func() string { return fmt.Sprintf("only %d panics", panics.Load()) })
+
+	// fixing the source lets the next restart succeed
+	writeFileT(t, src, "v1\n")
+	waitUntil(t, 5*time.Second, func() bool {
+		b, err := os.ReadFile(out)
+		return err == nil && string(b) == "v1\n"
+	}, func() string { return "daemon did not recover after the source was fixed" })
+
+	_ = syscall.Kill(os.Getpid(), syscall.SIGINT)
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Fatalf("daemon returned error on shutdown: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("daemon did not exit after SIGINT")
+	}
+}
+
+func TestRun_AutoRestartGivesUp(t *testing.T) {
+	td := t.TempDir()
+	src := filepath.Join(td, "src", "a.txt")
+	writeFileT(t, src, "v0\n")
+	cfgPath := filepath.Join(td, "confb.yaml")
+	writeFileT(t, cfgPath, `
+version: 1
+targets:
+  - name: raw
+    format: raw
+    output: `+quoteYAML(filepath.Join(td, "out.txt"))+`
+    sources:
+      - path: `+quoteYAML(src)+`
+`)
+	cfg, err := config.Load(cfgPath)
+	if err != nil {
+		t.Fatalf("config.Load: %v", err)
+	}
+
+	var runs atomic.Int32
+	beforeBuild = func(config.Target) {
+		runs.Add(1)
+		panic("always")
+	}
+	defer func() { beforeBuild = nil }()
+
+	// the 1s base backoff is capped at 1ms by RestartBackoffMax
+	err = Run(cfg, Options{LogLevel: LogQuiet, AutoRestart: true, MaxRestarts: 2, RestartBackoffMax: time.Millisecond})
+	var lerr *RestartLimitError
+	if !errors.As(err, &lerr) || !strings.Contains(err.Error(), "panic: always") {
+		t.Fatalf("want restart limit error, got %v", err)
+	}
+	if lerr.Restarts != 2 || lerr.ExitCode() != 2 {
+		t.Fatalf("restart limit error = %+v (exit %d), want 2 restarts, exit 2", lerr, lerr.ExitCode())
+	}
+	if n := runs.Load(); n != 3 {
+		t.Fatalf("runs = %d, want 3 (first run + 2 restarts)", n)
+	}
+}
+
+func TestRun_PostBuildOncePerBatch(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("signals differ on Windows; skip daemon E2E")
+	}
+
+	td := t.TempDir()
+	hookLog := filepath.Join(td, "hook.log")
+	var targets strings.Builder
+	for _, n := range []string{"a", "b", "c"} {
+		writeFileT(t, filepath.Join(td, "src", n, "in.txt"), n+"0\n")
+		targets.WriteString(`
+  - name: ` + n + `
+    format: raw
+    output: ` + quoteYAML(filepath.Join(td, "out", n+".txt")) + `
+    sources:
+      - path: ` + quoteYAML(filepath.Join(td, "src", n, "in.txt")) + `
+`)
+	}
+	cfgPath := filepath.Join(td, "confb.yaml")
+	writeFileT(t, cfgPath, `
+version: 1
+post_build: echo {built_count} >> `+quoteYAML(hookLog)+`
+targets:`+targets.String())
+	cfg, err := config.Load(cfgPath)
+	if err != nil {
+		t.Fatalf("config.Load: %v", err)
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- Run(cfg, Options{LogLevel: LogQuiet, Debounce: 100 * time.Millisecond, ConfigPath: cfgPath})
+	}()
+
+	readLog := func() string {
+		b, _ := os.ReadFile(hookLog)
+		return string(b)
+	}
+	waitUntil(t, 5*time.Second, func() bool { return readLog() == "3\n" },
+		func() string { return fmt.Sprintf("after initial build, hook log = %q", readLog()) })
+
+	// all three change together: one batch, one hook run
+	for _, n := range []string{"a", "b", "c"} {
+		writeFileT(t, filepath.Join(td, "src", n, "in.txt"), n+"1\n")
+	}
+	waitUntil(t, 5*time.Second, func() bool { return readLog() == "3\n3\n" },
+		func() string { return fmt.Sprintf("after rebuild, hook log = %q", readLog()) })
+
+	_ = syscall.Kill(os.Getpid(), syscall.SIGINT)
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Fatalf("daemon returned error on shutdown: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("daemon did not exit after SIGINT")
+	}
+}
+
+func TestRun_BatchOnChange(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("signals differ on Windows; skip daemon E2E")
+	}
+
+	td := t.TempDir()
+	hookLog := filepath.Join(td, "hook.log")
+	var targets strings.Builder
+	for _, n := range []string{"a", "b", "c"} {
+		writeFileT(t, filepath.Join(td, "src", n, "in.txt"), n+"0\n")
+		targets.WriteString(`
+  - name: ` + n + `
+    format: raw
+    output: ` + quoteYAML(filepath.Join(td, "out", n+".txt")) + `
+    sources:
+      - path: ` + quoteYAML(filepath.Join(td, "src", n, "in.txt")) + `
+`)
+	}
+	cfgPath := filepath.Join(td, "confb.yaml")
+	writeFileT(t, cfgPath, `
+version: 1
+on_change: echo {changed_targets} >> `+quoteYAML(hookLog)+`
+targets:`+targets.String())
+	cfg, err := config.Load(cfgPath)
+	if err != nil {
+		t.Fatalf("config.Load: %v", err)
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- Run(cfg, Options{LogLevel: LogQuiet, Debounce: 100 * time.Millisecond, ConfigPath: cfgPath, BatchOnChange: true})
+	}()
+
+	readLines := func() []string {
+		b, _ := os.ReadFile(hookLog)
+		return strings.Fields(string(b))
+	}
+	waitUntil(t, 5*time.Second, func() bool { return len(readLines()) == 1 },
+		func() string { return fmt.Sprintf("after initial build, hook runs = %q", readLines()) })
+	if got := readLines()[0]; got != "a,b,c" {
+		t.Fatalf("initial {changed_targets} = %q, want a,b,c", got)
+	}
+
+	// all three change together: one hook run naming all of them
+	for _, n := range []string{"a", "b", "c"} {
+		writeFileT(t, filepath.Join(td, "src", n, "in.txt"), n+"1\n")
+	}
+	waitUntil(t, 5*time.Second, func() bool { return len(readLines()) >= 2 },
+		func() string { return fmt.Sprintf("after rebuild, hook runs = %q", readLines()) })
+	time.Sleep(300 * time.Millisecond) // a second run would have landed by now
+	lines := readLines()
+	if len(lines) != 2 {
+		t.Fatalf("hook runs = %q, want one per batch", lines)
+	}
+	changed := strings.Split(lines[1], ",")
+	sort.Strings(changed)
+	if got := strings.Join(changed, ","); got != "a,b,c" {
+		t.Fatalf("batch {changed_targets} = %q, want a, b and c", lines[1])
+	}
+
+	_ = syscall.Kill(os.Getpid(), syscall.SIGINT)
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Fatalf("daemon returned error on shutdown: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("daemon did not exit after SIGINT")
+	}
+}
+
+func TestRun_StatusFIFO(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("relies on Linux FIFO semantics")
+	}
+
+	td := t.TempDir()
+	fifo := filepath.Join(td, "status.fifo")
+	srcA := filepath.Join(td, "src", "a", "in.txt")
+	srcB := filepath.Join(td, "src", "b", "in.txt")
+	writeFileT(t, srcA, "a0\n")
+	writeFileT(t, srcB, "b0\n")
+	cfgPath := filepath.Join(td, "confb.yaml")
+	writeFileT(t, cfgPath, `
+version: 1
+targets:
+  - name: a
+    format: raw
+    output: `+quoteYAML(filepath.Join(td, "out", "a.txt"))+`
+    sources:
+      - path: `+quoteYAML(srcA)+`
+  - name: b
+    format: raw
+    output: `+quoteYAML(filepath.Join(td, "out", "b.txt"))+`
+    sources:
+      - path: `+quoteYAML(srcB)+`
+`)
+	cfg, err := config.Load(cfgPath)
+	if err != nil {
+		t.Fatalf("config.Load: %v", err)
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- Run(cfg, Options{LogLevel: LogQuiet, Debounce: 20 * time.Millisecond, ConfigPath: cfgPath, StatusFIFO: fifo})
+	}()
+
+	waitUntil(t, 5*time.Second, func() bool {
+		st, err := os.Stat(fifo)
+		return err == nil && st.Mode()&os.ModeNamedPipe != 0
+	}, func() string { return "status fifo was not created" })
+
+	// O_RDWR keeps the pipe open for writing too, so reads block instead of hitting EOF
+	r, err := os.OpenFile(fifo, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatalf("open fifo: %v", err)
+	}
+	defer r.Close()
+	lines := make(chan string, 16)
+	go func() {
+		sc := bufio.NewScanner(r)
+		for sc.Scan() {
+			lines <- sc.Text()
+		}
+	}()
+
+	// wait for the initial build before triggering a rebuild of one target
+	waitUntil(t, 5*time.Second, func() bool {
+		b, err := os.ReadFile(filepath.Join(td, "out", "b.txt"))
+		return err == nil && string(b) == "b0\n"
+	}, func() string { return "initial build did not happen" })
+	writeFileT(t, srcA, "a1\n")
+
+	deadline := time.After(5 * time.Second)
+	for {
+		select {
+		case line := <-lines:
+			var st struct {
+				Targets, Built, Errors int
+				TS                     string
+			}
+			if err := json.Unmarshal([]byte(line), &st); err != nil {
+				t.Fatalf("bad status line %q: %v", line, err)
+			}
+			if st.Built != 1 {
+				continue // the initial build (built 2) if the reader was in time
+			}
+			if st.Targets != 2 || st.Errors != 0 || st.TS == "" {
+				t.Fatalf("status line = %q", line)
+			}
+			_ = syscall.Kill(os.Getpid(), syscall.SIGINT)
+			if err := <-errCh; err != nil {
+				t.Fatalf("daemon returned error on shutdown: %v", err)
+			}
+			return
+		case <-deadline:
+			t.Fatal("no status line for the rebuild")
+		}
+	}
+}
+
+func TestRun_PIDFileLock_OneOfTwoRacingDaemonsWins(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("signals differ on Windows; skip daemon E2E")
+	}
+
+	td := t.TempDir()
+	src := filepath.Join(td, "a.txt")
+	out := filepath.Join(td, "out.txt")
+	pidPath := filepath.Join(td, "run", "confb.pid")
+	writeFileT(t, src, "one\n")
+
+	cfgPath := filepath.Join(td, "confb.yaml")
+	writeFileT(t, cfgPath, `
+version: 1
+targets:
+  - name: raw
+    format: raw
+    output: `+quoteYAML(out)+`
+    sources:
+      - path: `+quoteYAML(src)+`
+`)
+
+	cfg, err := config.Load(cfgPath)
+	if err != nil {
+		t.Fatalf("config.Load: %v", err)
+	}
+	opts := Options{LogLevel: LogQuiet, ConfigPath: cfgPath, PIDFile: pidPath, PIDLockTimeout: 300 * time.Millisecond}
+
+	errCh := make(chan error, 2)
+	for range 2 {
+		go func() { errCh <- Run(cfg, opts) }()
+	}
+
+	// the loser gives up once the lock timeout passes; the winner keeps running
+	select {
+	case err := <-errCh:
+		if err == nil || !strings.Contains(err.Error(), "is locked by another confb") {
+			t.Fatalf("losing daemon: want lock error, got %v", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("neither daemon gave up on the pid file lock")
+	}
+	select {
+	case err := <-errCh:
+		t.Fatalf("both daemons exited: %v", err)
+	case <-time.After(500 * time.Millisecond):
+	}
+	if b, err := os.ReadFile(pidPath); err != nil || strings.TrimSpace(string(b)) != strconv.Itoa(os.Getpid()) {
+		t.Fatalf("pid file = %q (err=%v), want our pid", string(b), err)
+	}
+
+	_ = syscall.Kill(os.Getpid(), syscall.SIGINT)
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Fatalf("daemon returned error on shutdown: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("daemon did not exit after SIGINT")
+	}
+	if _, err := os.Stat(pidPath); !os.IsNotExist(err) {
+		t.Fatalf("pid file not removed on shutdown (err=%v)", err)
+	}
+}
+
+func TestRunOnChange_TargetTimeoutKillsHook(t *testing.T) {
+	tg := config.Target{Name: "slow", OnChange: "sleep 3", OnChangeTimeoutS: 1}
+	var logged []string
+	started := time.Now()
+	runOnChange(tg, "", "", func(_ LogLevel, msg string) {
+		logged = append(logged, msg)
+	}, LogQuiet)
+	if d := time.Since(started); d > 2500*time.Millisecond {
+		t.Fatalf("hook ran for %s; want it killed after 1s", d)
+	}
+	if got := strings.Join(logged, "\n"); !strings.Contains(got, "on_change timed out after 1s (killed)") {
+		t.Fatalf("want timeout in log, got:\n%s", got)
+	}
+}
+
+func TestRun_FailFast(t *testing.T) {
+	td := t.TempDir()
+	cfgPath := filepath.Join(td, "confb.yaml")
+	writeFileT(t, filepath.Join(td, "b.txt"), "b\n")
+	good := filepath.Join(td, "b.out")
+	writeFileT(t, cfgPath, `
+version: 1
+targets:
+  - name: a
+    format: raw
+    output: `+quoteYAML(filepath.Join(td, "a.out"))+`
+    sources:
+      - path: `+quoteYAML(filepath.Join(td, "missing.txt"))+`
+  - name: b
+    format: raw
+    output: `+quoteYAML(good)+`
+    sources:
+      - path: `+quoteYAML(filepath.Join(td, "b.txt"))+`
+`)
+	cfg, err := config.Load(cfgPath)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+
+	if err := Run(cfg, Options{LogLevel: LogQuiet, ConfigPath: cfgPath, FailFast: true}); err == nil || !strings.Contains(err.Error(), "missing.txt") {
+		t.Fatalf("Run with FailFast = %v, want missing source error", err)
+	}
+	if _, err := os.Stat(good); !os.IsNotExist(err) {
+		t.Fatalf("FailFast built b after a failed (stat err = %v)", err)
+	}
+
+	// without it b is built and the daemon keeps running (until idle)
+	if err := Run(cfg, Options{LogLevel: LogQuiet, ConfigPath: cfgPath, IdleTimeout: 200 * time.Millisecond}); err != nil {
+		t.Fatalf("Run = %v, want nil", err)
+	}
+	if b, err := os.ReadFile(good); err != nil || string(b) != "b\n" {
+		t.Fatalf("b.out = %q, %v", b, err)
+	}
+}
+
+func TestRun_SIGHUPRebuildKeepsConfig(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("signals differ on Windows; skip daemon E2E")
+	}
+
+	td := t.TempDir()
+	writeFileT(t, filepath.Join(td, "src", "a.txt"), "a\n")
+	writeFileT(t, filepath.Join(td, "src", "b.txt"), "b\n")
+	writeFileT(t, filepath.Join(td, "src", "other.txt"), "other\n")
+	outA := filepath.Join(td, "out", "a.txt")
+	outB := filepath.Join(td, "out", "b.txt")
+	conf := func(aSrc, extra string) string {
+		return `
+version: 1` + extra + `
+targets:
+  - name: a
+    format: raw
+    output: ` + quoteYAML(outA) + `
+    sources:
+      - path: ` + quoteYAML(filepath.Join(td, "src", aSrc)) + `
+  - name: b
+    format: raw
+    output: ` + quoteYAML(outB) + `
+    sources:
+      - path: ` + quoteYAML(filepath.Join(td, "src", "b.txt")) + `
+`
+	}
+	cfgPath := filepath.Join(td, "confb.yaml")
+	writeFileT(t, cfgPath, conf("a.txt", ""))
+	cfg, err := config.Load(cfgPath)
+	if err != nil {
+		t.Fatalf("config.Load: %v", err)
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- Run(cfg, Options{LogLevel: LogQuiet, ConfigPath: cfgPath, SIGHUPAction: "rebuild"})
+	}()
+	read := func(p string) string {
+		b, _ := os.ReadFile(p)
+		return string(b)
+	}
+	waitUntil(t, 5*time.Second, func() bool { return read(outA) == "a\n" && read(outB) == "b\n" },
+		func() string { return fmt.Sprintf("initial outputs: %q, %q", read(outA), read(outB)) })
+
+	// a reload would now read other.txt (and choke on the unknown key); rebuild must not
+	writeFileT(t, cfgPath, conf("other.txt", "\nnot_a_confb_field: {"))
+	writeFileT(t, outA, "stale\n")
+	writeFileT(t, outB, "stale\n")
+
+	_ = syscall.Kill(os.Getpid(), syscall.SIGHUP)
+	waitUntil(t, 5*time.Second, func() bool { return read(outA) == "a\n" && read(outB) == "b\n" },
+		func() string { return fmt.Sprintf("after SIGHUP: %q, %q", read(outA), read(outB)) })
+
+	_ = syscall.Kill(os.Getpid(), syscall.SIGINT)
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Fatalf("daemon returned error on shutdown: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("daemon did not exit after SIGINT")
+	}
+}
+
+func TestRun_CreatesOnlyGlobParentDirs(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("signals differ on Windows; skip daemon E2E")
+	}
+
+	td := t.TempDir()
+	base := filepath.Join(td, "base.txt")
+	out := filepath.Join(td, "out.txt")
+	writeFileT(t, base, "base\n")
+
+	cfgPath := filepath.Join(td, "confb.yaml")
+	writeFileT(t, cfgPath, `
+version: 1
+targets:
+  - name: raw
+    format: raw
+    output: `+quoteYAML(out)+`
+    sources:
+      - path: `+quoteYAML(base)+`
+      - path: `+quoteYAML(filepath.Join(td, "missing", "plain.txt"))+`
+        optional: true
+      - path: `+quoteYAML(filepath.Join(td, "conf.d", "*.txt"))+`
+        optional: true
+`)
+	cfg, err := config.Load(cfgPath)
+	if err != nil {
+		t.Fatalf("config.Load: %v", err)
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- Run(cfg, Options{LogLevel: LogQuiet, Debounce: 20 * time.Millisecond, ConfigPath: cfgPath})
+	}()
+	waitUntil(t, 5*time.Second, func() bool {
+		b, err := os.ReadFile(out)
+		return err == nil && string(b) == "base\n"
+	}, func() string { return "initial build did not happen" })
+
+	if st, err := os.Stat(filepath.Join(td, "conf.d")); err != nil || !st.IsDir() {
+		t.Fatalf("glob parent conf.d not created: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(td, "missing")); !os.IsNotExist(err) {
+		t.Fatalf("daemon created the directory of a plain optional source (err=%v)", err)
+	}
+
+	writeFileT(t, filepath.Join(td, "conf.d", "x.txt"), "x\n")
+	waitUntil(t, 5*time.Second, func() bool {
+		b, _ := os.ReadFile(out)
+		return string(b) == "base\nx\n"
+	}, func() string { b, _ := os.ReadFile(out); return "glob file not picked up; out=" + string(b) })
+
+	_ = syscall.Kill(os.Getpid(), syscall.SIGINT)
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Fatalf("daemon returned error on shutdown: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("daemon did not exit after SIGINT")
+	}
+}
+
+func TestHookQueue_RunsInOrderOneAtATime(t *testing.T) {
+	q := newHookQueue()
+	var mu sync.Mutex
+	var order []int
+	var running, overlap atomic.Int32
+	for i := 0; i < 50; i++ {
+		q.enqueue("app", func() {
+			if running.Add(1) > 1 {
+				overlap.Add(1)
+			}
+			time.Sleep(100 * time.Microsecond)
+			mu.Lock()
+			order = append(order, i)
+			mu.Unlock()
+			running.Add(-1)
+		})
+	}
+	// another target's queue is independent and does not wait for "app"
+	other := make(chan struct{})
+	q.enqueue("other", func() { close(other) })
+	select {
+	case <-other:
+	case <-time.After(5 * time.Second):
+		t.Fatal("hook for another target did not run")
+	}
+	q.wait()
+
+	if overlap.Load() != 0 {
+		t.Fatalf("%d hooks for the same target overlapped", overlap.Load())
+	}
+	for i, v := range order {
+		if v != i {
+			t.Fatalf("hooks ran out of order: %v", order)
+		}
+	}
+	if len(order) != 50 {
+		t.Fatalf("ran %d hooks, want 50", len(order))
+	}
+}
@@ -0,0 +1,67 @@
+package daemon
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+)
+
+// fifoStatus is the line written to the status FIFO after every build cycle.
+type fifoStatus struct {
+	Targets int    `json:"targets"`
+	Built   int    `json:"built"`
+	Errors  int    `json:"errors"`
+	TS      string `json:"ts"`
+}
+
+// ensureFIFO creates a named pipe at path unless one already exists there.
+func ensureFIFO(path string) error {
+	st, err := os.Stat(path)
+	if err == nil {
+		if st.Mode()&os.ModeNamedPipe == 0 {
+			return fmt.Errorf("status fifo %s exists and is not a named pipe", path)
+		}
+		return nil
+	}
+	if !os.IsNotExist(err) {
+		return fmt.Errorf("status fifo: %w", err)
+	}
+	if err := syscall.Mkfifo(path, 0o644); err != nil {
+		return fmt.Errorf("status fifo %s: %w", path, err)
+	}
+	return nil
+}
+
+// writeStatusFIFO writes one JSON line for the cycle to the FIFO at path. The
+// pipe is opened non-blocking, so without a reader the line is dropped.
+func writeStatusFIFO(path string, targets int, c *buildCycle, logf func(LogLevel, string)) {
+	if path == "" || c.empty() {
+		return
+	}
+	line, err := json.Marshal(fifoStatus{
+		Targets: targets,
+		Built:   len(c.Targets),
+		Errors:  len(c.Errors),
+		TS:      time.Now().UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		logf(LogNormal, fmt.Sprintf("status fifo: %v", err))
+		return
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		if errors.Is(err, syscall.ENXIO) {
+			logf(LogVerbose, "status fifo: no reader, skipped")
+		} else {
+			logf(LogNormal, fmt.Sprintf("status fifo: %v", err))
+		}
+		return
+	}
+	defer f.Close()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		logf(LogVerbose, fmt.Sprintf("status fifo: %v", err))
+	}
+}
@@ -0,0 +1,133 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// healthState tracks what the healthcheck endpoints report: whether the initial
+// build finished and the outcome of each target's last build.
+type healthState struct {
+	mu      sync.Mutex
+	ready   bool
+	targets map[string]targetHealth
+}
+
+type targetHealth struct {
+	Name   string    `json:"name"`
+	Status string    `json:"status"` // ok | error
+	Error  string    `json:"error,omitempty"`
+	Time   time.Time `json:"time"`
+}
+
+func newHealthState() *healthState {
+	return &healthState{targets: map[string]targetHealth{}}
+}
+
+// record stores the result of a target's latest build.
+func (h *healthState) record(target string, at time.Time, err error) {
+	th := targetHealth{Name: target, Status: "ok", Time: at}
+	if err != nil {
+		th.Status = "error"
+		th.Error = err.Error()
+	}
+	h.mu.Lock()
+	h.targets[target] = th
+	h.mu.Unlock()
+}
+
+// retain drops targets not in names (after a reload removed them).
+func (h *healthState) retain(names []string) {
+	keep := map[string]struct{}{}
+	for _, n := range names {
+		keep[n] = struct{}{}
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for n := range h.targets {
+		if _, ok := keep[n]; !ok {
+			delete(h.targets, n)
+		}
+	}
+}
+
+func (h *healthState) setReady() {
+	h.mu.Lock()
+	h.ready = true
+	h.mu.Unlock()
+}
+
+// snapshot returns the targets sorted by name.
+func (h *healthState) snapshot() (bool, []targetHealth) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	out := make([]targetHealth, 0, len(h.targets))
+	for _, th := range h.targets {
+		out = append(out, th)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return h.ready, out
+}
+
+// handler serves /healthz (503 while any target's last build failed), /readyz
+// (503 until the initial build succeeded) and /targets (JSON status per target).
+func (h *healthState) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, _ *http.Request) {
+		_, targets := h.snapshot()
+		for _, th := range targets {
+			if th.Status == "error" {
+				http.Error(w, fmt.Sprintf("target %s: %s", th.Name, th.Error), http.StatusServiceUnavailable)
+				return
+			}
+		}
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, _ *http.Request) {
+		if ready, _ := h.snapshot(); !ready {
+			http.Error(w, "initial build not finished", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("GET /targets", func(w http.ResponseWriter, _ *http.Request) {
+		_, targets := h.snapshot()
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(targets)
+	})
+	return mux
+}
+
+// serveHealth starts the healthcheck server on addr; stop shuts it down.
+func serveHealth(addr string, h *healthState) (stop func(timeout time.Duration) error, err error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("healthcheck listen %q: %w", addr, err)
+	}
+	srv := &http.Server{Handler: h.handler(), ReadHeaderTimeout: 5 * time.Second}
+
+	done := make(chan error, 1)
+	go func() {
+		err := srv.Serve(ln)
+		if errors.Is(err, http.ErrServerClosed) {
+			err = nil
+		}
+		done <- err
+	}()
+
+	return func(timeout time.Duration) error {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			return err
+		}
+		return <-done
+	}, nil
+}
@@ -0,0 +1,118 @@
+package daemon
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	executor "github.com/nekwebdev/confb/internal/exec"
+	"github.com/nekwebdev/confb/internal/plan"
+)
+
+// lockInfo is the JSON payload of <watchdir>/.confb.lock.
+type lockInfo struct {
+	PID     int    `json:"pid"`
+	Config  string `json:"config"`
+	Started string `json:"started"`
+}
+
+// dirLocks tracks the lock files this daemon owns.
+type dirLocks struct {
+	info lockInfo
+	held map[string]struct{} // lock file paths
+}
+
+func newDirLocks(configPath string) *dirLocks {
+	abs, err := filepath.Abs(configPath)
+	if err != nil {
+		abs = configPath
+	}
+	return &dirLocks{
+		info: lockInfo{PID: os.Getpid(), Config: abs, Started: time.Now().Format(time.RFC3339)},
+		held: map[string]struct{}{},
+	}
+}
+
+// acquire writes a lock file into every dir not already held. If another live
+// daemon owns one, it waits up to timeout for it to go away, then gives up.
+// On error, locks taken by this call are released again.
+func (l *dirLocks) acquire(dirs map[string]struct{}, timeout time.Duration) error {
+	payload, err := json.Marshal(l.info)
+	if err != nil {
+		return err
+	}
+	deadline := time.Now().Add(timeout)
+	var taken []string
+	for d := range dirs {
+		p := filepath.Join(d, plan.LockFileName)
+		if _, ok := l.held[p]; ok {
+			continue
+		}
+		for {
+			other, alive := readLiveLock(p)
+			if !alive {
+				break
+			}
+			if timeout <= 0 || time.Now().After(deadline) {
+				l.drop(taken)
+				return fmt.Errorf("%s is held by running confb (pid %d, config %s)", p, other.PID, other.Config)
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+		if err := os.MkdirAll(d, 0o755); err != nil {
+			l.drop(taken)
+			return fmt.Errorf("mkdir %q: %w", d, err)
+		}
+		if err := executor.WriteAtomic(p, string(payload)+"\n"); err != nil {
+			l.drop(taken)
+			return fmt.Errorf("write lock: %w", err)
+		}
+		l.held[p] = struct{}{}
+		taken = append(taken, p)
+	}
+	return nil
+}
+
+// release removes every lock file this daemon wrote.
+func (l *dirLocks) release() {
+	for p := range l.held {
+		_ = os.Remove(p)
+	}
+	l.held = map[string]struct{}{}
+}
+
+// retain releases held locks whose directory is not in dirs (e.g. after a reload).
+func (l *dirLocks) retain(dirs map[string]struct{}) {
+	for p := range l.held {
+		if _, ok := dirs[filepath.Dir(p)]; !ok {
+			_ = os.Remove(p)
+			delete(l.held, p)
+		}
+	}
+}
+
+func (l *dirLocks) drop(paths []string) {
+	for _, p := range paths {
+		_ = os.Remove(p)
+		delete(l.held, p)
+	}
+}
+
+// readLiveLock reports the lock at p and whether its recorded PID is still running.
+// Missing, unreadable or stale lock files count as free.
+func readLiveLock(p string) (lockInfo, bool) {
+	var li lockInfo
+	b, err := os.ReadFile(p)
+	if err != nil {
+		return li, false
+	}
+	if err := json.Unmarshal(b, &li); err != nil || li.PID <= 0 {
+		return li, false
+	}
+	err = syscall.Kill(li.PID, 0)
+	return li, err == nil || errors.Is(err, syscall.EPERM)
+}
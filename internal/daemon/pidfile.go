@@ -0,0 +1,91 @@
+package daemon
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// pidFile is a PID file held under an exclusive flock for the daemon's
+// lifetime, so two daemons started at once cannot both claim it.
+type pidFile struct {
+	path string
+	f    *os.File
+}
+
+// acquirePIDFile opens (or creates) path, takes an exclusive flock on it,
+// waiting up to timeout for another holder, and writes our PID into it.
+func acquirePIDFile(path string, timeout time.Duration) (*pidFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("pid file: %w", err)
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("pid file: %w", err)
+		}
+		err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			// the previous holder may have removed the file between our open
+			// and our flock; then we locked an orphaned inode, so start over
+			if sameFile(f, path) {
+				return writePID(path, f)
+			}
+			_ = f.Close()
+			continue
+		}
+		_ = f.Close()
+		if !errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, fmt.Errorf("pid file: flock %s: %w", path, err)
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("pid file %s is locked by another confb (pid %s); gave up after %s", path, readPIDText(path), timeout)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func writePID(path string, f *os.File) (*pidFile, error) {
+	err := f.Truncate(0)
+	if err == nil {
+		_, err = f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("pid file: write %s: %w", path, err)
+	}
+	return &pidFile{path: path, f: f}, nil
+}
+
+// release removes the PID file, then drops the lock.
+func (p *pidFile) release() {
+	_ = os.Remove(p.path)
+	_ = p.f.Close()
+}
+
+func sameFile(f *os.File, path string) bool {
+	a, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	b, err := os.Stat(path)
+	return err == nil && os.SameFile(a, b)
+}
+
+// readPIDText returns the PID recorded at path for error messages, or "?".
+func readPIDText(path string) string {
+	b, err := os.ReadFile(path)
+	if s := strings.TrimSpace(string(b)); err == nil && s != "" {
+		return s
+	}
+	return "?"
+}
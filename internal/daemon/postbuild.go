@@ -0,0 +1,95 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nekwebdev/confb/internal/config"
+)
+
+// defaultPostBuildTimeout applies when post_build_timeout_s is unset.
+const defaultPostBuildTimeout = 30 * time.Second
+
+// RunPostBuild runs the config's post_build command once for a finished batch
+// of builds; built lists the targets written in it. Template variables:
+// {built_count}, {targets} (comma-separated) and {timestamp}; the hook also
+// sees them as CONFB_BUILT_COUNT, CONFB_TARGETS and CONFB_TIMESTAMP, plus
+// CONFB_BUILD_ID. It is a no-op without post_build.
+func RunPostBuild(c *config.Config, built []string, buildID string) error {
+	cmdTmpl := strings.TrimSpace(c.PostBuild)
+	if cmdTmpl == "" {
+		return nil
+	}
+	count := strconv.Itoa(len(built))
+	targets := strings.Join(built, ",")
+	ts := time.Now().Format(time.RFC3339)
+
+	cmdStr := cmdTmpl
+	cmdStr = strings.ReplaceAll(cmdStr, "{built_count}", count)
+	cmdStr = strings.ReplaceAll(cmdStr, "{targets}", targets)
+	cmdStr = strings.ReplaceAll(cmdStr, "{timestamp}", ts)
+
+	timeout := defaultPostBuildTimeout
+	if c.PostBuildTimeoutS > 0 {
+		timeout = time.Duration(c.PostBuildTimeoutS) * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", cmdStr)
+	cmd.Env = append(os.Environ(),
+		"CONFB_BUILD_ID="+buildID,
+		"CONFB_BUILT_COUNT="+count,
+		"CONFB_TARGETS="+targets,
+		"CONFB_TIMESTAMP="+ts,
+	)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("post_build: %w", err)
+	}
+	return nil
+}
+
+// RunGlobalOnChange runs the config-wide on_change for targets the daemon just
+// rebuilt (one target, or a whole batch with --batch-on-change). Template
+// variables: {changed_targets} (comma-separated) and {timestamp}, also exported
+// as CONFB_CHANGED_TARGETS and CONFB_TIMESTAMP, plus CONFB_BUILD_ID. It uses
+// default_on_change_timeout_s (20s if unset) and is a no-op without on_change.
+func RunGlobalOnChange(c *config.Config, changed []string, buildID string) error {
+	cmdTmpl := strings.TrimSpace(c.OnChange)
+	if cmdTmpl == "" {
+		return nil
+	}
+	targets := strings.Join(changed, ",")
+	ts := time.Now().Format(time.RFC3339)
+
+	cmdStr := cmdTmpl
+	cmdStr = strings.ReplaceAll(cmdStr, "{changed_targets}", targets)
+	cmdStr = strings.ReplaceAll(cmdStr, "{timestamp}", ts)
+
+	timeout := defaultOnChangeTimeout
+	if c.DefaultOnChangeTimeoutS > 0 {
+		timeout = time.Duration(c.DefaultOnChangeTimeoutS) * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", cmdStr)
+	cmd.Env = append(os.Environ(),
+		"CONFB_BUILD_ID="+buildID,
+		"CONFB_CHANGED_TARGETS="+targets,
+		"CONFB_TIMESTAMP="+ts,
+	)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("on_change: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package daemon
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"runtime/debug"
+	"syscall"
+	"time"
+
+	"github.com/nekwebdev/confb/internal/config"
+)
+
+// restart backoff: restartBackoff doubles per consecutive failure up to
+// Options.RestartBackoffMax (default restartBackoffMax); a run lasting
+// restartResetAfter starts over at the base.
+var (
+	restartBackoff    = time.Second
+	restartBackoffMax = 60 * time.Second
+	restartResetAfter = 5 * time.Minute
+)
+
+const defaultMaxRestarts = 5
+
+// panicError is a panic recovered from the daemon, with the goroutine's stack.
+type panicError struct {
+	value any
+	stack []byte
+}
+
+func (e *panicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.value)
+}
+
+// RestartLimitError is returned by Run when AutoRestart gave up after
+// MaxRestarts consecutive panics. The CLI exits with ExitCode (2) for it.
+type RestartLimitError struct {
+	Restarts int
+	Err      error // the last panic
+}
+
+func (e *RestartLimitError) Error() string {
+	return fmt.Sprintf("%v; giving up after %d restarts", e.Err, e.Restarts)
+}
+
+func (e *RestartLimitError) Unwrap() error { return e.Err }
+
+// ExitCode distinguishes a restart loop from ordinary failures (exit 1).
+func (e *RestartLimitError) ExitCode() int { return 2 }
+
+// runWithRestarts runs the daemon until it exits normally, returns an error, or
+// panics more than MaxRestarts times in a row without a long-enough run.
+func runWithRestarts(cfg *config.Config, opts Options) error {
+	maxRestarts := opts.MaxRestarts
+	if maxRestarts <= 0 {
+		maxRestarts = defaultMaxRestarts
+	}
+	backoffMax := opts.RestartBackoffMax
+	if backoffMax <= 0 {
+		backoffMax = restartBackoffMax
+	}
+	logf := func(format string, args ...any) {
+		if opts.LogLevel >= LogNormal {
+			logLine(LogNormal, opts.Color, "", fmt.Sprintf(format, args...))
+		}
+	}
+	failures := 0
+	for {
+		started := time.Now()
+		err := runRecovered(cfg, opts)
+		perr, ok := err.(*panicError)
+		if !ok {
+			return err
+		}
+		if opts.LogLevel >= LogVerbose {
+			logLine(LogVerbose, opts.Color, "", fmt.Sprintf("%v\n%s", perr, perr.stack))
+		}
+		if time.Since(started) >= restartResetAfter {
+			failures = 0
+		}
+		failures++
+		if failures > maxRestarts {
+			lerr := &RestartLimitError{Restarts: maxRestarts, Err: perr}
+			logf("%v", lerr)
+			return lerr
+		}
+
+		wait := restartBackoff
+		for i := 1; i < failures && wait < backoffMax; i++ {
+			wait *= 2
+		}
+		wait = min(wait, backoffMax)
+		logf("restart attempt %d of %d in %s after %v", failures, maxRestarts, wait, perr)
+		if interrupted(wait) {
+			logf("received signal, exiting")
+			return nil
+		}
+
+		// pick up config fixes; keep the previous config if it does not load
+		if opts.ConfigPath != "" {
+			if c, err := config.Load(opts.ConfigPath, opts.ExtraConfigs...); err == nil {
+				cfg = c
+			} else {
+				logf("reload error: %v (keeping old config)", err)
+			}
+		}
+	}
+}
+
+// runRecovered is run with panics in the calling goroutine turned into errors.
+func runRecovered(cfg *config.Config, opts Options) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = &panicError{value: p, stack: debug.Stack()}
+		}
+	}()
+	return run(cfg, opts, make(chan *panicError, 1))
+}
+
+// interrupted waits d and reports whether SIGINT/SIGTERM arrived meanwhile.
+func interrupted(d time.Duration) bool {
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigc)
+	select {
+	case <-sigc:
+		return true
+	case <-time.After(d):
+		return false
+	}
+}
@@ -1,14 +1,19 @@
 package daemon
 
 import (
+	"bytes"
 	"context"
+	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"os/signal"
 	"path/filepath"
+	"runtime/debug"
+	"slices"
 	"strings"
 	"sync"
 	"syscall"
@@ -19,6 +24,7 @@
 	"github.com/nekwebdev/confb/internal/blend"
 	"github.com/nekwebdev/confb/internal/config"
 	executor "github.com/nekwebdev/confb/internal/exec"
+	"github.com/nekwebdev/confb/internal/metrics"
 	"github.com/nekwebdev/confb/internal/plan"
 )
 
@@ -36,12 +42,231 @@ type Options struct {
 	Debounce   time.Duration
 	ConfigPath string // ABS or relative; used for SIGHUP reload
 	Color      bool   // enable ANSI color for level tags
+
+	// ExtraConfigs are merged over ConfigPath on every reload (see config.Load).
+	ExtraConfigs []string
+
+	// GracePeriod delays the first watch-triggered rebuild after startup: events
+	// arriving during it are buffered, then replayed through the debounce.
+	GracePeriod time.Duration
+
+	// WriteLockFiles writes <watchdir>/.confb.lock while running and refuses to
+	// start if another live daemon holds one; LockTimeout waits for it instead.
+	WriteLockFiles bool
+	LockTimeout    time.Duration
+
+	// PIDFile, when set, is written with the daemon's PID and held under an
+	// exclusive flock until Run returns; PIDLockTimeout bounds the wait for
+	// another daemon holding it (0 = fail immediately).
+	PIDFile        string
+	PIDLockTimeout time.Duration
+
+	// VerifyWrite reads every output back after writing and compares checksums.
+	VerifyWrite bool
+
+	// VerifyOutput re-parses every output in its format after writing, as if
+	// each target set verify_output.
+	VerifyOutput bool
+
+	// TargetDebounce overrides the debounce per target name; it wins over the
+	// target's debounce_ms, which wins over Debounce.
+	TargetDebounce map[string]time.Duration
+
+	// StateFile, when set, receives the last HistoryDepth build records per
+	// target (default DefaultHistoryDepth) after every build; see `confb status`.
+	StateFile    string
+	HistoryDepth int
+
+	// NoResume rewrites every output at startup. By default a target whose
+	// freshly built checksum matches both the state file's last checksum and
+	// the output on disk is not rewritten and its on_change does not run.
+	NoResume bool
+
+	// MetricsAddr, when set (e.g. ":9095"), serves Prometheus metrics on GET /metrics.
+	MetricsAddr string
+
+	// MaxConcurrentFlushes caps how many debounced rebuilds (plan, merge, write
+	// and synchronous on_change) run at once; 0 means no limit.
+	MaxConcurrentFlushes int
+
+	// HealthcheckAddr, when set (e.g. ":8080"), serves GET /healthz, /readyz and
+	// /targets once the initial build is done.
+	HealthcheckAddr string
+
+	// ReadRetryAttempts and ReadRetryBackoff retry source reads that fail with a
+	// transient error (EAGAIN, EINTR, EIO); defaults 3 attempts, 50ms apart.
+	ReadRetryAttempts int
+	ReadRetryBackoff  time.Duration
+
+	// WatchdogInterval, when non-zero, periodically rehashes every target's
+	// source files and schedules a rebuild (through the debounce) for any that
+	// changed without a watch event, e.g. on NFS or Docker volume mounts.
+	WatchdogInterval time.Duration
+
+	// WebhookURL overrides the config's webhook.url (notified after every build cycle).
+	WebhookURL string
+
+	// StatusFIFO, when set, is a named pipe (created if missing) that receives
+	// one JSON line {"targets","built","errors","ts"} after every build cycle;
+	// the line is dropped when no reader has the pipe open.
+	StatusFIFO string
+
+	// ExitOnEmptySourceSet makes Run return nil (instead of an error) when a
+	// target resolves no files at startup because all its sources are optional.
+	ExitOnEmptySourceSet bool
+
+	// GracefulDrain makes SIGINT/SIGTERM drop pending rebuilds but wait for
+	// running ones and their on_change hooks (at most DrainTimeout, default 30s).
+	GracefulDrain bool
+	DrainTimeout  time.Duration
+
+	// AutoRestart recovers a panic (in the initial build or a debounced rebuild)
+	// and starts the daemon again after an exponential backoff (1s, 2s, 4s, …
+	// capped at RestartBackoffMax, default 60s). A run that lasted 5 minutes
+	// resets the backoff; after MaxRestarts (default 5) consecutive shorter
+	// runs, Run returns a *RestartLimitError wrapping the panic.
+	AutoRestart       bool
+	MaxRestarts       int
+	RestartBackoffMax time.Duration
+
+	// IdleTimeout, when non-zero, makes Run return nil once no watch event has
+	// touched a target for that long (and no rebuild is pending or running).
+	IdleTimeout time.Duration
+
+	// FailFast makes a target that fails to build at startup (or on reload)
+	// fail the whole build, as before. Without it the other targets are still
+	// built and the failed one stays watched; only a build where every target
+	// fails is an error.
+	FailFast bool
+
+	// BatchOnChange runs the config-wide on_change once per rebuild batch (after
+	// every target in it has finished) with {changed_targets} listing them all,
+	// instead of once per rebuilt target. Per-target on_change is unaffected.
+	BatchOnChange bool
+
+	// SIGHUPAction is what SIGHUP does: "reload" (default) re-reads the config
+	// and rebuilds every target with it; "rebuild" rebuilds every target with
+	// the config already loaded, without reading the file; "both" reloads, and
+	// if the new config can't be loaded rebuilds with the current one instead.
+	SIGHUPAction string
+
+	// WriteMode is how outputs are written: "atomic" (default), "copy" or
+	// "in-place" (see exec.WriteWithMode).
+	WriteMode string
+}
+
+// dropFSEvents is a test seam: when set, the event loop ignores watcher events.
+var dropFSEvents bool
+
+// beforeBuild is a test seam: when set, it is called before every target build.
+var beforeBuild func(t config.Target)
+
+// defaultOnChangeTimeout bounds a synchronous on_change hook unless the target
+// sets on_change_timeout_s.
+const defaultOnChangeTimeout = 20 * time.Second
+
+// source read retry defaults for the daemon
+const (
+	defaultReadRetryAttempts = 3
+	defaultReadRetryBackoff  = 50 * time.Millisecond
+)
+
+// metric names exported by the daemon (all labelled by target)
+const (
+	metricRebuilds        = "confb_rebuilds_total"
+	metricRebuildErrors   = "confb_rebuild_errors_total"
+	metricOnChangeRuns    = "confb_on_change_runs_total"
+	metricLastRebuild     = "confb_last_rebuild_timestamp_seconds"
+	metricSourceFileCount = "confb_source_files_count"
+)
+
+func newRegistry() *metrics.Registry {
+	r := metrics.New()
+	r.Counter(metricRebuilds, "Outputs written, including the initial build.")
+	r.Counter(metricRebuildErrors, "Rebuilds that failed to plan, merge or write.")
+	r.Counter(metricOnChangeRuns, "on_change hook runs started.")
+	r.Gauge(metricLastRebuild, "Unix time of the last successful write.")
+	r.Gauge(metricSourceFileCount, "Source files resolved by the last plan.")
+	return r
 }
 
 type tstate struct {
 	target   config.Target
 	lastSum  string              // SHA256 hex of *final output content*
+	srcSum   string              // sourceSum when last built (watchdog only)
 	watchSet map[string]struct{} // dirs to watch
+
+	hooks *hookQueue // async on_change runs, shared across reloads
+
+	reg *metrics.Registry
+}
+
+// hookQueue runs async on_change hooks one at a time per target name, in the
+// order they were queued. It lives for the whole daemon run, so a hook queued
+// before a reload still runs before (never alongside) one queued after it.
+type hookQueue struct {
+	mu      sync.Mutex
+	pending map[string][]func()
+	running map[string]bool
+	wg      sync.WaitGroup // queued and running hooks
+}
+
+func newHookQueue() *hookQueue {
+	return &hookQueue{pending: map[string][]func(){}, running: map[string]bool{}}
+}
+
+// enqueue queues fn behind name's earlier hooks, starting a worker for name
+// if none is running. It never blocks and never drops a hook.
+func (q *hookQueue) enqueue(name string, fn func()) {
+	q.wg.Add(1)
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.pending[name] = append(q.pending[name], fn)
+	if q.running[name] {
+		return
+	}
+	q.running[name] = true
+	go q.work(name)
+}
+
+// work runs name's queued hooks until its queue is empty.
+func (q *hookQueue) work(name string) {
+	for {
+		q.mu.Lock()
+		list := q.pending[name]
+		if len(list) == 0 {
+			delete(q.pending, name)
+			delete(q.running, name)
+			q.mu.Unlock()
+			return
+		}
+		fn := list[0]
+		q.pending[name] = list[1:]
+		q.mu.Unlock()
+
+		fn()
+		q.wg.Done()
+	}
+}
+
+// wait blocks until every queued hook has run.
+func (q *hookQueue) wait() { q.wg.Wait() }
+
+// fireOnChange runs the target's on_change hook. With on_change_async the hook runs
+// in the background; runs for the same target are queued in order, never dropped.
+func (st *tstate) fireOnChange(outputPath, buildID string, logf func(LogLevel, string), level LogLevel) {
+	t := st.target
+	if strings.TrimSpace(t.OnChange) == "" {
+		return
+	}
+	st.reg.Inc(metricOnChangeRuns, "target", t.Name)
+	if !t.OnChangeAsync {
+		runOnChange(t, outputPath, buildID, logf, level)
+		return
+	}
+	st.hooks.enqueue(t.Name, func() {
+		runOnChange(t, outputPath, buildID, logf, level)
+	})
 }
 
 // --- logging helpers ---
@@ -71,10 +296,44 @@ func logLine(level LogLevel, color bool, target, msg string) {
 	}
 }
 
+// Run builds every target, then watches their sources and rebuilds on change
+// until SIGINT/SIGTERM. With AutoRestart, a panic restarts it (see runWithRestarts).
 func Run(cfg *config.Config, opts Options) error {
+	switch opts.SIGHUPAction {
+	case "", "reload", "rebuild", "both":
+	default:
+		return fmt.Errorf("SIGHUP action must be reload|rebuild|both (got %q)", opts.SIGHUPAction)
+	}
+	if !executor.ValidWriteMode(opts.WriteMode) {
+		return fmt.Errorf("write mode must be atomic|copy|in-place (got %q)", opts.WriteMode)
+	}
+	if opts.PIDFile != "" {
+		pf, err := acquirePIDFile(opts.PIDFile, opts.PIDLockTimeout)
+		if err != nil {
+			return err
+		}
+		defer pf.release()
+	}
+	defer func() { _ = plan.RemoveInlineDir() }()
+	if opts.AutoRestart {
+		return runWithRestarts(cfg, opts)
+	}
+	return run(cfg, opts, nil)
+}
+
+// run is one daemon lifetime. When panics is non-nil, a panic in a debounced
+// rebuild is recovered and sent there, and run returns it as a *panicError.
+func run(cfg *config.Config, opts Options, panics chan *panicError) error {
 	if opts.Debounce <= 0 {
 		opts.Debounce = 200 * time.Millisecond
 	}
+	if opts.ReadRetryAttempts <= 0 {
+		opts.ReadRetryAttempts = defaultReadRetryAttempts
+	}
+	if opts.ReadRetryBackoff <= 0 {
+		opts.ReadRetryBackoff = defaultReadRetryBackoff
+	}
+	retry := executor.ReadRetry{Attempts: opts.ReadRetryAttempts, Backoff: opts.ReadRetryBackoff}
 
   // logf(level, target, "fmt %s", args...)
   logf := func(level LogLevel, target, format string, args ...any) {
@@ -83,38 +342,193 @@ func Run(cfg *config.Config, opts Options) error {
 	  }
   }
 
+	reg := newRegistry()
+	health := newHealthState()
+	hooks := newHookQueue()
+
+	var history *stateRecorder
+	var resumeSums map[string]string
+	if opts.StateFile != "" {
+		if prev, err := ReadState(opts.StateFile); err == nil && !opts.NoResume {
+			resumeSums = prev.LastSums()
+		}
+		history = newStateRecorder(opts.StateFile, opts.ConfigPath, opts.HistoryDepth)
+	}
+	// sendWebhook posts a finished cycle to the webhook (--webhook-url wins over c's)
+	sendWebhook := func(c *config.Config, cycle *buildCycle) {
+		url, method := opts.WebhookURL, "POST"
+		if c.Webhook != nil {
+			if url == "" {
+				url = c.Webhook.URL
+			}
+			method = c.Webhook.Method
+		}
+		postWebhook(url, method, cycle, func(level LogLevel, msg string) {
+			logf(level, "", "%s", msg)
+		})
+		writeStatusFIFO(opts.StatusFIFO, len(c.Targets), cycle, func(level LogLevel, msg string) {
+			logf(level, "", "%s", msg)
+		})
+	}
+
+	// recordBuild appends a build record for t to the state file (if enabled)
+	// and to the cycle reported to the webhook
+	recordBuild := func(cycle *buildCycle, t config.Target, started time.Time, before, after string, files []string, err error) {
+		target := t.Name
+		cycle.add(target, t.Output, after, time.Since(started), err)
+		health.record(target, started, err)
+		rec := BuildRecord{
+			BuildID:    cycle.BuildID,
+			Time:       started,
+			DurationMS: time.Since(started).Milliseconds(),
+			SumBefore:  before,
+			SumAfter:   after,
+			Files:      files,
+			Error:      errString(err),
+		}
+		if rec.Files == nil {
+			rec.Files = []string{}
+		}
+		if werr := history.record(target, rec); werr != nil {
+			logf(LogNormal, target, "state file: %v", werr)
+		}
+	}
+
 	// ---- helper closures ----
 
-	buildStates := func(c *config.Config) ([]*tstate, error) {
-		states := make([]*tstate, 0, len(c.Targets))
-		for i := range c.Targets {
-			t := c.Targets[i]
+	writeOut := func(t config.Target, rt *plan.ResolvedTarget, content string, merged bool) error {
+		wo := executor.WriteOptions{Verify: opts.VerifyWrite, Encodings: rt.Encodings, Retry: retry, Mtime: plan.OutputMtime(t, rt), PostProcess: t.PostProcess, Transforms: rt.Transforms, Target: t.Name, Mode: opts.WriteMode}
+		if opts.VerifyOutput || t.VerifyOutput {
+			format := t.Format
+			wo.Parse = func(b []byte) error { return blend.VerifyOutput(format, b) }
+		}
+		var err error
+		if merged {
+			err = executor.WriteWith(rt.Output, content, wo)
+		} else {
+			err = executor.BuildAndWriteWith(rt.Output, rt.Files, wo)
+		}
+		if err == nil {
+			reg.Inc(metricRebuilds, "target", rt.Name)
+			reg.Set(metricLastRebuild, float64(time.Now().Unix()), "target", rt.Name)
+		}
+		return err
+	}
 
-			rt, err := plan.PlanTarget(c, t, "")
-			if err != nil {
-				return nil, err
-			}
+	// globalOnChange runs the config-wide on_change for changed targets
+	globalOnChange := func(c *config.Config, changed []string, buildID string) {
+		if c.OnChange == "" || len(changed) == 0 {
+			return
+		}
+		logf(LogVerbose, "", "running on_change (%s)", strings.Join(changed, ","))
+		if err := RunGlobalOnChange(c, changed, buildID); err != nil {
+			logf(LogNormal, "", "%v", err)
+		}
+	}
 
-			content, checksum, merged, err := buildContentAndChecksum(t, rt.Files)
-			if err != nil {
-				return nil, fmt.Errorf("initial build %q: %w", t.Name, err)
+	// postBuild runs the config's post_build hook for a batch that wrote targets
+	postBuild := func(c *config.Config, built []string, buildID string) {
+		if c.PostBuild == "" || len(built) == 0 {
+			return
+		}
+		logf(LogNormal, "", "running post_build (%d targets)", len(built))
+		if err := RunPostBuild(c, built, buildID); err != nil {
+			logf(LogNormal, "", "%v", err)
+		}
+	}
+
+	// unchangedOnDisk reports whether output already holds content with checksum sum
+	unchangedOnDisk := func(output, sum string) bool {
+		b, err := executor.ReadOutput(output)
+		return err == nil && sha256Hex(string(b)) == sum
+	}
+
+	// buildStates builds every target of c; resume (startup only) maps target
+	// names to checksums persisted by a previous run
+	buildStates := func(c *config.Config, resume map[string]string) ([]*tstate, error) {
+		ordered, err := c.BuildOrder()
+		if err != nil {
+			return nil, err
+		}
+		debounce := map[string]int64{}
+		for _, t := range c.Targets {
+			debounce[t.Name] = debounceFor(t, opts).Milliseconds()
+		}
+		history.setDebounce(debounce)
+		// enabled_if is checked when the config is (re)loaded; a disabled target is not watched
+		var enabled []config.Target
+		for _, t := range ordered {
+			if !t.Enabled() {
+				logf(LogNormal, t.Name, "skipped (enabled_if %s)", strings.Join(t.EnabledIf, ", "))
+				continue
+			}
+			enabled = append(enabled, t)
+		}
+		ordered = enabled
+		buildID := NewBuildID()
+		logf(LogVerbose, "", "build %s", buildID)
+		cycle := newBuildCycle(buildID)
+		defer sendWebhook(c, cycle)
+		states := make([]*tstate, 0, len(ordered))
+		var built []string
+		var failed []error
+		// keepFailed (without FailFast) logs a target's build error and still
+		// watches it, with no checksum, so the next change retries it
+		var srcSum string // of the target being built
+		keepFailed := func(t config.Target, err error) error {
+			if opts.FailFast || (opts.ExitOnEmptySourceSet && errors.Is(err, plan.ErrEmptySourceSet)) {
+				return err
+			}
+			ws, werr := computeWatchDirs(c, t)
+			if werr != nil {
+				return err
+			}
+			logf(LogNormal, t.Name, "build error: %v", err)
+			failed = append(failed, err)
+			states = append(states, &tstate{target: t, srcSum: srcSum, watchSet: ws, hooks: hooks, reg: reg})
+			return nil
+		}
+		for _, t := range ordered {
+			started := time.Now()
+			if opts.WatchdogInterval > 0 {
+				srcSum = sourceSum(c, t, retry)
 			}
 
-			if merged {
-				if err := executor.WriteAtomic(rt.Output, content); err != nil {
+			rt, err := plan.PlanTarget(c, t, "")
+			if err != nil {
+				recordBuild(cycle, t, started, "", "", nil, err)
+				if err := keepFailed(t, err); err != nil {
 					return nil, err
 				}
-			} else {
-				if err := executor.BuildAndWrite(rt.Output, rt.Files); err != nil {
+				continue
+			}
+			reg.Set(metricSourceFileCount, float64(len(rt.Files)), "target", t.Name)
+			logf(LogVerbose, t.Name, "newest source mtime %s", rt.NewestSourceMtime.Format(time.RFC3339))
+
+			content, checksum, merged, err := buildContentAndChecksum(t, rt, retry)
+			if err != nil {
+				recordBuild(cycle, t, started, "", "", rt.Files, err)
+				if err := keepFailed(t, fmt.Errorf("initial build %q: %w", t.Name, err)); err != nil {
 					return nil, err
 				}
+				continue
 			}
-			logf(LogNormal, t.Name, "wrote %s", rt.Output)
 
-			if strings.TrimSpace(t.OnChange) != "" {
-				runOnChange(t, rt.Output, func(level LogLevel, msg string) {
-					logf(level, t.Name, msg)
-				}, opts.LogLevel)
+			resumed := resume[t.Name] == checksum && unchangedOnDisk(rt.Output, checksum)
+			if resumed {
+				logf(LogNormal, t.Name, "%s unchanged since last run", rt.Output)
+				health.record(t.Name, started, nil)
+			} else {
+				if err := writeOut(t, rt, content, merged); err != nil {
+					recordBuild(cycle, t, started, "", "", rt.Files, err)
+					if err := keepFailed(t, err); err != nil {
+						return nil, err
+					}
+					continue
+				}
+				recordBuild(cycle, t, started, "", checksum, rt.Files, nil)
+				logf(LogNormal, t.Name, "wrote %s", rt.Output)
+				built = append(built, t.Name)
 			}
 
 			ws, err := computeWatchDirs(c, t)
@@ -127,30 +541,67 @@ func Run(cfg *config.Config, opts Options) error {
 				}
 			}
 
-			states = append(states, &tstate{
+			st := &tstate{
 				target:   t,
 				lastSum:  checksum,
+				srcSum:   srcSum,
 				watchSet: ws,
-			})
+				hooks:    hooks,
+				reg:      reg,
+			}
+			if !resumed {
+				st.fireOnChange(rt.Output, buildID, func(level LogLevel, msg string) {
+					logf(level, t.Name, "%s", msg)
+				}, opts.LogLevel)
+				if !opts.BatchOnChange {
+					globalOnChange(c, []string{t.Name}, buildID)
+				}
+			}
+
+			states = append(states, st)
 		}
+		if len(failed) > 0 && len(failed) == len(ordered) {
+			// nothing built: fail as FailFast would
+			return nil, errors.Join(failed...)
+		}
+		if opts.BatchOnChange {
+			globalOnChange(c, built, buildID)
+		}
+		postBuild(c, built, buildID)
 		return states, nil
 	}
 
-	buildWatcher := func(states []*tstate) (*fsnotify.Watcher, map[string][]int, error) {
+	buildWatcher := func(c *config.Config, states []*tstate) (*fsnotify.Watcher, map[string][]int, error) {
 		w, err := fsnotify.NewWatcher()
 		if err != nil {
 			return nil, nil, err
 		}
 		dirToTargets := map[string][]int{}
 		global := map[string]struct{}{}
+		create := map[string]struct{}{}
 		for i, st := range states {
 			for d := range st.watchSet {
 				global[d] = struct{}{}
 				dirToTargets[d] = append(dirToTargets[d], i)
 			}
+			if _, roots, err := watchDirs(c, st.target); err == nil {
+				for d := range roots {
+					create[d] = struct{}{}
+				}
+			}
 		}
 		for d := range global {
-			_ = os.MkdirAll(d, 0o755)
+			if _, ok := create[d]; ok {
+				// a glob's parent: create it so files appearing under the glob are seen
+				if err := os.MkdirAll(d, 0o755); err != nil {
+					_ = w.Close()
+					return nil, nil, fmt.Errorf("create watch dir %q: %w", d, err)
+				}
+			} else if _, err := os.Stat(d); errors.Is(err, os.ErrNotExist) {
+				// plain (optional) source in a missing directory: nothing to watch yet
+				logf(LogVerbose, "", "not watching missing dir %s", d)
+				continue
+			}
 			if err := w.Add(d); err != nil {
 				_ = w.Close()
 				return nil, nil, fmt.Errorf("watch add %q: %w", d, err)
@@ -164,47 +615,152 @@ func Run(cfg *config.Config, opts Options) error {
 			return nil, fmt.Errorf("SIGHUP reload requested but Options.ConfigPath is empty")
 		}
 		logf(LogNormal, "", "reloading config from %s", opts.ConfigPath)
-		newCfg, err := config.Load(opts.ConfigPath)
+		newCfg, err := config.Load(opts.ConfigPath, opts.ExtraConfigs...)
 		if err != nil {
 			return nil, err
 		}
 		return newCfg, nil
 	}
 
+	allWatchDirs := func(c *config.Config) (map[string]struct{}, error) {
+		dirs := map[string]struct{}{}
+		for _, t := range c.Targets {
+			ws, err := computeWatchDirs(c, t)
+			if err != nil {
+				return nil, err
+			}
+			for d := range ws {
+				dirs[d] = struct{}{}
+			}
+		}
+		return dirs, nil
+	}
+
+	if opts.StatusFIFO != "" {
+		if err := ensureFIFO(opts.StatusFIFO); err != nil {
+			return err
+		}
+	}
+
+	// ---- lock files (before anything is written) ----
+	locks := newDirLocks(opts.ConfigPath)
+	if opts.WriteLockFiles {
+		dirs, err := allWatchDirs(cfg)
+		if err != nil {
+			return err
+		}
+		if err := locks.acquire(dirs, opts.LockTimeout); err != nil {
+			return err
+		}
+		defer locks.release()
+	}
+
+	// ---- metrics endpoint ----
+	if opts.MetricsAddr != "" {
+		stop, err := metrics.Serve(opts.MetricsAddr, reg)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err := stop(5 * time.Second); err != nil {
+				logf(LogNormal, "", "metrics shutdown: %v", err)
+			}
+		}()
+		logf(LogVerbose, "", "metrics on http://%s/metrics", opts.MetricsAddr)
+	}
+
 	// ---- initial build & watcher ----
-	states, err := buildStates(cfg)
+	states, err := buildStates(cfg, resumeSums)
 	if err != nil {
+		if opts.ExitOnEmptySourceSet && errors.Is(err, plan.ErrEmptySourceSet) {
+			logf(LogNormal, "", "%v (all sources optional and absent); exiting", err)
+			return nil
+		}
 		return err
 	}
-	w, dirToTargets, err := buildWatcher(states)
+	w, dirToTargets, err := buildWatcher(cfg, states)
 	if err != nil {
 		return err
 	}
 	defer w.Close()
 
+	// ---- healthcheck endpoint (after the initial build) ----
+	if opts.HealthcheckAddr != "" {
+		stop, err := serveHealth(opts.HealthcheckAddr, health)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err := stop(5 * time.Second); err != nil {
+				logf(LogNormal, "", "healthcheck shutdown: %v", err)
+			}
+		}()
+		logf(LogVerbose, "", "healthcheck on http://%s/healthz", opts.HealthcheckAddr)
+	}
+	health.setReady()
+
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
 	// signals: INT/TERM for exit; HUP for reload
 	sigc := make(chan os.Signal, 2)
 	signal.Notify(sigc, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(sigc)
 
 	// debounce machinery
 	var mu sync.Mutex
 	timers := make([]*time.Timer, len(states))
+	var flushes sync.WaitGroup // running flushes (graceful drain)
+	draining := false
+	// a batch lasts while rebuilds are pending or running; post_build runs when it settles
+	batchActive := 0
+	var batchBuilt []string
+	var flushSem chan struct{} // MaxConcurrentFlushes slots; nil = unlimited
+	if opts.MaxConcurrentFlushes > 0 {
+		flushSem = make(chan struct{}, opts.MaxConcurrentFlushes)
+	}
+	// no rebuild may start once run has returned (e.g. before a restart)
+	defer func() {
+		mu.Lock()
+		draining = true
+		for i := range timers {
+			if timers[i] != nil {
+				timers[i].Stop()
+			}
+		}
+		mu.Unlock()
+	}()
 
 	flush := func(idx int) {
 		st := states[idx]
 		t := st.target
+		started := time.Now()
+		buildID := NewBuildID()
+		logf(LogVerbose, t.Name, "build %s", buildID)
+		cycle := newBuildCycle(buildID)
+		defer sendWebhook(cfg, cycle)
+		if opts.WatchdogInterval > 0 {
+			// taken before building: a change made meanwhile still differs
+			sum := sourceSum(cfg, t, retry)
+			mu.Lock()
+			st.srcSum = sum
+			mu.Unlock()
+		}
 
 		rt, err := plan.PlanTarget(cfg, t, "")
 		if err != nil {
+			reg.Inc(metricRebuildErrors, "target", t.Name)
+			recordBuild(cycle, t, started, st.lastSum, "", nil, err)
 			logf(LogNormal, t.Name, "plan error: %v", err)
 			return
 		}
+		reg.Set(metricSourceFileCount, float64(len(rt.Files)), "target", t.Name)
+		logf(LogVerbose, t.Name, "newest source mtime %s", rt.NewestSourceMtime.Format(time.RFC3339))
 
-		content, checksum, merged, err := buildContentAndChecksum(t, rt.Files)
+		content, checksum, merged, err := buildContentAndChecksum(t, rt, retry)
 		if err != nil {
+			reg.Inc(metricRebuildErrors, "target", t.Name)
+			recordBuild(cycle, t, started, st.lastSum, "", rt.Files, err)
 			logf(LogNormal, t.Name, "build error: %v", err)
 			return
 		}
@@ -215,25 +771,179 @@ func Run(cfg *config.Config, opts Options) error {
 		}
 
 		logf(LogNormal, t.Name, "changed, rebuilding...")
-		if merged {
-			if err := executor.WriteAtomic(rt.Output, content); err != nil {
-				logf(LogNormal, t.Name, "write error: %v", err)
+		if err := writeOut(t, rt, content, merged); err != nil {
+			reg.Inc(metricRebuildErrors, "target", t.Name)
+			recordBuild(cycle, t, started, st.lastSum, "", rt.Files, err)
+			logf(LogNormal, t.Name, "write error: %v", err)
+			return
+		}
+		recordBuild(cycle, t, started, st.lastSum, checksum, rt.Files, nil)
+		mu.Lock()
+		st.lastSum = checksum
+		batchBuilt = append(batchBuilt, t.Name)
+		mu.Unlock()
+		logf(LogNormal, t.Name, "wrote %s", rt.Output)
+
+		st.fireOnChange(rt.Output, buildID, func(level LogLevel, msg string) {
+			logf(level, t.Name, "%s", msg)
+		}, opts.LogLevel)
+		if !opts.BatchOnChange {
+			globalOnChange(cfg, []string{t.Name}, buildID)
+		}
+	}
+
+	// settle ends one rebuild of the current batch; the last one runs post_build
+	// (and, with BatchOnChange, the config-wide on_change)
+	settle := func() {
+		mu.Lock()
+		batchActive--
+		if batchActive > 0 || draining || len(batchBuilt) == 0 {
+			mu.Unlock()
+			return
+		}
+		built, c := batchBuilt, cfg
+		batchBuilt = nil
+		mu.Unlock()
+		buildID := NewBuildID()
+		if opts.BatchOnChange {
+			globalOnChange(c, built, buildID)
+		}
+		postBuild(c, built, buildID)
+	}
+
+	schedule := func(idx int) {
+		mu.Lock()
+		defer mu.Unlock()
+		if idx >= len(timers) {
+			return
+		}
+		if timers[idx] == nil || !timers[idx].Stop() {
+			batchActive++ // not replacing a pending rebuild
+		}
+		i := idx
+		timers[i] = time.AfterFunc(debounceFor(states[i].target, opts), func() {
+			mu.Lock()
+			if draining {
+				batchActive--
+				mu.Unlock()
 				return
 			}
-		} else {
-			if err := executor.BuildAndWrite(rt.Output, rt.Files); err != nil {
-				logf(LogNormal, t.Name, "write error: %v", err)
-				return
+			flushes.Add(1)
+			mu.Unlock()
+			defer flushes.Done()
+			defer settle()
+			if panics != nil {
+				defer func() {
+					if p := recover(); p != nil {
+						select {
+						case panics <- &panicError{value: p, stack: debug.Stack()}:
+						default:
+						}
+					}
+				}()
+			}
+			if flushSem != nil {
+				flushSem <- struct{}{}
+				defer func() { <-flushSem }()
+			}
+			flush(i)
+		})
+	}
+
+	// startup grace period: buffer target indices until it expires
+	var graceC <-chan time.Time
+	graceBuf := map[int]struct{}{}
+	if opts.GracePeriod > 0 {
+		graceC = time.After(opts.GracePeriod)
+		logf(LogVerbose, "", "grace period %s before first rebuild", opts.GracePeriod)
+	}
+
+	// drain: drop pending rebuilds, then wait for running ones and their hooks
+	drain := func() {
+		mu.Lock()
+		draining = true
+		for i := range timers {
+			if timers[i] != nil {
+				timers[i].Stop()
 			}
 		}
-		st.lastSum = checksum
-		logf(LogNormal, t.Name, "wrote %s", rt.Output)
+		mu.Unlock()
 
-		if strings.TrimSpace(t.OnChange) != "" {
-			runOnChange(t, rt.Output, func(level LogLevel, msg string) {
-				logf(level, t.Name, msg)
-			}, opts.LogLevel)
+		timeout := opts.DrainTimeout
+		if timeout <= 0 {
+			timeout = 30 * time.Second
 		}
+		done := make(chan struct{})
+		go func() {
+			flushes.Wait()
+			hooks.wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+			logf(LogVerbose, "", "drained")
+		case <-time.After(timeout):
+			logf(LogNormal, "", "drain timed out after %s", timeout)
+		}
+	}
+
+	// watchdog: catch changes whose watch events were missed. Sources are
+	// hashed off the event loop; changed targets come back on watchdogDone.
+	var watchdogC <-chan time.Time
+	if opts.WatchdogInterval > 0 {
+		tick := time.NewTicker(opts.WatchdogInterval)
+		defer tick.Stop()
+		watchdogC = tick.C
+		logf(LogVerbose, "", "watchdog every %s", opts.WatchdogInterval)
+	}
+	watchdogDone := make(chan []*tstate, 1)
+	watchdogBusy := false
+	watchdog := func(c *config.Config, sts []*tstate) {
+		var changed []*tstate
+		for _, st := range sts {
+			sum := sourceSum(c, st.target, retry)
+			mu.Lock()
+			// an error that persists hashes the same and is not retried
+			if sum != st.srcSum {
+				changed = append(changed, st)
+			}
+			mu.Unlock()
+		}
+		watchdogDone <- changed
+	}
+
+	// watchNewDirs adds directories that now match the targets' source patterns
+	// (e.g. a new subdirectory under a `dir/*/*.yaml` glob) to the watcher.
+	watchNewDirs := func(indices []int) {
+		for _, idx := range indices {
+			st := states[idx]
+			ws, err := computeWatchDirs(cfg, st.target)
+			if err != nil {
+				continue
+			}
+			for d := range ws {
+				if _, ok := st.watchSet[d]; ok {
+					continue
+				}
+				if err := w.Add(d); err != nil {
+					logf(LogNormal, st.target.Name, "watch add %q: %v", d, err)
+					continue
+				}
+				logf(LogVerbose, st.target.Name, "watch dir %s", d)
+				st.watchSet[d] = struct{}{}
+				dirToTargets[d] = append(dirToTargets[d], idx)
+			}
+		}
+	}
+
+	// idle timeout: every watch event for a target restarts it
+	var idleC <-chan time.Time
+	var idle *time.Timer
+	if opts.IdleTimeout > 0 {
+		idle = time.NewTimer(opts.IdleTimeout)
+		defer idle.Stop()
+		idleC = idle.C
+		logf(LogVerbose, "", "exiting after %s without changes", opts.IdleTimeout)
 	}
 
 	// event loop
@@ -242,66 +952,143 @@ func Run(cfg *config.Config, opts Options) error {
 		case <-ctx.Done():
 			return nil
 
+		case <-idleC:
+			mu.Lock()
+			busy := batchActive > 0
+			mu.Unlock()
+			if busy {
+				idle.Reset(opts.IdleTimeout)
+				continue
+			}
+			logf(LogNormal, "", "no changes for %s, exiting", opts.IdleTimeout)
+			hooks.wait()
+			return nil
+
+		case p := <-panics:
+			return p
+
+		case <-graceC:
+			graceC = nil
+			logf(LogVerbose, "", "grace period over, %d target(s) pending", len(graceBuf))
+			for idx := range graceBuf {
+				schedule(idx)
+			}
+			graceBuf = map[int]struct{}{}
+
+		case <-watchdogC:
+			if graceC == nil && !watchdogBusy {
+				watchdogBusy = true
+				go watchdog(cfg, states)
+			}
+
+		case changed := <-watchdogDone:
+			watchdogBusy = false
+			// states may have been replaced by a reload meanwhile
+			for idx, st := range states {
+				if slices.Contains(changed, st) {
+					logf(LogVerbose, st.target.Name, "watchdog: sources changed without an event")
+					schedule(idx)
+				}
+			}
+
 		case err := <-w.Errors:
 			logf(LogNormal, "", "watcher error: %v", err)
 
 		case ev := <-w.Events:
+			if dropFSEvents {
+				continue
+			}
 			evDir := filepath.Dir(ev.Name)
 			indices := dirToTargets[evDir]
 			logf(LogVerbose, "", "fs %s %s -> %d target(s)", ev.Op.String(), ev.Name, len(indices))
+			if ev.Op&fsnotify.Create != 0 && len(indices) > 0 {
+				if fi, err := os.Stat(ev.Name); err == nil && fi.IsDir() {
+					watchNewDirs(indices)
+				}
+			}
+			if idle != nil && len(indices) > 0 {
+				idle.Reset(opts.IdleTimeout)
+			}
 			for _, idx := range indices {
-				mu.Lock()
-				if idx >= len(timers) {
-					mu.Unlock()
+				if graceC != nil {
+					graceBuf[idx] = struct{}{}
 					continue
 				}
-				if timers[idx] != nil {
-					timers[idx].Stop()
-				}
-				i := idx
-				timers[i] = time.AfterFunc(opts.Debounce, func() {
-					mu.Lock()
-					mu.Unlock()
-					flush(i)
-				})
-				mu.Unlock()
+				schedule(idx)
 			}
 
 		case s := <-sigc:
 			switch s {
 			case syscall.SIGINT, syscall.SIGTERM:
 				logf(LogNormal, "", "received %v, exiting", s)
+				if opts.GracefulDrain {
+					drain()
+				}
 				cancel()
 				return nil
 
 			case syscall.SIGHUP:
-				logf(LogNormal, "", "received SIGHUP, reloading")
+				action := opts.SIGHUPAction
+				if action == "" {
+					action = "reload"
+				}
+				verb := "reload"
+				if action == "rebuild" {
+					verb = "rebuild"
+					logf(LogNormal, "", "received SIGHUP, rebuilding all targets")
+				} else {
+					logf(LogNormal, "", "received SIGHUP, reloading")
+				}
 
 				// stop timers
 				mu.Lock()
 				for i := range timers {
 					if timers[i] != nil {
-						timers[i].Stop()
+						if timers[i].Stop() {
+							batchActive--
+						}
 						timers[i] = nil
 					}
 				}
 				mu.Unlock()
 
-				newCfg, err := reloadConfig()
-				if err != nil {
-					logf(LogNormal, "", "reload error: %v (keeping old config)", err)
-					continue
+				newCfg := cfg
+				if action != "rebuild" {
+					c, err := reloadConfig()
+					switch {
+					case err == nil:
+						newCfg = c
+					case action == "both":
+						logf(LogNormal, "", "reload error: %v (rebuilding with the current config)", err)
+						verb = "rebuild"
+					default:
+						logf(LogNormal, "", "reload error: %v (keeping old config)", err)
+						continue
+					}
+				}
+
+				var newDirs map[string]struct{}
+				var err error
+				if opts.WriteLockFiles {
+					newDirs, err = allWatchDirs(newCfg)
+					if err == nil {
+						err = locks.acquire(newDirs, 0)
+					}
+					if err != nil {
+						logf(LogNormal, "", "%s lock error: %v (keeping old config)", verb, err)
+						continue
+					}
 				}
 
-				newStates, err := buildStates(newCfg)
+				newStates, err := buildStates(newCfg, nil)
 				if err != nil {
-					logf(LogNormal, "", "reload build error: %v (keeping old config)", err)
+					logf(LogNormal, "", "%s build error: %v (keeping old config)", verb, err)
 					continue
 				}
 
-				newWatcher, newDirToTargets, err := buildWatcher(newStates)
+				newWatcher, newDirToTargets, err := buildWatcher(newCfg, newStates)
 				if err != nil {
-					logf(LogNormal, "", "reload watcher error: %v (keeping old config)", err)
+					logf(LogNormal, "", "%s watcher error: %v (keeping old config)", verb, err)
 					continue
 				}
 
@@ -311,19 +1098,52 @@ func Run(cfg *config.Config, opts Options) error {
 				dirToTargets = newDirToTargets
 				states = newStates
 				cfg = newCfg
+				names := make([]string, len(states))
+				for i, st := range states {
+					names[i] = st.target.Name
+				}
+				health.retain(names)
 				timers = make([]*time.Timer, len(states))
+				graceBuf = map[int]struct{}{} // indices refer to the old states; reload rebuilt everything
+				if opts.WriteLockFiles {
+					locks.retain(newDirs)
+				}
 
-				logf(LogNormal, "", "reload complete (%d targets)", len(states))
+				logf(LogNormal, "", "%s complete (%d targets)", verb, len(states))
 			}
 		}
 	}
 }
 
+// debounceFor resolves a target's debounce: CLI override, then config debounce_ms,
+// then the global interval.
+func debounceFor(t config.Target, opts Options) time.Duration {
+	if d, ok := opts.TargetDebounce[t.Name]; ok && d > 0 {
+		return d
+	}
+	if t.DebounceMS > 0 {
+		return time.Duration(t.DebounceMS) * time.Millisecond
+	}
+	return opts.Debounce
+}
+
+// DryBuild runs t's merge (or concat) pipeline over rt's files as a rebuild
+// would, but writes nothing; it returns the read, parse or merge error, if any.
+func DryBuild(t config.Target, rt *plan.ResolvedTarget) error {
+	_, _, _, err := buildContentAndChecksum(t, rt, executor.ReadRetry{})
+	return err
+}
+
 // buildContentAndChecksum builds the final output content (for merged formats),
 // or computes the normalized concatenation checksum (for concat path).
 // Returns (content, checksumHex, merged, error).
-func buildContentAndChecksum(t config.Target, files []string) (string, string, bool, error) {
+func buildContentAndChecksum(t config.Target, rt *plan.ResolvedTarget, retry executor.ReadRetry) (string, string, bool, error) {
+	if beforeBuild != nil {
+		beforeBuild(t)
+	}
 	format := strings.ToLower(t.Format)
+	files := rt.Files
+	bo := blend.Options{Encodings: rt.Encodings, MergePatch: rt.MergePatch, Retry: retry, Transforms: rt.Transforms, Target: t.Name}
 
 	// Merge path?
 	if t.Merge != nil && (format == "yaml" || format == "json" || format == "toml" || format == "kdl" || format == "ini") {
@@ -333,46 +1153,120 @@ func buildContentAndChecksum(t config.Target, files []string) (string, string, b
 		)
 		switch format {
 		case "yaml", "json", "toml":
-			content, err = blend.BlendStructured(format, t.Merge.Rules, files)
+			content, err = blend.BlendStructuredAs(format, format, t.Merge.Rules, files, bo)
 		case "kdl":
-			content, err = blend.BlendKDL(t.Merge.Rules, files)
+			content, err = blend.BlendKDLWith(t.Merge.Rules, files, bo)
 		case "ini":
-			content, err = blend.BlendINI(t.Merge.Rules, files)
+			content, err = blend.BlendINIWith(t.Merge.Rules, files, bo)
 		}
 		if err != nil {
 		 return "", "", false, err
 		}
-		sum := sha256Hex(content)
+		sum := sha256Hex(executor.ApplyPostProcess(content, t.PostProcess))
 		return content, sum, true, nil
 	}
 
 	// Concat path (no merge rules for this format/target)
-	sum, err := executor.SHA256OfFiles(files)
+	sum, err := executor.SHA256OfSources(files, executor.WriteOptions{Encodings: rt.Encodings, Retry: retry, PostProcess: t.PostProcess, Transforms: rt.Transforms, Target: t.Name})
 	if err != nil {
 		return "", "", false, err
 	}
 	return "", sum, false, nil
 }
 
+// sourceSum hashes t's resolved source files as read (before merging), for
+// the watchdog; plan and read errors hash too, so a lasting one compares equal.
+func sourceSum(cfg *config.Config, t config.Target, retry executor.ReadRetry) string {
+	rt, err := plan.PlanTarget(cfg, t, "")
+	if err != nil {
+		return "plan error: " + err.Error()
+	}
+	sum, err := executor.SHA256OfSources(rt.Files, executor.WriteOptions{Encodings: rt.Encodings, Retry: retry})
+	if err != nil {
+		return "read error: " + err.Error()
+	}
+	// the file list too: renaming a source can change the output
+	return sha256Hex(strings.Join(rt.Files, "\n") + "\n" + sum)
+}
+
 func sha256Hex(s string) string {
 	h := sha256.Sum256([]byte(s))
 	return hex.EncodeToString(h[:])
 }
 
 func computeWatchDirs(cfg *config.Config, t config.Target) (map[string]struct{}, error) {
+	out, _, err := watchDirs(cfg, t)
+	return out, err
+}
+
+// watchDirs is computeWatchDirs plus globRoots, the glob-free parent
+// directories of t's glob sources: the daemon creates those when missing so a
+// file appearing under the glob is seen, and leaves other directories alone.
+func watchDirs(cfg *config.Config, t config.Target) (out, globRoots map[string]struct{}, err error) {
 	baseDir, err := cfg.BaseDir()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	out := map[string]struct{}{}
+	baseDir = cfg.SourceDir(t) // work_dir, or the config directory
+	out, globRoots = map[string]struct{}{}, map[string]struct{}{}
 	for _, s := range t.Sources {
-		p := expandTilde(s.Path)
+		if s.Inline != "" || s.DataURI != "" {
+			continue // lives in confb.yaml; changes arrive via reload
+		}
+		p := expandTilde(os.ExpandEnv(s.Path))
 		if !filepath.IsAbs(p) {
 			p = filepath.Join(baseDir, p)
 		}
+		if s.TargetRef != "" {
+			if p, err = plan.RefOutput(cfg, s.TargetRef); err != nil {
+				return nil, nil, err
+			}
+		}
+		if dir := filepath.Dir(p); strings.ContainsAny(dir, "*?[") {
+			// glob in a directory component: watch the literal root and every
+			// directory matching a prefix of the pattern; new ones are added as
+			// they appear (see watchNewDirs)
+			dirs := globWatchDirs(dir)
+			globRoots[dirs[0]] = struct{}{}
+			for _, d := range dirs {
+				out[d] = struct{}{}
+			}
+			continue
+		}
 		out[filepath.Dir(p)] = struct{}{}
+		if s.TargetRef == "" && strings.ContainsAny(filepath.Base(p), "*?[") {
+			globRoots[filepath.Dir(p)] = struct{}{}
+		}
+		if st, err := os.Stat(p); err == nil && st.IsDir() {
+			// directory source: events land inside it
+			out[p] = struct{}{}
+		}
+	}
+	return out, globRoots, nil
+}
+
+// globWatchDirs returns the deepest glob-free ancestor of the directory pattern
+// dir plus the existing directories matching each deeper prefix of it.
+func globWatchDirs(dir string) []string {
+	parts := strings.Split(filepath.ToSlash(dir), "/")
+	i := 0
+	for i < len(parts) && !strings.ContainsAny(parts[i], "*?[") {
+		i++
+	}
+	root := filepath.FromSlash(strings.Join(parts[:i], "/"))
+	if root == "" {
+		root = string(filepath.Separator)
 	}
-	return out, nil
+	out := []string{root}
+	for ; i < len(parts); i++ {
+		matches, _ := filepath.Glob(filepath.FromSlash(strings.Join(parts[:i+1], "/")))
+		for _, m := range matches {
+			if st, err := os.Stat(m); err == nil && st.IsDir() {
+				out = append(out, m)
+			}
+		}
+	}
+	return out
 }
 
 func expandTilde(p string) string {
@@ -389,7 +1283,17 @@ func expandTilde(p string) string {
 
 // --- on_change hook ---
 
-func runOnChange(t config.Target, outputPath string, logf func(LogLevel, string), level LogLevel) {
+// NewBuildID returns a short random ID (8 lowercase hex chars) for one build
+// cycle; hooks see it as CONFB_BUILD_ID.
+func NewBuildID() string {
+	var b [4]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return fmt.Sprintf("%08x", uint32(time.Now().UnixNano()))
+	}
+	return hex.EncodeToString(b[:])
+}
+
+func runOnChange(t config.Target, outputPath, buildID string, logf func(LogLevel, string), level LogLevel) {
 	cmdTmpl := strings.TrimSpace(t.OnChange)
 	if cmdTmpl == "" {
 		return
@@ -400,21 +1304,45 @@ func runOnChange(t config.Target, outputPath string, logf func(LogLevel, string)
 	cmdStr = strings.ReplaceAll(cmdStr, "{output}", outputPath)
 	cmdStr = strings.ReplaceAll(cmdStr, "{timestamp}", time.Now().Format(time.RFC3339))
 
-	// best-effort timeout to avoid wedging the daemon
-	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	// best-effort timeout to avoid wedging the daemon; async hooks get a longer budget
+	timeout := defaultOnChangeTimeout
+	if t.OnChangeTimeoutS > 0 {
+		timeout = time.Duration(t.OnChangeTimeoutS) * time.Second
+	}
+	if t.OnChangeAsync {
+		timeout = 60 * time.Second
+		if t.OnChangeAsyncTimeoutS > 0 {
+			timeout = time.Duration(t.OnChangeAsyncTimeoutS) * time.Second
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
 
 	logf(LogNormal, fmt.Sprintf("running on_change: %s", cmdStr))
 	c := exec.CommandContext(ctx, "/bin/sh", "-c", cmdStr)
 	c.Env = append(os.Environ(),
 		"CONFB_TARGET="+t.Name,
+		"CONFB_BUILD_ID="+buildID,
 		"CONFB_OUTPUT="+outputPath,
 		"CONFB_TIMESTAMP="+time.Now().Format(time.RFC3339),
 	)
 	c.Stdout = os.Stderr
 	c.Stderr = os.Stderr
 
+	// optionally feed the freshly written output on stdin (e.g. `sysctl -p -`)
+	if t.OnChangePipeOutput {
+		if b, err := executor.ReadOutput(outputPath); err != nil {
+			logf(LogNormal, fmt.Sprintf("on_change: cannot read %s for stdin: %v (running with empty stdin)", outputPath, err))
+		} else {
+			c.Stdin = bytes.NewReader(b)
+		}
+	}
+
 	if err := c.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			logf(LogNormal, fmt.Sprintf("on_change timed out after %s (killed)", timeout))
+			return
+		}
 		logf(LogNormal, fmt.Sprintf("on_change error: %v", err))
 	}
 }
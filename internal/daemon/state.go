@@ -0,0 +1,139 @@
+package daemon
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	executor "github.com/nekwebdev/confb/internal/exec"
+)
+
+// DefaultHistoryDepth is how many build records per target the state file keeps.
+const DefaultHistoryDepth = 10
+
+// State is the daemon state file read by `confb status`.
+type State struct {
+	PID       int                      `json:"pid"`
+	Config    string                   `json:"config"`
+	UpdatedAt time.Time                `json:"updated_at"`
+	Targets   map[string][]BuildRecord `json:"targets"` // oldest first
+
+	// DebounceMS is each target's effective rebuild debounce in milliseconds:
+	// --target-debounce, else debounce_ms, else --debounce-ms.
+	DebounceMS map[string]int64 `json:"debounce_ms,omitempty"`
+}
+
+// BuildRecord describes one build of a target (initial build or rebuild).
+type BuildRecord struct {
+	BuildID    string    `json:"build_id,omitempty"` // CONFB_BUILD_ID of the build cycle
+	Time       time.Time `json:"time"`
+	DurationMS int64     `json:"duration_ms"`
+	SumBefore  string    `json:"sum_before,omitempty"` // output checksum before the build
+	SumAfter   string    `json:"sum_after,omitempty"`  // output checksum written (empty on error)
+	Files      []string  `json:"files"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// DefaultStatePath is ~/.cache/confb/state.json (next to the pid file).
+func DefaultStatePath() string {
+	home, err := os.UserHomeDir()
+	if err != nil || home == "" {
+		return ""
+	}
+	return filepath.Join(home, ".cache", "confb", "state.json")
+}
+
+// ReadState loads a state file written by the daemon.
+func ReadState(path string) (*State, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var st State
+	if err := json.Unmarshal(b, &st); err != nil {
+		return nil, err
+	}
+	if st.Targets == nil {
+		st.Targets = map[string][]BuildRecord{}
+	}
+	return &st, nil
+}
+
+// LastSums returns each target's most recent successfully written checksum.
+func (s *State) LastSums() map[string]string {
+	out := map[string]string{}
+	for name, recs := range s.Targets {
+		for i := len(recs) - 1; i >= 0; i-- {
+			if recs[i].Error == "" && recs[i].SumAfter != "" {
+				out[name] = recs[i].SumAfter
+				break
+			}
+		}
+	}
+	return out
+}
+
+// stateRecorder appends build records and rewrites the state file atomically.
+// History from an earlier daemon run is kept. A nil recorder ignores records.
+type stateRecorder struct {
+	mu    sync.Mutex
+	path  string
+	depth int
+	st    State
+}
+
+func newStateRecorder(path, configPath string, depth int) *stateRecorder {
+	if depth <= 0 {
+		depth = DefaultHistoryDepth
+	}
+	r := &stateRecorder{path: path, depth: depth}
+	if prev, err := ReadState(path); err == nil {
+		r.st.Targets = prev.Targets
+	} else {
+		r.st.Targets = map[string][]BuildRecord{}
+	}
+	r.st.PID = os.Getpid()
+	r.st.Config = configPath
+	return r
+}
+
+// setDebounce replaces the per-target debounces written with the next record.
+func (r *stateRecorder) setDebounce(d map[string]int64) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.st.DebounceMS = d
+}
+
+func (r *stateRecorder) record(target string, rec BuildRecord) error {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	h := append(r.st.Targets[target], rec)
+	if len(h) > r.depth {
+		h = append([]BuildRecord(nil), h[len(h)-r.depth:]...)
+	}
+	r.st.Targets[target] = h
+	r.st.UpdatedAt = rec.Time
+
+	b, err := json.MarshalIndent(r.st, "", "  ")
+	if err != nil {
+		return err
+	}
+	return executor.WriteAtomic(r.path, string(b)+"\n")
+}
+
+// errString is err.Error(), or "" for nil.
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
@@ -0,0 +1,93 @@
+package daemon
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// webhookTimeout bounds one webhook request.
+const webhookTimeout = 5 * time.Second
+
+// buildCycle collects the outcome of one build cycle (the initial build, a
+// reload, or one debounced rebuild) for the webhook.
+type buildCycle struct {
+	BuildID   string          `json:"build_id"`
+	Targets   []webhookTarget `json:"targets"`
+	Errors    []webhookError  `json:"errors,omitempty"`
+	Timestamp time.Time       `json:"timestamp"`
+}
+
+type webhookTarget struct {
+	Name       string `json:"name"`
+	Output     string `json:"output"`
+	Checksum   string `json:"checksum"`
+	DurationMS int64  `json:"duration_ms"`
+}
+
+type webhookError struct {
+	Target string `json:"target"`
+	Error  string `json:"error"`
+}
+
+func newBuildCycle(buildID string) *buildCycle {
+	return &buildCycle{BuildID: buildID, Targets: []webhookTarget{}}
+}
+
+// add records one target's result; a nil cycle ignores it.
+func (c *buildCycle) add(name, output, checksum string, duration time.Duration, err error) {
+	if c == nil {
+		return
+	}
+	if err != nil {
+		c.Errors = append(c.Errors, webhookError{Target: name, Error: err.Error()})
+		return
+	}
+	c.Targets = append(c.Targets, webhookTarget{
+		Name:       name,
+		Output:     output,
+		Checksum:   checksum,
+		DurationMS: duration.Milliseconds(),
+	})
+}
+
+func (c *buildCycle) empty() bool {
+	return c == nil || (len(c.Targets) == 0 && len(c.Errors) == 0)
+}
+
+// postWebhook sends the cycle as JSON in the background; failures are only logged.
+func postWebhook(url, method string, c *buildCycle, logf func(LogLevel, string)) {
+	if url == "" || c.empty() {
+		return
+	}
+	c.Timestamp = time.Now().UTC()
+	body, err := json.Marshal(c)
+	if err != nil {
+		logf(LogNormal, fmt.Sprintf("webhook: %v", err))
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), webhookTimeout)
+		defer cancel()
+		req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+		if err != nil {
+			logf(LogNormal, fmt.Sprintf("webhook: %v", err))
+			return
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			logf(LogNormal, fmt.Sprintf("webhook: %v", err))
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			logf(LogNormal, fmt.Sprintf("webhook: %s %s: %s", method, url, resp.Status))
+			return
+		}
+		logf(LogVerbose, fmt.Sprintf("webhook: %s %s: %s", method, url, resp.Status))
+	}()
+}
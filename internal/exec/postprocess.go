@@ -0,0 +1,80 @@
+package exec
+
+import (
+	"strings"
+)
+
+// postProcessors are the output post-processors a target can list in
+// post_process; they run in order on the final content (header included).
+var postProcessors = map[string]func(string) string{
+	"ensure_trailing_newline": EnsureTrailingNewline,
+	"strip_trailing_newline":  StripTrailingNewline,
+	"no_header":               NoHeader,
+	"trim_blank_lines":        TrimBlankLines,
+	"unix_eol":                UnixEOL,
+	"windows_eol":             WindowsEOL,
+}
+
+// ApplyPostProcess runs the named post-processors over s in order. Unknown
+// names are skipped; the config loader rejects them.
+func ApplyPostProcess(s string, names []string) string {
+	for _, n := range names {
+		if f, ok := postProcessors[n]; ok {
+			s = f(s)
+		}
+	}
+	return s
+}
+
+// EnsureTrailingNewline appends "\n" unless s is empty or already ends in one.
+func EnsureTrailingNewline(s string) string {
+	if s == "" || strings.HasSuffix(s, "\n") {
+		return s
+	}
+	return s + "\n"
+}
+
+// StripTrailingNewline removes every trailing line break ("\n" or "\r\n").
+func StripTrailingNewline(s string) string {
+	return strings.TrimRight(s, "\r\n")
+}
+
+// NoHeader drops the "confb build" annotation block (up to its blank line)
+// that build prepends to outputs of formats with comments.
+func NoHeader(s string) string {
+	first, _, _ := strings.Cut(s, "\n")
+	switch strings.TrimSuffix(first, "\r") {
+	case "# confb build", "// confb build", "; confb build":
+	default:
+		return s
+	}
+	if i := strings.Index(s, "\n\n"); i >= 0 {
+		return s[i+2:]
+	}
+	if i := strings.Index(s, "\r\n\r\n"); i >= 0 {
+		return s[i+4:]
+	}
+	return s
+}
+
+// TrimBlankLines removes lines that are empty or whitespace-only.
+func TrimBlankLines(s string) string {
+	lines := strings.SplitAfter(s, "\n")
+	var b strings.Builder
+	for _, l := range lines {
+		if strings.TrimSpace(l) != "" {
+			b.WriteString(l)
+		}
+	}
+	return b.String()
+}
+
+// UnixEOL converts CRLF and lone CR line endings to LF.
+func UnixEOL(s string) string {
+	return normalizeNewlines(s)
+}
+
+// WindowsEOL converts every line ending to CRLF.
+func WindowsEOL(s string) string {
+	return strings.ReplaceAll(normalizeNewlines(s), "\n", "\r\n")
+}
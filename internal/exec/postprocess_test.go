@@ -0,0 +1,26 @@
+package exec
+
+import "testing"
+
+func TestApplyPostProcess(t *testing.T) {
+	header := "# confb build\n# fmt: yaml\n\na: 1\n"
+	cases := []struct {
+		names []string
+		in    string
+		want  string
+	}{
+		{[]string{"ensure_trailing_newline"}, "a", "a\n"},
+		{[]string{"strip_trailing_newline"}, "a\n\n", "a"},
+		{[]string{"no_header"}, header, "a: 1\n"},
+		{[]string{"no_header"}, "a: 1\n\nb: 2\n", "a: 1\n\nb: 2\n"},
+		{[]string{"trim_blank_lines"}, "a\n\n  \nb\n", "a\nb\n"},
+		{[]string{"unix_eol"}, "a\r\nb\rc\n", "a\nb\nc\n"},
+		{[]string{"windows_eol"}, "a\nb\r\n", "a\r\nb\r\n"},
+		{[]string{"no_header", "windows_eol", "strip_trailing_newline"}, header, "a: 1"},
+	}
+	for _, c := range cases {
+		if got := ApplyPostProcess(c.in, c.names); got != c.want {
+			t.Errorf("%v(%q) = %q, want %q", c.names, c.in, got, c.want)
+		}
+	}
+}
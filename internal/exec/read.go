@@ -0,0 +1,203 @@
+package exec
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/charmap"
+
+	"github.com/nekwebdev/confb/internal/source"
+)
+
+// NormalizeEncoding maps a source encoding name to its canonical form:
+// "" and "utf8"/"utf-8" → "utf8"; "latin1"/"iso-8859-1" → "latin1".
+// Unknown names are returned lowercased (callers validate).
+func NormalizeEncoding(enc string) string {
+	switch e := strings.ToLower(strings.TrimSpace(enc)); e {
+	case "", "utf8", "utf-8":
+		return "utf8"
+	case "latin1", "iso-8859-1", "iso8859-1":
+		return "latin1"
+	default:
+		return e
+	}
+}
+
+// DecodeSource converts b from the given source encoding to UTF-8.
+func DecodeSource(b []byte, enc string) ([]byte, error) {
+	switch NormalizeEncoding(enc) {
+	case "utf8":
+		return b, nil
+	case "latin1":
+		return charmap.ISO8859_1.NewDecoder().Bytes(b)
+	default:
+		return nil, fmt.Errorf("unsupported source encoding %q", enc)
+	}
+}
+
+// ReadRetry configures transient-error retries for source reads.
+// The zero value reads once.
+type ReadRetry struct {
+	Attempts int
+	Backoff  time.Duration
+}
+
+// readFile is a test seam for ReadWithRetry.
+var readFile = os.ReadFile
+
+// ReadWithRetry reads path, retrying up to maxAttempts times in total when the
+// read fails with a transient error (EAGAIN, EINTR, EIO), sleeping backoff
+// between attempts. Other errors are returned immediately.
+func ReadWithRetry(path string, maxAttempts int, backoff time.Duration) ([]byte, error) {
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+	var err error
+	for attempt := 1; ; attempt++ {
+		var b []byte
+		b, err = readFile(path)
+		if err == nil {
+			return b, nil
+		}
+		if attempt >= maxAttempts || !isTransient(err) {
+			return nil, err
+		}
+		time.Sleep(backoff)
+	}
+}
+
+// openFile is a test seam for OpenWithRetry.
+var openFile = os.Open
+
+// OpenWithRetry is ReadWithRetry for callers that stream the file: only the
+// open is retried, read errors surface from the returned file.
+func OpenWithRetry(path string, maxAttempts int, backoff time.Duration) (*os.File, error) {
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+	for attempt := 1; ; attempt++ {
+		f, err := openFile(path)
+		if err == nil {
+			return f, nil
+		}
+		if attempt >= maxAttempts || !isTransient(err) {
+			return nil, err
+		}
+		time.Sleep(backoff)
+	}
+}
+
+// isTransient reports whether a read error is worth retrying (e.g. a network
+// filesystem or editor briefly holding the file).
+func isTransient(err error) bool {
+	return errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.EINTR) || errors.Is(err, syscall.EIO)
+}
+
+// ReadSource reads a source file and returns its content as UTF-8.
+func ReadSource(path, enc string) ([]byte, error) {
+	return ReadSourceRetry(path, enc, ReadRetry{})
+}
+
+// ReadSourceRetry is ReadSource with transient-error retries.
+func ReadSourceRetry(path, enc string, retry ReadRetry) ([]byte, error) {
+	b, err := ReadWithRetry(path, retry.Attempts, retry.Backoff)
+	if err != nil {
+		return nil, err
+	}
+	out, err := DecodeSource(b, enc)
+	if err != nil {
+		return nil, fmt.Errorf("decode %q: %w", path, err)
+	}
+	return out, nil
+}
+
+// CheckUTF8 returns an error naming path, the line and the first byte of b
+// that is not valid UTF-8 ("path:line: invalid UTF-8 byte 0xNN"), or nil.
+func CheckUTF8(path string, b []byte) error {
+	if utf8.Valid(b) {
+		return nil
+	}
+	return utf8Error(path, string(b), 1)
+}
+
+// NewUTF8Reader returns a reader that passes r through and fails with the
+// CheckUTF8 error for path at the first byte that is not valid UTF-8, so a
+// source can be validated while it is decoded instead of up front.
+func NewUTF8Reader(path string, r io.Reader) *UTF8Reader {
+	return &UTF8Reader{path: path, r: r, line: 1}
+}
+
+// UTF8Reader is the reader returned by NewUTF8Reader.
+type UTF8Reader struct {
+	path string
+	r    io.Reader
+	line int
+	tail []byte // start of a rune split across reads
+	err  error
+}
+
+// Err returns the encoding error the reader stopped on, if any. Decoders wrap
+// read errors in their own messages; callers report this one instead.
+func (u *UTF8Reader) Err() error { return u.err }
+
+func (u *UTF8Reader) Read(p []byte) (int, error) {
+	if u.err != nil {
+		return 0, u.err
+	}
+	n, err := u.r.Read(p)
+	data := p[:n]
+	if len(u.tail) > 0 {
+		data = append(u.tail, data...)
+		u.tail = nil
+	}
+	for i := 0; i < len(data); {
+		if data[i] < utf8.RuneSelf {
+			if data[i] == '\n' {
+				u.line++
+			}
+			i++
+			continue
+		}
+		r, size := utf8.DecodeRune(data[i:])
+		if r == utf8.RuneError && size == 1 {
+			if err == nil && !utf8.FullRune(data[i:]) {
+				u.tail = append([]byte(nil), data[i:]...)
+				break
+			}
+			u.err = utf8Error(u.path, string(data[i:]), u.line)
+			return 0, u.err
+		}
+		i += size
+	}
+	return n, err
+}
+
+// utf8Error is CheckUTF8 for s starting on line line.
+func utf8Error(path, s string, line int) error {
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			return fmt.Errorf("%s:%d: invalid UTF-8 byte 0x%02X", path, line, s[i])
+		}
+		if s[i] == '\n' {
+			line++
+		}
+		i += size
+	}
+	return nil
+}
+
+// TransformSource runs the named source transformers (see source.Default) in
+// order over b, the decoded content of path, read for target.
+func TransformSource(b []byte, target, path string, names []string) ([]byte, error) {
+	if len(names) == 0 {
+		return b, nil
+	}
+	return source.Default.Apply(b, names, source.TransformContext{Target: target, Path: path})
+}
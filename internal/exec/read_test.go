@@ -0,0 +1,81 @@
+package exec
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"testing/iotest"
+)
+
+func TestReadWithRetry_RetriesTransientErrors(t *testing.T) {
+	calls := 0
+	readFile = func(name string) ([]byte, error) {
+		calls++
+		if calls <= 2 {
+			return nil, &fs.PathError{Op: "read", Path: name, Err: syscall.EAGAIN}
+		}
+		return []byte("ok\n"), nil
+	}
+	defer func() { readFile = os.ReadFile }()
+
+	b, err := ReadWithRetry("src.conf", 3, 0)
+	if err != nil {
+		t.Fatalf("ReadWithRetry: %v", err)
+	}
+	if string(b) != "ok\n" || calls != 3 {
+		t.Fatalf("got %q after %d calls, want \"ok\\n\" after 3", b, calls)
+	}
+
+	// permanent errors are not retried
+	calls = 0
+	readFile = func(name string) ([]byte, error) {
+		calls++
+		return nil, &fs.PathError{Op: "open", Path: name, Err: syscall.ENOENT}
+	}
+	if _, err := ReadWithRetry("missing.conf", 3, 0); !errors.Is(err, fs.ErrNotExist) || calls != 1 {
+		t.Fatalf("got err=%v after %d calls, want ErrNotExist after 1", err, calls)
+	}
+}
+
+func TestSHA256OfSources_InvalidUTF8ReportsLine(t *testing.T) {
+	td := t.TempDir()
+	ok := filepath.Join(td, "ok.txt")
+	bad := filepath.Join(td, "bad.bin")
+	if err := os.WriteFile(ok, []byte("one\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// the invalid byte is on line 4 (lone CR counts as a line break)
+	if err := os.WriteFile(bad, []byte("l1\r\nl2\rl3\nab\x80c\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	_, err := SHA256OfSources([]string{ok, bad}, WriteOptions{})
+	if err == nil || err.Error() != bad+":4: invalid UTF-8 byte 0x80" {
+		t.Fatalf("want %s:4 invalid byte 0x80, got %v", bad, err)
+	}
+}
+
+func TestUTF8Reader_SplitRunesAndBadByte(t *testing.T) {
+	// one byte per read splits every multi-byte rune across reads
+	good := "héllo\nwörld\n"
+	b, err := io.ReadAll(NewUTF8Reader("ok.yaml", iotest.OneByteReader(strings.NewReader(good))))
+	if err != nil || string(b) != good {
+		t.Fatalf("want %q, got %q, %v", good, b, err)
+	}
+
+	r := NewUTF8Reader("bad.yaml", iotest.OneByteReader(strings.NewReader("a: é\nb: \xff\n")))
+	_, err = io.ReadAll(r)
+	if err == nil || err.Error() != "bad.yaml:2: invalid UTF-8 byte 0xFF" || r.Err() != err {
+		t.Fatalf("want bad.yaml:2 invalid byte 0xFF, got %v", err)
+	}
+
+	// a rune cut off by the end of the file
+	_, err = io.ReadAll(NewUTF8Reader("cut.yaml", strings.NewReader("a: \xc3")))
+	if err == nil || err.Error() != "cut.yaml:1: invalid UTF-8 byte 0xC3" {
+		t.Fatalf("want cut.yaml:1 invalid byte 0xC3, got %v", err)
+	}
+}
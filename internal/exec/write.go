@@ -2,6 +2,7 @@
 
 import (
 	"bufio"
+	"bytes"
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
@@ -9,21 +10,206 @@
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 	"unicode/utf8"
 )
 
+// WriteOptions tweaks how an output is written.
+type WriteOptions struct {
+	// Verify reads the file back after the rename and compares its SHA-256
+	// with the in-memory content (catches truncation / full-disk corruption).
+	Verify bool
+
+	// Encodings maps source paths to their encoding (see ReadSource) for
+	// BuildAndWriteWith; unlisted files are read as UTF-8.
+	Encodings map[string]string
+
+	// Retry retries transient source read errors in BuildAndWriteWith.
+	Retry ReadRetry
+
+	// Transforms maps source paths to the transformers (see TransformSource)
+	// BuildAndWriteWith runs on them; Target names the target for their context.
+	Transforms map[string][]string
+	Target     string
+
+	// Mtime, when non-zero, is applied to the output after the rename
+	// (reproducible builds); the zero value keeps the write time.
+	Mtime time.Time
+
+	// PostProcess names post-processors (see ApplyPostProcess) run on the
+	// content just before it is written.
+	PostProcess []string
+
+	// Mode is the write strategy (see WriteWithMode); "" means atomic.
+	Mode string
+
+	// Parse, when set, is run on the output read back after the write
+	// (verify_output); its error comes back as an *OutputVerifyError.
+	Parse func(b []byte) error
+}
+
+// OutputVerifyError reports a written output that does not parse in its own
+// format: a serialization bug rather than a problem with the sources.
+type OutputVerifyError struct {
+	Output string
+	Err    error
+}
+
+func (e *OutputVerifyError) Error() string {
+	return fmt.Sprintf("%s: output verification failed: %v", e.Output, e.Err)
+}
+
+func (e *OutputVerifyError) Unwrap() error { return e.Err }
+
+// afterRename is a test seam invoked right after the output file is in place
+// (renamed from its temp file, or rewritten by the copy and in-place modes).
+var afterRename func(outputPath string)
+
 // BuildAndWrite concatenates files -> normalized string -> atomic write.
 // (Used when no merge is requested.)
 func BuildAndWrite(outputPath string, files []string) error {
-	content, err := readAndNormalize(files)
+	return BuildAndWriteWith(outputPath, files, WriteOptions{})
+}
+
+// BuildAndWriteWith is BuildAndWrite with write options.
+func BuildAndWriteWith(outputPath string, files []string, opts WriteOptions) error {
+	content, err := readAndNormalize(files, opts)
 	if err != nil {
 		return err
 	}
-	return WriteAtomic(outputPath, content)
+	return WriteWith(outputPath, content, opts)
+}
+
+// WriteWith writes content atomically, then applies opts (e.g. read-back verification).
+func WriteWith(outputPath string, content string, opts WriteOptions) error {
+	content = ApplyPostProcess(content, opts.PostProcess)
+	if err := WriteWithMode(outputPath, content, opts.Mode); err != nil {
+		return err
+	}
+	if !opts.Mtime.IsZero() && IsFileOutput(outputPath) {
+		if err := os.Chtimes(outputPath, opts.Mtime, opts.Mtime); err != nil {
+			return fmt.Errorf("set mtime of %q: %w", outputPath, err)
+		}
+	}
+	if opts.Verify && IsFileOutput(outputPath) {
+		if err := verifyWritten(outputPath, content); err != nil {
+			return err
+		}
+	}
+	if opts.Parse != nil && outputPath != StdoutPath {
+		b, err := ReadOutput(outputPath)
+		if err != nil {
+			return fmt.Errorf("verify %q: %w", outputPath, err)
+		}
+		if err := opts.Parse(b); err != nil {
+			return &OutputVerifyError{Output: outputPath, Err: err}
+		}
+	}
+	return nil
+}
+
+// verifyWritten streams outputPath through SHA-256 and compares it with content's checksum.
+func verifyWritten(outputPath string, content string) error {
+	want := sha256.Sum256([]byte(content))
+
+	f, err := os.Open(outputPath)
+	if err != nil {
+		return fmt.Errorf("verify %q: %w", outputPath, err)
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return fmt.Errorf("verify %q: %w", outputPath, err)
+	}
+	if got := h.Sum(nil); !bytes.Equal(got, want[:]) {
+		return fmt.Errorf("verify %q: checksum mismatch after write (sha256 %s, want %s)",
+			outputPath, hex.EncodeToString(got), hex.EncodeToString(want[:]))
+	}
+	return nil
+}
+
+// StdoutPath is the output path meaning "write to stdout instead of a file".
+const StdoutPath = "-"
+
+// EnvOutputPrefix marks an output "env:NAME" that sets the environment
+// variable NAME of this process (inherited by hooks) instead of writing a file.
+const EnvOutputPrefix = "env:"
+
+// EnvOutputName returns NAME for an "env:NAME" output.
+func EnvOutputName(outputPath string) (string, bool) {
+	return strings.CutPrefix(outputPath, EnvOutputPrefix)
+}
+
+// IsFileOutput reports whether outputPath names a file (not stdout or env:NAME).
+func IsFileOutput(outputPath string) bool {
+	_, isEnv := EnvOutputName(outputPath)
+	return outputPath != StdoutPath && !isEnv
+}
+
+// ReadOutput reads back what WriteAtomic wrote to a file or env:NAME output.
+func ReadOutput(outputPath string) ([]byte, error) {
+	if name, ok := EnvOutputName(outputPath); ok {
+		v, set := os.LookupEnv(name)
+		if !set {
+			return nil, fmt.Errorf("environment variable %s is not set", name)
+		}
+		return []byte(v), nil
+	}
+	return os.ReadFile(outputPath)
+}
+
+// Output write strategies for WriteWithMode.
+const (
+	WriteModeAtomic  = "atomic"   // same-dir temp + fsync + rename
+	WriteModeCopy    = "copy"     // temp in os.TempDir(), then copied over the output
+	WriteModeInPlace = "in-place" // truncate and rewrite the output itself
+)
+
+// ValidWriteMode reports whether mode is "" or one of the WriteMode constants.
+func ValidWriteMode(mode string) bool {
+	switch mode {
+	case "", WriteModeAtomic, WriteModeCopy, WriteModeInPlace:
+		return true
+	}
+	return false
 }
 
 // WriteAtomic writes content to outputPath atomically (same-dir temp + fsync + rename).
+// outputPath "-" writes to stdout instead; "env:NAME" sets the variable NAME.
 func WriteAtomic(outputPath string, content string) error {
+	return WriteWithMode(outputPath, content, WriteModeAtomic)
+}
+
+// WriteWithMode is WriteAtomic with a choice of strategy for file outputs.
+// copy and in-place trade atomicity for outputs whose directory the user
+// cannot write to (or, for copy, rename into): readers may see a partial file.
+func WriteWithMode(outputPath string, content string, mode string) error {
+	if outputPath == StdoutPath {
+		if _, err := io.WriteString(os.Stdout, content); err != nil {
+			return fmt.Errorf("write stdout: %w", err)
+		}
+		return nil
+	}
+	if name, ok := EnvOutputName(outputPath); ok {
+		if err := os.Setenv(name, content); err != nil {
+			return fmt.Errorf("set %s: %w", name, err)
+		}
+		return nil
+	}
+	switch mode {
+	case "", WriteModeAtomic:
+		return writeAtomicFile(outputPath, content)
+	case WriteModeCopy:
+		return writeCopy(outputPath, content)
+	case WriteModeInPlace:
+		return writeInPlace(outputPath, strings.NewReader(content))
+	default:
+		return fmt.Errorf("unknown output mode %q (want atomic|copy|in-place)", mode)
+	}
+}
+
+// writeAtomicFile is WriteAtomic for a file output.
+func writeAtomicFile(outputPath string, content string) error {
 	// ensure parent dir exists
 	if err := os.MkdirAll(filepath.Dir(outputPath), 0o755); err != nil {
 		return fmt.Errorf("mkdir %q: %w", filepath.Dir(outputPath), err)
@@ -64,6 +250,9 @@ func WriteAtomic(outputPath string, content string) error {
 		_ = os.Remove(tmpName)
 		return fmt.Errorf("rename %q -> %q: %w", tmpName, outputPath, err)
 	}
+	if afterRename != nil {
+		afterRename(outputPath)
+	}
 
 	// best-effort fsync the directory
 	if dir, err := os.Open(filepath.Dir(outputPath)); err == nil {
@@ -74,51 +263,124 @@ func WriteAtomic(outputPath string, content string) error {
 	return nil
 }
 
+// writeCopy writes content to a temp file in os.TempDir(), then copies it
+// over outputPath.
+func writeCopy(outputPath string, content string) error {
+	tmp, err := os.CreateTemp("", ".confb-*")
+	if err != nil {
+		return fmt.Errorf("create temp: %w", err)
+	}
+	tmpName := tmp.Name()
+	defer os.Remove(tmpName)
+	defer tmp.Close()
+
+	if _, err := io.WriteString(tmp, content); err != nil {
+		return fmt.Errorf("write temp: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		return fmt.Errorf("sync temp: %w", err)
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("rewind temp: %w", err)
+	}
+	return writeInPlace(outputPath, tmp)
+}
+
+// writeInPlace truncates outputPath (creating it 0644 if missing) and writes
+// r into it, keeping the file's inode, owner and mode.
+func writeInPlace(outputPath string, r io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(outputPath), 0o755); err != nil {
+		return fmt.Errorf("mkdir %q: %w", filepath.Dir(outputPath), err)
+	}
+	f, err := os.OpenFile(outputPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return fmt.Errorf("open %q: %w", outputPath, err)
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("write %q: %w", outputPath, err)
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("sync %q: %w", outputPath, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("close %q: %w", outputPath, err)
+	}
+	if afterRename != nil {
+		afterRename(outputPath)
+	}
+	return nil
+}
+
 // SHA256OfFiles returns a hex sha256 of the normalized concatenation.
 // used only for --trace-checksums; same path as BuildAndWrite but without writing.
 func SHA256OfFiles(files []string) (string, error) {
-	content, err := readAndNormalize(files)
+	return SHA256OfSources(files, WriteOptions{})
+}
+
+// SHA256OfSources is SHA256OfFiles reading sources as BuildAndWriteWith would
+// (opts.Encodings, opts.Retry, opts.PostProcess).
+func SHA256OfSources(files []string, opts WriteOptions) (string, error) {
+	content, err := readAndNormalize(files, opts)
 	if err != nil {
 		return "", err
 	}
 	h := sha256.New()
-	_, _ = io.WriteString(h, content)
+	_, _ = io.WriteString(h, ApplyPostProcess(content, opts.PostProcess))
 	return hex.EncodeToString(h.Sum(nil)), nil
 }
 
-// readAndNormalize streams all files, converts CRLF/CR to LF, validates UTF-8,
-// ensures a single trailing newline, and inserts a newline between files if needed.
-func readAndNormalize(files []string) (string, error) {
+// readAndNormalize reads all files (retrying transient errors per opts.Retry),
+// decodes non-UTF-8 sources (per opts.Encodings), runs their transforms,
+// converts CRLF/CR to LF, validates UTF-8, ensures a single trailing newline,
+// and inserts a newline between files if needed.
+func readAndNormalize(files []string, opts WriteOptions) (string, error) {
 	var b stringsBuilder
 
 	for idx, path := range files {
-		f, err := os.Open(path)
+		raw, err := ReadWithRetry(path, opts.Retry.Attempts, opts.Retry.Backoff)
 		if err != nil {
-			return "", fmt.Errorf("open %q: %w", path, err)
+			return "", fmt.Errorf("read %q: %w", path, err)
+		}
+		enc := opts.Encodings[path]
+		if names := opts.Transforms[path]; len(names) > 0 {
+			// transformers see the whole decoded file
+			if raw, err = DecodeSource(raw, enc); err != nil {
+				return "", fmt.Errorf("decode %q: %w", path, err)
+			}
+			enc = ""
+			if raw, err = TransformSource(raw, opts.Target, path, names); err != nil {
+				return "", err
+			}
 		}
 
-		r := bufio.NewReader(f)
+		r := bufio.NewReader(bytes.NewReader(raw))
+		line := 1 // line the next chunk starts on, for UTF-8 errors
 		for {
 			chunk, err := r.ReadString('\n')
 			if len(chunk) > 0 {
+				if NormalizeEncoding(enc) != "utf8" {
+					dec, derr := DecodeSource([]byte(chunk), enc)
+					if derr != nil {
+						return "", fmt.Errorf("decode %q: %w", path, derr)
+					}
+					chunk = string(dec)
+				}
 				chunk = normalizeNewlines(chunk)
 				if !utf8.ValidString(chunk) {
-					_ = f.Close()
-					return "", fmt.Errorf("%q: not valid UTF-8 (MVP requires utf8)", path)
+					return "", utf8Error(path, chunk, line)
 				}
+				line += strings.Count(chunk, "\n")
 				b.WriteString(chunk)
 			}
 			if err == io.EOF {
 				break
 			}
 			if err != nil {
-				_ = f.Close()
 				return "", fmt.Errorf("read %q: %w", path, err)
 			}
 		}
-		if err := f.Close(); err != nil {
-			return "", fmt.Errorf("close %q: %w", path, err)
-		}
 
 		// ensure a newline boundary between files if the previous didn't end with one
 		if idx < len(files)-1 && !b.endsWithNewline() {
@@ -149,9 +411,9 @@ type stringsBuilder struct {
 	sb strings.Builder
 }
 
-func (b *stringsBuilder) WriteString(s string) { _, _ = b.sb.WriteString(s) }
-func (b *stringsBuilder) WriteByte(c byte)     { _ = b.sb.WriteByte(c) }
-func (b *stringsBuilder) String() string       { return b.sb.String() }
+func (b *stringsBuilder) WriteString(s string)   { _, _ = b.sb.WriteString(s) }
+func (b *stringsBuilder) WriteByte(c byte) error { return b.sb.WriteByte(c) }
+func (b *stringsBuilder) String() string         { return b.sb.String() }
 
 func (b *stringsBuilder) endsWithNewline() bool {
 	s := b.sb.String()
@@ -3,6 +3,9 @@
 import (
 	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
@@ -67,3 +70,118 @@ func TestSHA256OfFiles_MatchesBuildContent(t *testing.T) {
 		t.Fatalf("sha mismatch: got %s want %s", sum, want)
 	}
 }
+
+func TestWriteWith_VerifyCatchesTruncation(t *testing.T) {
+	td := t.TempDir()
+	out := filepath.Join(td, "out.yaml")
+	content := "a: 1\nb: 2\n"
+
+	// simulate corruption: truncate the file right after it is renamed into place
+	afterRename = func(p string) { _ = os.Truncate(p, 3) }
+	defer func() { afterRename = nil }()
+
+	if err := WriteWith(out, content, WriteOptions{}); err != nil {
+		t.Fatalf("unverified write should not notice corruption, got %v", err)
+	}
+	err := WriteWith(out, content, WriteOptions{Verify: true})
+	if err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Fatalf("verified write: want checksum mismatch, got %v", err)
+	}
+
+	afterRename = nil
+	if err := WriteWith(out, content, WriteOptions{Verify: true}); err != nil {
+		t.Fatalf("verified write of intact file: %v", err)
+	}
+}
+
+func TestWriteWith_ParseReportsCorruptOutput(t *testing.T) {
+	td := t.TempDir()
+	out := filepath.Join(td, "out.json")
+	content := "{\"a\": 1}\n"
+	parse := func(b []byte) error {
+		var v any
+		if err := json.Unmarshal(b, &v); err != nil {
+			return fmt.Errorf("JSON: %w", err)
+		}
+		return nil
+	}
+
+	if err := WriteWith(out, content, WriteOptions{Parse: parse}); err != nil {
+		t.Fatalf("intact output: %v", err)
+	}
+
+	// simulate a serializer bug: the written file no longer parses
+	afterRename = func(p string) {
+		b, _ := os.ReadFile(p)
+		_ = os.WriteFile(p, append([]byte("{{{"), b...), 0o644)
+	}
+	defer func() { afterRename = nil }()
+
+	err := WriteWith(out, content, WriteOptions{Parse: parse})
+	var verr *OutputVerifyError
+	if !errors.As(err, &verr) || verr.Output != out {
+		t.Fatalf("want *OutputVerifyError for %s, got %v", out, err)
+	}
+	if !strings.Contains(err.Error(), "output verification failed") || !strings.Contains(err.Error(), "JSON") {
+		t.Fatalf("want output verification failed naming JSON, got %v", err)
+	}
+}
+
+func TestWriteWithMode_AllModes(t *testing.T) {
+	td := t.TempDir()
+	sysTmp := filepath.Join(td, "tmp")
+	if err := os.Mkdir(sysTmp, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TMPDIR", sysTmp)
+
+	temps := func(dir string) int {
+		m, _ := filepath.Glob(filepath.Join(dir, ".confb-*"))
+		return len(m)
+	}
+	for _, mode := range []string{WriteModeAtomic, WriteModeCopy, WriteModeInPlace} {
+		out := filepath.Join(td, mode, "out.conf")
+		for _, content := range []string{"first version\nwith two lines\n", "second\n"} {
+			if err := WriteWithMode(out, content, mode); err != nil {
+				t.Fatalf("%s: %v", mode, err)
+			}
+			b, err := os.ReadFile(out)
+			if err != nil || string(b) != content {
+				t.Fatalf("%s: out = %q, %v; want %q", mode, b, err, content)
+			}
+		}
+		if n := temps(filepath.Dir(out)) + temps(sysTmp); n != 0 {
+			t.Fatalf("%s: %d temp files left behind", mode, n)
+		}
+	}
+
+	if err := WriteWithMode(filepath.Join(td, "x"), "x", "sideways"); err == nil || !strings.Contains(err.Error(), "unknown output mode") {
+		t.Fatalf("want unknown output mode error, got %v", err)
+	}
+}
+
+func TestWriteWithMode_InPlaceNeedsNoDirWrite(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("root ignores directory permissions")
+	}
+	dir := filepath.Join(t.TempDir(), "ro")
+	out := filepath.Join(dir, "out.conf")
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writeFileT(t, out, "old\n")
+	if err := os.Chmod(dir, 0o555); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(dir, 0o755)
+
+	if err := WriteWithMode(out, "new\n", WriteModeAtomic); err == nil {
+		t.Fatalf("atomic write into a read-only directory should fail")
+	}
+	if err := WriteWithMode(out, "new\n", WriteModeInPlace); err != nil {
+		t.Fatalf("in-place: %v", err)
+	}
+	if got, _ := os.ReadFile(out); string(got) != "new\n" {
+		t.Fatalf("out = %q", got)
+	}
+}
@@ -0,0 +1,179 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Registry holds labelled counters and gauges and renders them in the
+// Prometheus text exposition format (version 0.0.4). It is safe for
+// concurrent use; a nil *Registry ignores all updates.
+type Registry struct {
+	mu       sync.Mutex
+	families map[string]*family
+	order    []string // declaration order, used for output
+}
+
+type family struct {
+	help   string
+	kind   string             // counter|gauge
+	series map[string]float64 // rendered label set -> value
+}
+
+// New returns an empty registry.
+func New() *Registry {
+	return &Registry{families: map[string]*family{}}
+}
+
+// Counter declares a counter family. Declaring an existing name is a no-op.
+func (r *Registry) Counter(name, help string) { r.declare(name, help, "counter") }
+
+// Gauge declares a gauge family. Declaring an existing name is a no-op.
+func (r *Registry) Gauge(name, help string) { r.declare(name, help, "gauge") }
+
+func (r *Registry) declare(name, help, kind string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.families[name]; ok {
+		return
+	}
+	r.families[name] = &family{help: help, kind: kind, series: map[string]float64{}}
+	r.order = append(r.order, name)
+}
+
+// Add increments the series of a declared family; labels are key/value pairs.
+func (r *Registry) Add(name string, delta float64, labels ...string) {
+	r.update(name, labels, func(v float64) float64 { return v + delta })
+}
+
+// Inc is Add(name, 1, labels...).
+func (r *Registry) Inc(name string, labels ...string) { r.Add(name, 1, labels...) }
+
+// Set sets the series of a declared family to v.
+func (r *Registry) Set(name string, v float64, labels ...string) {
+	r.update(name, labels, func(float64) float64 { return v })
+}
+
+func (r *Registry) update(name string, labels []string, fn func(float64) float64) {
+	if r == nil {
+		return
+	}
+	key := renderLabels(labels)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	f, ok := r.families[name]
+	if !ok {
+		return
+	}
+	f.series[key] = fn(f.series[key])
+}
+
+// WriteText renders all families with their HELP/TYPE lines. Series within a
+// family are sorted by label set so output is deterministic.
+func (r *Registry) WriteText(w io.Writer) error {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	var b strings.Builder
+	for _, name := range r.order {
+		f := r.families[name]
+		fmt.Fprintf(&b, "# HELP %s %s\n", name, escapeHelp(f.help))
+		fmt.Fprintf(&b, "# TYPE %s %s\n", name, f.kind)
+		keys := make([]string, 0, len(f.series))
+		for k := range f.series {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Fprintf(&b, "%s%s %s\n", name, k, strconv.FormatFloat(f.series[k], 'g', -1, 64))
+		}
+	}
+	r.mu.Unlock()
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// Handler serves the registry at any path; mount it on /metrics.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_ = r.WriteText(w)
+	})
+}
+
+// Serve starts an HTTP server exposing GET /metrics on addr. The listener is
+// bound before returning, so address errors surface immediately. The returned
+// function shuts the server down, waiting up to timeout for in-flight scrapes.
+func Serve(addr string, r *Registry) (stop func(timeout time.Duration) error, err error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("metrics listen %q: %w", addr, err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", r.Handler())
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+
+	done := make(chan error, 1)
+	go func() {
+		err := srv.Serve(ln)
+		if errors.Is(err, http.ErrServerClosed) {
+			err = nil
+		}
+		done <- err
+	}()
+
+	return func(timeout time.Duration) error {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			return err
+		}
+		return <-done
+	}, nil
+}
+
+// renderLabels formats key/value pairs as {k="v",...}; an odd trailing key is dropped.
+func renderLabels(kv []string) string {
+	if len(kv) < 2 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i := 0; i+1 < len(kv); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(kv[i])
+		b.WriteString(`="`)
+		b.WriteString(escapeLabel(kv[i+1]))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+func escapeHelp(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(s)
+}
@@ -0,0 +1,35 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWriteText_FormatAndOrder(t *testing.T) {
+	r := New()
+	r.Counter("x_total", "Things.")
+	r.Gauge("y", "Level.")
+	r.Inc("x_total", "target", "b")
+	r.Add("x_total", 2, "target", "a")
+	r.Set("y", 1.5, "target", `q"t`)
+	r.Inc("undeclared", "target", "a") // ignored
+
+	var b strings.Builder
+	if err := r.WriteText(&b); err != nil {
+		t.Fatal(err)
+	}
+	want := `# HELP x_total Things.
+# TYPE x_total counter
+x_total{target="a"} 2
+x_total{target="b"} 1
+# HELP y Level.
+# TYPE y gauge
+y{target="q\"t"} 1.5
+`
+	if b.String() != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", b.String(), want)
+	}
+
+	var nilReg *Registry
+	nilReg.Inc("x_total") // must not panic
+}
@@ -1,21 +1,60 @@
 package plan
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/nekwebdev/confb/internal/config"
+	executor "github.com/nekwebdev/confb/internal/exec"
 )
 
+// LockFileName is the presence marker `confb run --lock` writes into watched
+// directories. It is never treated as a source, even when a glob matches it.
+const LockFileName = ".confb.lock"
+
+// ErrEmptySourceSet is returned by PlanTarget when every source is optional
+// and none matched a file.
+var ErrEmptySourceSet = errors.New("resolved file list is empty")
+
 // ResolvedTarget is the concrete build plan for one target.
 type ResolvedTarget struct {
 	Name    string
 	Output  string   // final output path (already tilde-expanded in config)
 	Files   []string // absolute paths to read, in order
 	Deduped []string // absolute paths dropped due to by_path dedupe
+
+	// Encodings maps files read with a non-UTF-8 source encoding to it.
+	Encodings map[string]string
+	// MergePatch marks files whose source sets merge_patch.
+	MergePatch map[string]bool
+	// Transforms maps files whose source lists transforms to them.
+	Transforms map[string][]string
+
+	// NewestSourceMtime is the latest modification time among Files.
+	NewestSourceMtime time.Time
+}
+
+// OutputMtime returns the mtime to stamp on the output for t's mtime_source:
+// the zero time for "now" (leave it to the write), the newest source mtime,
+// or the Unix epoch for "zero".
+func OutputMtime(t config.Target, rt *ResolvedTarget) time.Time {
+	switch strings.ToLower(t.MtimeSource) {
+	case "newest_source":
+		return rt.NewestSourceMtime
+	case "zero":
+		return time.Unix(0, 0)
+	default:
+		return time.Time{}
+	}
 }
 
 // PlanTarget resolves globs, expands ~, applies sort + optional + dedupe rules.
@@ -24,36 +63,100 @@ func PlanTarget(cfg *config.Config, t config.Target, outputOverride string) (*Re
 	if err != nil {
 		return nil, err
 	}
+	baseDir = cfg.SourceDir(t) // work_dir, or the config directory
 
 	out := t.Output
 	if outputOverride != "" {
 		out = outputOverride
 	}
 
-	var files []string
-	var deduped []string
-	seen := map[string]struct{}{}
+	// every match of every source, in order; dedupe happens once all are known
+	type candidate struct {
+		abs string
+		src config.Source
+	}
+	var cands []candidate
 
 	for i, src := range t.Sources {
-		// expand ~ and make path absolute (relative to confb.yaml dir)
-		p := expandTilde(src.Path)
+		// expand $VARS and ~, make path absolute (relative to confb.yaml dir)
+		p := expandTilde(os.ExpandEnv(src.Path))
 		if !filepath.IsAbs(p) {
 			p = filepath.Join(baseDir, p)
 		}
 
 		var matches []string
 		hasGlob := strings.ContainsAny(p, "*?[")
-		if hasGlob {
-			m, err := filepath.Glob(p)
+		isDir := false
+		if src.Inline != "" {
+			// literal fragment, materialised as a content-addressed file
+			p, err = inlineFile(src.Inline)
+			if err != nil {
+				return nil, fmt.Errorf("%s: sources[%d] inline: %w", t.Name, i, err)
+			}
+			hasGlob = false
+		} else if src.DataURI != "" {
+			// decoded payload, materialised like inline (bytes kept as-is)
+			_, data, derr := config.DecodeDataURI(src.DataURI)
+			if derr != nil {
+				return nil, fmt.Errorf("%s: sources[%d] %w", t.Name, i, derr)
+			}
+			p, err = contentFile(data)
+			if err != nil {
+				return nil, fmt.Errorf("%s: sources[%d] data_uri: %w", t.Name, i, err)
+			}
+			hasGlob = false
+		} else if src.TargetRef != "" {
+			// another target's output, read as a single file
+			p, err = RefOutput(cfg, src.TargetRef)
 			if err != nil {
-				return nil, fmt.Errorf("%s: sources[%d] invalid glob %q: %w", t.Name, i, src.Path, err)
+				return nil, fmt.Errorf("%s: sources[%d] %w", t.Name, i, err)
+			}
+			hasGlob = false
+		} else if !hasGlob {
+			if st, err := os.Stat(p); err == nil && st.IsDir() {
+				isDir = true
+			}
+		}
+		if hasGlob || isDir {
+			var m []string
+			if isDir {
+				m, err = listDir(p, src.DirGlob)
+				if err != nil {
+					return nil, fmt.Errorf("%s: sources[%d] directory %q: %w", t.Name, i, src.Path, err)
+				}
+			} else if src.FollowSymlinks {
+				m, err = globFollow(p, src.Optional)
+				if err != nil {
+					return nil, fmt.Errorf("%s: sources[%d] glob %q: %w", t.Name, i, src.Path, err)
+				}
+			} else {
+				m, err = filepath.Glob(p)
+				if err != nil {
+					return nil, fmt.Errorf("%s: sources[%d] invalid glob %q: %w", t.Name, i, src.Path, err)
+				}
+			}
+			for _, f := range m {
+				if filepath.Base(f) != LockFileName {
+					matches = append(matches, f)
+				}
 			}
-			matches = append(matches, m...)
 
 			// explicit deterministic sort for lex (do NOT rely on OS glob order)
 			if !strings.EqualFold(src.Sort, "none") {
 				sort.Strings(matches)
 			}
+			// numeric: natural order of basenames ("2-x" before "10-x"), lex order breaks ties
+			if strings.EqualFold(src.Sort, "numeric") {
+				sort.SliceStable(matches, func(a, b int) bool {
+					return naturalLess(filepath.Base(matches[a]), filepath.Base(matches[b]))
+				})
+			}
+			// mtime_*: order by modification time, lex order breaks ties
+			if mode := strings.ToLower(src.Sort); mode == "mtime_asc" || mode == "mtime_desc" {
+				if err := sortByMtime(matches, mode == "mtime_desc"); err != nil {
+					return nil, fmt.Errorf("%s: sources[%d] %w", t.Name, i, err)
+				}
+			}
 
 			if len(matches) == 0 && !src.Optional {
 				return nil, fmt.Errorf("%s: sources[%d] pattern %q matched no files", t.Name, i, src.Path)
@@ -68,40 +171,220 @@ func PlanTarget(cfg *config.Config, t config.Target, outputOverride string) (*Re
 				return nil, fmt.Errorf("%s: sources[%d] file %q: %w", t.Name, i, src.Path, err)
 			}
 			if st.IsDir() {
-				return nil, fmt.Errorf("%s: sources[%d] %q is a directory (use a glob like %q/*)", t.Name, i, src.Path, src.Path)
+				return nil, fmt.Errorf("%s: sources[%d] %q is a directory", t.Name, i, src.Path)
 			}
 			matches = []string{p}
 		}
 
-		// apply dedupe policy (by absolute path), keep first occurrence
 		for _, m := range matches {
 			abs, err := filepath.Abs(m)
 			if err != nil {
 				return nil, fmt.Errorf("%s: resolve %q: %w", t.Name, m, err)
 			}
-			if strings.EqualFold(t.Dedupe, "by_path") {
-				if _, ok := seen[abs]; ok {
-					deduped = append(deduped, abs)
-					continue
-				}
-				seen[abs] = struct{}{}
+			cands = append(cands, candidate{abs: abs, src: src})
+		}
+	}
+
+	// apply dedupe policy (by absolute path): the occurrence from the source with
+	// the highest priority wins, the first one among equal priorities
+	winner := map[string]int{}
+	if strings.EqualFold(t.Dedupe, "by_path") {
+		for i, c := range cands {
+			if w, ok := winner[c.abs]; !ok || c.src.Priority > cands[w].src.Priority {
+				winner[c.abs] = i
 			}
-			files = append(files, abs)
+		}
+	}
+	var files []string
+	var deduped []string
+	encodings := map[string]string{}
+	patches := map[string]bool{}
+	transforms := map[string][]string{}
+	for i, c := range cands {
+		if w, ok := winner[c.abs]; ok && w != i {
+			deduped = append(deduped, c.abs)
+			continue
+		}
+		files = append(files, c.abs)
+		if enc := executor.NormalizeEncoding(c.src.Encoding); enc != "utf8" {
+			encodings[c.abs] = enc
+		}
+		if c.src.MergePatch {
+			patches[c.abs] = true
+		}
+		if len(c.src.Transforms) > 0 {
+			transforms[c.abs] = c.src.Transforms
 		}
 	}
 
 	if len(files) == 0 {
-		return nil, fmt.Errorf("%s: resolved file list is empty", t.Name)
+		return nil, fmt.Errorf("%s: %w", t.Name, ErrEmptySourceSet)
+	}
+
+	var newest time.Time
+	for _, f := range files {
+		if fi, err := os.Stat(f); err == nil && fi.ModTime().After(newest) {
+			newest = fi.ModTime()
+		}
 	}
 
 	return &ResolvedTarget{
-		Name:    t.Name,
-		Output:  out,
-		Files:   files,
-		Deduped: deduped,
+		Name:              t.Name,
+		Output:            out,
+		Files:             files,
+		Deduped:           deduped,
+		Encodings:         encodings,
+		MergePatch:        patches,
+		Transforms:        transforms,
+		NewestSourceMtime: newest,
 	}, nil
 }
 
+// inlineFile writes an inline source (CRLF normalised) to a file in the
+// inline directory named by the SHA-256 of its content and returns the path.
+// Identical fragments share a file, so daemon rebuilds reuse it.
+func inlineFile(content string) (string, error) {
+	return contentFile([]byte(strings.ReplaceAll(content, "\r\n", "\n")))
+}
+
+// contentFile is inlineFile without the newline normalisation; data_uri
+// payloads are written byte for byte.
+func contentFile(content []byte) (string, error) {
+	sum := sha256.Sum256(content)
+	dir, err := inlineDir()
+	if err != nil {
+		return "", err
+	}
+	p := filepath.Join(dir, hex.EncodeToString(sum[:]))
+	if b, err := os.ReadFile(p); err == nil && bytes.Equal(b, content) {
+		return p, nil
+	}
+	tmp, err := os.CreateTemp(dir, ".inline-*")
+	if err != nil {
+		return "", err
+	}
+	if _, err := tmp.Write(content); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), p); err != nil {
+		_ = os.Remove(tmp.Name())
+		return "", err
+	}
+	return p, nil
+}
+
+// inline is the temp directory holding inline and data_uri content; it is
+// created on first use and lives until RemoveInlineDir.
+var inline struct {
+	mu  sync.Mutex
+	dir string
+}
+
+func inlineDir() (string, error) {
+	inline.mu.Lock()
+	defer inline.mu.Unlock()
+	if inline.dir == "" {
+		dir, err := os.MkdirTemp("", "confb-inline-")
+		if err != nil {
+			return "", err
+		}
+		inline.dir = dir
+	}
+	return inline.dir, nil
+}
+
+// IsInlineFile reports whether p was written by PlanTarget for an inline or
+// data_uri source. Its directory differs from one build to the next, so
+// headers show these files without it.
+func IsInlineFile(p string) bool {
+	inline.mu.Lock()
+	defer inline.mu.Unlock()
+	return inline.dir != "" && filepath.Dir(p) == inline.dir
+}
+
+// RemoveInlineDir deletes the files PlanTarget wrote for inline and data_uri
+// sources. Call it once the build (or daemon run) using them is over; a later
+// PlanTarget starts a new directory.
+func RemoveInlineDir() error {
+	inline.mu.Lock()
+	defer inline.mu.Unlock()
+	if inline.dir == "" {
+		return nil
+	}
+	err := os.RemoveAll(inline.dir)
+	inline.dir = ""
+	return err
+}
+
+// listDir returns the regular files directly inside dir whose names match
+// pattern (default "*"), in directory order. Subdirectories are not descended.
+func listDir(dir, pattern string) ([]string, error) {
+	if pattern == "" {
+		pattern = "*"
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, e := range entries {
+		if !e.Type().IsRegular() {
+			continue
+		}
+		ok, err := filepath.Match(pattern, e.Name())
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			out = append(out, filepath.Join(dir, e.Name()))
+		}
+	}
+	return out, nil
+}
+
+// sortByMtime stably sorts paths by modification time (oldest first, or newest first if desc).
+func sortByMtime(paths []string, desc bool) error {
+	mtimes := make(map[string]int64, len(paths))
+	for _, p := range paths {
+		st, err := os.Stat(p)
+		if err != nil {
+			return fmt.Errorf("stat %q: %w", p, err)
+		}
+		mtimes[p] = st.ModTime().UnixNano()
+	}
+	sort.SliceStable(paths, func(i, j int) bool {
+		if desc {
+			return mtimes[paths[i]] > mtimes[paths[j]]
+		}
+		return mtimes[paths[i]] < mtimes[paths[j]]
+	})
+	return nil
+}
+
+// RefOutput returns the absolute output path of the target named ref, i.e. the
+// file a target_ref source reads. Relative outputs resolve like the writer does
+// (against the working directory).
+func RefOutput(cfg *config.Config, ref string) (string, error) {
+	dep, ok := cfg.TargetByName(ref)
+	if !ok {
+		return "", fmt.Errorf("target_ref %q does not name a target", ref)
+	}
+	if dep.Output == "-" {
+		return "", fmt.Errorf("target_ref %q writes to stdout", ref)
+	}
+	abs, err := filepath.Abs(expandTilde(dep.Output))
+	if err != nil {
+		return "", fmt.Errorf("target_ref %q: %w", ref, err)
+	}
+	return abs, nil
+}
+
 // local copy; avoids exporting from config package
 func expandTilde(p string) string {
 	if p == "" {
@@ -6,6 +6,7 @@
 	"runtime"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/nekwebdev/confb/internal/config"
 )
@@ -198,3 +199,398 @@ func TestPlanTarget_OutputTildeExpanded(t *testing.T) {
 		t.Fatalf("Output not expanded to HOME: %s", rt.Output)
 	}
 }
+
+func TestPlanTarget_NewestSourceMtime(t *testing.T) {
+	td := t.TempDir()
+	base := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	mtimes := map[string]time.Time{
+		"a.conf": base,
+		"b.conf": base.Add(90 * time.Minute), // newest, in the middle of the order
+		"c.conf": base.Add(30 * time.Minute),
+	}
+	for name, mt := range mtimes {
+		p := filepath.Join(td, "d", name)
+		writeFileT(t, p, name+"\n")
+		if err := os.Chtimes(p, mt, mt); err != nil {
+			t.Fatalf("chtimes: %v", err)
+		}
+	}
+	cfgPath := writeConfT(t, td, `
+version: 1
+targets:
+  - name: r
+    format: raw
+    output: ./out.conf
+    sources:
+      - path: ./d/*.conf
+`)
+	cfg, err := config.Load(cfgPath)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	rt, err := PlanTarget(cfg, cfg.Targets[0], "")
+	if err != nil {
+		t.Fatalf("PlanTarget: %v", err)
+	}
+	if want := mtimes["b.conf"]; !rt.NewestSourceMtime.Equal(want) {
+		t.Fatalf("NewestSourceMtime = %v, want %v", rt.NewestSourceMtime, want)
+	}
+}
+
+func TestPlanTarget_SortMtime(t *testing.T) {
+	td := t.TempDir()
+
+	// names deliberately disagree with mtime order
+	now := time.Now()
+	files := []struct {
+		name string
+		age  time.Duration
+	}{
+		{"a.yaml", 1 * time.Hour},
+		{"b.yaml", 3 * time.Hour},
+		{"c.yaml", 2 * time.Hour},
+	}
+	for _, f := range files {
+		p := filepath.Join(td, "d", f.name)
+		writeFileT(t, p, "x: 1\n")
+		mt := now.Add(-f.age)
+		if err := os.Chtimes(p, mt, mt); err != nil {
+			t.Fatalf("chtimes: %v", err)
+		}
+	}
+
+	for _, c := range []struct {
+		mode string
+		want []string
+	}{
+		{"mtime_asc", []string{"b.yaml", "c.yaml", "a.yaml"}},
+		{"mtime_desc", []string{"a.yaml", "c.yaml", "b.yaml"}},
+	} {
+		cfgPath := writeConfT(t, td, `
+version: 1
+targets:
+  - name: y
+    format: raw
+    output: ./out.yaml
+    sources:
+      - path: ./d/*.yaml
+        sort: `+c.mode+`
+`)
+		cfg, err := config.Load(cfgPath)
+		if err != nil {
+			t.Fatalf("Load: %v", err)
+		}
+		rt, err := PlanTarget(cfg, cfg.Targets[0], "")
+		if err != nil {
+			t.Fatalf("PlanTarget: %v", err)
+		}
+		var got []string
+		for _, f := range rt.Files {
+			got = append(got, filepath.Base(f))
+		}
+		if strings.Join(got, ",") != strings.Join(c.want, ",") {
+			t.Fatalf("%s: order = %v, want %v", c.mode, got, c.want)
+		}
+	}
+}
+
+func TestPlanTarget_DirectorySource_AllChildrenSorted(t *testing.T) {
+	td := t.TempDir()
+	dir := filepath.Join(td, "conf.d")
+	writeFileT(t, filepath.Join(dir, "c.yaml"), "c: 1\n")
+	writeFileT(t, filepath.Join(dir, "a.yaml"), "a: 1\n")
+	writeFileT(t, filepath.Join(dir, "b.yaml"), "b: 1\n")
+	writeFileT(t, filepath.Join(dir, "nested", "d.yaml"), "d: 1\n") // not descended
+
+	cfgPath := writeConfT(t, td, `
+version: 1
+targets:
+  - name: app
+    format: yaml
+    output: ./out.yaml
+    sources:
+      - path: ./conf.d
+`)
+	cfg, err := config.Load(cfgPath)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	rt, err := PlanTarget(cfg, cfg.Targets[0], "")
+	if err != nil {
+		t.Fatalf("PlanTarget: %v", err)
+	}
+	want := []string{
+		filepath.Join(dir, "a.yaml"),
+		filepath.Join(dir, "b.yaml"),
+		filepath.Join(dir, "c.yaml"),
+	}
+	if strings.Join(rt.Files, ",") != strings.Join(want, ",") {
+		t.Fatalf("files = %v, want %v", rt.Files, want)
+	}
+
+	// dir_glob filters the directory's children
+	writeFileT(t, filepath.Join(dir, "notes.txt"), "x\n")
+	tgt := cfg.Targets[0]
+	tgt.Sources[0].DirGlob = "*.yaml"
+	rt, err = PlanTarget(cfg, tgt, "")
+	if err != nil {
+		t.Fatalf("PlanTarget with dir_glob: %v", err)
+	}
+	if len(rt.Files) != 3 {
+		t.Fatalf("dir_glob *.yaml: files = %v, want 3 yaml files", rt.Files)
+	}
+}
+
+func TestPlanTarget_PriorityWinsDedupe(t *testing.T) {
+	td := t.TempDir()
+	a := filepath.Join(td, "src", "a.kdl")
+	b := filepath.Join(td, "src", "b.kdl")
+	local := filepath.Join(td, "local.kdl")
+	writeFileT(t, a, "a\n")
+	writeFileT(t, b, "b\n")
+	writeFileT(t, local, "local\n")
+
+	// a.kdl matches both globs; the later, higher-priority source keeps it
+	cfgPath := writeConfT(t, td, `
+version: 1
+targets:
+  - name: niri
+    format: kdl
+    output: ./out.kdl
+    sources:
+      - path: ./src/*.kdl
+      - path: ./local.kdl
+      - path: ./src/a*.kdl
+        priority: 10
+`)
+	cfg, err := config.Load(cfgPath)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	rt, err := PlanTarget(cfg, cfg.Targets[0], "")
+	if err != nil {
+		t.Fatalf("PlanTarget: %v", err)
+	}
+
+	want := []string{b, local, a}
+	if strings.Join(rt.Files, ",") != strings.Join(want, ",") {
+		t.Fatalf("files = %v, want %v", rt.Files, want)
+	}
+	if len(rt.Deduped) != 1 || rt.Deduped[0] != a {
+		t.Fatalf("deduped = %v, want [%s]", rt.Deduped, a)
+	}
+}
+
+func TestPlanTarget_SortNumeric(t *testing.T) {
+	td := t.TempDir()
+	// lex order would be 01-a, 10-c, 2-b, 20-d, 3-e
+	for _, n := range []string{"20-d", "10-c", "3-e", "2-b", "01-a"} {
+		writeFileT(t, filepath.Join(td, "d", n+".yaml"), n+"\n")
+	}
+
+	cfgPath := writeConfT(t, td, `
+version: 1
+targets:
+  - name: y
+    format: raw
+    output: ./out.yaml
+    sources:
+      - path: ./d/*.yaml
+        sort: numeric
+`)
+	cfg, err := config.Load(cfgPath)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	rt, err := PlanTarget(cfg, cfg.Targets[0], "")
+	if err != nil {
+		t.Fatalf("PlanTarget: %v", err)
+	}
+	var got []string
+	for _, f := range rt.Files {
+		got = append(got, filepath.Base(f))
+	}
+	want := []string{"01-a.yaml", "2-b.yaml", "3-e.yaml", "10-c.yaml", "20-d.yaml"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("order = %v, want %v", got, want)
+	}
+}
+
+func TestPlanTarget_FollowSymlinks(t *testing.T) {
+	td := t.TempDir()
+	writeFileT(t, filepath.Join(td, "real", "a.yaml"), "a: 1\n")
+	writeFileT(t, filepath.Join(td, "d", "b.yaml"), "b: 1\n")
+	if err := os.Symlink(filepath.Join(td, "real", "a.yaml"), filepath.Join(td, "d", "a.yaml")); err != nil {
+		t.Skipf("symlink: %v", err)
+	}
+
+	cfgPath := writeConfT(t, td, `
+version: 1
+targets:
+  - name: y
+    format: yaml
+    output: ./out.yaml
+    sources:
+      - path: ./d/*.yaml
+        follow_symlinks: true
+`)
+	cfg, err := config.Load(cfgPath)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	rt, err := PlanTarget(cfg, cfg.Targets[0], "")
+	if err != nil {
+		t.Fatalf("PlanTarget: %v", err)
+	}
+	var got []string
+	for _, f := range rt.Files {
+		got = append(got, filepath.Base(f))
+	}
+	if strings.Join(got, ",") != "a.yaml,b.yaml" {
+		t.Fatalf("files = %v, want [a.yaml b.yaml]", got)
+	}
+
+	// a broken link is fatal unless the source is optional
+	if err := os.Symlink(filepath.Join(td, "real", "gone.yaml"), filepath.Join(td, "d", "c.yaml")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := PlanTarget(cfg, cfg.Targets[0], ""); err == nil || !strings.Contains(err.Error(), "broken symlink") {
+		t.Fatalf("want broken symlink error, got %v", err)
+	}
+	cfg.Targets[0].Sources[0].Optional = true
+	rt, err = PlanTarget(cfg, cfg.Targets[0], "")
+	if err != nil {
+		t.Fatalf("PlanTarget (optional): %v", err)
+	}
+	if len(rt.Files) != 2 {
+		t.Fatalf("files = %v, want 2", rt.Files)
+	}
+}
+
+func TestPlanTarget_WorkDir(t *testing.T) {
+	td := t.TempDir()
+	// same relative names in the config dir and in subproject/: only the latter may match
+	writeFileT(t, filepath.Join(td, "base.yaml"), "wrong: true\n")
+	writeFileT(t, filepath.Join(td, "conf.d", "x.yaml"), "wrong: true\n")
+	writeFileT(t, filepath.Join(td, "subproject", "base.yaml"), "a: 1\n")
+	writeFileT(t, filepath.Join(td, "subproject", "conf.d", "y.yaml"), "b: 1\n")
+
+	cfgPath := writeConfT(t, td, `
+version: 1
+targets:
+  - name: y
+    format: yaml
+    output: ./out.yaml
+    work_dir: ./subproject
+    sources:
+      - path: ./base.yaml
+      - path: conf.d/*.yaml
+`)
+	cfg, err := config.Load(cfgPath)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	rt, err := PlanTarget(cfg, cfg.Targets[0], "")
+	if err != nil {
+		t.Fatalf("PlanTarget: %v", err)
+	}
+	want := []string{
+		filepath.Join(td, "subproject", "base.yaml"),
+		filepath.Join(td, "subproject", "conf.d", "y.yaml"),
+	}
+	if strings.Join(rt.Files, ",") != strings.Join(want, ",") {
+		t.Fatalf("files = %v, want %v", rt.Files, want)
+	}
+	if w := cfg.Warnings(); len(w) != 0 {
+		t.Fatalf("unexpected warnings: %v", w)
+	}
+
+	cfg.Targets[0].WorkDir = "/definitely/not/home"
+	if w := cfg.Warnings(); len(w) != 1 || !strings.Contains(w[0], "outside the home and config directories") {
+		t.Fatalf("want work_dir warning, got %v", w)
+	}
+}
+
+func TestRemoveInlineDir(t *testing.T) {
+	td := t.TempDir()
+	cfgPath := writeConfT(t, td, `
+version: 1
+targets:
+  - name: x
+    format: raw
+    output: ./out.conf
+    sources:
+      - inline: "a = 1"
+`)
+	cfg, err := config.Load(cfgPath)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	rt, err := PlanTarget(cfg, cfg.Targets[0], "")
+	if err != nil {
+		t.Fatalf("PlanTarget: %v", err)
+	}
+	first := rt.Files[0]
+	if fi, err := os.Stat(filepath.Dir(first)); err != nil || fi.Mode().Perm() != 0o700 {
+		t.Fatalf("inline dir: %v, %v; want mode 0700", fi, err)
+	}
+
+	if err := RemoveInlineDir(); err != nil {
+		t.Fatalf("RemoveInlineDir: %v", err)
+	}
+	if _, err := os.Stat(filepath.Dir(first)); !os.IsNotExist(err) {
+		t.Fatalf("inline dir still there after RemoveInlineDir: %v", err)
+	}
+
+	// the next plan starts a fresh directory
+	rt, err = PlanTarget(cfg, cfg.Targets[0], "")
+	if err != nil {
+		t.Fatalf("PlanTarget after remove: %v", err)
+	}
+	defer func() { _ = RemoveInlineDir() }()
+	if b, err := os.ReadFile(rt.Files[0]); err != nil || string(b) != "a = 1" {
+		t.Fatalf("inline file = %q, %v", b, err)
+	}
+	if filepath.Dir(rt.Files[0]) == filepath.Dir(first) {
+		t.Fatalf("inline dir reused after RemoveInlineDir: %s", rt.Files[0])
+	}
+}
+
+func TestSchedule_TargetRefOrderAndBatchSize(t *testing.T) {
+	ref := func(name string, refs ...string) config.Target {
+		tg := config.Target{Name: name}
+		for _, r := range refs {
+			tg.Sources = append(tg.Sources, config.Source{TargetRef: r})
+		}
+		return tg
+	}
+	names := func(batches [][]config.Target) string {
+		var out []string
+		for _, b := range batches {
+			var n []string
+			for _, tg := range b {
+				n = append(n, tg.Name)
+			}
+			out = append(out, strings.Join(n, ","))
+		}
+		return strings.Join(out, " | ")
+	}
+
+	// c <- b <- a chain plus independent x and y; "gone" is not being built
+	targets := []config.Target{ref("c", "b"), ref("b", "a", "a"), ref("a", "gone"), ref("x"), ref("y")}
+	got, err := Schedule(targets, 0)
+	if err != nil {
+		t.Fatalf("Schedule: %v", err)
+	}
+	if want := "a,x,y | b | c"; names(got) != want {
+		t.Fatalf("batches = %q, want %q", names(got), want)
+	}
+	got, _ = Schedule(targets, 2)
+	if want := "a,x | y | b | c"; names(got) != want {
+		t.Fatalf("batches (max 2) = %q, want %q", names(got), want)
+	}
+
+	if _, err := Schedule([]config.Target{ref("p", "q"), ref("q", "p"), ref("r")}, 4); err == nil || !strings.Contains(err.Error(), "cycle among: p, q") {
+		t.Fatalf("want cycle error, got %v", err)
+	}
+}
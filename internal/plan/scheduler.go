@@ -0,0 +1,80 @@
+package plan
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/nekwebdev/confb/internal/config"
+)
+
+// Schedule groups targets into batches that can be built concurrently: a
+// target is placed in a later batch than every target it reads via target_ref
+// (Kahn's algorithm, level by level). References to targets not in the list
+// are ignored. Each batch keeps the input order and holds at most maxParallel
+// targets (no limit if maxParallel <= 0). A cycle is an error.
+func Schedule(targets []config.Target, maxParallel int) ([][]config.Target, error) {
+	index := make(map[string]int, len(targets))
+	for i, t := range targets {
+		index[t.Name] = i
+	}
+	indegree := make([]int, len(targets))
+	dependents := make([][]int, len(targets))
+	for i, t := range targets {
+		seen := map[int]bool{}
+		for _, s := range t.Sources {
+			dep, ok := index[s.TargetRef]
+			if s.TargetRef == "" || !ok || seen[dep] {
+				continue
+			}
+			seen[dep] = true
+			indegree[i]++
+			dependents[dep] = append(dependents[dep], i)
+		}
+	}
+
+	var ready []int
+	for i := range targets {
+		if indegree[i] == 0 {
+			ready = append(ready, i)
+		}
+	}
+	var batches [][]config.Target
+	scheduled := 0
+	for len(ready) > 0 {
+		var next []int
+		for start := 0; start < len(ready); {
+			end := len(ready)
+			if maxParallel > 0 {
+				end = min(start+maxParallel, len(ready))
+			}
+			batch := make([]config.Target, 0, end-start)
+			for _, i := range ready[start:end] {
+				batch = append(batch, targets[i])
+			}
+			batches = append(batches, batch)
+			start = end
+		}
+		for _, i := range ready {
+			scheduled++
+			for _, d := range dependents[i] {
+				if indegree[d]--; indegree[d] == 0 {
+					next = append(next, d)
+				}
+			}
+		}
+		sort.Ints(next)
+		ready = next
+	}
+
+	if scheduled < len(targets) {
+		var stuck []string
+		for i, t := range targets {
+			if indegree[i] > 0 {
+				stuck = append(stuck, t.Name)
+			}
+		}
+		return nil, fmt.Errorf("target_ref cycle among: %s", strings.Join(stuck, ", "))
+	}
+	return batches, nil
+}
@@ -0,0 +1,85 @@
+package plan
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// globFollow expands pattern like filepath.Glob, but walks from the pattern's
+// literal base directory and follows symlinks explicitly: links to files are
+// matched by their own path, links to directories are descended. A broken
+// symlink that matches is an error unless skipBroken is set.
+func globFollow(pattern string, skipBroken bool) ([]string, error) {
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+	pattern = filepath.Clean(pattern)
+	parts := strings.Split(pattern, string(filepath.Separator))
+	n := 0
+	for n < len(parts)-1 && !hasMeta(parts[n]) {
+		n++
+	}
+	base := strings.Join(parts[:n], string(filepath.Separator))
+	if base == "" {
+		if filepath.IsAbs(pattern) {
+			base = string(filepath.Separator)
+		} else {
+			base = "."
+		}
+	}
+	var out []string
+	err := walkFollow(base, parts[n:], skipBroken, &out)
+	return out, err
+}
+
+// walkFollow matches the remaining pattern components rest below dir.
+func walkFollow(dir string, rest []string, skipBroken bool, out *[]string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		// like filepath.Glob: unreadable or missing directories match nothing
+		return nil
+	}
+	for _, e := range entries {
+		ok, err := filepath.Match(rest[0], e.Name())
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		p := filepath.Join(dir, e.Name())
+		isDir := e.IsDir()
+		if e.Type()&os.ModeSymlink != 0 {
+			target, err := os.Readlink(p)
+			if err != nil {
+				return fmt.Errorf("readlink %q: %w", p, err)
+			}
+			st, err := os.Stat(p)
+			if err != nil {
+				if skipBroken {
+					continue
+				}
+				return fmt.Errorf("broken symlink %q -> %q: %w", p, target, err)
+			}
+			isDir = st.IsDir()
+		}
+		if len(rest) > 1 {
+			if isDir {
+				if err := walkFollow(p, rest[1:], skipBroken, out); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if !isDir {
+			*out = append(*out, p)
+		}
+	}
+	return nil
+}
+
+func hasMeta(s string) bool {
+	return strings.ContainsAny(s, "*?[")
+}
@@ -0,0 +1,124 @@
+package source
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"sync"
+)
+
+// TransformContext describes the source file a transformer is applied to.
+type TransformContext struct {
+	Target string // target being built
+	Path   string // source file (absolute)
+
+	// Env looks up environment variables; nil means os.LookupEnv.
+	Env func(key string) (string, bool)
+}
+
+// lookupEnv resolves key through ctx.Env (or the process environment).
+func (ctx TransformContext) lookupEnv(key string) (string, bool) {
+	if ctx.Env != nil {
+		return ctx.Env(key)
+	}
+	return os.LookupEnv(key)
+}
+
+// SourceTransformer rewrites a source file's content (UTF-8, already decoded
+// from its encoding) before it is merged or concatenated.
+type SourceTransformer interface {
+	Transform(content []byte, ctx TransformContext) ([]byte, error)
+}
+
+// Registry maps transformer names (as listed in a source's transforms) to
+// their implementation. It is safe for concurrent use.
+type Registry struct {
+	mu sync.RWMutex
+	m  map[string]SourceTransformer
+}
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{m: map[string]SourceTransformer{}}
+}
+
+// Register adds t under name, replacing any transformer already registered as name.
+func (r *Registry) Register(name string, t SourceTransformer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.m[name] = t
+}
+
+// Has reports whether name is registered.
+func (r *Registry) Has(name string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	_, ok := r.m[name]
+	return ok
+}
+
+// Names returns the registered names, sorted.
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.m))
+	for n := range r.m {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Apply chains the named transformers over content, in order.
+func (r *Registry) Apply(content []byte, names []string, ctx TransformContext) ([]byte, error) {
+	for _, n := range names {
+		r.mu.RLock()
+		t, ok := r.m[n]
+		r.mu.RUnlock()
+		if !ok {
+			return nil, fmt.Errorf("%s: unknown transform %q", ctx.Path, n)
+		}
+		var err error
+		if content, err = t.Transform(content, ctx); err != nil {
+			return nil, fmt.Errorf("%s: transform %s: %w", ctx.Path, n, err)
+		}
+	}
+	return content, nil
+}
+
+// Default is the registry sources' transforms refer to. It starts with
+// env_interpolate (EnvInterpolate).
+var Default = NewRegistry()
+
+func init() {
+	Default.Register("env_interpolate", EnvInterpolate{})
+}
+
+// envPlaceholder matches {{ env:NAME }} (spaces inside the braces optional).
+var envPlaceholder = regexp.MustCompile(`\{\{\s*env:([A-Za-z_][A-Za-z0-9_]*)\s*\}\}`)
+
+// EnvInterpolate replaces every {{ env:NAME }} with the value of $NAME. Only
+// that form is expanded (no $VAR, defaults or shell syntax); a variable that
+// is not set is an error rather than an empty string.
+type EnvInterpolate struct{}
+
+// Transform implements SourceTransformer.
+func (EnvInterpolate) Transform(content []byte, ctx TransformContext) ([]byte, error) {
+	var missing string
+	out := envPlaceholder.ReplaceAllFunc(content, func(m []byte) []byte {
+		name := string(envPlaceholder.FindSubmatch(m)[1])
+		v, ok := ctx.lookupEnv(name)
+		if !ok {
+			if missing == "" {
+				missing = name
+			}
+			return m
+		}
+		return []byte(v)
+	})
+	if missing != "" {
+		return nil, fmt.Errorf("{{ env:%s }}: variable is not set", missing)
+	}
+	return out, nil
+}
@@ -0,0 +1,44 @@
+package source
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// upper is a test transformer: it uppercases the content.
+type upper struct{}
+
+func (upper) Transform(content []byte, _ TransformContext) ([]byte, error) {
+	return bytes.ToUpper(content), nil
+}
+
+func TestRegistry_ChainsEnvInterpolate(t *testing.T) {
+	r := NewRegistry()
+	r.Register("env_interpolate", EnvInterpolate{})
+	r.Register("upper", upper{})
+	ctx := TransformContext{
+		Target: "app",
+		Path:   "/src/app.yaml",
+		Env: func(k string) (string, bool) {
+			v, ok := map[string]string{"MY_SECRET": "s3cr3t", "EMPTY": ""}[k]
+			return v, ok
+		},
+	}
+
+	got, err := r.Apply([]byte("pass: {{ env:MY_SECRET }}\nkeep: {{env:EMPTY}}|$MY_SECRET\n"), []string{"env_interpolate", "upper"}, ctx)
+	if err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	if want := "PASS: S3CR3T\nKEEP: |$MY_SECRET\n"; string(got) != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+
+	_, err = r.Apply([]byte("a: {{ env:NOPE }}\n"), []string{"env_interpolate"}, ctx)
+	if err == nil || err.Error() != "/src/app.yaml: transform env_interpolate: {{ env:NOPE }}: variable is not set" {
+		t.Fatalf("want unset variable error, got %v", err)
+	}
+	if _, err := r.Apply(nil, []string{"vault"}, ctx); err == nil || !strings.Contains(err.Error(), `unknown transform "vault"`) {
+		t.Fatalf("want unknown transform error, got %v", err)
+	}
+}